@@ -0,0 +1,292 @@
+package cli
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"time"
+
+	"arr-proxy/internal/ca"
+	"arr-proxy/internal/config"
+)
+
+// fileNamePattern restricts certificate names, which become file names.
+var fileNamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._@-]*$`)
+
+// caFlags adds the flags shared by the ca subcommands.
+func caFlags(name string) (*flag.FlagSet, *string) {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	dir := os.Getenv("APP_CA_DIR")
+	if dir == "" {
+		dir = "ca"
+	}
+	return fs, fs.String("dir", dir, "CA directory (default $APP_CA_DIR or ./ca)")
+}
+
+func runCAInit(args []string, stdout io.Writer) error {
+	fs, dir := caFlags("ca init")
+	name := fs.String("name", "arr-proxy CA", "CA common name")
+	days := fs.Int("days", 3650, "CA certificate validity in days")
+	positional, err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 0 || *days <= 0 {
+		return errUsage
+	}
+
+	c, err := ca.Init(*dir, *name, daysDuration(*days))
+	if err != nil {
+		return err
+	}
+	_, _ = fmt.Fprintf(stdout, "Created CA %q in %s, valid until %s\n\n", *name, c.Dir(), c.Certificate().NotAfter.Format(time.DateOnly))
+	_, _ = fmt.Fprintf(stdout, "# Trust the CA for client certificates and check its revocation list:\nAPP_CA_CERT=%s\nAPP_CRL_FILES=%s\n",
+		filepath.Join(c.Dir(), ca.CertFile), filepath.Join(c.Dir(), ca.CRLFile))
+	return nil
+}
+
+func runCAIssueClient(args []string, stdout io.Writer) error {
+	fs, dir := caFlags("ca issue-client")
+	days := fs.Int("days", 365, "certificate validity in days")
+	out := fs.String("out", "", "output directory (default DIR/issued)")
+	password := fs.String("password", "", "PKCS#12 bundle password (default: generated)")
+	email := fs.String("email", "", "email address SAN")
+	ou := fs.String("ou", "", "subject organizational unit")
+	positional, err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 1 || *days <= 0 {
+		return errUsage
+	}
+	name := positional[0]
+	if !fileNamePattern.MatchString(name) {
+		return fmt.Errorf("invalid client name %q (use letters, digits, '.', '_', '@' and '-')", name)
+	}
+
+	c, err := ca.Open(*dir)
+	if err != nil {
+		return err
+	}
+	req := ca.Request{Kind: ca.KindClient, Name: name, Validity: daysDuration(*days)}
+	if *email != "" {
+		req.Emails = []string{*email}
+	}
+	if *ou != "" {
+		req.OU = []string{*ou}
+	}
+	cert, key, err := c.Issue(req)
+	if err != nil {
+		return err
+	}
+
+	pw := *password
+	if pw == "" {
+		pw = rand.Text()[:16]
+	}
+	bundle, err := ca.EncodePKCS12(key, cert, []*x509.Certificate{c.Certificate()}, name, pw)
+	if err != nil {
+		return err
+	}
+	base, err := writeIssued(c, *out, name, cert, key)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(base+".p12", bundle, 0o600); err != nil {
+		return err
+	}
+
+	_, _ = fmt.Fprintf(stdout, "Issued client certificate %q, serial %s, valid until %s\n", name, cert.SerialNumber.Text(16), cert.NotAfter.Format(time.DateOnly))
+	_, _ = fmt.Fprintf(stdout, "  certificate: %s.crt\n  key:         %s.key\n  bundle:      %s.p12\n", base, base, base)
+	if *password == "" {
+		_, _ = fmt.Fprintf(stdout, "  password:    %s\n", pw)
+	}
+	_, _ = fmt.Fprintf(stdout, "\n# The certificate authenticates as client %q. To accept only this certificate\n# for the client, add it to clients.yaml:\nclients:\n  %s:\n    certificates:\n      - fingerprint: %q\n",
+		name, name, config.Fingerprint(cert))
+	return nil
+}
+
+func runCAIssueServer(args []string, stdout io.Writer) error {
+	fs, dir := caFlags("ca issue-server")
+	days := fs.Int("days", 365, "certificate validity in days")
+	out := fs.String("out", "", "output directory (default DIR/issued)")
+	name := fs.String("name", "server", "output file name")
+	positional, err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) == 0 || *days <= 0 {
+		return errUsage
+	}
+	if !fileNamePattern.MatchString(*name) {
+		return fmt.Errorf("invalid name %q (use letters, digits, '.', '_', '@' and '-')", *name)
+	}
+
+	req := ca.Request{Kind: ca.KindServer, Name: positional[0], Validity: daysDuration(*days)}
+	for _, host := range positional {
+		if ip := net.ParseIP(host); ip != nil {
+			req.IPs = append(req.IPs, ip)
+		} else {
+			req.DNSNames = append(req.DNSNames, host)
+		}
+	}
+	c, err := ca.Open(*dir)
+	if err != nil {
+		return err
+	}
+	cert, key, err := c.Issue(req)
+	if err != nil {
+		return err
+	}
+	base, err := writeIssued(c, *out, *name, cert, key)
+	if err != nil {
+		return err
+	}
+
+	_, _ = fmt.Fprintf(stdout, "Issued server certificate for %v, serial %s, valid until %s\n\n", positional, cert.SerialNumber.Text(16), cert.NotAfter.Format(time.DateOnly))
+	_, _ = fmt.Fprintf(stdout, "APP_TLS_CERT=%s.crt\nAPP_TLS_KEY=%s.key\n", base, base)
+	return nil
+}
+
+func runCARevoke(args []string, stdout io.Writer) error {
+	fs, dir := caFlags("ca revoke")
+	positional, err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 1 {
+		return errUsage
+	}
+
+	c, err := ca.Open(*dir)
+	if err != nil {
+		return err
+	}
+	revoked, err := c.Revoke(positional[0])
+	if err != nil {
+		return err
+	}
+	for _, r := range revoked {
+		_, _ = fmt.Fprintf(stdout, "Revoked %s certificate %q, serial %s\n", r.Kind, r.Name, r.Serial)
+	}
+	_, _ = fmt.Fprintf(stdout, "Published %s; the proxy picks it up within a few seconds\n", filepath.Join(c.Dir(), ca.CRLFile))
+	return nil
+}
+
+func runCACRL(args []string, stdout io.Writer) error {
+	fs, dir := caFlags("ca crl")
+	positional, err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 0 {
+		return errUsage
+	}
+
+	c, err := ca.Open(*dir)
+	if err != nil {
+		return err
+	}
+	if err := c.PublishCRL(); err != nil {
+		return err
+	}
+	_, _ = fmt.Fprintf(stdout, "Published %s\n", filepath.Join(c.Dir(), ca.CRLFile))
+	return nil
+}
+
+func runCAList(args []string, stdout io.Writer) error {
+	fs, dir := caFlags("ca list")
+	positional, err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 0 {
+		return errUsage
+	}
+
+	c, err := ca.Open(*dir)
+	if err != nil {
+		return err
+	}
+	records, err := c.Records()
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	for _, r := range records {
+		status := "valid"
+		switch {
+		case r.RevokedAt != nil:
+			status = "revoked " + r.RevokedAt.Format(time.DateOnly)
+		case now.After(r.NotAfter):
+			status = "expired"
+		}
+		source := "issued"
+		if r.Enrollment != "" {
+			source = fmt.Sprintf("enrolled %s from %s", r.Enrollment, r.RemoteAddr)
+		}
+		_, _ = fmt.Fprintf(stdout, "%-32s  %-6s  %-20s  %s  %-18s  %s\n", r.Serial, r.Kind, r.Name, r.NotAfter.Format(time.DateOnly), status, source)
+	}
+	return nil
+}
+
+func runCAEnroll(args []string, stdout io.Writer) error {
+	fs, dir := caFlags("ca enroll")
+	ttl := fs.Duration("ttl", 24*time.Hour, "how long the enrollment token is valid")
+	positional, err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 1 || *ttl <= 0 {
+		return errUsage
+	}
+	name := positional[0]
+
+	c, err := ca.Open(*dir)
+	if err != nil {
+		return err
+	}
+	token, e, err := c.CreateEnrollment(name, *ttl)
+	if err != nil {
+		return err
+	}
+	_, _ = fmt.Fprintf(stdout, "Enrollment %s for client %q, valid until %s\n\ntoken: %s\n\n", e.ID, name, e.ExpiresAt.Format(time.RFC3339), token)
+	_, _ = fmt.Fprintf(stdout, "# On the device, create a key and request the certificate (the proxy needs APP_CA_DIR=%s):\n", c.Dir())
+	_, _ = fmt.Fprintf(stdout, "openssl req -new -newkey ec -pkeyopt ec_paramgen_curve:P-256 -nodes -subj /CN=%s -keyout %s.key -out %s.csr\n", name, name, name)
+	_, _ = fmt.Fprintf(stdout, "curl --cacert ca.crt -H 'Authorization: Bearer %s' --data-binary @%s.csr https://PROXY/enroll -o %s.crt\n", token, name, name)
+	return nil
+}
+
+// writeIssued writes the certificate and key to out (default DIR/issued) and returns
+// the path of the files without extension.
+func writeIssued(c *ca.CA, out, name string, cert *x509.Certificate, key crypto.Signer) (string, error) {
+	if out == "" {
+		out = filepath.Join(c.Dir(), "issued")
+	}
+	if err := os.MkdirAll(out, 0o700); err != nil {
+		return "", err
+	}
+	keyPEM, err := ca.EncodeKeyPEM(key)
+	if err != nil {
+		return "", err
+	}
+	base := filepath.Join(out, name)
+	if err := os.WriteFile(base+".key", keyPEM, 0o600); err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(base+".crt", ca.EncodeCertPEM(cert), 0o644); err != nil {
+		return "", err
+	}
+	return base, nil
+}
+
+func daysDuration(days int) time.Duration {
+	return time.Duration(days) * 24 * time.Hour
+}
@@ -0,0 +1,189 @@
+// Package cli implements the arr-proxy command line subcommands.
+package cli
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+)
+
+// errUsage signals that a command was invoked with invalid arguments.
+var errUsage = errors.New("invalid usage")
+
+type command struct {
+	name    string // space separated subcommand path, e.g. "learn suggest"
+	usage   string
+	summary string
+	run     func(args []string, stdout io.Writer) error
+}
+
+// commands lists all subcommands. Running the binary without arguments (or with
+// "serve") starts the proxy server instead.
+var commands = []command{
+	{
+		name:    "config check",
+		usage:   "config check",
+		summary: "Load the configuration with strict validation",
+		run:     runConfigCheck,
+	},
+	{
+		name:    "config print",
+		usage:   "config print",
+		summary: "Print the effective configuration with secrets masked",
+		run:     runConfigPrint,
+	},
+	{
+		name:    "rule test",
+		usage:   "rule test [--client NAME] SERVICE METHOD PATH",
+		summary: "Show whether a request would be allowed and which rule matches",
+		run:     runRuleTest,
+	},
+	{
+		name:    "learn suggest",
+		usage:   "learn suggest [--client NAME] FILE",
+		summary: "Propose whitelist rules from a learning mode recording",
+		run:     runLearnSuggest,
+	},
+	{
+		name:    "key generate",
+		usage:   "key generate [--client NAME]",
+		summary: "Print a new proxy API key and the hash to configure",
+		run:     runKeyGenerate,
+	},
+	{
+		name:    "key hash",
+		usage:   "key hash [KEY]",
+		summary: "Print the hash of an existing API key (read from stdin if omitted)",
+		run:     runKeyHash,
+	},
+	{
+		name:    "secret generate-key",
+		usage:   "secret generate-key",
+		summary: "Print a new random master key for encrypted config values",
+		run:     runSecretGenerateKey,
+	},
+	{
+		name:    "secret encrypt",
+		usage:   "secret encrypt --name SETTING [VALUE]",
+		summary: "Encrypt a config value (read from stdin if omitted) with the master key",
+		run:     runSecretEncrypt,
+	},
+	{
+		name:    "secret decrypt",
+		usage:   "secret decrypt --name SETTING [VALUE]",
+		summary: "Decrypt an enc: config value with the master key",
+		run:     runSecretDecrypt,
+	},
+	{
+		name:    "ca init",
+		usage:   "ca init [--dir DIR] [--name NAME] [--days N]",
+		summary: "Create a certificate authority for client certificates",
+		run:     runCAInit,
+	},
+	{
+		name:    "ca issue-client",
+		usage:   "ca issue-client [--dir DIR] [--days N] [--out DIR] [--password PW] [--email EMAIL] [--ou OU] NAME",
+		summary: "Issue a client certificate, key and PKCS#12 bundle",
+		run:     runCAIssueClient,
+	},
+	{
+		name:    "ca issue-server",
+		usage:   "ca issue-server [--dir DIR] [--days N] [--out DIR] [--name NAME] HOST...",
+		summary: "Issue a server certificate for host names and IP addresses",
+		run:     runCAIssueServer,
+	},
+	{
+		name:    "ca enroll",
+		usage:   "ca enroll [--dir DIR] [--ttl DURATION] NAME",
+		summary: "Create a one-time token for enrolling a client certificate",
+		run:     runCAEnroll,
+	},
+	{
+		name:    "ca revoke",
+		usage:   "ca revoke [--dir DIR] NAME|SERIAL",
+		summary: "Revoke certificates and publish the CRL",
+		run:     runCARevoke,
+	},
+	{
+		name:    "ca crl",
+		usage:   "ca crl [--dir DIR]",
+		summary: "Publish the CRL again, renewing its validity",
+		run:     runCACRL,
+	},
+	{
+		name:    "ca list",
+		usage:   "ca list [--dir DIR]",
+		summary: "List issued certificates",
+		run:     runCAList,
+	},
+}
+
+// Run executes the subcommand selected by args and returns the process exit code.
+func Run(args []string, stdout, stderr io.Writer) int {
+	// Keep stdout for command output; only warnings from config loading go to stderr
+	slog.SetDefault(slog.New(slog.NewTextHandler(stderr, &slog.HandlerOptions{Level: slog.LevelWarn})))
+
+	cmd, rest := findCommand(args)
+	if cmd == nil {
+		printUsage(stderr)
+		return 2
+	}
+
+	if err := cmd.run(rest, stdout); err != nil {
+		if errors.Is(err, errUsage) {
+			_, _ = fmt.Fprintf(stderr, "usage: arr-proxy %s\n", cmd.usage)
+			return 2
+		}
+		_, _ = fmt.Fprintf(stderr, "error: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// findCommand returns the command with the longest name matching the leading args.
+func findCommand(args []string) (*command, []string) {
+	var found *command
+	var rest []string
+	for i := range commands {
+		parts := strings.Fields(commands[i].name)
+		if len(args) < len(parts) || strings.Join(args[:len(parts)], " ") != commands[i].name {
+			continue
+		}
+		if found == nil || len(parts) > len(strings.Fields(found.name)) {
+			found = &commands[i]
+			rest = args[len(parts):]
+		}
+	}
+	return found, rest
+}
+
+func printUsage(w io.Writer) {
+	_, _ = fmt.Fprintln(w, "usage: arr-proxy [serve]")
+	for _, c := range commands {
+		_, _ = fmt.Fprintf(w, "       arr-proxy %s\n", c.usage)
+	}
+	_, _ = fmt.Fprintln(w)
+	for _, c := range commands {
+		_, _ = fmt.Fprintf(w, "  %-20s %s\n", c.name, c.summary)
+	}
+}
+
+// parseFlags parses flags that may appear before, between or after positional
+// arguments and returns the positional arguments.
+func parseFlags(fs *flag.FlagSet, args []string) ([]string, error) {
+	fs.SetOutput(io.Discard)
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, fmt.Errorf("%w: %v", errUsage, err)
+		}
+		if fs.NArg() == 0 {
+			return positional, nil
+		}
+		positional = append(positional, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+}
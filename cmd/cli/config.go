@@ -0,0 +1,293 @@
+package cli
+
+import (
+	"crypto/tls"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/netip"
+	"sort"
+	"strings"
+
+	"arr-proxy/internal/config"
+
+	"gopkg.in/yaml.v3"
+)
+
+// maskedValue replaces secrets in printed configuration.
+const maskedValue = "********"
+
+func runConfigCheck(args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("config check", flag.ContinueOnError)
+	positional, err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 0 {
+		return errUsage
+	}
+
+	// Always strict, regardless of APP_CONFIG_LENIENT. Warnings are logged to stderr.
+	cfg, err := config.LoadWithOptions(config.LoadOptions{})
+	var validationErr *config.ValidationError
+	if errors.As(err, &validationErr) {
+		for _, e := range validationErr.Errors {
+			_, _ = fmt.Fprintf(stdout, "error: %s\n", e)
+		}
+		return fmt.Errorf("configuration has %d errors", len(validationErr.Errors))
+	}
+	if err != nil {
+		return err
+	}
+
+	_, _ = fmt.Fprintf(stdout, "configuration OK (auth mode %s, TLS %v)\n", cfg.Auth.Mode, cfg.TLSEnabled())
+	for _, name := range cfg.ServiceNames() {
+		sc := cfg.Service(name)
+		_, _ = fmt.Fprintf(stdout, "  %-8s %s (%d whitelist rules, %d rule sets)\n", name, sc.URL, len(sc.CompiledWhitelist), len(sc.CompiledRuleSets))
+		if len(sc.Auth) > 0 {
+			_, _ = fmt.Fprintf(stdout, "           auth %s\n", strings.Join(sc.Auth, ","))
+		}
+	}
+	_, _ = fmt.Fprintf(stdout, "  %d clients\n", len(cfg.Clients))
+	return nil
+}
+
+type printedService struct {
+	URL                string              `yaml:"url"`
+	APIKey             string              `yaml:"api_key"`
+	Whitelist          []string            `yaml:"whitelist"`
+	RuleSets           map[string][]string `yaml:"rule_sets,omitempty"`
+	CandidateWhitelist []string            `yaml:"candidate_whitelist,omitempty"`
+	Auth               []string            `yaml:"auth,omitempty"`
+	AllowedIPs         []string            `yaml:"allowed_ips,omitempty"`
+	DeniedIPs          []string            `yaml:"denied_ips,omitempty"`
+}
+
+type printedClient struct {
+	Services     []string                  `yaml:"services,omitempty"`
+	RuleSets     []string                  `yaml:"rule_sets,omitempty"`
+	APIKeys      []string                  `yaml:"api_keys,omitempty"`
+	Groups       []string                  `yaml:"groups,omitempty"`
+	Certificates []config.CertificateMatch `yaml:"certificates,omitempty"`
+	AllowedIPs   []string                  `yaml:"allowed_ips,omitempty"`
+	DeniedIPs    []string                  `yaml:"denied_ips,omitempty"`
+}
+
+type printedKeyPair struct {
+	Cert string `yaml:"cert"`
+	Key  string `yaml:"key"`
+}
+
+type printedConfig struct {
+	Port string `yaml:"port"`
+	TLS  struct {
+		Cert            string           `yaml:"cert"`
+		Key             string           `yaml:"key"`
+		Certificates    []printedKeyPair `yaml:"certificates,omitempty"`
+		SelfSigned      bool             `yaml:"self_signed"`
+		SelfSignedHosts []string         `yaml:"self_signed_hosts,omitempty"`
+		CACert          string           `yaml:"ca_cert"`
+		CRLFiles        []string         `yaml:"crl_files,omitempty"`
+		Denylist        string           `yaml:"denylist,omitempty"`
+		CADir           string           `yaml:"ca_dir,omitempty"`
+		EnrollValidity  string           `yaml:"enroll_validity"`
+	} `yaml:"tls"`
+	Auth struct {
+		Mode     string `yaml:"mode"`
+		APIKey   string `yaml:"api_key"`
+		AdminKey string `yaml:"admin_key,omitempty"`
+		Basic    struct {
+			User     string `yaml:"user"`
+			Password string `yaml:"password"`
+		} `yaml:"basic"`
+		Token struct {
+			Algorithm string `yaml:"algorithm"`
+			Key       string `yaml:"key"`
+			MaxTTL    string `yaml:"max_ttl"`
+		} `yaml:"token"`
+		JWT struct {
+			JWKS          string `yaml:"jwks"`
+			JWKSRefresh   string `yaml:"jwks_refresh"`
+			Issuer        string `yaml:"issuer"`
+			Audience      string `yaml:"audience"`
+			IdentityClaim string `yaml:"identity_claim"`
+			GroupsClaim   string `yaml:"groups_claim"`
+		} `yaml:"jwt"`
+		Forward struct {
+			URL            string   `yaml:"url"`
+			Headers        []string `yaml:"headers"`
+			IdentityHeader string   `yaml:"identity_header"`
+			GroupsHeader   string   `yaml:"groups_header"`
+			CacheTTL       string   `yaml:"cache_ttl"`
+		} `yaml:"forward"`
+		Header struct {
+			UserHeader     string   `yaml:"user_header"`
+			GroupsHeader   string   `yaml:"groups_header"`
+			TrustedProxies []string `yaml:"trusted_proxies,omitempty"`
+		} `yaml:"header"`
+		MTLS struct {
+			RequireMapping bool `yaml:"require_mapping"`
+		} `yaml:"mtls"`
+		Lockout struct {
+			Threshold  int    `yaml:"threshold"`
+			Window     string `yaml:"window"`
+			BanTime    string `yaml:"ban_time"`
+			MaxBanTime string `yaml:"max_ban_time"`
+		} `yaml:"lockout"`
+	} `yaml:"auth"`
+	Network struct {
+		AllowedIPs      []string `yaml:"allowed_ips,omitempty"`
+		DeniedIPs       []string `yaml:"denied_ips,omitempty"`
+		ProxyProtocol   []string `yaml:"proxy_protocol,omitempty"`
+		ForwardedHeader string   `yaml:"forwarded_header"`
+	} `yaml:"network"`
+	Server struct {
+		ReadTimeout       string   `yaml:"read_timeout"`
+		WriteTimeout      string   `yaml:"write_timeout"`
+		IdleTimeout       string   `yaml:"idle_timeout"`
+		ReadHeaderTimeout string   `yaml:"read_header_timeout"`
+		MaxBodySize       int64    `yaml:"max_body_size"`
+		TLSMinVersion     string   `yaml:"tls_min_version"`
+		TLSCipherSuites   []string `yaml:"tls_cipher_suites,omitempty"`
+		TLSCurves         []string `yaml:"tls_curves,omitempty"`
+		TLSALPN           []string `yaml:"tls_alpn"`
+		LogLevel          string   `yaml:"log_level"`
+		LowercasePaths    bool     `yaml:"lowercase_paths"`
+		LearnFile         string   `yaml:"learn_file"`
+		KeyStore          string   `yaml:"key_store,omitempty"`
+	} `yaml:"server"`
+	Services map[string]printedService `yaml:"services"`
+	Clients  map[string]printedClient  `yaml:"clients,omitempty"`
+}
+
+func runConfigPrint(args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("config print", flag.ContinueOnError)
+	positional, err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 0 {
+		return errUsage
+	}
+
+	cfg, err := config.Load()
+	if err != nil {
+		return err
+	}
+
+	var out printedConfig
+	out.Port = cfg.Port
+	out.TLS.Cert = cfg.TLSCert
+	out.TLS.Key = cfg.TLSKey
+	for _, pair := range cfg.TLS.Certificates {
+		out.TLS.Certificates = append(out.TLS.Certificates, printedKeyPair{Cert: pair.Cert, Key: pair.Key})
+	}
+	out.TLS.SelfSigned = cfg.TLS.SelfSigned
+	out.TLS.SelfSignedHosts = cfg.TLS.SelfSignedHosts
+	out.TLS.CACert = cfg.CACert
+	out.TLS.CRLFiles = cfg.Revocation.CRLFiles
+	out.TLS.Denylist = cfg.Revocation.Denylist
+	out.TLS.CADir = cfg.Enrollment.CADir
+	out.TLS.EnrollValidity = cfg.Enrollment.Validity.String()
+	out.Auth.Mode = cfg.Auth.Mode
+	out.Auth.APIKey = mask(cfg.Auth.APIKey)
+	out.Auth.AdminKey = mask(cfg.Auth.AdminKey)
+	out.Auth.Basic.User = cfg.Auth.BasicAuth.User
+	out.Auth.Basic.Password = mask(cfg.Auth.BasicAuth.Password)
+	out.Auth.Token.Algorithm = cfg.Auth.Token.Algorithm
+	out.Auth.Token.Key = mask(cfg.Auth.Token.Key)
+	out.Auth.Token.MaxTTL = cfg.Auth.Token.MaxTTL.String()
+	out.Auth.JWT.JWKS = cfg.Auth.JWT.JWKS
+	out.Auth.JWT.JWKSRefresh = cfg.Auth.JWT.JWKSRefresh.String()
+	out.Auth.JWT.Issuer = cfg.Auth.JWT.Issuer
+	out.Auth.JWT.Audience = cfg.Auth.JWT.Audience
+	out.Auth.JWT.IdentityClaim = cfg.Auth.JWT.IdentityClaim
+	out.Auth.JWT.GroupsClaim = cfg.Auth.JWT.GroupsClaim
+	out.Auth.Forward.URL = cfg.Auth.Forward.URL
+	out.Auth.Forward.Headers = cfg.Auth.Forward.Headers
+	out.Auth.Forward.IdentityHeader = cfg.Auth.Forward.IdentityHeader
+	out.Auth.Forward.GroupsHeader = cfg.Auth.Forward.GroupsHeader
+	out.Auth.Forward.CacheTTL = cfg.Auth.Forward.CacheTTL.String()
+	out.Auth.Header.UserHeader = cfg.Auth.Header.UserHeader
+	out.Auth.Header.GroupsHeader = cfg.Auth.Header.GroupsHeader
+	out.Auth.Header.TrustedProxies = prefixStrings(cfg.Network.TrustedProxies)
+	out.Auth.MTLS.RequireMapping = cfg.Auth.MTLS.RequireMapping
+	out.Auth.Lockout.Threshold = cfg.Auth.Lockout.Threshold
+	out.Auth.Lockout.Window = cfg.Auth.Lockout.Window.String()
+	out.Auth.Lockout.BanTime = cfg.Auth.Lockout.BanTime.String()
+	out.Auth.Lockout.MaxBanTime = cfg.Auth.Lockout.MaxBanTime.String()
+	out.Network.AllowedIPs = prefixStrings(cfg.Network.IPRules.Allowed)
+	out.Network.DeniedIPs = prefixStrings(cfg.Network.IPRules.Denied)
+	out.Network.ProxyProtocol = prefixStrings(cfg.Network.ProxyProtocol)
+	out.Network.ForwardedHeader = cfg.Network.ForwardedHeader
+	out.Server.ReadTimeout = cfg.Server.ReadTimeout.String()
+	out.Server.WriteTimeout = cfg.Server.WriteTimeout.String()
+	out.Server.IdleTimeout = cfg.Server.IdleTimeout.String()
+	out.Server.ReadHeaderTimeout = cfg.Server.ReadHeaderTimeout.String()
+	out.Server.MaxBodySize = cfg.Server.MaxBodySize
+	out.Server.TLSMinVersion = cfg.Server.TLSMinVersion
+	for _, id := range cfg.Server.TLSCipherSuites {
+		out.Server.TLSCipherSuites = append(out.Server.TLSCipherSuites, tls.CipherSuiteName(id))
+	}
+	for _, id := range cfg.Server.TLSCurves {
+		out.Server.TLSCurves = append(out.Server.TLSCurves, id.String())
+	}
+	out.Server.TLSALPN = cfg.Server.TLSALPN
+	out.Server.LogLevel = cfg.Server.LogLevel
+	out.Server.LowercasePaths = cfg.Server.LowercasePaths
+	out.Server.LearnFile = cfg.Server.LearnFile
+	out.Server.KeyStore = cfg.Server.KeyStore
+
+	out.Services = make(map[string]printedService)
+	for name, sc := range cfg.Services {
+		out.Services[name] = printedService{
+			URL:                sc.URL,
+			APIKey:             mask(sc.APIKey),
+			Whitelist:          sc.Whitelist,
+			RuleSets:           sc.RuleSets,
+			CandidateWhitelist: sc.CandidateWhitelist,
+			Auth:               sc.Auth,
+			AllowedIPs:         sc.AllowedIPs,
+			DeniedIPs:          sc.DeniedIPs,
+		}
+	}
+
+	if len(cfg.Clients) > 0 {
+		out.Clients = make(map[string]printedClient, len(cfg.Clients))
+		names := make([]string, 0, len(cfg.Clients))
+		for name := range cfg.Clients {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			c := cfg.Clients[name]
+			out.Clients[name] = printedClient{Services: c.Services, RuleSets: c.RuleSets, APIKeys: c.APIKeys, Groups: c.Groups, Certificates: c.Certificates,
+				AllowedIPs: c.AllowedIPs, DeniedIPs: c.DeniedIPs}
+		}
+	}
+
+	enc := yaml.NewEncoder(stdout)
+	enc.SetIndent(2)
+	if err := enc.Encode(out); err != nil {
+		return err
+	}
+	return enc.Close()
+}
+
+// prefixStrings formats network prefixes for printing.
+func prefixStrings(prefixes []netip.Prefix) []string {
+	var list []string
+	for _, p := range prefixes {
+		list = append(list, p.String())
+	}
+	return list
+}
+
+// mask hides a secret value while still showing whether it is set.
+func mask(secret string) string {
+	if secret == "" {
+		return ""
+	}
+	return maskedValue
+}
@@ -0,0 +1,72 @@
+package cli
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"arr-proxy/internal/apikeys"
+)
+
+func runKeyGenerate(args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("key generate", flag.ContinueOnError)
+	client := fs.String("client", "", "client name used in the printed configuration snippet")
+	positional, err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 0 {
+		return errUsage
+	}
+
+	key, err := apikeys.Generate()
+	if err != nil {
+		return err
+	}
+	hash, err := apikeys.Hash(key)
+	if err != nil {
+		return err
+	}
+
+	name := *client
+	if name == "" {
+		name = "CLIENT"
+	}
+	_, _ = fmt.Fprintf(stdout, "key:  %s\nhash: %s\n\n", key, hash)
+	_, _ = fmt.Fprintf(stdout, "# Give the key to the client and add the hash to clients.yaml:\nclients:\n  %s:\n    api_keys:\n      - %q\n", name, hash)
+	return nil
+}
+
+func runKeyHash(args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("key hash", flag.ContinueOnError)
+	positional, err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) > 1 {
+		return errUsage
+	}
+
+	var key string
+	if len(positional) == 1 {
+		key = positional[0]
+	} else {
+		data, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			return fmt.Errorf("failed to read key from stdin: %w", err)
+		}
+		key = strings.TrimRight(string(data), "\r\n")
+	}
+	if key == "" {
+		return fmt.Errorf("empty key")
+	}
+
+	hash, err := apikeys.Hash(key)
+	if err != nil {
+		return err
+	}
+	_, _ = fmt.Fprintln(stdout, hash)
+	return nil
+}
@@ -0,0 +1,81 @@
+package cli
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"arr-proxy/internal/usecases"
+)
+
+func runLearnSuggest(args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("learn suggest", flag.ContinueOnError)
+	client := fs.String("client", "", "only use requests made by this client identity")
+	positional, err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 1 {
+		return errUsage
+	}
+
+	f, err := os.Open(positional[0])
+	if err != nil {
+		return err
+	}
+	defer func() { _ = f.Close() }()
+
+	observations, err := usecases.ReadObservations(f)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", positional[0], err)
+	}
+
+	suggestions := usecases.SuggestWhitelist(observations, *client)
+	if len(suggestions) == 0 {
+		return fmt.Errorf("no usable requests recorded in %s", positional[0])
+	}
+
+	services := make([]string, 0, len(suggestions))
+	for s := range suggestions {
+		services = append(services, s)
+	}
+	sort.Strings(services)
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "# Whitelist proposed from %s", positional[0])
+	if *client != "" {
+		fmt.Fprintf(&b, " (client %s)", *client)
+	}
+	b.WriteString("\n# Review before use: every recorded request is included.\n")
+	b.WriteString("# Merge into arr-proxy.yaml; services also need their url and api_key.\n")
+	b.WriteString("services:\n")
+	for _, service := range services {
+		fmt.Fprintf(&b, "  %s:\n    whitelist:\n", service)
+		for _, rule := range suggestions[service] {
+			var notes []string
+			if len(rule.QueryKeys) > 0 {
+				notes = append(notes, "query: "+strings.Join(rule.QueryKeys, ", "))
+			}
+			if rule.Blocked {
+				notes = append(notes, "currently blocked")
+			}
+			fmt.Fprintf(&b, "      - %s", yamlQuote(rule.Pattern))
+			if len(notes) > 0 {
+				fmt.Fprintf(&b, "  # %s", strings.Join(notes, "; "))
+			}
+			b.WriteString("\n")
+		}
+	}
+
+	_, err = io.WriteString(stdout, b.String())
+	return err
+}
+
+// yamlQuote returns s as a single-quoted YAML scalar, matching the style of the
+// shipped whitelist files.
+func yamlQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
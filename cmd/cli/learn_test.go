@@ -0,0 +1,54 @@
+package cli
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"arr-proxy/internal/config"
+)
+
+func TestLearnSuggestOutputLoads(t *testing.T) {
+	dir := t.TempDir()
+	recording := filepath.Join(dir, "learn.jsonl")
+	lines := `{"identity":"default","service":"sonarr","method":"GET","path":"/api/v3/series"}
+{"identity":"default","service":"sonarr","method":"GET","path":"/api/v3/series/{id}","query_keys":["includeSeasonImages"]}
+{"identity":"default","service":"sonarr","method":"POST","path":"/api/v3/command","blocked":true}
+`
+	if err := os.WriteFile(recording, []byte(lines), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	var stdout, stderr bytes.Buffer
+	if code := Run([]string{"learn", "suggest", recording}, &stdout, &stderr); code != 0 {
+		t.Fatalf("learn suggest exited %d: %s", code, stderr.String())
+	}
+
+	// The suggestion is used as the unified configuration file as is
+	if err := os.WriteFile(filepath.Join(dir, "arr-proxy.yaml"), stdout.Bytes(), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("APP_CONFIG_DIR", dir)
+	t.Setenv("APP_CONFIG_FILE", "")
+	t.Setenv("APP_AUTH_MODE", "apikey")
+	t.Setenv("APP_API_KEY", "proxy-key")
+	t.Setenv("SONARR_URL", "http://sonarr:8989")
+	t.Setenv("SONARR_API_KEY", "sonarr-key")
+	t.Setenv("RADARR_URL", "")
+
+	stdout.Reset()
+	stderr.Reset()
+	if code := Run([]string{"config", "check"}, &stdout, &stderr); code != 0 {
+		t.Fatalf("config check exited %d: %s%s", code, stdout.String(), stderr.String())
+	}
+	cfg, err := config.Load()
+	if err != nil {
+		t.Fatalf("Load() unexpected error: %v", err)
+	}
+	want := []string{`POST:^/api/v3/command$`, `GET:^/api/v3/series(?:/\d+)?$`}
+	if got := cfg.Services["sonarr"].Whitelist; !slices.Equal(got, want) {
+		t.Errorf("loaded whitelist = %q, want %q", got, want)
+	}
+}
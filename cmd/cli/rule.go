@@ -0,0 +1,74 @@
+package cli
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+
+	"arr-proxy/internal/config"
+	"arr-proxy/internal/middleware"
+)
+
+func runRuleTest(args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("rule test", flag.ContinueOnError)
+	client := fs.String("client", middleware.DefaultIdentity, "client identity to evaluate the request for")
+	positional, err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 3 {
+		return errUsage
+	}
+	service, method, target := positional[0], strings.ToUpper(positional[1]), positional[2]
+
+	u, err := url.Parse(target)
+	if err != nil {
+		return fmt.Errorf("invalid path %q: %w", target, err)
+	}
+
+	cfg, err := config.Load()
+	if err != nil {
+		return err
+	}
+	sc := cfg.Service(service)
+	if sc == nil {
+		return fmt.Errorf("service %q is not configured", service)
+	}
+
+	// Evaluate exactly what the server would: the canonical path
+	path, err := middleware.CanonicalizePath(u.EscapedPath(), cfg.Server.LowercasePaths)
+	if err != nil {
+		_, _ = fmt.Fprintf(stdout, "decision:  DENY (400, %v)\n", err)
+		return nil
+	}
+
+	decision := cfg.Evaluate(*client, sc, method, path)
+
+	_, _ = fmt.Fprintf(stdout, "service:   %s\n", service)
+	_, _ = fmt.Fprintf(stdout, "client:    %s", *client)
+	if _, ok := cfg.Clients[*client]; !ok {
+		_, _ = fmt.Fprint(stdout, " (no client entry, default whitelist)")
+	}
+	_, _ = fmt.Fprintln(stdout)
+	_, _ = fmt.Fprintf(stdout, "request:   %s %s\n", method, path)
+	if u.RawQuery != "" {
+		_, _ = fmt.Fprintf(stdout, "query:     %s (forwarded, not evaluated by rules)\n", u.RawQuery)
+	}
+	if decision.Allowed {
+		_, _ = fmt.Fprintln(stdout, "decision:  ALLOW")
+		_, _ = fmt.Fprintf(stdout, "rule set:  %s\n", decision.RuleSet)
+		_, _ = fmt.Fprintf(stdout, "rule:      %s\n", decision.Rule.Raw)
+	} else {
+		_, _ = fmt.Fprintf(stdout, "decision:  DENY (403, %s)\n", decision.Reason)
+	}
+	if decision.Allowed && sc.HasCandidateWhitelist() {
+		if sc.IsCandidateWhitelisted(method, path) {
+			_, _ = fmt.Fprintln(stdout, "candidate: ALLOW")
+		} else {
+			_, _ = fmt.Fprintln(stdout, "candidate: DENY (report-only)")
+		}
+	}
+	return nil
+}
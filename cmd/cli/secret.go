@@ -0,0 +1,78 @@
+package cli
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"arr-proxy/internal/secrets"
+)
+
+func runSecretGenerateKey(args []string, stdout io.Writer) error {
+	if len(args) != 0 {
+		return errUsage
+	}
+	key, err := secrets.GenerateKey()
+	if err != nil {
+		return err
+	}
+	_, _ = fmt.Fprintln(stdout, key)
+	return nil
+}
+
+func runSecretEncrypt(args []string, stdout io.Writer) error {
+	value, name, key, err := secretArgs("secret encrypt", args)
+	if err != nil {
+		return err
+	}
+	encrypted, err := secrets.Encrypt(key, name, value)
+	if err != nil {
+		return err
+	}
+	_, _ = fmt.Fprintln(stdout, encrypted)
+	return nil
+}
+
+func runSecretDecrypt(args []string, stdout io.Writer) error {
+	value, name, key, err := secretArgs("secret decrypt", args)
+	if err != nil {
+		return err
+	}
+	decrypted, err := secrets.Decrypt(key, name, value)
+	if err != nil {
+		return err
+	}
+	_, _ = fmt.Fprintln(stdout, decrypted)
+	return nil
+}
+
+// secretArgs returns the value to process, the setting name the value is bound
+// to and the master key. The value is read from stdin if it is not given as
+// argument, which keeps it out of the shell history.
+func secretArgs(cmd string, args []string) (string, string, []byte, error) {
+	fs := flag.NewFlagSet(cmd, flag.ContinueOnError)
+	name := fs.String("name", "", "environment variable of the setting, e.g. SONARR_API_KEY")
+	positional, err := parseFlags(fs, args)
+	if err != nil {
+		return "", "", nil, err
+	}
+	if len(positional) > 1 || *name == "" {
+		return "", "", nil, errUsage
+	}
+
+	key, err := secrets.LoadMasterKey()
+	if err != nil {
+		return "", "", nil, err
+	}
+
+	if len(positional) == 1 {
+		return positional[0], *name, key, nil
+	}
+	data, err := io.ReadAll(os.Stdin)
+	if err != nil {
+		return "", "", nil, fmt.Errorf("failed to read value from stdin: %w", err)
+	}
+	return strings.TrimRight(string(data), "\r\n"), *name, key, nil
+}
@@ -8,6 +8,7 @@ import (
 	"syscall"
 	"time"
 
+	"arr-proxy/cmd/cli"
 	"arr-proxy/cmd/proxy"
 	"arr-proxy/internal/config"
 )
@@ -32,6 +33,11 @@ func setupLogger(level string) {
 }
 
 func main() {
+	// Subcommands (everything except "serve") run and exit without starting the server
+	if len(os.Args) > 1 && os.Args[1] != "serve" {
+		os.Exit(cli.Run(os.Args[1:], os.Stdout, os.Stderr))
+	}
+
 	cfg, err := config.Load()
 	if err != nil {
 		slog.Error("Configuration error", "error", err)
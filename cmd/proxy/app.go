@@ -4,20 +4,103 @@ import (
 	"context"
 	"log/slog"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
 
+	"arr-proxy/internal/apikeys"
+	"arr-proxy/internal/ca"
 	"arr-proxy/internal/config"
 	"arr-proxy/internal/handlers/rest"
+	"arr-proxy/internal/lockout"
+	"arr-proxy/internal/tokens"
 	"arr-proxy/internal/usecases"
 )
 
 // Run starts the proxy server and returns a shutdown function.
 func Run(cfg *config.Config) (func(ctx context.Context), error) {
 	proxyUseCase := usecases.NewProxyUseCase()
-	proxyHandler := rest.NewProxyHandler(cfg, proxyUseCase)
-	infoHandler := rest.NewInfoHandler(cfg)
+	candidateReport := usecases.NewCandidateReport()
 
-	srv, err := rest.New(cfg, proxyHandler, infoHandler)
+	var learner *usecases.Learner
+	if cfg.Server.LearnFile != "" {
+		l, err := usecases.NewLearner(cfg.Server.LearnFile)
+		if err != nil {
+			return nil, err
+		}
+		slog.Info("Learning mode enabled", "file", cfg.Server.LearnFile)
+		learner = l
+	}
+
+	var keyStore *apikeys.Store
+	if cfg.Server.KeyStore != "" {
+		s, err := apikeys.OpenStore(cfg.Server.KeyStore)
+		if err != nil {
+			_ = learner.Close()
+			return nil, err
+		}
+		keyStore = s
+	}
+
+	var guard *lockout.Guard
+	if cfg.Auth.Lockout.Enabled() {
+		lo := cfg.Auth.Lockout
+		slog.Info("Brute-force lockout enabled", "threshold", lo.Threshold, "window", lo.Window, "ban_time", lo.BanTime, "max_ban_time", lo.MaxBanTime)
+		guard = lockout.New(lockout.Options{Threshold: lo.Threshold, Window: lo.Window, BanTime: lo.BanTime, MaxBanTime: lo.MaxBanTime})
+	}
+
+	var minter *tokens.Minter
+	if cfg.TokensEnabled() {
+		m, err := tokens.NewMinter(cfg.Auth.Token.Algorithm, []byte(cfg.Auth.Token.Key))
+		if err != nil {
+			_ = learner.Close()
+			return nil, err
+		}
+		slog.Info("Token endpoint enabled", "algorithm", cfg.Auth.Token.Algorithm, "max_ttl", cfg.Auth.Token.MaxTTL)
+		minter = m
+	}
+	secrets := rest.NewSecrets(cfg, minter)
+
+	handlers := rest.Handlers{
+		Proxy: rest.NewProxyHandler(cfg, secrets, proxyUseCase, candidateReport, learner),
+		Info:  rest.NewInfoHandler(cfg, candidateReport),
+	}
+	if cfg.AdminEnabled() {
+		slog.Info("Admin API enabled", "key_store", cfg.Server.KeyStore, "lockout", guard != nil)
+		handlers.Admin = rest.NewAdminHandler(cfg, keyStore, guard)
+	}
+
+	creds := rest.Credentials{Secrets: secrets, KeyStore: keyStore, Minter: minter, Lockout: guard}
+	if minter != nil {
+		handlers.Token = rest.NewTokenHandler(cfg, minter)
+	}
+	if cfg.Enrollment.Enabled() {
+		authority, err := ca.Open(cfg.Enrollment.CADir)
+		if err != nil {
+			_ = learner.Close()
+			return nil, err
+		}
+		slog.Info("Certificate enrollment enabled", "ca_dir", cfg.Enrollment.CADir, "validity", cfg.Enrollment.Validity)
+		handlers.Enroll = rest.NewEnrollHandler(cfg, authority)
+	}
+	if cfg.UsesAuth(config.AuthModeJWT) {
+		jwt := cfg.Auth.JWT
+		keys := tokens.NewJWKS(jwt.JWKS, jwt.JWKSRefresh)
+		if err := keys.Refresh(context.Background()); err != nil {
+			// A key set URL may be unreachable until the identity provider is up
+			if !tokens.IsURL(jwt.JWKS) {
+				_ = learner.Close()
+				return nil, err
+			}
+			slog.Warn("Failed to load JWKS, will retry on requests", "source", jwt.JWKS, "error", err)
+		}
+		slog.Info("JWT authentication enabled", "jwks", jwt.JWKS, "issuer", jwt.Issuer, "audience", jwt.Audience)
+		creds.JWT = tokens.NewValidator(keys, jwt.Issuer, jwt.Audience)
+	}
+
+	srv, err := rest.New(cfg, handlers, creds)
 	if err != nil {
+		_ = learner.Close()
 		return nil, err
 	}
 
@@ -26,12 +109,46 @@ func Run(cfg *config.Config) (func(ctx context.Context), error) {
 			slog.Error("HTTP server error", "error", err)
 		}
 	}()
+	reloadCtx, stopReload := context.WithCancel(context.Background())
+	go reloadSecrets(reloadCtx, secrets)
 
 	stop := func(ctx context.Context) {
+		stopReload()
 		if err := srv.Shutdown(ctx); err != nil {
 			slog.Error("HTTP server shutdown error", "error", err)
 		}
+		if err := learner.Close(); err != nil {
+			slog.Error("Failed to close learning file", "error", err)
+		}
+		if err := keyStore.Close(); err != nil {
+			slog.Error("Failed to write key store", "error", err)
+		}
 	}
 
 	return stop, nil
 }
+
+// reloadSecrets loads the configuration again on SIGHUP and applies its secrets,
+// so rotated secret files, environment references and encrypted values take
+// effect without a restart. Other settings still require a restart.
+func reloadSecrets(ctx context.Context, secrets *rest.Secrets) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			slog.Info("SIGHUP received, reloading secrets")
+			cfg, err := config.Load()
+			if err == nil {
+				err = secrets.Reload(&cfg)
+			}
+			if err != nil {
+				slog.Error("Failed to reload secrets, keeping previous secrets", "error", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,128 @@
+// Package apikeys generates, hashes and verifies proxy API keys.
+package apikeys
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// hashPrefix marks a salted SHA-256 key hash: "sha256:<salt>:<digest>", both
+// parts base64 (raw URL) encoded.
+const hashPrefix = "sha256:"
+
+const (
+	keySize  = 32
+	saltSize = 16
+)
+
+// Generate returns a new random API key.
+func Generate() (string, error) {
+	key := make([]byte, keySize)
+	if _, err := rand.Read(key); err != nil {
+		return "", fmt.Errorf("failed to generate key: %w", err)
+	}
+	return hex.EncodeToString(key), nil
+}
+
+// Hash returns a salted hash of key for storing in the configuration.
+func Hash(key string) (string, error) {
+	salt := make([]byte, saltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return "", fmt.Errorf("failed to generate salt: %w", err)
+	}
+	return hashPrefix + base64.RawURLEncoding.EncodeToString(salt) + ":" + base64.RawURLEncoding.EncodeToString(digest(salt, key)), nil
+}
+
+// IsHash returns true if s is a well-formed key hash produced by Hash.
+func IsHash(s string) bool {
+	_, _, ok := parseHash(s)
+	return ok
+}
+
+// Verify reports whether key matches hash in constant time.
+func Verify(hash, key string) bool {
+	salt, want, ok := parseHash(hash)
+	if !ok {
+		return false
+	}
+	return subtle.ConstantTimeCompare(digest(salt, key), want) == 1
+}
+
+func digest(salt []byte, key string) []byte {
+	h := sha256.New()
+	h.Write(salt)
+	h.Write([]byte(key))
+	return h.Sum(nil)
+}
+
+func parseHash(s string) (salt, sum []byte, ok bool) {
+	rest, found := strings.CutPrefix(s, hashPrefix)
+	if !found {
+		return nil, nil, false
+	}
+	saltPart, sumPart, found := strings.Cut(rest, ":")
+	if !found {
+		return nil, nil, false
+	}
+	salt, err := base64.RawURLEncoding.DecodeString(saltPart)
+	if err != nil || len(salt) < 8 {
+		return nil, nil, false
+	}
+	sum, err = base64.RawURLEncoding.DecodeString(sumPart)
+	if err != nil || len(sum) != sha256.Size {
+		return nil, nil, false
+	}
+	return salt, sum, true
+}
+
+type entry struct {
+	identity string
+	key      string // hash, or plaintext for the legacy shared key
+}
+
+// Set holds the active API keys and the identities they belong to.
+// An identity may have several keys, which allows rotating keys without downtime.
+type Set struct {
+	entries []entry
+}
+
+// NewSet creates an empty key set.
+func NewSet() *Set {
+	return &Set{}
+}
+
+// Add registers a key for identity. key is a hash produced by Hash; any other
+// value is treated as a plaintext key.
+func (s *Set) Add(identity, key string) {
+	if key == "" {
+		return
+	}
+	s.entries = append(s.entries, entry{identity: identity, key: key})
+}
+
+// Len returns the number of registered keys.
+func (s *Set) Len() int {
+	return len(s.entries)
+}
+
+// Lookup returns the identity owning key.
+func (s *Set) Lookup(key string) (string, bool) {
+	if key == "" {
+		return "", false
+	}
+	for _, e := range s.entries {
+		if IsHash(e.key) {
+			if Verify(e.key, key) {
+				return e.identity, true
+			}
+		} else if subtle.ConstantTimeCompare([]byte(key), []byte(e.key)) == 1 {
+			return e.identity, true
+		}
+	}
+	return "", false
+}
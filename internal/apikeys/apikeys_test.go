@@ -0,0 +1,84 @@
+package apikeys
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestHashVerify(t *testing.T) {
+	key, err := Generate()
+	if err != nil {
+		t.Fatalf("Generate() unexpected error: %v", err)
+	}
+	hash, err := Hash(key)
+	if err != nil {
+		t.Fatalf("Hash() unexpected error: %v", err)
+	}
+	if !IsHash(hash) || strings.Contains(hash, key) {
+		t.Fatalf("Hash() = %q, want a well-formed hash not containing the key", hash)
+	}
+
+	other, _ := Hash(key)
+	if other == hash {
+		t.Errorf("Hash() returned the same hash twice, want a random salt")
+	}
+
+	tests := []struct {
+		name string
+		hash string
+		key  string
+		want bool
+	}{
+		{name: "matching key", hash: hash, key: key, want: true},
+		{name: "second hash of same key", hash: other, key: key, want: true},
+		{name: "wrong key", hash: hash, key: key + "x", want: false},
+		{name: "empty key", hash: hash, key: "", want: false},
+		{name: "malformed hash", hash: "sha256:abc", key: key, want: false},
+		{name: "plaintext is not a hash", hash: key, key: key, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Verify(tt.hash, tt.key); got != tt.want {
+				t.Errorf("Verify() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSetLookup(t *testing.T) {
+	oldHash, _ := Hash("old-key")
+	newHash, _ := Hash("new-key")
+	botHash, _ := Hash("bot-key")
+
+	s := NewSet()
+	s.Add("default", "legacy-plaintext")
+	s.Add("alice", oldHash)
+	s.Add("alice", newHash)
+	s.Add("bot", botHash)
+	s.Add("nobody", "")
+
+	tests := []struct {
+		key          string
+		wantIdentity string
+		wantOK       bool
+	}{
+		{key: "legacy-plaintext", wantIdentity: "default", wantOK: true},
+		{key: "old-key", wantIdentity: "alice", wantOK: true},
+		{key: "new-key", wantIdentity: "alice", wantOK: true},
+		{key: "bot-key", wantIdentity: "bot", wantOK: true},
+		{key: oldHash, wantOK: false},
+		{key: "", wantOK: false},
+		{key: "unknown", wantOK: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.key, func(t *testing.T) {
+			identity, ok := s.Lookup(tt.key)
+			if identity != tt.wantIdentity || ok != tt.wantOK {
+				t.Errorf("Lookup(%q) = %q, %v, want %q, %v", tt.key, identity, ok, tt.wantIdentity, tt.wantOK)
+			}
+		})
+	}
+	if s.Len() != 4 {
+		t.Errorf("Len() = %d, want 4", s.Len())
+	}
+}
@@ -0,0 +1,281 @@
+package apikeys
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ErrNotFound is returned for operations on unknown key IDs.
+var ErrNotFound = errors.New("key not found")
+
+// ErrRevoked is returned when modifying a revoked key.
+var ErrRevoked = errors.New("key is revoked")
+
+// lastUsedFlushInterval limits how often last-used timestamps are written to disk.
+const lastUsedFlushInterval = time.Minute
+
+// prefixLen is the number of key characters kept for identifying masked keys.
+const prefixLen = 6
+
+// Record is a managed API key. The key itself is never stored, only its hash.
+type Record struct {
+	ID         string     `json:"id"`
+	Owner      string     `json:"owner"`              // identity the key authenticates as
+	Prefix     string     `json:"prefix"`             // first characters of the key
+	Hash       string     `json:"hash"`               // salted hash, see Hash
+	Services   []string   `json:"services,omitempty"` // empty means all services the owner may access
+	CreatedAt  time.Time  `json:"created_at"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+}
+
+// Active returns true if the key is neither revoked nor expired at now.
+func (r *Record) Active(now time.Time) bool {
+	return r.RevokedAt == nil && (r.ExpiresAt == nil || now.Before(*r.ExpiresAt))
+}
+
+// Masked returns the key prefix followed by a mask, for display.
+func (r *Record) Masked() string {
+	return r.Prefix + "********"
+}
+
+type storeFile struct {
+	Keys []*Record `json:"keys"`
+}
+
+// Store is a file-backed collection of managed API keys. All changes are written
+// to the file immediately; last-used timestamps are written at most once a minute per key.
+type Store struct {
+	mu      sync.Mutex
+	path    string
+	records []*Record
+	flushed map[string]time.Time // last persisted last-used time per key ID
+	now     func() time.Time
+}
+
+// OpenStore loads the store from path. A missing file is created on the first change.
+func OpenStore(path string) (*Store, error) {
+	s := &Store{path: path, flushed: make(map[string]time.Time), now: func() time.Time { return time.Now().UTC() }}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key store: %w", err)
+	}
+	var f storeFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("failed to parse key store %s: %w", path, err)
+	}
+	for _, r := range f.Keys {
+		if !IsHash(r.Hash) {
+			return nil, fmt.Errorf("key store %s: key %q has an invalid hash", path, r.ID)
+		}
+	}
+	s.records = f.Keys
+	return s, nil
+}
+
+// Create adds a new key for owner and returns its record and the key, which is
+// not retrievable later.
+func (s *Store) Create(owner string, services []string, expiresAt *time.Time) (Record, string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r, key, err := s.newRecord(owner, services, expiresAt)
+	if err != nil {
+		return Record{}, "", err
+	}
+	s.records = append(s.records, r)
+	if err := s.save(); err != nil {
+		s.records = s.records[:len(s.records)-1]
+		return Record{}, "", err
+	}
+	return *r, key, nil
+}
+
+// List returns all records, including revoked and expired ones, sorted by creation time.
+func (s *Store) List() []Record {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	list := make([]Record, 0, len(s.records))
+	for _, r := range s.records {
+		list = append(list, *r)
+	}
+	sort.SliceStable(list, func(i, j int) bool { return list[i].CreatedAt.Before(list[j].CreatedAt) })
+	return list
+}
+
+// Rotate creates a replacement key with the same owner, services and expiry. The
+// old key stays valid for grace (immediately revoked if grace is zero).
+func (s *Store) Rotate(id string, grace time.Duration) (Record, string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	old, err := s.find(id)
+	if err != nil {
+		return Record{}, "", err
+	}
+	if old.RevokedAt != nil {
+		return Record{}, "", ErrRevoked
+	}
+	r, key, err := s.newRecord(old.Owner, old.Services, old.ExpiresAt)
+	if err != nil {
+		return Record{}, "", err
+	}
+
+	previous := *old
+	now := s.now()
+	if grace > 0 {
+		end := now.Add(grace)
+		if old.ExpiresAt == nil || end.Before(*old.ExpiresAt) {
+			old.ExpiresAt = &end
+		}
+	} else {
+		old.RevokedAt = &now
+	}
+	s.records = append(s.records, r)
+	if err := s.save(); err != nil {
+		*old = previous
+		s.records = s.records[:len(s.records)-1]
+		return Record{}, "", err
+	}
+	return *r, key, nil
+}
+
+// Expire sets the expiry of a key. A time in the past disables the key.
+func (s *Store) Expire(id string, at time.Time) (Record, error) {
+	return s.update(id, func(r *Record) { r.ExpiresAt = &at })
+}
+
+// Revoke permanently disables a key. The record is kept for auditing.
+func (s *Store) Revoke(id string) (Record, error) {
+	now := s.now()
+	return s.update(id, func(r *Record) { r.RevokedAt = &now })
+}
+
+// Lookup returns the active record matching key and records its use.
+func (s *Store) Lookup(key string) (Record, bool) {
+	if key == "" {
+		return Record{}, false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	for _, r := range s.records {
+		if !r.Active(now) || !Verify(r.Hash, key) {
+			continue
+		}
+		r.LastUsedAt = &now
+		if now.Sub(s.flushed[r.ID]) >= lastUsedFlushInterval {
+			s.flushed[r.ID] = now
+			if err := s.save(); err != nil {
+				// Authentication must not fail because of bookkeeping
+				s.flushed[r.ID] = time.Time{}
+			}
+		}
+		return *r, true
+	}
+	return Record{}, false
+}
+
+// Close writes pending last-used timestamps.
+func (s *Store) Close() error {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.records) == 0 {
+		return nil
+	}
+	return s.save()
+}
+
+func (s *Store) update(id string, change func(r *Record)) (Record, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r, err := s.find(id)
+	if err != nil {
+		return Record{}, err
+	}
+	if r.RevokedAt != nil {
+		return Record{}, ErrRevoked
+	}
+	previous := *r
+	change(r)
+	if err := s.save(); err != nil {
+		*r = previous
+		return Record{}, err
+	}
+	return *r, nil
+}
+
+func (s *Store) find(id string) (*Record, error) {
+	for _, r := range s.records {
+		if r.ID == id {
+			return r, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (s *Store) newRecord(owner string, services []string, expiresAt *time.Time) (*Record, string, error) {
+	key, err := Generate()
+	if err != nil {
+		return nil, "", err
+	}
+	hash, err := Hash(key)
+	if err != nil {
+		return nil, "", err
+	}
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return nil, "", fmt.Errorf("failed to generate key id: %w", err)
+	}
+	return &Record{
+		ID:        hex.EncodeToString(id),
+		Owner:     owner,
+		Prefix:    key[:prefixLen],
+		Hash:      hash,
+		Services:  services,
+		CreatedAt: s.now(),
+		ExpiresAt: expiresAt,
+	}, key, nil
+}
+
+// save writes the store atomically. Callers must hold s.mu.
+func (s *Store) save() error {
+	data, err := json.MarshalIndent(storeFile{Keys: s.records}, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode key store: %w", err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".tmp*")
+	if err != nil {
+		return fmt.Errorf("failed to write key store: %w", err)
+	}
+	defer func() { _ = os.Remove(tmp.Name()) }()
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		_ = tmp.Close()
+		return fmt.Errorf("failed to write key store: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write key store: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		return fmt.Errorf("failed to write key store: %w", err)
+	}
+	return nil
+}
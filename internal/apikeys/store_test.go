@@ -0,0 +1,112 @@
+package apikeys
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStoreLifecycle(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys.json")
+	s, err := OpenStore(path)
+	if err != nil {
+		t.Fatalf("OpenStore() unexpected error: %v", err)
+	}
+	now := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	s.now = func() time.Time { return now }
+
+	rec, key, err := s.Create("alice", []string{"sonarr"}, nil)
+	if err != nil {
+		t.Fatalf("Create() unexpected error: %v", err)
+	}
+	if !strings.HasPrefix(key, rec.Prefix) || rec.Masked() == key || rec.Owner != "alice" {
+		t.Errorf("Create() = %+v, want record of alice with masked key prefix", rec)
+	}
+	data, _ := os.ReadFile(path)
+	if strings.Contains(string(data), key) {
+		t.Errorf("key store file contains the plaintext key")
+	}
+
+	got, ok := s.Lookup(key)
+	if !ok || got.ID != rec.ID || got.LastUsedAt == nil {
+		t.Fatalf("Lookup() = %+v, %v, want record %s with last use", got, ok, rec.ID)
+	}
+
+	// Rotation keeps the old key valid during the grace period
+	newRec, newKey, err := s.Rotate(rec.ID, time.Hour)
+	if err != nil {
+		t.Fatalf("Rotate() unexpected error: %v", err)
+	}
+	if newRec.Owner != "alice" || len(newRec.Services) != 1 {
+		t.Errorf("Rotate() = %+v, want same owner and services", newRec)
+	}
+	if _, ok := s.Lookup(key); !ok {
+		t.Errorf("Lookup(old key) during grace period failed")
+	}
+	now = now.Add(2 * time.Hour)
+	if _, ok := s.Lookup(key); ok {
+		t.Errorf("Lookup(old key) after grace period succeeded")
+	}
+	if _, ok := s.Lookup(newKey); !ok {
+		t.Errorf("Lookup(new key) failed")
+	}
+
+	// Expiry and revocation
+	if _, err := s.Expire(newRec.ID, now.Add(-time.Second)); err != nil {
+		t.Fatalf("Expire() unexpected error: %v", err)
+	}
+	if _, ok := s.Lookup(newKey); ok {
+		t.Errorf("Lookup(expired key) succeeded")
+	}
+	if _, err := s.Expire(newRec.ID, now.Add(time.Hour)); err != nil {
+		t.Fatalf("Expire() unexpected error: %v", err)
+	}
+	if _, err := s.Revoke(newRec.ID); err != nil {
+		t.Fatalf("Revoke() unexpected error: %v", err)
+	}
+	if _, ok := s.Lookup(newKey); ok {
+		t.Errorf("Lookup(revoked key) succeeded")
+	}
+	if _, err := s.Expire(newRec.ID, now.Add(time.Hour)); !errors.Is(err, ErrRevoked) {
+		t.Errorf("Expire(revoked) error = %v, want ErrRevoked", err)
+	}
+	if _, err := s.Revoke("missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Revoke(missing) error = %v, want ErrNotFound", err)
+	}
+
+	// Everything is persisted
+	reopened, err := OpenStore(path)
+	if err != nil {
+		t.Fatalf("OpenStore() reopen unexpected error: %v", err)
+	}
+	list := reopened.List()
+	if len(list) != 2 || list[0].ID != rec.ID || list[1].RevokedAt == nil {
+		t.Errorf("List() after reopen = %+v, want both keys with revocation", list)
+	}
+}
+
+func TestOpenStoreInvalid(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		name    string
+		content string
+		wantErr string
+	}{
+		{name: "invalid json", content: "{", wantErr: "failed to parse"},
+		{name: "plaintext hash", content: `{"keys":[{"id":"a","owner":"x","hash":"plain"}]}`, wantErr: "invalid hash"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(dir, tt.name+".json")
+			if err := os.WriteFile(path, []byte(tt.content), 0600); err != nil {
+				t.Fatalf("failed to write store: %v", err)
+			}
+			if _, err := OpenStore(path); err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("OpenStore() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,453 @@
+// Package ca implements a small file-based certificate authority that issues client
+// and server certificates and publishes a CRL of the revoked ones for the proxy.
+package ca
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Files in the CA directory.
+const (
+	CertFile  = "ca.crt"     // CA certificate, for APP_CA_CERT
+	KeyFile   = "ca.key"     // CA private key
+	CRLFile   = "ca.crl"     // revocation list, for APP_CRL_FILES
+	IndexFile = "index.json" // issued certificates
+)
+
+// Certificate kinds.
+const (
+	KindClient = "client"
+	KindServer = "server"
+)
+
+// crlValidity is how long a published CRL stays current. The proxy keeps using an
+// outdated CRL, with a warning, so it is renewed on every change and by "ca crl".
+const crlValidity = 90 * 24 * time.Hour
+
+var (
+	// ErrExists is returned by Init if the directory already holds a CA.
+	ErrExists = errors.New("CA already exists")
+	// ErrNotFound is returned by Revoke if no active certificate matches.
+	ErrNotFound = errors.New("no active certificate found")
+)
+
+// Record is a certificate issued by the CA.
+type Record struct {
+	Serial      string     `json:"serial"` // lowercase hex
+	Name        string     `json:"name"`   // subject common name
+	Kind        string     `json:"kind"`
+	Fingerprint string     `json:"fingerprint"` // hex SHA-256 of the certificate
+	IssuedAt    time.Time  `json:"issued_at"`
+	NotAfter    time.Time  `json:"not_after"`
+	RevokedAt   *time.Time `json:"revoked_at,omitempty"`
+	Enrollment  string     `json:"enrollment,omitempty"`  // ID of the enrollment token, if enrolled
+	RemoteAddr  string     `json:"remote_addr,omitempty"` // address the CSR was posted from, if enrolled
+}
+
+type indexFile struct {
+	CRLNumber    int64     `json:"crl_number"`
+	Certificates []*Record `json:"certificates"`
+}
+
+// Request describes a certificate to issue.
+type Request struct {
+	Kind     string
+	Name     string // subject common name; client certificates map to the client of this name
+	DNSNames []string
+	IPs      []net.IP
+	Emails   []string
+	OU       []string
+	Validity time.Duration
+}
+
+// CA is a certificate authority stored in a directory. All changes are written to
+// the directory immediately, and the index is read again before each change so the
+// command line and a running proxy can share the directory.
+type CA struct {
+	mu    sync.Mutex
+	dir   string
+	cert  *x509.Certificate
+	key   crypto.Signer
+	index indexFile
+	now   func() time.Time
+}
+
+// Init creates a CA named name in dir, with a self-signed certificate valid for
+// validity and an empty CRL.
+func Init(dir, name string, validity time.Duration) (*CA, error) {
+	if _, err := os.Stat(filepath.Join(dir, CertFile)); err == nil {
+		return nil, fmt.Errorf("%w in %s", ErrExists, dir)
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	serial, err := newSerial()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now().UTC()
+	tmpl := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(validity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLenZero:        true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, key.Public(), key)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+	keyPEM, err := EncodeKeyPEM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &CA{dir: dir, cert: cert, key: key, now: func() time.Time { return time.Now().UTC() }}
+	if err := writeFile(filepath.Join(dir, KeyFile), keyPEM, 0o600); err != nil {
+		return nil, err
+	}
+	if err := writeFile(filepath.Join(dir, CertFile), EncodeCertPEM(cert), 0o644); err != nil {
+		return nil, err
+	}
+	if err := c.save(); err != nil {
+		return nil, err
+	}
+	if err := c.writeCRL(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Open loads the CA stored in dir.
+func Open(dir string) (*CA, error) {
+	certPEM, err := os.ReadFile(filepath.Join(dir, CertFile))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CA certificate: %w", err)
+	}
+	block, _ := pem.Decode(certPEM)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, fmt.Errorf("%s: no PEM certificate", CertFile)
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", CertFile, err)
+	}
+	keyPEM, err := os.ReadFile(filepath.Join(dir, KeyFile))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CA key: %w", err)
+	}
+	key, err := ParseKeyPEM(keyPEM)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", KeyFile, err)
+	}
+
+	c := &CA{dir: dir, cert: cert, key: key, now: func() time.Time { return time.Now().UTC() }}
+	if err := c.load(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// load reads the index. The caller must hold mu, except in Open.
+func (c *CA) load() error {
+	data, err := os.ReadFile(filepath.Join(c.dir, IndexFile))
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		// A CA created with other tools has not issued anything through this package
+		c.index = indexFile{}
+		return nil
+	case err != nil:
+		return fmt.Errorf("failed to read CA index: %w", err)
+	}
+	var index indexFile
+	if err := json.Unmarshal(data, &index); err != nil {
+		return fmt.Errorf("failed to parse CA index %s: %w", IndexFile, err)
+	}
+	c.index = index
+	return nil
+}
+
+// Certificate returns the CA certificate.
+func (c *CA) Certificate() *x509.Certificate {
+	return c.cert
+}
+
+// Dir returns the CA directory.
+func (c *CA) Dir() string {
+	return c.dir
+}
+
+// Issue generates a key pair and a certificate for it.
+func (c *CA) Issue(req Request) (*x509.Certificate, crypto.Signer, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	cert, err := c.Sign(req, key.Public())
+	if err != nil {
+		return nil, nil, err
+	}
+	return cert, key, nil
+}
+
+// Sign issues a certificate for pub and records it in the index.
+func (c *CA) Sign(req Request, pub crypto.PublicKey) (*x509.Certificate, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.sign(req, pub, Record{})
+}
+
+// sign issues a certificate and records it with the audit fields of rec. The
+// caller must hold mu.
+func (c *CA) sign(req Request, pub crypto.PublicKey, rec Record) (*x509.Certificate, error) {
+	if req.Name == "" {
+		return nil, errors.New("certificate name is required")
+	}
+	if req.Validity <= 0 {
+		return nil, errors.New("certificate validity must be positive")
+	}
+	serial, err := newSerial()
+	if err != nil {
+		return nil, err
+	}
+	if err := c.load(); err != nil {
+		return nil, err
+	}
+
+	now := c.now()
+	notAfter := now.Add(req.Validity)
+	if notAfter.After(c.cert.NotAfter) {
+		notAfter = c.cert.NotAfter
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:   serial,
+		Subject:        pkix.Name{CommonName: req.Name, OrganizationalUnit: req.OU},
+		NotBefore:      now.Add(-5 * time.Minute), // tolerate clock skew
+		NotAfter:       notAfter,
+		DNSNames:       req.DNSNames,
+		IPAddresses:    req.IPs,
+		EmailAddresses: req.Emails,
+		KeyUsage:       x509.KeyUsageDigitalSignature,
+	}
+	switch req.Kind {
+	case KindClient:
+		tmpl.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}
+	case KindServer:
+		tmpl.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+		if _, ok := pub.(*ecdsa.PublicKey); !ok {
+			// RSA key exchange in TLS 1.2 encrypts with the server key
+			tmpl.KeyUsage |= x509.KeyUsageKeyEncipherment
+		}
+	default:
+		return nil, fmt.Errorf("unknown certificate kind %q", req.Kind)
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, c.cert, pub, c.key)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(der)
+	rec.Serial = cert.SerialNumber.Text(16)
+	rec.Name = req.Name
+	rec.Kind = req.Kind
+	rec.Fingerprint = hex.EncodeToString(sum[:])
+	rec.IssuedAt = now
+	rec.NotAfter = notAfter
+	c.index.Certificates = append(c.index.Certificates, &rec)
+	if err := c.save(); err != nil {
+		return nil, err
+	}
+	return cert, nil
+}
+
+// Revoke revokes the active certificates whose name or hex serial number is ref,
+// and publishes a new CRL.
+func (c *CA) Revoke(ref string) ([]Record, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.load(); err != nil {
+		return nil, err
+	}
+
+	serial, isSerial := new(big.Int).SetString(strings.ReplaceAll(strings.ToLower(ref), ":", ""), 16)
+	now := c.now()
+	var revoked []Record
+	for _, r := range c.index.Certificates {
+		if r.RevokedAt != nil {
+			continue
+		}
+		if r.Name == ref || (isSerial && r.Serial == serial.Text(16)) {
+			r.RevokedAt = &now
+			revoked = append(revoked, *r)
+		}
+	}
+	if len(revoked) == 0 {
+		return nil, fmt.Errorf("%w for %q", ErrNotFound, ref)
+	}
+	if err := c.save(); err != nil {
+		return nil, err
+	}
+	if err := c.writeCRL(); err != nil {
+		return nil, err
+	}
+	return revoked, nil
+}
+
+// PublishCRL signs a new CRL with the current revocations, renewing its validity.
+func (c *CA) PublishCRL() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.load(); err != nil {
+		return err
+	}
+	return c.writeCRL()
+}
+
+// Records returns the issued certificates, oldest first.
+func (c *CA) Records() ([]Record, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.load(); err != nil {
+		return nil, err
+	}
+	records := make([]Record, len(c.index.Certificates))
+	for i, r := range c.index.Certificates {
+		records[i] = *r
+	}
+	return records, nil
+}
+
+// writeCRL signs and writes the CRL. The caller must hold mu.
+func (c *CA) writeCRL() error {
+	var entries []x509.RevocationListEntry
+	for _, r := range c.index.Certificates {
+		if r.RevokedAt == nil {
+			continue
+		}
+		serial, ok := new(big.Int).SetString(r.Serial, 16)
+		if !ok {
+			return fmt.Errorf("invalid serial %q in CA index", r.Serial)
+		}
+		entries = append(entries, x509.RevocationListEntry{SerialNumber: serial, RevocationTime: *r.RevokedAt})
+	}
+
+	now := c.now()
+	c.index.CRLNumber++
+	der, err := x509.CreateRevocationList(rand.Reader, &x509.RevocationList{
+		Number:                    big.NewInt(c.index.CRLNumber),
+		ThisUpdate:                now,
+		NextUpdate:                now.Add(crlValidity),
+		RevokedCertificateEntries: entries,
+	}, c.cert, c.key)
+	if err != nil {
+		return err
+	}
+	if err := c.save(); err != nil {
+		return err
+	}
+	return writeFile(filepath.Join(c.dir, CRLFile), pem.EncodeToMemory(&pem.Block{Type: "X509 CRL", Bytes: der}), 0o644)
+}
+
+// save writes the index. The caller must hold mu.
+func (c *CA) save() error {
+	data, err := json.MarshalIndent(&c.index, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFile(filepath.Join(c.dir, IndexFile), append(data, '\n'), 0o600)
+}
+
+// writeFile replaces path atomically, so the proxy never reads a partial CRL.
+func writeFile(path string, data []byte, perm os.FileMode) error {
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, perm); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// newSerial returns a random positive 128-bit serial number.
+func newSerial() (*big.Int, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 127))
+	if err != nil {
+		return nil, err
+	}
+	return serial.Add(serial, big.NewInt(1)), nil
+}
+
+// EncodeCertPEM returns cert as a PEM block.
+func EncodeCertPEM(cert *x509.Certificate) []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+}
+
+// EncodeKeyPEM returns key as an unencrypted PKCS#8 PEM block.
+func EncodeKeyPEM(key crypto.Signer) ([]byte, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
+}
+
+// ParseKeyPEM parses a PKCS#8, PKCS#1 or SEC 1 PEM private key, so CAs created with
+// openssl can be used too.
+func ParseKeyPEM(data []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM private key")
+	}
+	var key any
+	var err error
+	switch block.Type {
+	case "PRIVATE KEY":
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported PEM block %q", block.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported key type %T", key)
+	}
+	return signer, nil
+}
@@ -0,0 +1,106 @@
+package ca
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"arr-proxy/internal/revocation"
+)
+
+func TestCA(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "ca")
+	c, err := Init(dir, "Home CA", 24*time.Hour)
+	if err != nil {
+		t.Fatalf("Init failed: %v", err)
+	}
+	if _, err := Init(dir, "Home CA", time.Hour); !errors.Is(err, ErrExists) {
+		t.Errorf("second Init error = %v, want ErrExists", err)
+	}
+
+	phone, _, err := c.Issue(Request{Kind: KindClient, Name: "phone", Validity: time.Hour})
+	if err != nil {
+		t.Fatalf("Issue failed: %v", err)
+	}
+	laptop, _, err := c.Issue(Request{Kind: KindClient, Name: "laptop", Validity: 48 * time.Hour})
+	if err != nil {
+		t.Fatalf("Issue failed: %v", err)
+	}
+	if !laptop.NotAfter.Equal(c.Certificate().NotAfter) {
+		t.Errorf("laptop NotAfter = %v, want capped at CA expiry %v", laptop.NotAfter, c.Certificate().NotAfter)
+	}
+
+	roots := x509.NewCertPool()
+	roots.AddCert(c.Certificate())
+	opts := x509.VerifyOptions{Roots: roots, KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}}
+	if _, err := phone.Verify(opts); err != nil {
+		t.Errorf("client certificate does not verify: %v", err)
+	}
+	server, _, err := c.Issue(Request{Kind: KindServer, Name: "proxy.home", DNSNames: []string{"proxy.home"}, Validity: time.Hour})
+	if err != nil {
+		t.Fatalf("Issue failed: %v", err)
+	}
+	opts = x509.VerifyOptions{Roots: roots, DNSName: "proxy.home"}
+	if _, err := server.Verify(opts); err != nil {
+		t.Errorf("server certificate does not verify: %v", err)
+	}
+
+	// Revocations persist and are published in the CRL the proxy checks
+	c, err = Open(dir)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	if records, err := c.Records(); err != nil || len(records) != 3 {
+		t.Fatalf("reopened CA has %d records, want 3 (%v)", len(records), err)
+	}
+	revoked, err := c.Revoke("phone")
+	if err != nil || len(revoked) != 1 || revoked[0].Serial != phone.SerialNumber.Text(16) {
+		t.Fatalf("Revoke = %+v, %v", revoked, err)
+	}
+	if _, err := c.Revoke("phone"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("second Revoke error = %v, want ErrNotFound", err)
+	}
+	if _, err := c.Revoke(laptop.SerialNumber.Text(16)); err != nil {
+		t.Errorf("Revoke by serial failed: %v", err)
+	}
+
+	checker, err := revocation.NewChecker([]string{filepath.Join(dir, CRLFile)}, "", []*x509.Certificate{c.Certificate()})
+	if err != nil {
+		t.Fatalf("CRL not accepted by the revocation checker: %v", err)
+	}
+	for _, tc := range []struct {
+		cert *x509.Certificate
+		want error
+	}{
+		{phone, revocation.ErrRevoked},
+		{laptop, revocation.ErrRevoked},
+		{server, nil},
+	} {
+		err := checker.VerifyConnection(tls.ConnectionState{PeerCertificates: []*x509.Certificate{tc.cert, c.Certificate()}})
+		if !errors.Is(err, tc.want) {
+			t.Errorf("%s: VerifyConnection = %v, want %v", tc.cert.Subject.CommonName, err, tc.want)
+		}
+	}
+}
+
+func TestSignRejectsInvalidRequests(t *testing.T) {
+	c, err := Init(t.TempDir(), "Home CA", time.Hour)
+	if err != nil {
+		t.Fatalf("Init failed: %v", err)
+	}
+	for name, req := range map[string]Request{
+		"no name":     {Kind: KindClient, Validity: time.Hour},
+		"no validity": {Kind: KindClient, Name: "phone"},
+		"bad kind":    {Kind: "peer", Name: "phone", Validity: time.Hour},
+	} {
+		if _, _, err := c.Issue(req); err == nil {
+			t.Errorf("%s: Issue succeeded, want error", name)
+		}
+	}
+	if records, _ := c.Records(); len(records) != 0 {
+		t.Errorf("failed requests recorded %d certificates", len(records))
+	}
+}
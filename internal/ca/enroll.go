@@ -0,0 +1,193 @@
+package ca
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// EnrollmentsFile holds the enrollment tokens in the CA directory.
+const EnrollmentsFile = "enrollments.json"
+
+var (
+	// ErrInvalidToken is returned for unknown, expired and used enrollment tokens.
+	ErrInvalidToken = errors.New("invalid enrollment token")
+	// ErrInvalidCSR is returned for CSRs that cannot be certified.
+	ErrInvalidCSR = errors.New("invalid certificate request")
+)
+
+// Enrollment is a one-time token allowing a device to obtain a client certificate
+// for a pre-registered client name. Only the token hash is stored.
+type Enrollment struct {
+	ID         string     `json:"id"`
+	Name       string     `json:"name"` // client name, the CN of the certificate
+	Hash       string     `json:"hash"` // hex SHA-256 of the token
+	CreatedAt  time.Time  `json:"created_at"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+	UsedAt     *time.Time `json:"used_at,omitempty"`
+	Serial     string     `json:"serial,omitempty"`      // certificate issued with the token
+	RemoteAddr string     `json:"remote_addr,omitempty"` // address the token was used from
+}
+
+type enrollmentsFile struct {
+	Enrollments []*Enrollment `json:"enrollments"`
+}
+
+// CreateEnrollment registers a client name and returns a token, valid for ttl, with
+// which one certificate for the name can be enrolled.
+func (c *CA) CreateEnrollment(name string, ttl time.Duration) (string, Enrollment, error) {
+	if name == "" {
+		return "", Enrollment{}, errors.New("client name is required")
+	}
+	if ttl <= 0 {
+		return "", Enrollment{}, errors.New("enrollment token lifetime must be positive")
+	}
+	id := make([]byte, 4)
+	if _, err := rand.Read(id); err != nil {
+		return "", Enrollment{}, err
+	}
+	token := rand.Text()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	f, err := c.loadEnrollments()
+	if err != nil {
+		return "", Enrollment{}, err
+	}
+	now := c.now()
+	e := &Enrollment{
+		ID:        hex.EncodeToString(id),
+		Name:      name,
+		Hash:      hashToken(token),
+		CreatedAt: now,
+		ExpiresAt: now.Add(ttl),
+	}
+	f.Enrollments = append(f.Enrollments, e)
+	if err := c.saveEnrollments(f); err != nil {
+		return "", Enrollment{}, err
+	}
+	return token, *e, nil
+}
+
+// Enroll issues a client certificate for the public key of csr if token is a valid
+// enrollment token, and marks the token as used. The certificate is issued for the
+// enrollment's client name; the subject and extensions requested by the CSR are
+// ignored. remoteAddr is recorded for auditing.
+func (c *CA) Enroll(token string, csr *x509.CertificateRequest, validity time.Duration, remoteAddr string) (*x509.Certificate, Enrollment, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	f, err := c.loadEnrollments()
+	if err != nil {
+		return nil, Enrollment{}, err
+	}
+	hash := hashToken(token)
+	var e *Enrollment
+	for _, candidate := range f.Enrollments {
+		if subtle.ConstantTimeCompare([]byte(candidate.Hash), []byte(hash)) == 1 {
+			e = candidate
+		}
+	}
+	now := c.now()
+	switch {
+	case e == nil:
+		return nil, Enrollment{}, ErrInvalidToken
+	case e.UsedAt != nil:
+		return nil, *e, fmt.Errorf("%w: already used", ErrInvalidToken)
+	case !now.Before(e.ExpiresAt):
+		return nil, *e, fmt.Errorf("%w: expired", ErrInvalidToken)
+	}
+	if err := csr.CheckSignature(); err != nil {
+		return nil, *e, fmt.Errorf("%w: %v", ErrInvalidCSR, err)
+	}
+	if err := checkPublicKey(csr.PublicKey); err != nil {
+		return nil, *e, fmt.Errorf("%w: %v", ErrInvalidCSR, err)
+	}
+
+	req := Request{Kind: KindClient, Name: e.Name, Validity: validity}
+	cert, err := c.sign(req, csr.PublicKey, Record{Enrollment: e.ID, RemoteAddr: remoteAddr})
+	if err != nil {
+		return nil, *e, err
+	}
+	e.UsedAt = &now
+	e.Serial = cert.SerialNumber.Text(16)
+	e.RemoteAddr = remoteAddr
+	if err := c.saveEnrollments(f); err != nil {
+		return nil, *e, err
+	}
+	return cert, *e, nil
+}
+
+// Enrollments returns the enrollment tokens, oldest first.
+func (c *CA) Enrollments() ([]Enrollment, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	f, err := c.loadEnrollments()
+	if err != nil {
+		return nil, err
+	}
+	enrollments := make([]Enrollment, len(f.Enrollments))
+	for i, e := range f.Enrollments {
+		enrollments[i] = *e
+	}
+	return enrollments, nil
+}
+
+// loadEnrollments reads the enrollments file. The caller must hold mu.
+func (c *CA) loadEnrollments() (*enrollmentsFile, error) {
+	f := &enrollmentsFile{}
+	data, err := os.ReadFile(filepath.Join(c.dir, EnrollmentsFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return f, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read enrollments: %w", err)
+	}
+	if err := json.Unmarshal(data, f); err != nil {
+		return nil, fmt.Errorf("failed to parse enrollments %s: %w", EnrollmentsFile, err)
+	}
+	return f, nil
+}
+
+// saveEnrollments writes the enrollments file. The caller must hold mu.
+func (c *CA) saveEnrollments(f *enrollmentsFile) error {
+	data, err := json.MarshalIndent(f, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFile(filepath.Join(c.dir, EnrollmentsFile), append(data, '\n'), 0o600)
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// checkPublicKey rejects key types and sizes that are not safe to certify.
+func checkPublicKey(pub any) error {
+	switch k := pub.(type) {
+	case *rsa.PublicKey:
+		if k.N.BitLen() < 2048 {
+			return fmt.Errorf("RSA key of %d bits is too small, use at least 2048", k.N.BitLen())
+		}
+	case *ecdsa.PublicKey:
+		if k.Curve != elliptic.P256() && k.Curve != elliptic.P384() && k.Curve != elliptic.P521() {
+			return errors.New("unsupported elliptic curve")
+		}
+	case ed25519.PublicKey:
+	default:
+		return fmt.Errorf("unsupported key type %T", pub)
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+package ca
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"testing"
+	"time"
+)
+
+func newCSR(t *testing.T, cn string) *x509.CertificateRequest {
+	t.Helper()
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	der, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:  pkix.Name{CommonName: cn},
+		DNSNames: []string{"evil.example"},
+	}, key)
+	if err != nil {
+		t.Fatalf("failed to create CSR: %v", err)
+	}
+	csr, _ := x509.ParseCertificateRequest(der)
+	return csr
+}
+
+func TestEnroll(t *testing.T) {
+	c, err := Init(t.TempDir(), "Home CA", 24*time.Hour)
+	if err != nil {
+		t.Fatalf("Init failed: %v", err)
+	}
+	token, enrollment, err := c.CreateEnrollment("phone", time.Hour)
+	if err != nil {
+		t.Fatalf("CreateEnrollment failed: %v", err)
+	}
+	expired, _, _ := c.CreateEnrollment("tablet", time.Hour)
+
+	// The certificate is bound to the enrolled name, whatever the CSR requests
+	cert, used, err := c.Enroll(token, newCSR(t, "admin"), time.Hour, "192.0.2.1:5000")
+	if err != nil {
+		t.Fatalf("Enroll failed: %v", err)
+	}
+	if cert.Subject.CommonName != "phone" || len(cert.DNSNames) != 0 {
+		t.Errorf("certificate subject %q with DNS names %v, want CN phone only", cert.Subject.CommonName, cert.DNSNames)
+	}
+	if used.ID != enrollment.ID || used.UsedAt == nil || used.Serial != cert.SerialNumber.Text(16) {
+		t.Errorf("enrollment not marked as used: %+v", used)
+	}
+
+	// The index records how the certificate was obtained
+	records, _ := c.Records()
+	if len(records) != 1 || records[0].Enrollment != enrollment.ID || records[0].RemoteAddr != "192.0.2.1:5000" {
+		t.Errorf("index records = %+v", records)
+	}
+
+	c.now = func() time.Time { return time.Now().UTC().Add(2 * time.Hour) }
+	for name, tok := range map[string]string{"reused": token, "expired": expired, "unknown": "nope"} {
+		if _, _, err := c.Enroll(tok, newCSR(t, "phone"), time.Hour, ""); !errors.Is(err, ErrInvalidToken) {
+			t.Errorf("%s token: Enroll error = %v, want ErrInvalidToken", name, err)
+		}
+	}
+}
+
+func TestEnrollRejectsWeakKeys(t *testing.T) {
+	c, err := Init(t.TempDir(), "Home CA", time.Hour)
+	if err != nil {
+		t.Fatalf("Init failed: %v", err)
+	}
+	token, _, _ := c.CreateEnrollment("phone", time.Hour)
+
+	key, _ := rsa.GenerateKey(rand.Reader, 1024)
+	der, _ := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{}, key)
+	csr, _ := x509.ParseCertificateRequest(der)
+	if _, _, err := c.Enroll(token, csr, time.Hour, ""); !errors.Is(err, ErrInvalidCSR) {
+		t.Errorf("Enroll with a 1024-bit RSA key error = %v, want ErrInvalidCSR", err)
+	}
+	// A rejected CSR does not use up the token
+	if _, _, err := c.Enroll(token, newCSR(t, "phone"), time.Hour, ""); err != nil {
+		t.Errorf("Enroll after rejected CSR failed: %v", err)
+	}
+}
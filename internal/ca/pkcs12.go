@@ -0,0 +1,289 @@
+package ca
+
+import (
+	"crypto"
+	"crypto/cipher"
+	"crypto/des"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"unicode/utf16"
+)
+
+// pkcs12Iterations is the key derivation iteration count for the bundle key and MAC.
+const pkcs12Iterations = 2048
+
+// PKCS#12 object identifiers, see RFC 7292.
+var (
+	oidData                = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}
+	oidPKCS8ShroudedKeyBag = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 10, 1, 2}
+	oidCertBag             = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 10, 1, 3}
+	oidCertTypeX509        = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 22, 1}
+	oidFriendlyName        = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 20}
+	oidLocalKeyID          = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 21}
+	oidPBEWithSHA3DES      = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 1, 3}
+	oidSHA1                = asn1.ObjectIdentifier{1, 3, 14, 3, 2, 26}
+)
+
+type pfxPDU struct {
+	Version  int
+	AuthSafe contentInfo
+	MacData  macData
+}
+
+type contentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue // [0] EXPLICIT
+}
+
+type macData struct {
+	Mac        digestInfo
+	MacSalt    []byte
+	Iterations int
+}
+
+type digestInfo struct {
+	Algorithm pkix.AlgorithmIdentifier
+	Digest    []byte
+}
+
+type safeBag struct {
+	ID         asn1.ObjectIdentifier
+	Value      asn1.RawValue  // [0] EXPLICIT
+	Attributes []bagAttribute `asn1:"set,optional"`
+}
+
+type bagAttribute struct {
+	ID    asn1.ObjectIdentifier
+	Value asn1.RawValue // SET OF
+}
+
+type certBag struct {
+	ID   asn1.ObjectIdentifier
+	Data []byte `asn1:"tag:0,explicit"`
+}
+
+type encryptedPrivateKeyInfo struct {
+	Algorithm     pkix.AlgorithmIdentifier
+	EncryptedData []byte
+}
+
+type pbeParams struct {
+	Salt       []byte
+	Iterations int
+}
+
+// EncodePKCS12 returns a PKCS#12 bundle with key, its certificate and the CA
+// certificates, protected by password. It uses the SHA-1 and 3DES based encryption
+// that every phone and browser can import.
+func EncodePKCS12(key crypto.Signer, cert *x509.Certificate, caCerts []*x509.Certificate, friendlyName, password string) ([]byte, error) {
+	if password == "" {
+		return nil, errors.New("PKCS#12 password is required")
+	}
+	pw := bmpPassword(password)
+	keyID := sha1.Sum(cert.Raw)
+	attrs, err := bagAttributes(friendlyName, keyID[:])
+	if err != nil {
+		return nil, err
+	}
+
+	// Certificates, unencrypted
+	var certBags []safeBag
+	for i, c := range append([]*x509.Certificate{cert}, caCerts...) {
+		bag, err := asn1.Marshal(certBag{ID: oidCertTypeX509, Data: c.Raw})
+		if err != nil {
+			return nil, err
+		}
+		sb := safeBag{ID: oidCertBag, Value: explicit0(bag)}
+		if i == 0 {
+			sb.Attributes = attrs
+		}
+		certBags = append(certBags, sb)
+	}
+
+	// Key, encrypted with a password derived key
+	pkcs8, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	salt := make([]byte, 8)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	encrypted, err := encrypt3DES(pkcs8, pw, salt)
+	if err != nil {
+		return nil, err
+	}
+	params, err := asn1.Marshal(pbeParams{Salt: salt, Iterations: pkcs12Iterations})
+	if err != nil {
+		return nil, err
+	}
+	keyInfo, err := asn1.Marshal(encryptedPrivateKeyInfo{
+		Algorithm:     pkix.AlgorithmIdentifier{Algorithm: oidPBEWithSHA3DES, Parameters: asn1.RawValue{FullBytes: params}},
+		EncryptedData: encrypted,
+	})
+	if err != nil {
+		return nil, err
+	}
+	keyBags := []safeBag{{ID: oidPKCS8ShroudedKeyBag, Value: explicit0(keyInfo), Attributes: attrs}}
+
+	var safe []contentInfo
+	for _, bags := range [][]safeBag{certBags, keyBags} {
+		ci, err := dataContentInfo(bags)
+		if err != nil {
+			return nil, err
+		}
+		safe = append(safe, ci)
+	}
+	authSafe, err := asn1.Marshal(safe)
+	if err != nil {
+		return nil, err
+	}
+
+	// MAC over the authenticated safe
+	macSalt := make([]byte, 8)
+	if _, err := rand.Read(macSalt); err != nil {
+		return nil, err
+	}
+	mac := hmac.New(sha1.New, pkcs12KDF(pw, macSalt, 3, pkcs12Iterations, sha1.Size))
+	mac.Write(authSafe)
+
+	authSafeContent, err := asn1.Marshal(authSafe)
+	if err != nil {
+		return nil, err
+	}
+	return asn1.Marshal(pfxPDU{
+		Version:  3,
+		AuthSafe: contentInfo{ContentType: oidData, Content: explicit0(authSafeContent)},
+		MacData: macData{
+			Mac: digestInfo{
+				Algorithm: pkix.AlgorithmIdentifier{Algorithm: oidSHA1, Parameters: asn1.NullRawValue},
+				Digest:    mac.Sum(nil),
+			},
+			MacSalt:    macSalt,
+			Iterations: pkcs12Iterations,
+		},
+	})
+}
+
+// dataContentInfo wraps bags in an unencrypted data content info.
+func dataContentInfo(bags []safeBag) (contentInfo, error) {
+	contents, err := asn1.Marshal(bags)
+	if err != nil {
+		return contentInfo{}, err
+	}
+	octets, err := asn1.Marshal(contents)
+	if err != nil {
+		return contentInfo{}, err
+	}
+	return contentInfo{ContentType: oidData, Content: explicit0(octets)}, nil
+}
+
+// bagAttributes returns the friendly name and local key ID attributes that pair the
+// key with its certificate.
+func bagAttributes(friendlyName string, keyID []byte) ([]bagAttribute, error) {
+	id, err := asn1.Marshal(keyID)
+	if err != nil {
+		return nil, err
+	}
+	attrs := []bagAttribute{{ID: oidLocalKeyID, Value: set(id)}}
+	if friendlyName != "" {
+		name, err := asn1.Marshal(asn1.RawValue{Tag: 30, Bytes: bmpString(friendlyName)}) // BMPString
+		if err != nil {
+			return nil, err
+		}
+		attrs = append(attrs, bagAttribute{ID: oidFriendlyName, Value: set(name)})
+	}
+	return attrs, nil
+}
+
+func explicit0(der []byte) asn1.RawValue {
+	return asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: der}
+}
+
+func set(der []byte) asn1.RawValue {
+	return asn1.RawValue{Class: asn1.ClassUniversal, Tag: asn1.TagSet, IsCompound: true, Bytes: der}
+}
+
+// encrypt3DES encrypts data with pbeWithSHAAnd3-KeyTripleDES-CBC.
+func encrypt3DES(data, password, salt []byte) ([]byte, error) {
+	block, err := des.NewTripleDESCipher(pkcs12KDF(password, salt, 1, pkcs12Iterations, 24))
+	if err != nil {
+		return nil, err
+	}
+	iv := pkcs12KDF(password, salt, 2, pkcs12Iterations, block.BlockSize())
+	padding := block.BlockSize() - len(data)%block.BlockSize()
+	out := append([]byte(nil), data...)
+	for range padding {
+		out = append(out, byte(padding))
+	}
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(out, out)
+	return out, nil
+}
+
+// pkcs12KDF derives size bytes of key material with SHA-1 as specified in RFC 7292
+// appendix B.2; id is 1 for keys, 2 for IVs and 3 for MAC keys.
+func pkcs12KDF(password, salt []byte, id byte, iterations, size int) []byte {
+	const v = 64 // SHA-1 block length
+
+	d := make([]byte, v)
+	for i := range d {
+		d[i] = id
+	}
+	input := append(fill(salt, v), fill(password, v)...)
+
+	var out []byte
+	for len(out) < size {
+		h := sha1.New()
+		h.Write(d)
+		h.Write(input)
+		a := h.Sum(nil)
+		for range iterations - 1 {
+			sum := sha1.Sum(a)
+			a = sum[:]
+		}
+		out = append(out, a...)
+
+		// Each v-byte block of input becomes (block + b + 1) mod 2^(8v)
+		b := fill(a, v)[:v]
+		for j := 0; j < len(input); j += v {
+			carry := 1
+			for k := v - 1; k >= 0; k-- {
+				sum := int(input[j+k]) + int(b[k]) + carry
+				input[j+k] = byte(sum)
+				carry = sum >> 8
+			}
+		}
+	}
+	return out[:size]
+}
+
+// fill repeats b to a multiple of v bytes.
+func fill(b []byte, v int) []byte {
+	if len(b) == 0 {
+		return nil
+	}
+	out := make([]byte, v*((len(b)+v-1)/v))
+	for i := range out {
+		out[i] = b[i%len(b)]
+	}
+	return out
+}
+
+// bmpPassword encodes a password as a null-terminated BMPString.
+func bmpPassword(password string) []byte {
+	return append(bmpString(password), 0, 0)
+}
+
+// bmpString encodes s as big-endian UTF-16.
+func bmpString(s string) []byte {
+	var out []byte
+	for _, r := range utf16.Encode([]rune(s)) {
+		out = append(out, byte(r>>8), byte(r))
+	}
+	return out
+}
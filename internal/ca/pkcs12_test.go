@@ -0,0 +1,130 @@
+package ca
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"crypto/des"
+	"crypto/ecdsa"
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/x509"
+	"encoding/asn1"
+	"testing"
+	"time"
+)
+
+// decodePKCS12 verifies the MAC of a bundle written by EncodePKCS12 and returns its
+// certificates and decrypted PKCS#8 key.
+func decodePKCS12(t *testing.T, data []byte, password string) ([]*x509.Certificate, []byte) {
+	t.Helper()
+	pw := bmpPassword(password)
+
+	var pfx pfxPDU
+	if _, err := asn1.Unmarshal(data, &pfx); err != nil {
+		t.Fatalf("failed to parse PFX: %v", err)
+	}
+	var authSafe []byte
+	if _, err := asn1.Unmarshal(pfx.AuthSafe.Content.Bytes, &authSafe); err != nil {
+		t.Fatalf("failed to parse auth safe: %v", err)
+	}
+	mac := hmac.New(sha1.New, pkcs12KDF(pw, pfx.MacData.MacSalt, 3, pfx.MacData.Iterations, sha1.Size))
+	mac.Write(authSafe)
+	if !hmac.Equal(mac.Sum(nil), pfx.MacData.Mac.Digest) {
+		t.Fatal("MAC does not verify")
+	}
+
+	var safe []contentInfo
+	if _, err := asn1.Unmarshal(authSafe, &safe); err != nil {
+		t.Fatalf("failed to parse content infos: %v", err)
+	}
+	var certs []*x509.Certificate
+	var key []byte
+	for _, ci := range safe {
+		var contents []byte
+		if _, err := asn1.Unmarshal(ci.Content.Bytes, &contents); err != nil {
+			t.Fatalf("failed to parse content: %v", err)
+		}
+		var bags []safeBag
+		if _, err := asn1.Unmarshal(contents, &bags); err != nil {
+			t.Fatalf("failed to parse bags: %v", err)
+		}
+		for _, bag := range bags {
+			switch {
+			case bag.ID.Equal(oidCertBag):
+				var cb certBag
+				if _, err := asn1.Unmarshal(bag.Value.Bytes, &cb); err != nil {
+					t.Fatalf("failed to parse cert bag: %v", err)
+				}
+				cert, err := x509.ParseCertificate(cb.Data)
+				if err != nil {
+					t.Fatalf("failed to parse certificate: %v", err)
+				}
+				certs = append(certs, cert)
+			case bag.ID.Equal(oidPKCS8ShroudedKeyBag):
+				var info encryptedPrivateKeyInfo
+				if _, err := asn1.Unmarshal(bag.Value.Bytes, &info); err != nil {
+					t.Fatalf("failed to parse key bag: %v", err)
+				}
+				var params pbeParams
+				if _, err := asn1.Unmarshal(info.Algorithm.Parameters.FullBytes, &params); err != nil {
+					t.Fatalf("failed to parse PBE parameters: %v", err)
+				}
+				block, _ := des.NewTripleDESCipher(pkcs12KDF(pw, params.Salt, 1, params.Iterations, 24))
+				iv := pkcs12KDF(pw, params.Salt, 2, params.Iterations, 8)
+				key = make([]byte, len(info.EncryptedData))
+				cipher.NewCBCDecrypter(block, iv).CryptBlocks(key, info.EncryptedData)
+				key = key[:len(key)-int(key[len(key)-1])]
+			}
+		}
+	}
+	return certs, key
+}
+
+func TestEncodePKCS12(t *testing.T) {
+	c, err := Init(t.TempDir(), "Home CA", time.Hour)
+	if err != nil {
+		t.Fatalf("Init failed: %v", err)
+	}
+	cert, key, err := c.Issue(Request{Kind: KindClient, Name: "phone", Validity: time.Hour})
+	if err != nil {
+		t.Fatalf("Issue failed: %v", err)
+	}
+	data, err := EncodePKCS12(key, cert, []*x509.Certificate{c.Certificate()}, "phone", "pässwörd")
+	if err != nil {
+		t.Fatalf("EncodePKCS12 failed: %v", err)
+	}
+
+	certs, pkcs8 := decodePKCS12(t, data, "pässwörd")
+	if len(certs) != 2 || !certs[0].Equal(cert) || !certs[1].Equal(c.Certificate()) {
+		t.Errorf("bundle has %d certificates, want the client and CA certificate", len(certs))
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(pkcs8)
+	if err != nil {
+		t.Fatalf("decrypted key does not parse: %v", err)
+	}
+	if !parsed.(*ecdsa.PrivateKey).Equal(key) {
+		t.Error("decrypted key differs from the issued key")
+	}
+
+	if _, err := EncodePKCS12(key, cert, nil, "phone", ""); err == nil {
+		t.Error("EncodePKCS12 accepted an empty password")
+	}
+}
+
+func TestPKCS12KDF(t *testing.T) {
+	// Multi-block output exercises the input adjustment between blocks
+	pw := bmpPassword("smeg")
+	salt := []byte{0x0a, 0x58, 0xcf, 0x64, 0x53, 0x0d, 0x82, 0x3f}
+	long := pkcs12KDF(pw, salt, 1, 1, 48)
+	if short := pkcs12KDF(pw, salt, 1, 1, 24); !bytes.Equal(short, long[:24]) {
+		t.Error("shorter output is not a prefix of longer output")
+	}
+	if bytes.Equal(long[:24], pkcs12KDF(pw, salt, 2, 1, 24)) {
+		t.Error("different IDs derive the same bytes")
+	}
+	// RFC 7292 test vector from the OpenSSL and Bouncy Castle test suites
+	want := []byte{0x8a, 0xaa, 0xe6, 0x29, 0x7b, 0x6c, 0xb0, 0x46, 0x42, 0xab, 0x5b, 0x07, 0x78, 0x51, 0x28, 0x4e, 0xb7, 0x12, 0x8f, 0x1a, 0x2a, 0x7f, 0xbc, 0xa3}
+	if got := long[:24]; !bytes.Equal(got, want) {
+		t.Errorf("pkcs12KDF = %x, want %x", got, want)
+	}
+}
@@ -0,0 +1,85 @@
+package ca
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// WriteSelfSigned creates a self-signed server certificate for hosts, DNS names or
+// IP addresses, and writes it and its key unless the certificate file exists. It
+// returns the new certificate, or nil if the files existed.
+func WriteSelfSigned(certFile, keyFile string, hosts []string, validity time.Duration) (*x509.Certificate, error) {
+	_, certErr := os.Stat(certFile)
+	_, keyErr := os.Stat(keyFile)
+	switch {
+	case certErr == nil && keyErr == nil:
+		return nil, nil
+	case certErr == nil || keyErr == nil:
+		return nil, fmt.Errorf("only one of %s and %s exists, remove it to generate a self-signed certificate", certFile, keyFile)
+	case !errors.Is(certErr, os.ErrNotExist):
+		return nil, certErr
+	case len(hosts) == 0:
+		return nil, errors.New("self-signed certificate needs at least one host")
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	serial, err := newSerial()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now().UTC()
+	tmpl := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: hosts[0]},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(validity),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+	}
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			tmpl.IPAddresses = append(tmpl.IPAddresses, ip)
+		} else {
+			tmpl.DNSNames = append(tmpl.DNSNames, host)
+		}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+	keyPEM, err := EncodeKeyPEM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, dir := range []string{filepath.Dir(certFile), filepath.Dir(keyFile)} {
+		if err := os.MkdirAll(dir, 0o700); err != nil {
+			return nil, err
+		}
+	}
+	// The key is written first, so a certificate file always has its key
+	if err := writeFile(keyFile, keyPEM, 0o600); err != nil {
+		return nil, err
+	}
+	if err := writeFile(certFile, EncodeCertPEM(cert), 0o644); err != nil {
+		return nil, err
+	}
+	return cert, nil
+}
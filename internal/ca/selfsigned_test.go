@@ -0,0 +1,38 @@
+package ca
+
+import (
+	"crypto/tls"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWriteSelfSigned(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "tls")
+	certFile, keyFile := filepath.Join(dir, "self-signed.crt"), filepath.Join(dir, "self-signed.key")
+
+	cert, err := WriteSelfSigned(certFile, keyFile, []string{"proxy.home", "192.168.1.10"}, time.Hour)
+	if err != nil {
+		t.Fatalf("WriteSelfSigned failed: %v", err)
+	}
+	if cert == nil || cert.VerifyHostname("proxy.home") != nil || cert.VerifyHostname("192.168.1.10") != nil {
+		t.Fatalf("certificate = %+v, want one valid for proxy.home and 192.168.1.10", cert)
+	}
+	if _, err := tls.LoadX509KeyPair(certFile, keyFile); err != nil {
+		t.Errorf("written files are not a key pair: %v", err)
+	}
+
+	// Existing files are kept
+	again, err := WriteSelfSigned(certFile, keyFile, []string{"other.home"}, time.Hour)
+	if err != nil || again != nil {
+		t.Errorf("WriteSelfSigned with existing files = %v, %v, want nil, nil", again, err)
+	}
+
+	if err := os.Remove(keyFile); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := WriteSelfSigned(certFile, keyFile, []string{"proxy.home"}, time.Hour); err == nil {
+		t.Error("WriteSelfSigned overwrote a certificate without its key")
+	}
+}
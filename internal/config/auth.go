@@ -0,0 +1,235 @@
+package config
+
+import (
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"arr-proxy/internal/apikeys"
+	"arr-proxy/internal/tokens"
+
+	"github.com/spf13/viper"
+)
+
+// loadAuthConfig loads the authentication settings of the app settings v.
+// Secrets can come from <VAR>_FILE or file:/env: references.
+func loadAuthConfig(v *viper.Viper) (AuthConfig, Problems) {
+	var problems Problems
+
+	// Several auth methods may be listed, they are tried in order
+	authMode := strings.Join(normalizeAuthMethods(listValue(v, "auth_mode")), ",")
+	if authMode == "" {
+		authMode = AuthModeAPIKey
+	}
+
+	secrets := make(map[string]string, len(appSecretKeys))
+	for _, key := range appSecretKeys {
+		value, err := lookupSecret(v, key, appEnvKeys[key])
+		if err != nil {
+			problems.Errorf("%s: %v", key, err)
+		}
+		secrets[key] = value
+	}
+
+	tokenMaxTTL, err := time.ParseDuration(v.GetString("token_max_ttl"))
+	if err != nil || tokenMaxTTL <= 0 {
+		problems.Errorf("invalid APP_TOKEN_MAX_TTL %q (use a positive duration like 1h)", v.GetString("token_max_ttl"))
+	}
+	jwksRefresh, err := time.ParseDuration(v.GetString("jwt_jwks_refresh"))
+	if err != nil || jwksRefresh <= 0 {
+		problems.Errorf("invalid APP_JWT_JWKS_REFRESH %q (use a positive duration like 1h)", v.GetString("jwt_jwks_refresh"))
+	}
+	forwardCacheTTL, err := time.ParseDuration(v.GetString("forward_auth_cache_ttl"))
+	if err != nil || forwardCacheTTL < 0 {
+		problems.Errorf("invalid APP_FORWARD_AUTH_CACHE_TTL %q (use a duration like 10s, 0 disables caching)", v.GetString("forward_auth_cache_ttl"))
+	}
+	lockout, lockoutProblems := loadLockoutConfig(v)
+	problems.Merge(lockoutProblems)
+
+	return AuthConfig{
+		Mode: authMode,
+		BasicAuth: BasicAuthConfig{
+			User:     secrets["basic_auth_user"],
+			Password: secrets["basic_auth_pass"],
+		},
+		APIKey:   secrets["api_key"],
+		AdminKey: secrets["admin_key"],
+		Token: TokenConfig{
+			Algorithm: v.GetString("token_algorithm"),
+			Key:       secrets["token_key"],
+			MaxTTL:    tokenMaxTTL,
+		},
+		JWT: JWTConfig{
+			JWKS:          v.GetString("jwt_jwks"),
+			JWKSRefresh:   jwksRefresh,
+			Issuer:        v.GetString("jwt_issuer"),
+			Audience:      v.GetString("jwt_audience"),
+			IdentityClaim: v.GetString("jwt_identity_claim"),
+			GroupsClaim:   v.GetString("jwt_groups_claim"),
+		},
+		Forward: ForwardAuthConfig{
+			URL:            v.GetString("forward_auth_url"),
+			Headers:        listValue(v, "forward_auth_headers"),
+			IdentityHeader: v.GetString("forward_auth_identity_header"),
+			GroupsHeader:   v.GetString("forward_auth_groups_header"),
+			CacheTTL:       forwardCacheTTL,
+		},
+		Header: TrustedHeaderConfig{
+			UserHeader:   v.GetString("header_auth_user"),
+			GroupsHeader: v.GetString("header_auth_groups"),
+		},
+		MTLS: MTLSConfig{
+			RequireMapping: v.GetBool("mtls_require_mapping"),
+		},
+		Lockout: lockout,
+	}, problems
+}
+
+// loadLockoutConfig loads the brute-force lockout settings of v.
+func loadLockoutConfig(v *viper.Viper) (LockoutConfig, Problems) {
+	var problems Problems
+	threshold, err := strconv.Atoi(v.GetString("lockout_threshold"))
+	if err != nil || threshold < 0 {
+		problems.Errorf("invalid APP_LOCKOUT_THRESHOLD %q (use a number of failures, 0 disables lockout)", v.GetString("lockout_threshold"))
+	}
+	durations := make(map[string]time.Duration)
+	for _, key := range []string{"lockout_window", "lockout_ban_time", "lockout_max_ban_time"} {
+		d, err := time.ParseDuration(v.GetString(key))
+		if err != nil || d <= 0 {
+			problems.Errorf("invalid %s %q (use a positive duration like 10m)", appEnvKeys[key], v.GetString(key))
+		}
+		durations[key] = d
+	}
+	if durations["lockout_ban_time"] > durations["lockout_max_ban_time"] {
+		problems.Errorf("APP_LOCKOUT_BAN_TIME must not exceed APP_LOCKOUT_MAX_BAN_TIME")
+	}
+	return LockoutConfig{
+		Threshold:  threshold,
+		Window:     durations["lockout_window"],
+		BanTime:    durations["lockout_ban_time"],
+		MaxBanTime: durations["lockout_max_ban_time"],
+	}, problems
+}
+
+// validateAuthMethods checks that every auth method used globally or by a service
+// has the settings it requires.
+func validateAuthMethods(cfg *Config) Problems {
+	authMethods, problems := usedAuthMethods(cfg)
+	for _, method := range authMethods {
+		switch method {
+		case AuthModeBasic:
+			if cfg.Auth.BasicAuth.User == "" {
+				problems.Errorf("APP_BASIC_AUTH_USER or APP_BASIC_AUTH_USER_FILE required for basic auth mode")
+			}
+			if cfg.Auth.BasicAuth.Password == "" {
+				problems.Errorf("APP_BASIC_AUTH_PASS or APP_BASIC_AUTH_PASS_FILE required for basic auth mode")
+			}
+		case AuthModeAPIKey, AuthModeToken:
+			// In token mode clients authenticate with an API key to mint tokens. Keys
+			// are checked once if both methods are used.
+			if method == AuthModeAPIKey || !slices.Contains(authMethods, AuthModeAPIKey) {
+				if cfg.Auth.APIKey == "" && !cfg.hasClientAPIKeys() && cfg.Server.KeyStore == "" {
+					problems.Errorf("APP_API_KEY, APP_API_KEY_FILE, client api_keys or a key store required for %s auth mode", method)
+				}
+				if cfg.Auth.APIKey != "" && !apikeys.IsHash(cfg.Auth.APIKey) {
+					problems.Warnf("APP_API_KEY is stored in plaintext, configure its hash instead (arr-proxy key hash)")
+				}
+			}
+			if method != AuthModeToken {
+				break
+			}
+			if cfg.Auth.Token.Key == "" {
+				problems.Errorf("APP_TOKEN_KEY or APP_TOKEN_KEY_FILE required for token auth mode")
+			} else if _, err := tokens.NewMinter(cfg.Auth.Token.Algorithm, []byte(cfg.Auth.Token.Key)); err != nil {
+				problems.Errorf("APP_TOKEN_KEY: %v", err)
+			}
+		case AuthModeJWT:
+			if cfg.Auth.JWT.JWKS == "" {
+				problems.Errorf("APP_JWT_JWKS (key set file or URL) required for jwt auth mode")
+			} else if !tokens.IsURL(cfg.Auth.JWT.JWKS) {
+				if _, err := os.Stat(cfg.Auth.JWT.JWKS); err != nil {
+					problems.Errorf("APP_JWT_JWKS: %v", err)
+				}
+			} else if strings.HasPrefix(cfg.Auth.JWT.JWKS, "http://") {
+				problems.Warnf("APP_JWT_JWKS is fetched over plain HTTP, use https so keys cannot be tampered with")
+			}
+			if cfg.Auth.JWT.Issuer == "" {
+				problems.Errorf("APP_JWT_ISSUER required for jwt auth mode")
+			}
+			if cfg.Auth.JWT.Audience == "" {
+				problems.Errorf("APP_JWT_AUDIENCE required for jwt auth mode")
+			}
+		case AuthModeForward:
+			if cfg.Auth.Forward.URL == "" {
+				problems.Errorf("APP_FORWARD_AUTH_URL required for forward auth mode")
+			} else if u, err := url.Parse(cfg.Auth.Forward.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				problems.Errorf("invalid APP_FORWARD_AUTH_URL %q (must be an http or https URL)", cfg.Auth.Forward.URL)
+			}
+		case AuthModeHeader:
+			if len(cfg.Network.TrustedProxies) == 0 {
+				problems.Errorf("APP_TRUSTED_PROXIES required for header auth mode (addresses of the reverse proxies setting %s)", cfg.Auth.Header.UserHeader)
+			}
+			for _, p := range cfg.Network.TrustedProxies {
+				if p.Bits() == 0 {
+					problems.Warnf("APP_TRUSTED_PROXIES contains %s, any client can set %s", p, cfg.Auth.Header.UserHeader)
+				}
+			}
+		case AuthModeMTLS:
+			// mTLS requires all TLS certificates
+			if cfg.TLSCert == "" {
+				problems.Errorf("APP_TLS_CERT required for mTLS mode")
+			}
+			if cfg.TLSKey == "" {
+				problems.Errorf("APP_TLS_KEY required for mTLS mode")
+			}
+			if cfg.CACert == "" {
+				problems.Errorf("APP_CA_CERT required for mTLS mode (CA to verify client certs)")
+			}
+		default:
+			problems.Errorf("invalid APP_AUTH_MODE '%s' (valid modes: apikey, token, jwt, oidc, forward, header, mtls, basic)", method)
+		}
+	}
+	return problems
+}
+
+// validateAdminAPI checks the admin key against the features it manages.
+func validateAdminAPI(cfg *Config) Problems {
+	var problems Problems
+	// The admin API manages the key store
+	switch {
+	case cfg.Auth.AdminKey != "" && cfg.Server.KeyStore == "" && !cfg.Auth.Lockout.Enabled():
+		problems.Warnf("APP_ADMIN_KEY is set but neither a key store nor lockout is configured (set APP_KEY_STORE or APP_LOCKOUT_THRESHOLD), admin API disabled")
+	case cfg.Auth.AdminKey == "" && cfg.Server.KeyStore != "":
+		problems.Warnf("key store is configured but APP_ADMIN_KEY is not set, admin API disabled")
+	case cfg.Auth.AdminKey != "" && !apikeys.IsHash(cfg.Auth.AdminKey):
+		problems.Warnf("APP_ADMIN_KEY is stored in plaintext, configure its hash instead (arr-proxy key hash)")
+	}
+	return problems
+}
+
+// validateUnusedAuthSettings warns about settings of auth methods that are not used.
+func validateUnusedAuthSettings(cfg *Config) Problems {
+	var problems Problems
+	if cfg.Server.KeyStore != "" && !cfg.UsesAuth(AuthModeAPIKey) && !cfg.UsesAuth(AuthModeToken) {
+		problems.Warnf("key store keys are only accepted in apikey and token auth modes")
+	}
+	if cfg.Auth.Lockout.Enabled() && !cfg.UsesAuth(AuthModeBasic) && !cfg.UsesAuth(AuthModeAPIKey) && !cfg.UsesAuth(AuthModeToken) {
+		problems.Warnf("APP_LOCKOUT_THRESHOLD is set but auth mode is %s, lockout only applies to basic and apikey auth", cfg.Auth.Mode)
+	}
+	if cfg.Auth.Token.Key != "" && !cfg.UsesAuth(AuthModeToken) {
+		problems.Warnf("APP_TOKEN_KEY is set but auth mode is %s, token endpoint disabled", cfg.Auth.Mode)
+	}
+	if cfg.Auth.JWT.JWKS != "" && !cfg.UsesAuth(AuthModeJWT) {
+		problems.Warnf("APP_JWT_JWKS is set but auth mode is %s, JWT validation disabled", cfg.Auth.Mode)
+	}
+	if cfg.hasClientCertificates() && !cfg.UsesAuth(AuthModeMTLS) {
+		problems.Warnf("clients map certificates but mtls auth is not used, certificates entries are ignored")
+	}
+	if cfg.Auth.Forward.URL != "" && !cfg.UsesAuth(AuthModeForward) {
+		problems.Warnf("APP_FORWARD_AUTH_URL is set but auth mode is %s, forward auth disabled", cfg.Auth.Mode)
+	}
+	return problems
+}
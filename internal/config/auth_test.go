@@ -0,0 +1,132 @@
+package config
+
+import (
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+func TestLoadLockoutConfig(t *testing.T) {
+	tests := []struct {
+		name       string
+		settings   map[string]any
+		want       LockoutConfig
+		wantErrors []string
+	}{
+		{
+			name:     "defaults",
+			settings: map[string]any{"lockout_threshold": 5},
+			want:     LockoutConfig{Threshold: 5, Window: 10 * time.Minute, BanTime: 5 * time.Minute, MaxBanTime: 24 * time.Hour},
+		},
+		{
+			name:       "invalid threshold",
+			settings:   map[string]any{"lockout_threshold": "many"},
+			wantErrors: []string{"invalid APP_LOCKOUT_THRESHOLD"},
+		},
+		{
+			name:       "ban time above maximum",
+			settings:   map[string]any{"lockout_ban_time": "2h", "lockout_max_ban_time": "1h"},
+			wantErrors: []string{"must not exceed APP_LOCKOUT_MAX_BAN_TIME"},
+		},
+		{
+			name:       "invalid window",
+			settings:   map[string]any{"lockout_window": "-1m"},
+			wantErrors: []string{"invalid APP_LOCKOUT_WINDOW"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := viper.New()
+			v.SetDefault("lockout_threshold", 0)
+			v.SetDefault("lockout_window", "10m")
+			v.SetDefault("lockout_ban_time", "5m")
+			v.SetDefault("lockout_max_ban_time", "24h")
+			for key, value := range tt.settings {
+				v.Set(key, value)
+			}
+
+			got, problems := loadLockoutConfig(v)
+			if len(problems.Errors) != len(tt.wantErrors) {
+				t.Fatalf("errors = %q, want %d", problems.Errors, len(tt.wantErrors))
+			}
+			for _, want := range tt.wantErrors {
+				if !containsProblem(problems.Errors, want) {
+					t.Errorf("errors = %q, want one containing %q", problems.Errors, want)
+				}
+			}
+			if len(tt.wantErrors) == 0 && got != tt.want {
+				t.Errorf("loadLockoutConfig() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateAuthMethods(t *testing.T) {
+	tests := []struct {
+		name         string
+		cfg          Config
+		wantErrors   []string
+		wantWarnings []string
+	}{
+		{
+			name:       "basic without password",
+			cfg:        Config{Auth: AuthConfig{Mode: AuthModeBasic, BasicAuth: BasicAuthConfig{User: "admin"}}},
+			wantErrors: []string{"APP_BASIC_AUTH_PASS or APP_BASIC_AUTH_PASS_FILE required"},
+		},
+		{
+			name:         "plaintext api key",
+			cfg:          Config{Auth: AuthConfig{Mode: AuthModeAPIKey, APIKey: "plaintext"}},
+			wantWarnings: []string{"APP_API_KEY is stored in plaintext"},
+		},
+		{
+			name:       "jwt without issuer and audience",
+			cfg:        Config{Auth: AuthConfig{Mode: AuthModeJWT, JWT: JWTConfig{JWKS: "https://idp.example/jwks"}}},
+			wantErrors: []string{"APP_JWT_ISSUER required", "APP_JWT_AUDIENCE required"},
+		},
+		{
+			name:       "forward auth URL without scheme",
+			cfg:        Config{Auth: AuthConfig{Mode: AuthModeForward, Forward: ForwardAuthConfig{URL: "authelia:9091"}}},
+			wantErrors: []string{"invalid APP_FORWARD_AUTH_URL"},
+		},
+		{
+			name: "header auth trusting everyone",
+			cfg: Config{
+				Auth:    AuthConfig{Mode: AuthModeHeader, Header: TrustedHeaderConfig{UserHeader: "X-Forwarded-User"}},
+				Network: NetworkConfig{TrustedProxies: []netip.Prefix{netip.MustParsePrefix("0.0.0.0/0")}},
+			},
+			wantWarnings: []string{"any client can set X-Forwarded-User"},
+		},
+		{
+			name:       "mtls without certificates",
+			cfg:        Config{Auth: AuthConfig{Mode: AuthModeMTLS}},
+			wantErrors: []string{"APP_TLS_CERT required", "APP_TLS_KEY required", "APP_CA_CERT required"},
+		},
+		{
+			name:       "unknown method",
+			cfg:        Config{Auth: AuthConfig{Mode: "kerberos"}},
+			wantErrors: []string{"invalid APP_AUTH_MODE 'kerberos'"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			problems := validateAuthMethods(&tt.cfg)
+			if len(problems.Errors) != len(tt.wantErrors) {
+				t.Errorf("errors = %q, want %d", problems.Errors, len(tt.wantErrors))
+			}
+			for _, want := range tt.wantErrors {
+				if !containsProblem(problems.Errors, want) {
+					t.Errorf("errors = %q, want one containing %q", problems.Errors, want)
+				}
+			}
+			for _, want := range tt.wantWarnings {
+				if !containsProblem(problems.Warnings, want) {
+					t.Errorf("warnings = %q, want one containing %q", problems.Warnings, want)
+				}
+			}
+		})
+	}
+}
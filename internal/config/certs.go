@@ -0,0 +1,195 @@
+package config
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"arr-proxy/internal/ca"
+
+	"github.com/spf13/viper"
+)
+
+// CertificateMatch selects client certificates by their attributes. All fields that
+// are set must match; values other than the fingerprint may contain * wildcards.
+type CertificateMatch struct {
+	CN          string `yaml:"cn,omitempty" mapstructure:"cn"`                   // subject common name
+	DNS         string `yaml:"dns,omitempty" mapstructure:"dns"`                 // any DNS name SAN, case-insensitive
+	Email       string `yaml:"email,omitempty" mapstructure:"email"`             // any email SAN, case-insensitive
+	URI         string `yaml:"uri,omitempty" mapstructure:"uri"`                 // any URI SAN
+	SPIFFE      string `yaml:"spiffe,omitempty" mapstructure:"spiffe"`           // SPIFFE ID, the spiffe:// URI SAN
+	OU          string `yaml:"ou,omitempty" mapstructure:"ou"`                   // any subject organizational unit
+	Issuer      string `yaml:"issuer,omitempty" mapstructure:"issuer"`           // issuer DN (e.g. "CN=Home CA,O=Home") or issuer CN
+	Fingerprint string `yaml:"fingerprint,omitempty" mapstructure:"fingerprint"` // SHA-256 of the certificate, hex with optional colons
+}
+
+// Matches returns true if cert has all attributes of the match.
+func (m *CertificateMatch) Matches(cert *x509.Certificate) bool {
+	var uris, spiffeIDs []string
+	for _, u := range cert.URIs {
+		uris = append(uris, u.String())
+		if u.Scheme == "spiffe" {
+			spiffeIDs = append(spiffeIDs, u.String())
+		}
+	}
+	return matchAny(m.CN, []string{cert.Subject.CommonName}, false) &&
+		matchAny(m.DNS, cert.DNSNames, true) &&
+		matchAny(m.Email, cert.EmailAddresses, true) &&
+		matchAny(m.URI, uris, false) &&
+		matchAny(m.SPIFFE, spiffeIDs, false) &&
+		matchAny(m.OU, cert.Subject.OrganizationalUnit, false) &&
+		matchAny(m.Issuer, []string{cert.Issuer.String(), cert.Issuer.CommonName}, false) &&
+		(m.Fingerprint == "" || normalizeFingerprint(m.Fingerprint) == Fingerprint(cert))
+}
+
+// validate returns a problem description if the match cannot select any certificate.
+func (m *CertificateMatch) validate() string {
+	if *m == (CertificateMatch{}) {
+		return "sets no attribute"
+	}
+	if m.Fingerprint != "" {
+		fp := normalizeFingerprint(m.Fingerprint)
+		if _, err := hex.DecodeString(fp); err != nil || len(fp) != 2*sha256.Size {
+			return fmt.Sprintf("fingerprint %q is not a SHA-256 hex digest", m.Fingerprint)
+		}
+	}
+	if m.SPIFFE != "" && !strings.HasPrefix(m.SPIFFE, "spiffe://") {
+		return fmt.Sprintf("spiffe %q must start with spiffe://", m.SPIFFE)
+	}
+	return ""
+}
+
+// Fingerprint returns the lowercase hex SHA-256 fingerprint of cert.
+func Fingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	return hex.EncodeToString(sum[:])
+}
+
+func normalizeFingerprint(fp string) string {
+	fp = strings.TrimPrefix(strings.ToLower(fp), "sha256:")
+	return strings.ReplaceAll(fp, ":", "")
+}
+
+// matchAny returns true if pattern is empty or matches one of values.
+func matchAny(pattern string, values []string, foldCase bool) bool {
+	if pattern == "" {
+		return true
+	}
+	for _, v := range values {
+		if foldCase {
+			if matchWildcard(strings.ToLower(pattern), strings.ToLower(v)) {
+				return true
+			}
+		} else if matchWildcard(pattern, v) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchWildcard matches s against pattern, where * matches any sequence of characters.
+func matchWildcard(pattern, s string) bool {
+	parts := strings.Split(pattern, "*")
+	if len(parts) == 1 {
+		return pattern == s
+	}
+	if !strings.HasPrefix(s, parts[0]) {
+		return false
+	}
+	s = s[len(parts[0]):]
+	last := parts[len(parts)-1]
+	for _, part := range parts[1 : len(parts)-1] {
+		i := strings.Index(s, part)
+		if i < 0 {
+			return false
+		}
+		s = s[i+len(part):]
+	}
+	return strings.HasSuffix(s, last)
+}
+
+// ClientForCert maps a verified client certificate to a client identity: the first
+// client (by name) with a matching certificates entry, otherwise the certificate CN.
+// It returns false if the certificate must be rejected: its CN names a client whose
+// certificates entries it does not match, or mapping is required and no client matches.
+func (c *Config) ClientForCert(cert *x509.Certificate) (string, bool) {
+	names := make([]string, 0, len(c.Clients))
+	for name := range c.Clients {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if slices.ContainsFunc(c.Clients[name].Certificates, func(m CertificateMatch) bool { return m.Matches(cert) }) {
+			return name, true
+		}
+	}
+
+	cn := cert.Subject.CommonName
+	client, ok := c.Clients[cn]
+	switch {
+	case ok && len(client.Certificates) > 0:
+		return "", false
+	case !ok && c.Auth.MTLS.RequireMapping:
+		return "", false
+	}
+	return cn, true
+}
+
+// loadEnrollmentConfig loads the certificate enrollment settings of v.
+func loadEnrollmentConfig(v *viper.Viper) (EnrollmentConfig, Problems) {
+	var problems Problems
+	validity, err := time.ParseDuration(v.GetString("enroll_validity"))
+	if err != nil || validity <= 0 {
+		problems.Errorf("invalid APP_ENROLL_VALIDITY %q (use a positive duration like 8760h)", v.GetString("enroll_validity"))
+	}
+	return EnrollmentConfig{CADir: v.GetString("ca_dir"), Validity: validity}, problems
+}
+
+// validateRevocation checks that the revocation lists exist and can be checked.
+// Revocation lists are checked against certificates issued by the client CA.
+func validateRevocation(cfg *Config) Problems {
+	var problems Problems
+	for _, path := range append(slices.Clone(cfg.Revocation.CRLFiles), cfg.Revocation.Denylist) {
+		if path == "" {
+			continue
+		}
+		if _, err := os.Stat(path); err != nil {
+			problems.Errorf("certificate revocation list: %v", err)
+		}
+	}
+	if cfg.Revocation.Enabled() && cfg.CACert == "" {
+		problems.Errorf("APP_CA_CERT required to check APP_CRL_FILES and APP_CERT_DENYLIST")
+	} else if cfg.Revocation.Enabled() && !cfg.UsesAuth(AuthModeMTLS) {
+		problems.Warnf("certificate revocation is configured but mtls auth is not used")
+	}
+	return problems
+}
+
+// validateEnrollment checks that the built-in CA exists and enrolled certificates
+// can be used. Enrolled certificates are signed by the built-in CA, see
+// "arr-proxy ca init".
+func validateEnrollment(cfg *Config) Problems {
+	var problems Problems
+	if !cfg.Enrollment.Enabled() {
+		return problems
+	}
+	for _, name := range []string{ca.CertFile, ca.KeyFile} {
+		if _, err := os.Stat(filepath.Join(cfg.Enrollment.CADir, name)); err != nil {
+			problems.Errorf("APP_CA_DIR: %v", err)
+		}
+	}
+	if !cfg.TLSEnabled() {
+		problems.Warnf("certificate enrollment is enabled without TLS, enrollment tokens are sent in plaintext")
+	}
+	if !cfg.UsesAuth(AuthModeMTLS) {
+		problems.Warnf("APP_CA_DIR is set but mtls auth is not used, enrolled certificates cannot authenticate")
+	}
+	return problems
+}
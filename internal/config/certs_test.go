@@ -0,0 +1,88 @@
+package config
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/url"
+	"testing"
+)
+
+func testCert(t *testing.T) *x509.Certificate {
+	t.Helper()
+	spiffe, _ := url.Parse("spiffe://home.example/ns/media/sa/tv")
+	web, _ := url.Parse("https://tv.home.example/device")
+	return &x509.Certificate{
+		Raw:            []byte("certificate"),
+		Subject:        pkix.Name{CommonName: "living-room-tv", OrganizationalUnit: []string{"Devices", "LivingRoom"}},
+		Issuer:         pkix.Name{CommonName: "Home CA", Organization: []string{"Home"}},
+		DNSNames:       []string{"TV.home.example"},
+		EmailAddresses: []string{"tv@home.example"},
+		URIs:           []*url.URL{web, spiffe},
+	}
+}
+
+func TestCertificateMatch(t *testing.T) {
+	cert := testCert(t)
+	fingerprint := Fingerprint(cert)
+
+	tests := []struct {
+		name  string
+		match CertificateMatch
+		want  bool
+	}{
+		{"cn", CertificateMatch{CN: "living-room-tv"}, true},
+		{"cn wildcard", CertificateMatch{CN: "*-tv"}, true},
+		{"cn mismatch", CertificateMatch{CN: "kitchen-*"}, false},
+		{"dns ignores case", CertificateMatch{DNS: "*.home.example"}, true},
+		{"email", CertificateMatch{Email: "tv@*"}, true},
+		{"uri", CertificateMatch{URI: "https://tv.home.example/*"}, true},
+		{"spiffe", CertificateMatch{SPIFFE: "spiffe://home.example/ns/media/*"}, true},
+		{"spiffe only matches spiffe uris", CertificateMatch{SPIFFE: "https://*"}, false},
+		{"ou", CertificateMatch{OU: "LivingRoom"}, true},
+		{"issuer cn", CertificateMatch{Issuer: "Home CA"}, true},
+		{"issuer dn", CertificateMatch{Issuer: "CN=Home CA,O=Home"}, true},
+		{"fingerprint with colons", CertificateMatch{Fingerprint: "SHA256:" + fingerprint[:2] + ":" + fingerprint[2:]}, true},
+		{"fingerprint mismatch", CertificateMatch{Fingerprint: "00" + fingerprint[2:]}, false},
+		{"all fields must match", CertificateMatch{OU: "LivingRoom", Issuer: "Other CA"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.match.Matches(cert); got != tt.want {
+				t.Errorf("Matches() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestClientForCert(t *testing.T) {
+	cert := testCert(t)
+	tests := []struct {
+		name    string
+		clients map[string]*ClientConfig
+		require bool
+		want    string
+		wantOK  bool
+	}{
+		{"no clients uses cn", nil, false, "living-room-tv", true},
+		{"first matching client by name", map[string]*ClientConfig{
+			"tv":      {Certificates: []CertificateMatch{{OU: "LivingRoom"}}},
+			"devices": {Certificates: []CertificateMatch{{OU: "Devices"}}},
+		}, false, "devices", true},
+		{"client named like cn", map[string]*ClientConfig{"living-room-tv": {}}, true, "living-room-tv", true},
+		{"cn client with other certificates", map[string]*ClientConfig{
+			"living-room-tv": {Certificates: []CertificateMatch{{OU: "Kitchen"}}},
+		}, false, "", false},
+		{"mapping required", map[string]*ClientConfig{
+			"kitchen": {Certificates: []CertificateMatch{{OU: "Kitchen"}}},
+		}, true, "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{Clients: tt.clients, Auth: AuthConfig{MTLS: MTLSConfig{RequireMapping: tt.require}}}
+			got, ok := cfg.ClientForCert(cert)
+			if got != tt.want || ok != tt.wantOK {
+				t.Errorf("ClientForCert() = %q, %v, want %q, %v", got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
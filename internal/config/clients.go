@@ -0,0 +1,198 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+
+	"arr-proxy/internal/apikeys"
+
+	"github.com/spf13/viper"
+	"gopkg.in/yaml.v3"
+)
+
+// loadClientsConfig loads per-client restrictions from clients.yaml and the clients
+// section of the unified config file, which takes precedence for duplicate names.
+// Returns an empty map if neither defines clients.
+func loadClientsConfig(unified *unifiedConfig, configPaths ...string) (map[string]*ClientConfig, Problems) {
+	var problems Problems
+	v := viper.New()
+	v.SetConfigName("clients")
+	v.SetConfigType("yaml")
+	for _, p := range configPaths {
+		v.AddConfigPath(p)
+	}
+
+	clients := make(map[string]*ClientConfig)
+	if err := v.ReadInConfig(); err != nil {
+		if _, ok := err.(viper.ConfigFileNotFoundError); !ok {
+			problems.Errorf("failed to read clients config: %v", err)
+		}
+	} else if data, err := os.ReadFile(v.ConfigFileUsed()); err != nil {
+		problems.Errorf("failed to read clients config: %v", err)
+	} else {
+		legacy, parseProblems := parseClients(data, "clients.yaml")
+		problems.Merge(parseProblems)
+		clients = legacy
+	}
+
+	if unified.clients == nil {
+		return clients, problems
+	}
+
+	// Only the clients section is decoded strictly; other sections are validated elsewhere
+	var doc map[string]yaml.Node
+	if err := yaml.Unmarshal(unified.clients, &doc); err != nil {
+		problems.Errorf("failed to parse %s: %v", unified.name(), err)
+		return clients, problems
+	}
+	section, err := yaml.Marshal(map[string]yaml.Node{"clients": doc["clients"]})
+	if err != nil {
+		problems.Errorf("failed to parse %s clients: %v", unified.name(), err)
+		return clients, problems
+	}
+	fromUnified, parseProblems := parseClients(section, unified.name())
+	problems.Merge(parseProblems)
+
+	names := make([]string, 0, len(fromUnified))
+	for name := range fromUnified {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if existing, ok := clients[name]; ok && !sameValue(*existing, *fromUnified[name]) {
+			problems.Warnf("client %q is defined in both %s and clients.yaml, using %s", name, unified.name(), unified.name())
+		}
+		clients[name] = fromUnified[name]
+	}
+	return clients, problems
+}
+
+// parseClients decodes a document with a top-level clients map. Client names are
+// identities and case-sensitive, so documents are decoded directly instead of
+// through viper (which lowercases map keys).
+func parseClients(data []byte, source string) (map[string]*ClientConfig, Problems) {
+	var problems Problems
+	clients := make(map[string]*ClientConfig)
+
+	var raw struct {
+		Clients map[string]*ClientConfig `yaml:"clients"`
+	}
+	if err := decodeYAMLStrict(data, &raw); err != nil {
+		var typeErr *yaml.TypeError
+		if !errors.As(err, &typeErr) {
+			problems.Errorf("failed to parse clients in %s: %v", source, err)
+			return clients, problems
+		}
+		// Unknown keys are reported as warnings, the lenient decode below still applies
+		for _, msg := range typeErr.Errors {
+			problems.Warnf("%s clients: %s", source, msg)
+		}
+		if err := yaml.Unmarshal(data, &raw); err != nil {
+			problems.Errorf("failed to parse clients in %s: %v", source, err)
+			return clients, problems
+		}
+	}
+	for name, client := range raw.Clients {
+		if client == nil {
+			client = &ClientConfig{}
+		}
+		client.Name = name
+		// Rule set names are case-insensitive (service files are read through viper)
+		for i, rs := range client.RuleSets {
+			client.RuleSets[i] = strings.ToLower(rs)
+		}
+		clients[name] = client
+	}
+	return clients, problems
+}
+
+// ClientFor maps a user and groups asserted by an external identity provider to a
+// client identity: the client named like the user, otherwise the first client
+// (by name) listing one of the groups, otherwise the user itself.
+func (c *Config) ClientFor(user string, groups []string) string {
+	if _, ok := c.Clients[user]; ok {
+		return user
+	}
+	names := make([]string, 0, len(c.Clients))
+	for name := range c.Clients {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		for _, group := range c.Clients[name].Groups {
+			if slices.Contains(groups, group) {
+				return name
+			}
+		}
+	}
+	return user
+}
+
+// decodeYAMLStrict decodes YAML and fails on keys that do not map to a struct field.
+func decodeYAMLStrict(data []byte, out any) error {
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(out); err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+	return nil
+}
+
+// validateClients checks that clients only reference known services and rule sets.
+func validateClients(cfg *Config) Problems {
+	names := make([]string, 0, len(cfg.Clients))
+	for name := range cfg.Clients {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var problems Problems
+	for _, name := range names {
+		client := cfg.Clients[name]
+		for _, service := range client.Services {
+			if cfg.Service(service) == nil {
+				problems.Errorf("client %q references unknown or unconfigured service %q", name, service)
+			}
+		}
+		for _, ruleSet := range client.RuleSets {
+			if !ruleSetDefined(cfg, client, ruleSet) {
+				problems.Errorf("client %q references rule set %q which no allowed service defines", name, ruleSet)
+			}
+		}
+		for i := range client.Certificates {
+			if problem := client.Certificates[i].validate(); problem != "" {
+				problems.Errorf("client %q certificates[%d] %s", name, i, problem)
+			}
+		}
+		for i, key := range client.APIKeys {
+			if !apikeys.IsHash(key) {
+				problems.Errorf("client %q api_keys[%d] is not a key hash (generate one with \"arr-proxy key generate\")", name, i)
+			}
+		}
+		rules, ipProblems := parseIPRules(fmt.Sprintf("client %q ", name), client.AllowedIPs, client.DeniedIPs)
+		client.IPRules = rules
+		problems.Merge(ipProblems)
+	}
+	return problems
+}
+
+func ruleSetDefined(cfg *Config, client *ClientConfig, ruleSet string) bool {
+	if ruleSet == DefaultRuleSet {
+		return true
+	}
+	for name, sc := range cfg.Services {
+		if !client.AllowsService(name) {
+			continue
+		}
+		if _, ok := sc.RuleSets[ruleSet]; ok {
+			return true
+		}
+	}
+	return false
+}
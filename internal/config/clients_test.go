@@ -0,0 +1,32 @@
+package config
+
+import "testing"
+
+func TestClientFor(t *testing.T) {
+	cfg := &Config{
+		Clients: map[string]*ClientConfig{
+			"alice":  {Name: "alice"},
+			"family": {Name: "family", Groups: []string{"family", "friends"}},
+			"admins": {Name: "admins", Groups: []string{"media-admins", "family"}},
+		},
+	}
+
+	tests := []struct {
+		name   string
+		user   string
+		groups []string
+		want   string
+	}{
+		{"user names a client", "alice", []string{"media-admins"}, "alice"},
+		{"group of a client", "bob", []string{"friends"}, "family"},
+		{"first client by name wins", "bob", []string{"family"}, "admins"},
+		{"unmapped user", "carol", []string{"other"}, "carol"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := cfg.ClientFor(tt.user, tt.groups); got != tt.want {
+				t.Errorf("ClientFor(%q, %q) = %q, want %q", tt.user, tt.groups, got, tt.want)
+			}
+		})
+	}
+}
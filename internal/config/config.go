@@ -4,17 +4,27 @@ package config
 import (
 	"net/url"
 	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"time"
 )
 
 const (
-	AuthModeMTLS   = "mtls"
-	AuthModeBasic  = "basic"
-	AuthModeAPIKey = "apikey"
+	AuthModeMTLS    = "mtls"
+	AuthModeBasic   = "basic"
+	AuthModeAPIKey  = "apikey"
+	AuthModeToken   = "token"
+	AuthModeJWT     = "jwt"
+	AuthModeOIDC    = "oidc" // alias of AuthModeJWT
+	AuthModeForward = "forward"
+	AuthModeHeader  = "header"
 )
 
 // WhitelistRule represents a single whitelist entry with optional method restrictions.
 // Format: "METHOD1,METHOD2:pattern" or just "pattern" (allows all methods)
 type WhitelistRule struct {
+	Raw     string          // rule as written in the configuration
 	Methods map[string]bool // nil means all methods allowed
 	Pattern *regexp.Regexp
 }
@@ -33,11 +43,47 @@ func (r *WhitelistRule) Matches(method, path string) bool {
 
 // ServiceConfig holds the configuration for a single service (like Sonarr or Radarr).
 type ServiceConfig struct {
+	Name              string
 	URL               string   `yaml:"url" mapstructure:"url"`
 	APIKey            string   `yaml:"api_key" mapstructure:"api_key"`
 	Whitelist         []string `yaml:"whitelist" mapstructure:"whitelist"`
 	CompiledWhitelist []WhitelistRule
-	ParsedURL         *url.URL
+	// RuleSets are additional named whitelists that clients can be restricted to.
+	RuleSets         map[string][]string `yaml:"rule_sets" mapstructure:"rule_sets"`
+	CompiledRuleSets map[string][]WhitelistRule
+	// CandidateWhitelist is evaluated in report-only mode alongside the enforced
+	// whitelist. Requests it would block are logged and counted but still forwarded.
+	CandidateWhitelist         []string `yaml:"candidate_whitelist" mapstructure:"candidate_whitelist"`
+	CompiledCandidateWhitelist []WhitelistRule
+	// Auth lists the auth methods accepted for this service in the order they are
+	// tried, overriding the global auth mode. Empty means the global methods.
+	Auth []string `yaml:"auth" mapstructure:"auth"`
+	// AllowedIPs and DeniedIPs restrict the client addresses accepted for this service.
+	AllowedIPs []string `yaml:"allowed_ips" mapstructure:"allowed_ips"`
+	DeniedIPs  []string `yaml:"denied_ips" mapstructure:"denied_ips"`
+	IPRules    IPRules
+	ParsedURL  *url.URL
+}
+
+// ClientConfig restricts what an authenticated client identity may access.
+// Identities without a client entry use the default whitelist of every service.
+type ClientConfig struct {
+	Name     string
+	Services []string `yaml:"services" mapstructure:"services"`   // empty means all services
+	RuleSets []string `yaml:"rule_sets" mapstructure:"rule_sets"` // empty means the default whitelist
+	APIKeys  []string `yaml:"api_keys" mapstructure:"api_keys"`   // key hashes; several allow rotation
+	Groups   []string `yaml:"groups" mapstructure:"groups"`       // identity provider groups mapped to this client
+	// Certificates select the client certificates mapped to this client.
+	Certificates []CertificateMatch `yaml:"certificates" mapstructure:"certificates"`
+	// AllowedIPs and DeniedIPs restrict the addresses the client may connect from.
+	AllowedIPs []string `yaml:"allowed_ips" mapstructure:"allowed_ips"`
+	DeniedIPs  []string `yaml:"denied_ips" mapstructure:"denied_ips"`
+	IPRules    IPRules  `yaml:"-" mapstructure:"-"`
+}
+
+// AllowsService returns true if the client may access the named service.
+func (cc *ClientConfig) AllowsService(name string) bool {
+	return len(cc.Services) == 0 || slices.Contains(cc.Services, name)
 }
 
 type BasicAuthConfig struct {
@@ -45,22 +91,135 @@ type BasicAuthConfig struct {
 	Password string
 }
 
+// TokenConfig configures the short-lived tokens minted by the proxy.
+type TokenConfig struct {
+	Algorithm string        // HS256 or EdDSA
+	Key       string        // HS256 secret or PEM encoded Ed25519 private key
+	MaxTTL    time.Duration // longest lifetime a client may request
+}
+
+// JWTConfig configures validation of tokens issued by an external identity provider.
+type JWTConfig struct {
+	JWKS          string        // key set file or URL
+	JWKSRefresh   time.Duration // how often the key set is reloaded
+	Issuer        string        // required iss claim
+	Audience      string        // required aud claim
+	IdentityClaim string        // claim naming the client identity, dots select nested claims
+	GroupsClaim   string        // claim listing the groups matched against client groups
+}
+
+// ForwardAuthConfig configures delegation of authentication to an external service.
+type ForwardAuthConfig struct {
+	URL            string        // auth endpoint, e.g. Authelia's /api/authz/forward-auth
+	Headers        []string      // request headers passed to the auth endpoint
+	IdentityHeader string        // response header naming the user
+	GroupsHeader   string        // response header listing the user's groups
+	CacheTTL       time.Duration // how long decisions are cached, zero disables caching
+}
+
+// TrustedHeaderConfig configures identities asserted by an authenticating reverse proxy.
+type TrustedHeaderConfig struct {
+	UserHeader   string // request header naming the user
+	GroupsHeader string // request header listing the user's groups
+}
+
+// MTLSConfig configures how client certificates map to client identities.
+type MTLSConfig struct {
+	RequireMapping bool // reject certificates that no client's certificates entries match
+}
+
+// LockoutConfig configures bans of clients after repeated authentication failures.
+type LockoutConfig struct {
+	Threshold  int           // failures that start a ban, 0 disables lockout
+	Window     time.Duration // period the failures are counted in
+	BanTime    time.Duration // length of the first ban, doubled for every further ban
+	MaxBanTime time.Duration // longest ban
+}
+
+// Enabled returns true if clients are banned after repeated failures.
+func (lc *LockoutConfig) Enabled() bool {
+	return lc.Threshold > 0
+}
+
 type AuthConfig struct {
-	Mode      string
+	Mode      string // auth method, or comma-separated methods tried in order
 	BasicAuth BasicAuthConfig
 	APIKey    string
+	AdminKey  string // key (or hash) for the admin API; empty disables it
+	Token     TokenConfig
+	JWT       JWTConfig
+	Forward   ForwardAuthConfig
+	Header    TrustedHeaderConfig
+	MTLS      MTLSConfig
+	Lockout   LockoutConfig
+}
+
+// Methods returns the globally accepted auth methods in the order they are tried.
+func (a *AuthConfig) Methods() []string {
+	return splitMethods(a.Mode)
+}
+
+// splitMethods splits a comma-separated list of auth methods.
+func splitMethods(mode string) []string {
+	var methods []string
+	for _, m := range strings.Split(mode, ",") {
+		if m = strings.TrimSpace(m); m != "" {
+			methods = append(methods, m)
+		}
+	}
+	return methods
+}
+
+// KeyPair is a certificate file and its private key file.
+type KeyPair struct {
+	Cert string
+	Key  string
+}
+
+// TLSConfig configures server certificates beyond APP_TLS_CERT and APP_TLS_KEY.
+type TLSConfig struct {
+	Certificates    []KeyPair // additional certificates, selected by SNI
+	SelfSigned      bool      // generate a self-signed certificate if the files do not exist
+	SelfSignedHosts []string  // names and addresses of the self-signed certificate
+}
+
+// RevocationConfig lists revoked client certificates.
+type RevocationConfig struct {
+	CRLFiles []string // CRLs signed by the client CA, PEM or DER
+	Denylist string   // file listing revoked serial numbers and fingerprints
+}
+
+// Enabled returns true if revoked certificates are checked.
+func (rc *RevocationConfig) Enabled() bool {
+	return len(rc.CRLFiles) > 0 || rc.Denylist != ""
+}
+
+// EnrollmentConfig configures the endpoint where devices obtain client certificates
+// from the built-in CA with one-time enrollment tokens.
+type EnrollmentConfig struct {
+	CADir    string        // directory of the built-in CA; empty disables enrollment
+	Validity time.Duration // validity of enrolled certificates
+}
+
+// Enabled returns true if devices can enroll client certificates.
+func (ec *EnrollmentConfig) Enabled() bool {
+	return ec.CADir != ""
 }
 
 // Config holds the application configuration.
 type Config struct {
-	Sonarr  *ServiceConfig // nil if not configured
-	Radarr  *ServiceConfig // nil if not configured
-	TLSCert string
-	TLSKey  string
-	CACert  string
-	Port    string
-	Auth    AuthConfig
-	Server  ServerConfig
+	Services   map[string]*ServiceConfig // keyed by name, only configured services
+	TLSCert    string
+	TLSKey     string
+	CACert     string
+	TLS        TLSConfig
+	Revocation RevocationConfig
+	Enrollment EnrollmentConfig
+	Network    NetworkConfig
+	Port       string
+	Auth       AuthConfig
+	Server     ServerConfig
+	Clients    map[string]*ClientConfig // keyed by identity name
 }
 
 // IsWhitelisted checks if a given method and path combination is whitelisted for the service.
@@ -73,7 +232,95 @@ func (sc *ServiceConfig) IsWhitelisted(method, path string) bool {
 	return false
 }
 
+// Service returns the configuration of the named service, or nil if it is not configured.
+func (c *Config) Service(name string) *ServiceConfig {
+	return c.Services[name]
+}
+
+// ServiceNames returns the names of all configured services in sorted order.
+func (c *Config) ServiceNames() []string {
+	names := make([]string, 0, len(c.Services))
+	for name := range c.Services {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// HasCandidateWhitelist returns true if a report-only candidate whitelist is configured.
+func (sc *ServiceConfig) HasCandidateWhitelist() bool {
+	return len(sc.CandidateWhitelist) > 0
+}
+
+// IsCandidateWhitelisted checks if a given method and path combination is allowed by the candidate whitelist.
+func (sc *ServiceConfig) IsCandidateWhitelisted(method, path string) bool {
+	for _, rule := range sc.CompiledCandidateWhitelist {
+		if rule.Matches(method, path) {
+			return true
+		}
+	}
+	return false
+}
+
+// hasClientAPIKeys returns true if any client has its own API keys.
+func (c *Config) hasClientAPIKeys() bool {
+	for _, client := range c.Clients {
+		if len(client.APIKeys) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// hasClientCertificates returns true if any client maps client certificates.
+func (c *Config) hasClientCertificates() bool {
+	for _, client := range c.Clients {
+		if len(client.Certificates) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// AdminEnabled returns true if the admin API, managing keys and bans, is available.
+func (c *Config) AdminEnabled() bool {
+	return c.Auth.AdminKey != "" && (c.Server.KeyStore != "" || c.Auth.Lockout.Enabled())
+}
+
+// AuthMethods returns the auth methods accepted for the named service in the
+// order they are tried. An empty name returns the global methods.
+func (c *Config) AuthMethods(service string) []string {
+	if sc := c.Service(service); sc != nil && len(sc.Auth) > 0 {
+		return sc.Auth
+	}
+	return c.Auth.Methods()
+}
+
+// UsesAuth returns true if the auth method is accepted globally or for any service.
+func (c *Config) UsesAuth(method string) bool {
+	if slices.Contains(c.Auth.Methods(), method) {
+		return true
+	}
+	for _, sc := range c.Services {
+		if slices.Contains(sc.Auth, method) {
+			return true
+		}
+	}
+	return false
+}
+
+// TokensEnabled returns true if clients can mint and use proxy tokens.
+func (c *Config) TokensEnabled() bool {
+	return c.UsesAuth(AuthModeToken) && c.Auth.Token.Key != ""
+}
+
 // TLSEnabled returns true if TLS certificates are configured.
 func (c *Config) TLSEnabled() bool {
 	return c.TLSCert != "" && c.TLSKey != ""
 }
+
+// Certificates returns the server certificates, APP_TLS_CERT first. It is served to
+// clients whose SNI matches no other certificate.
+func (c *Config) Certificates() []KeyPair {
+	return append([]KeyPair{{Cert: c.TLSCert, Key: c.TLSKey}}, c.TLS.Certificates...)
+}
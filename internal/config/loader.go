@@ -2,25 +2,33 @@
 package config
 
 import (
-	"errors"
 	"fmt"
 	"log/slog"
+	"net/netip"
 	"os"
 	"regexp"
+	"slices"
+	"sort"
+	"strconv"
 	"strings"
 
+	"arr-proxy/internal/tokens"
+
 	"github.com/spf13/viper"
 )
 
-// compileWhitelist parses whitelist patterns into WhitelistRules.
+// compileRules parses whitelist patterns into WhitelistRules and returns a problem
+// description for every pattern that could not be compiled.
 // Supported formats:
 //   - "^/api/v3/path$"           -> all methods allowed
 //   - "GET:^/api/v3/path$"       -> only GET allowed
 //   - "GET,POST:^/api/v3/path$"  -> GET and POST allowed
-func compileWhitelist(patterns []string) []WhitelistRule {
+func compileRules(patterns []string) ([]WhitelistRule, []string) {
 	compiled := make([]WhitelistRule, 0, len(patterns))
-	for _, p := range patterns {
-		rule := WhitelistRule{}
+	var problems []string
+	for _, raw := range patterns {
+		p := raw
+		rule := WhitelistRule{Raw: raw}
 
 		// Check if pattern has method prefix (e.g., "GET,POST:^/path$")
 		if idx := strings.Index(p, ":"); idx > 0 {
@@ -40,13 +48,13 @@ func compileWhitelist(patterns []string) []WhitelistRule {
 
 		re, err := regexp.Compile(p)
 		if err != nil {
-			slog.Warn("Failed to compile whitelist regex, skipping", "pattern", p, "error", err)
+			problems = append(problems, fmt.Sprintf("invalid pattern %q: %v", raw, err))
 			continue
 		}
 		rule.Pattern = re
 		compiled = append(compiled, rule)
 	}
-	return compiled
+	return compiled, problems
 }
 
 // isValidMethodSpec checks if a string looks like HTTP method(s)
@@ -63,102 +71,366 @@ func isValidMethodSpec(s string) bool {
 	return true
 }
 
+// appEnvKeys maps app settings to the environment variables overriding them.
+var appEnvKeys = map[string]string{
+	"tls_cert":                     "APP_TLS_CERT",
+	"tls_key":                      "APP_TLS_KEY",
+	"tls_certificates":             "APP_TLS_CERTIFICATES",
+	"tls_self_signed":              "APP_TLS_SELF_SIGNED",
+	"tls_self_signed_hosts":        "APP_TLS_SELF_SIGNED_HOSTS",
+	"ca_cert":                      "APP_CA_CERT",
+	"crl_files":                    "APP_CRL_FILES",
+	"cert_denylist":                "APP_CERT_DENYLIST",
+	"ca_dir":                       "APP_CA_DIR",
+	"enroll_validity":              "APP_ENROLL_VALIDITY",
+	"port":                         "APP_PORT",
+	"auth_mode":                    "APP_AUTH_MODE",
+	"basic_auth_user":              "APP_BASIC_AUTH_USER",
+	"basic_auth_pass":              "APP_BASIC_AUTH_PASS",
+	"api_key":                      "APP_API_KEY",
+	"admin_key":                    "APP_ADMIN_KEY",
+	"token_algorithm":              "APP_TOKEN_ALGORITHM",
+	"token_key":                    "APP_TOKEN_KEY",
+	"token_max_ttl":                "APP_TOKEN_MAX_TTL",
+	"jwt_jwks":                     "APP_JWT_JWKS",
+	"jwt_jwks_refresh":             "APP_JWT_JWKS_REFRESH",
+	"jwt_issuer":                   "APP_JWT_ISSUER",
+	"jwt_audience":                 "APP_JWT_AUDIENCE",
+	"jwt_identity_claim":           "APP_JWT_IDENTITY_CLAIM",
+	"jwt_groups_claim":             "APP_JWT_GROUPS_CLAIM",
+	"forward_auth_url":             "APP_FORWARD_AUTH_URL",
+	"forward_auth_headers":         "APP_FORWARD_AUTH_HEADERS",
+	"forward_auth_identity_header": "APP_FORWARD_AUTH_IDENTITY_HEADER",
+	"forward_auth_groups_header":   "APP_FORWARD_AUTH_GROUPS_HEADER",
+	"forward_auth_cache_ttl":       "APP_FORWARD_AUTH_CACHE_TTL",
+	"header_auth_user":             "APP_HEADER_AUTH_USER",
+	"header_auth_groups":           "APP_HEADER_AUTH_GROUPS",
+	"trusted_proxies":              "APP_TRUSTED_PROXIES",
+	"allowed_ips":                  "APP_ALLOWED_IPS",
+	"denied_ips":                   "APP_DENIED_IPS",
+	"proxy_protocol":               "APP_PROXY_PROTOCOL",
+	"forwarded_header":             "APP_FORWARDED_HEADER",
+	"mtls_require_mapping":         "APP_MTLS_REQUIRE_MAPPING",
+	"lockout_threshold":            "APP_LOCKOUT_THRESHOLD",
+	"lockout_window":               "APP_LOCKOUT_WINDOW",
+	"lockout_ban_time":             "APP_LOCKOUT_BAN_TIME",
+	"lockout_max_ban_time":         "APP_LOCKOUT_MAX_BAN_TIME",
+}
+
+// defaultForwardAuthHeaders are the credential headers passed to a forward-auth service.
+var defaultForwardAuthHeaders = []string{"Authorization", "Cookie", "Proxy-Authorization", "X-Api-Key"}
+
+// appSecretKeys are the app settings that are resolved as secrets.
+var appSecretKeys = []string{"api_key", "basic_auth_user", "basic_auth_pass", "admin_key", "token_key"}
+
+// validServiceName restricts service names, which are used as URL path prefixes
+// and environment variable prefixes.
+var validServiceName = regexp.MustCompile(`^[a-z][a-z0-9-]*$`)
+
+// reservedServiceNames would clash with the proxy's own endpoints.
+var reservedServiceNames = map[string]bool{"info": true, "admin": true, "token": true, "enroll": true}
+
+// listValue returns a list setting, which environment variables give as a
+// comma-separated string.
+func listValue(v *viper.Viper, key string) []string {
+	value, ok := v.Get(key).(string)
+	if !ok {
+		return v.GetStringSlice(key)
+	}
+	var list []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// keyPairList returns a list of certificate and key files. Environment variables
+// give them as comma-separated CERT:KEY pairs, the config file as a list of maps
+// with cert and key.
+func keyPairList(v *viper.Viper, key, label string) ([]KeyPair, Problems) {
+	var problems Problems
+	var items []any
+	switch value := v.Get(key).(type) {
+	case nil:
+	case string:
+		for _, item := range listValue(v, key) {
+			items = append(items, item)
+		}
+	case []any:
+		items = value
+	default:
+		problems.Errorf("%s: expected a list of certificates", label)
+	}
+
+	var pairs []KeyPair
+	for i, item := range items {
+		var pair KeyPair
+		switch item := item.(type) {
+		case string:
+			pair.Cert, pair.Key, _ = strings.Cut(item, ":")
+		case map[string]any:
+			pair.Cert, _ = item["cert"].(string)
+			pair.Key, _ = item["key"].(string)
+		}
+		if pair.Cert == "" || pair.Key == "" {
+			problems.Errorf("%s[%d]: certificate and key required (use CERT:KEY, or cert and key in the config file)", label, i)
+			continue
+		}
+		pairs = append(pairs, pair)
+	}
+	return pairs, problems
+}
+
+// authMethodNames are the valid auth methods, after resolving aliases.
+var authMethodNames = []string{
+	AuthModeAPIKey, AuthModeToken, AuthModeJWT, AuthModeForward, AuthModeHeader, AuthModeMTLS, AuthModeBasic,
+}
+
+// normalizeAuthMethods resolves aliases in a list of auth methods.
+func normalizeAuthMethods(methods []string) []string {
+	for i, m := range methods {
+		if m == AuthModeOIDC {
+			methods[i] = AuthModeJWT
+		}
+	}
+	return methods
+}
+
+// usedAuthMethods returns the auth methods accepted globally or for any service,
+// each once, and checks that the methods of each list can be combined.
+func usedAuthMethods(cfg *Config) ([]string, Problems) {
+	var problems Problems
+	var used []string
+	check := func(label string, methods []string, global bool) {
+		for i, m := range methods {
+			// Invalid global modes are reported with the other mode checks
+			if !global && !slices.Contains(authMethodNames, m) {
+				problems.Errorf("invalid %s method %q (valid methods: apikey, token, jwt, oidc, forward, header, mtls, basic)", label, m)
+				continue
+			}
+			if !slices.Contains(used, m) {
+				used = append(used, m)
+			}
+			if m == AuthModeForward && i < len(methods)-1 {
+				problems.Warnf("%s: forward auth decides every request, methods listed after it are never tried", label)
+			}
+		}
+		if slices.Contains(methods, AuthModeToken) && slices.Contains(methods, AuthModeJWT) {
+			problems.Errorf("%s: token and jwt auth cannot be combined, both use bearer tokens", label)
+		}
+	}
+	check("APP_AUTH_MODE", cfg.Auth.Methods(), true)
+	for _, name := range cfg.ServiceNames() {
+		if sc := cfg.Services[name]; len(sc.Auth) > 0 {
+			check(name+" auth", sc.Auth, false)
+		}
+	}
+	return used, problems
+}
+
+// parsePrefixes parses a list of CIDRs; single addresses are accepted as
+// host prefixes. label names the setting in problem messages.
+func parsePrefixes(label string, list []string) ([]netip.Prefix, Problems) {
+	var problems Problems
+	var prefixes []netip.Prefix
+	for _, s := range list {
+		if addr, err := netip.ParseAddr(s); err == nil {
+			prefixes = append(prefixes, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+			continue
+		}
+		p, err := netip.ParsePrefix(s)
+		if err != nil {
+			problems.Errorf("%s: invalid CIDR %q", label, s)
+			continue
+		}
+		prefixes = append(prefixes, p.Masked())
+	}
+	return prefixes, problems
+}
+
+// LoadOptions controls how strictly the configuration is validated.
+type LoadOptions struct {
+	// Lenient downgrades service and client errors (invalid URL, missing api_key,
+	// uncompilable pattern, unknown rule set, ...) to warnings. Unusable services are
+	// disabled instead of failing the whole configuration.
+	Lenient bool
+}
+
 // Load loads the entire application configuration.
-// Returns an error if configuration is invalid.
+// Lenient mode is enabled with APP_CONFIG_LENIENT=true.
+// Returns a *ValidationError if configuration is invalid.
 func Load() (Config, error) {
+	lenient, _ := strconv.ParseBool(os.Getenv("APP_CONFIG_LENIENT"))
+	return LoadWithOptions(LoadOptions{Lenient: lenient})
+}
+
+// LoadWithOptions loads the entire application configuration with the given options.
+// Warnings are logged. Returns a *ValidationError if configuration is invalid.
+func LoadWithOptions(opts LoadOptions) (Config, error) {
 	configDir := os.Getenv("APP_CONFIG_DIR")
 	if configDir == "" {
 		configDir = "config"
 	}
 
-	appViper := viper.New()
-	appViper.SetDefault("port", "8443")
-	appViper.SetEnvPrefix("APP")
-	appViper.AutomaticEnv()
+	// Validate configuration - collect all problems before failing.
+	// Service and client problems can be downgraded in lenient mode, global ones cannot.
+	var globalProblems, serviceProblems Problems
 
-	_ = appViper.BindEnv("tls_cert", "APP_TLS_CERT")
-	_ = appViper.BindEnv("tls_key", "APP_TLS_KEY")
-	_ = appViper.BindEnv("ca_cert", "APP_CA_CERT")
-	_ = appViper.BindEnv("port", "APP_PORT")
-	_ = appViper.BindEnv("auth_mode", "APP_AUTH_MODE")
-	_ = appViper.BindEnv("basic_auth_user", "APP_BASIC_AUTH_USER")
-	_ = appViper.BindEnv("basic_auth_pass", "APP_BASIC_AUTH_PASS")
-	_ = appViper.BindEnv("api_key", "APP_API_KEY")
-
-	authMode := appViper.GetString("auth_mode")
-	if authMode == "" {
-		authMode = AuthModeAPIKey
+	unified, problems := loadUnifiedConfig(configDir)
+	globalProblems.Merge(problems)
+	if unified.path != "" {
+		slog.Info("Using unified config file", "path", unified.path)
 	}
+	appViper := newAppViper(unified, &globalProblems)
+
+	server, problems := loadServerConfig(unified.server, unified.name(), configDir)
+	globalProblems.Merge(problems)
+	auth, problems := loadAuthConfig(appViper)
+	globalProblems.Merge(problems)
+	enrollment, problems := loadEnrollmentConfig(appViper)
+	globalProblems.Merge(problems)
+	network, problems := loadNetworkConfig(appViper)
+	globalProblems.Merge(problems)
+	tlsCert, tlsKey, tlsConfig, problems := loadTLSConfig(appViper, configDir)
+	globalProblems.Merge(problems)
 
 	cfg := Config{
-		Sonarr:  LoadSonarrConfig(configDir),
-		Radarr:  LoadRadarrConfig(configDir),
-		TLSCert: appViper.GetString("tls_cert"),
-		TLSKey:  appViper.GetString("tls_key"),
+		TLSCert: tlsCert,
+		TLSKey:  tlsKey,
 		CACert:  appViper.GetString("ca_cert"),
 		Port:    appViper.GetString("port"),
-		Auth: AuthConfig{
-			Mode: authMode,
-			BasicAuth: BasicAuthConfig{
-				User:     appViper.GetString("basic_auth_user"),
-				Password: appViper.GetString("basic_auth_pass"),
-			},
-			APIKey: appViper.GetString("api_key"),
+		TLS:     tlsConfig,
+		Auth:    auth,
+		Revocation: RevocationConfig{
+			CRLFiles: listValue(appViper, "crl_files"),
+			Denylist: appViper.GetString("cert_denylist"),
 		},
-		Server: LoadServerConfig(configDir),
+		Enrollment: enrollment,
+		Network:    network,
+		Server:     server,
 	}
 
-	// Validate configuration - collect all errors before failing
-	var configErrors []string
+	names, problems := serviceNames(unified)
+	globalProblems.Merge(problems)
+	cfg.Services, problems = loadServices(names, unified, configDir, server.LowercasePaths)
+	serviceProblems.Merge(problems)
+
+	clients, problems := loadClientsConfig(unified, configDir)
+	cfg.Clients = clients
+	serviceProblems.Merge(problems)
+	serviceProblems.Merge(validateClients(&cfg))
+
+	if opts.Lenient {
+		serviceProblems.Downgrade()
+	}
+	globalProblems.Merge(serviceProblems)
 
 	// At least one service must be configured
-	if cfg.Sonarr == nil && cfg.Radarr == nil {
-		configErrors = append(configErrors, "at least one service must be configured (set SONARR_URL or RADARR_URL)")
+	if len(cfg.Services) == 0 {
+		globalProblems.Errorf("at least one service must be configured (set SONARR_URL or RADARR_URL, or add services to %s)", unified.name())
 	}
 
-	switch cfg.Auth.Mode {
-	case AuthModeBasic:
-		if cfg.Auth.BasicAuth.User == "" {
-			configErrors = append(configErrors, "APP_BASIC_AUTH_USER required for basic auth mode")
-		}
-		if cfg.Auth.BasicAuth.Password == "" {
-			configErrors = append(configErrors, "APP_BASIC_AUTH_PASS required for basic auth mode")
-		}
-	case AuthModeAPIKey:
-		if cfg.Auth.APIKey == "" {
-			configErrors = append(configErrors, "APP_API_KEY required for apikey auth mode")
-		}
-	case AuthModeMTLS:
-		// mTLS requires all TLS certificates
-		if cfg.TLSCert == "" {
-			configErrors = append(configErrors, "APP_TLS_CERT required for mTLS mode")
-		}
-		if cfg.TLSKey == "" {
-			configErrors = append(configErrors, "APP_TLS_KEY required for mTLS mode")
-		}
-		if cfg.CACert == "" {
-			configErrors = append(configErrors, "APP_CA_CERT required for mTLS mode (CA to verify client certs)")
-		}
-	default:
-		configErrors = append(configErrors, fmt.Sprintf("invalid APP_AUTH_MODE '%s' (valid modes: apikey, mtls, basic)", cfg.Auth.Mode))
+	// Settings that depend on each other are checked once everything is loaded
+	for _, validate := range []func(*Config) Problems{
+		validateAuthMethods,
+		validateAdminAPI,
+		validateUnusedAuthSettings,
+		validateRevocation,
+		validateEnrollment,
+		validateTLS,
+	} {
+		globalProblems.Merge(validate(&cfg))
 	}
 
-	// If TLS cert is provided, key must also be provided
-	if (cfg.TLSCert != "" && cfg.TLSKey == "") || (cfg.TLSCert == "" && cfg.TLSKey != "") {
-		configErrors = append(configErrors, "APP_TLS_CERT and APP_TLS_KEY must both be set for HTTPS")
+	for _, w := range globalProblems.Warnings {
+		slog.Warn("Configuration warning", "problem", w)
 	}
 
-	if len(configErrors) > 0 {
-		return Config{}, errors.New("configuration validation failed: " + strings.Join(configErrors, "; "))
+	if len(globalProblems.Errors) > 0 {
+		return Config{}, &ValidationError{Errors: globalProblems.Errors, Warnings: globalProblems.Warnings}
 	}
 
 	// Log configured services
-	if cfg.Sonarr != nil {
-		slog.Info("Sonarr configured", "url", cfg.Sonarr.URL)
-	}
-	if cfg.Radarr != nil {
-		slog.Info("Radarr configured", "url", cfg.Radarr.URL)
+	for _, name := range cfg.ServiceNames() {
+		slog.Info("Service configured", "service", name, "url", cfg.Services[name].URL)
 	}
 
 	return cfg, nil
 }
+
+// newAppViper returns the app settings: defaults, overridden by the unified file,
+// overridden by environment variables.
+func newAppViper(unified *unifiedConfig, problems *Problems) *viper.Viper {
+	v := viper.New()
+	v.SetDefault("port", "8443")
+	v.SetDefault("forwarded_header", "X-Forwarded-For")
+	v.SetDefault("token_algorithm", tokens.AlgHS256)
+	v.SetDefault("token_max_ttl", "1h")
+	v.SetDefault("jwt_jwks_refresh", "1h")
+	v.SetDefault("jwt_identity_claim", "sub")
+	v.SetDefault("jwt_groups_claim", "groups")
+	v.SetDefault("forward_auth_headers", defaultForwardAuthHeaders)
+	v.SetDefault("forward_auth_identity_header", "Remote-User")
+	v.SetDefault("forward_auth_groups_header", "Remote-Groups")
+	v.SetDefault("forward_auth_cache_ttl", "10s")
+	v.SetDefault("header_auth_user", "X-Forwarded-User")
+	v.SetDefault("header_auth_groups", "X-Forwarded-Groups")
+	v.SetDefault("enroll_validity", "8760h")
+	v.SetDefault("lockout_threshold", 0)
+	v.SetDefault("lockout_window", "10m")
+	v.SetDefault("lockout_ban_time", "5m")
+	v.SetDefault("lockout_max_ban_time", "24h")
+	mergeUnified(v, "app", unified.name(), "", unified.app, appEnvKeys, problems)
+	v.SetEnvPrefix("APP")
+	v.AutomaticEnv()
+
+	for key, env := range appEnvKeys {
+		_ = v.BindEnv(key, env)
+	}
+	return v
+}
+
+// serviceNames returns the names of the configured services in order. Sonarr and
+// Radarr can be configured through their own files and environment variables; any
+// other service must be declared in the unified file. Invalid names are reported
+// and skipped.
+func serviceNames(unified *unifiedConfig) ([]string, Problems) {
+	var problems Problems
+	names := []string{"sonarr", "radarr"}
+	for name := range unified.services {
+		if !slices.Contains(names, name) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	valid := names[:0]
+	for _, name := range names {
+		if !validServiceName.MatchString(name) || reservedServiceNames[name] {
+			problems.Errorf("invalid service name %q in %s (use lowercase letters, digits and dashes; \"info\", \"admin\", \"token\" and \"enroll\" are reserved)", name, unified.name())
+			continue
+		}
+		valid = append(valid, name)
+	}
+	return valid, problems
+}
+
+// loadServices loads the named services. Services with configuration errors are
+// disabled and left out.
+func loadServices(names []string, unified *unifiedConfig, configDir string, lowercasePaths bool) (map[string]*ServiceConfig, Problems) {
+	var problems Problems
+	services := make(map[string]*ServiceConfig, len(names))
+	for _, name := range names {
+		sc, p := loadServiceConfig(name, unified.services[name], unified.name(), configDir)
+		problems.Merge(p)
+		if sc == nil {
+			continue
+		}
+		if lowercasePaths {
+			sc.ignorePathCase()
+		}
+		services[name] = sc
+	}
+	return services, problems
+}
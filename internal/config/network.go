@@ -0,0 +1,103 @@
+package config
+
+import (
+	"net/http"
+	"net/netip"
+	"slices"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// NetworkConfig restricts the client addresses requests are accepted from.
+type NetworkConfig struct {
+	TrustedProxies  []netip.Prefix // reverse proxies whose forwarding headers are trusted
+	ForwardedHeader string         // the one header the trusted proxies append the client address to
+	ProxyProtocol   []netip.Prefix // load balancers that must send a PROXY protocol header
+	IPRules         IPRules        // global client address rules
+}
+
+// loadNetworkConfig loads the client address settings of the app settings v.
+func loadNetworkConfig(v *viper.Viper) (NetworkConfig, Problems) {
+	var problems Problems
+	trustedProxies, p := parsePrefixes("APP_TRUSTED_PROXIES", listValue(v, "trusted_proxies"))
+	problems.Merge(p)
+	forwardedHeader, ok := forwardedHeaderName(v.GetString("forwarded_header"))
+	if !ok {
+		problems.Errorf("invalid APP_FORWARDED_HEADER %q (use X-Forwarded-For, Forwarded or X-Real-IP)", v.GetString("forwarded_header"))
+	}
+	allowedIPs, p := parsePrefixes("APP_ALLOWED_IPS", listValue(v, "allowed_ips"))
+	problems.Merge(p)
+	deniedIPs, p := parsePrefixes("APP_DENIED_IPS", listValue(v, "denied_ips"))
+	problems.Merge(p)
+	proxyProtocol, p := parsePrefixes("APP_PROXY_PROTOCOL", listValue(v, "proxy_protocol"))
+	problems.Merge(p)
+	for _, prefix := range proxyProtocol {
+		if prefix.Bits() == 0 {
+			problems.Warnf("APP_PROXY_PROTOCOL contains %s, any client can claim another address", prefix)
+		}
+	}
+	return NetworkConfig{
+		TrustedProxies:  trustedProxies,
+		ForwardedHeader: forwardedHeader,
+		ProxyProtocol:   proxyProtocol,
+		IPRules:         IPRules{Allowed: allowedIPs, Denied: deniedIPs},
+	}, problems
+}
+
+// forwardedHeaders are the headers a trusted proxy can announce the client in.
+var forwardedHeaders = []string{"X-Forwarded-For", "Forwarded", "X-Real-IP"}
+
+// forwardedHeaderName returns the canonical name of a forwarded header, or false
+// if the header is not supported.
+func forwardedHeaderName(name string) (string, bool) {
+	i := slices.IndexFunc(forwardedHeaders, func(h string) bool { return strings.EqualFold(h, strings.TrimSpace(name)) })
+	if i < 0 {
+		return http.CanonicalHeaderKey(name), false
+	}
+	return forwardedHeaders[i], true
+}
+
+// IPRules allow and deny client networks. Denied networks take precedence; if
+// Allowed is not empty, any address outside it is denied as well.
+type IPRules struct {
+	Allowed []netip.Prefix
+	Denied  []netip.Prefix
+}
+
+// denyAll replaces rules that could not be parsed in lenient mode, so a broken
+// deny list does not open access.
+var denyAll = IPRules{Denied: []netip.Prefix{netip.MustParsePrefix("0.0.0.0/0"), netip.MustParsePrefix("::/0")}}
+
+// Empty returns true if the rules permit every address.
+func (r IPRules) Empty() bool {
+	return len(r.Allowed) == 0 && len(r.Denied) == 0
+}
+
+// Check returns why addr is not permitted, or an empty string if it is.
+func (r IPRules) Check(addr netip.Addr) string {
+	addr = addr.Unmap()
+	contains := func(p netip.Prefix) bool { return p.Contains(addr) }
+	if i := slices.IndexFunc(r.Denied, contains); i >= 0 {
+		return "address in denied_ips " + r.Denied[i].String()
+	}
+	if len(r.Allowed) > 0 && !slices.ContainsFunc(r.Allowed, contains) {
+		return "address not in allowed_ips"
+	}
+	return ""
+}
+
+// parseIPRules parses allowed and denied CIDRs or addresses; label prefixes the
+// setting names in problem messages. Rules with errors deny every address.
+func parseIPRules(label string, allowed, denied []string) (IPRules, Problems) {
+	var problems, p Problems
+	var rules IPRules
+	rules.Allowed, p = parsePrefixes(label+"allowed_ips", allowed)
+	problems.Merge(p)
+	rules.Denied, p = parsePrefixes(label+"denied_ips", denied)
+	problems.Merge(p)
+	if len(problems.Errors) > 0 {
+		return denyAll, problems
+	}
+	return rules, problems
+}
@@ -0,0 +1,86 @@
+package config
+
+import (
+	"net/netip"
+	"testing"
+)
+
+func TestIPRulesCheck(t *testing.T) {
+	rules := IPRules{
+		Allowed: []netip.Prefix{netip.MustParsePrefix("192.168.1.0/24"), netip.MustParsePrefix("fd00::/8")},
+		Denied:  []netip.Prefix{netip.MustParsePrefix("192.168.1.13/32")},
+	}
+	tests := []struct {
+		addr    string
+		allowed bool
+	}{
+		{"192.168.1.10", true},
+		{"::ffff:192.168.1.10", true},
+		{"fd00::1", true},
+		{"192.168.1.13", false},
+		{"10.0.0.1", false},
+	}
+	for _, tt := range tests {
+		if reason := rules.Check(netip.MustParseAddr(tt.addr)); (reason == "") != tt.allowed {
+			t.Errorf("Check(%s) = %q, want allowed %v", tt.addr, reason, tt.allowed)
+		}
+	}
+
+	denyOnly := IPRules{Denied: rules.Denied}
+	if reason := denyOnly.Check(netip.MustParseAddr("10.0.0.1")); reason != "" {
+		t.Errorf("Check() without allowed networks = %q, want allowed", reason)
+	}
+	if reason := (IPRules{}).Check(netip.MustParseAddr("10.0.0.1")); reason != "" {
+		t.Errorf("Check() of empty rules = %q, want allowed", reason)
+	}
+}
+
+func TestLoadIPRules(t *testing.T) {
+	dir := t.TempDir()
+	writeServiceFile(t, dir, "arr-proxy", `network:
+  allowed_ips: [192.168.0.0/16, 10.0.0.1]
+services:
+  sonarr:
+    url: http://sonarr:8989
+    api_key: key
+    denied_ips: [192.168.5.0/24]
+clients:
+  writer:
+    allowed_ips: [192.168.1.0/24]
+`)
+	t.Setenv("APP_CONFIG_DIR", dir)
+	t.Setenv("APP_AUTH_MODE", "apikey")
+	t.Setenv("APP_API_KEY", "proxy-key")
+	t.Setenv("APP_DENIED_IPS", "192.168.1.13")
+	t.Setenv("APP_PROXY_PROTOCOL", "10.0.0.5,fd00::/8")
+	t.Setenv("SONARR_URL", "")
+	t.Setenv("SONARR_API_KEY", "")
+	t.Setenv("RADARR_URL", "")
+
+	cfg, err := LoadWithOptions(LoadOptions{})
+	if err != nil {
+		t.Fatalf("LoadWithOptions() unexpected error: %v", err)
+	}
+	if got := cfg.Network.IPRules; len(got.Allowed) != 2 || got.Allowed[1] != netip.MustParsePrefix("10.0.0.1/32") || len(got.Denied) != 1 {
+		t.Errorf("Network.IPRules = %+v, want allowed_ips from arr-proxy.yaml and APP_DENIED_IPS", got)
+	}
+	if got := cfg.Network.ProxyProtocol; len(got) != 2 || got[0] != netip.MustParsePrefix("10.0.0.5/32") {
+		t.Errorf("Network.ProxyProtocol = %v, want APP_PROXY_PROTOCOL", got)
+	}
+	if got := cfg.Service("sonarr").IPRules; len(got.Denied) != 1 || len(got.Allowed) != 0 {
+		t.Errorf("sonarr IPRules = %+v, want denied_ips", got)
+	}
+	if got := cfg.Clients["writer"].IPRules; got.Check(netip.MustParseAddr("192.168.2.1")) == "" {
+		t.Errorf("client writer IPRules = %+v, want only 192.168.1.0/24 allowed", got)
+	}
+}
+
+func TestParseIPRulesDeniesAllOnErrors(t *testing.T) {
+	rules, problems := parseIPRules("Sonarr ", nil, []string{"192.168.1.13", "bogus"})
+	if len(problems.Errors) != 1 {
+		t.Fatalf("errors = %q, want one", problems.Errors)
+	}
+	if rules.Check(netip.MustParseAddr("10.0.0.1")) == "" || rules.Check(netip.MustParseAddr("fd00::1")) == "" {
+		t.Errorf("rules with errors = %+v, want every address denied", rules)
+	}
+}
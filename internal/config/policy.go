@@ -0,0 +1,63 @@
+package config
+
+import "slices"
+
+// The whitelist policy decides which requests a client identity may forward. A
+// service's whitelist forms the default rule set; services can define further
+// named rule sets, and clients can be restricted to some services and rule sets.
+
+// DefaultRuleSet is the name of the rule set formed by a service's whitelist.
+const DefaultRuleSet = "default"
+
+// Decision is the result of evaluating a request against the whitelist policy.
+type Decision struct {
+	Allowed bool
+	RuleSet string         // rule set containing the matching rule
+	Rule    *WhitelistRule // matching rule, nil if denied
+	Reason  string         // why the request was denied
+}
+
+// Evaluate decides whether the client identity may perform method on path of the service.
+func (c *Config) Evaluate(identity string, sc *ServiceConfig, method, path string) Decision {
+	return c.EvaluateScoped(identity, nil, sc, method, path)
+}
+
+// EvaluateScoped is like Evaluate but only considers those of the client's rule
+// sets that are named in scope. A nil scope means no additional restriction.
+func (c *Config) EvaluateScoped(identity string, scope []string, sc *ServiceConfig, method, path string) Decision {
+	if client, ok := c.Clients[identity]; ok && !client.AllowsService(sc.Name) {
+		return Decision{Reason: "service not allowed for client"}
+	}
+
+	for _, name := range c.RuleSets(identity) {
+		if scope != nil && !slices.Contains(scope, name) {
+			continue
+		}
+		if rule := sc.MatchRule(name, method, path); rule != nil {
+			return Decision{Allowed: true, RuleSet: name, Rule: rule}
+		}
+	}
+	return Decision{Reason: "method/endpoint not whitelisted"}
+}
+
+// RuleSets returns the names of the rule sets the client identity is evaluated against.
+func (c *Config) RuleSets(identity string) []string {
+	if client, ok := c.Clients[identity]; ok && len(client.RuleSets) > 0 {
+		return client.RuleSets
+	}
+	return []string{DefaultRuleSet}
+}
+
+// MatchRule returns the first rule of the named rule set matching method and path, or nil.
+func (sc *ServiceConfig) MatchRule(ruleSet, method, path string) *WhitelistRule {
+	rules := sc.CompiledWhitelist
+	if ruleSet != DefaultRuleSet {
+		rules = sc.CompiledRuleSets[ruleSet]
+	}
+	for i := range rules {
+		if rules[i].Matches(method, path) {
+			return &rules[i]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,98 @@
+package config
+
+import (
+	"testing"
+)
+
+func TestEvaluate(t *testing.T) {
+	whitelist, _ := compileRules([]string{"GET,DELETE:^/api/v3/series(?:/\\d+)?$"})
+	readonly, _ := compileRules([]string{"GET:^/api/v3/series(?:/.*)?$"})
+	sonarr := &ServiceConfig{
+		Name:              "sonarr",
+		CompiledWhitelist: whitelist,
+		CompiledRuleSets:  map[string][]WhitelistRule{"readonly": readonly},
+	}
+	cfg := &Config{
+		Services: map[string]*ServiceConfig{"sonarr": sonarr},
+		Clients: map[string]*ClientConfig{
+			"bot":    {Name: "bot", RuleSets: []string{"readonly"}},
+			"radarr": {Name: "radarr", Services: []string{"radarr"}},
+		},
+	}
+
+	tests := []struct {
+		name        string
+		identity    string
+		method      string
+		path        string
+		wantAllowed bool
+		wantRuleSet string
+	}{
+		{"unknown identity uses whitelist", "someone", "DELETE", "/api/v3/series/5", true, DefaultRuleSet},
+		{"client rule set allows GET", "bot", "GET", "/api/v3/series/5", true, "readonly"},
+		{"client rule set blocks DELETE", "bot", "DELETE", "/api/v3/series/5", false, ""},
+		{"client restricted to other service", "radarr", "GET", "/api/v3/series", false, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := cfg.Evaluate(tt.identity, sonarr, tt.method, tt.path)
+			if d.Allowed != tt.wantAllowed || d.RuleSet != tt.wantRuleSet {
+				t.Errorf("Evaluate(%q, %q, %q) = %+v, want allowed=%v rule set=%q", tt.identity, tt.method, tt.path, d, tt.wantAllowed, tt.wantRuleSet)
+			}
+			if d.Allowed && d.Rule == nil {
+				t.Errorf("Evaluate(%q, %q, %q) allowed without a matching rule", tt.identity, tt.method, tt.path)
+			}
+		})
+	}
+}
+
+func TestEvaluateScoped(t *testing.T) {
+	whitelist, _ := compileRules([]string{"GET,DELETE:^/api/v3/series(?:/\\d+)?$"})
+	readonly, _ := compileRules([]string{"GET:^/api/v3/series(?:/.*)?$"})
+	sonarr := &ServiceConfig{
+		Name:              "sonarr",
+		CompiledWhitelist: whitelist,
+		CompiledRuleSets:  map[string][]WhitelistRule{"readonly": readonly},
+	}
+	cfg := &Config{
+		Services: map[string]*ServiceConfig{"sonarr": sonarr},
+		Clients: map[string]*ClientConfig{
+			"bot": {Name: "bot", RuleSets: []string{"readonly", DefaultRuleSet}},
+		},
+	}
+
+	tests := []struct {
+		name        string
+		scope       []string
+		method      string
+		wantAllowed bool
+		wantRuleSet string
+	}{
+		{"nil scope uses all client rule sets", nil, "DELETE", true, DefaultRuleSet},
+		{"scope limits rule sets", []string{"readonly"}, "DELETE", false, ""},
+		{"scope keeps matching rule set", []string{"readonly"}, "GET", true, "readonly"},
+		{"scope cannot add rule sets", []string{"other"}, "GET", false, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := cfg.EvaluateScoped("bot", tt.scope, sonarr, tt.method, "/api/v3/series/5")
+			if d.Allowed != tt.wantAllowed || d.RuleSet != tt.wantRuleSet {
+				t.Errorf("EvaluateScoped(%q, %q) = %+v, want allowed=%v rule set=%q", tt.scope, tt.method, d, tt.wantAllowed, tt.wantRuleSet)
+			}
+		})
+	}
+}
+
+func TestMatchRule(t *testing.T) {
+	whitelist, _ := compileRules([]string{"GET:^/api/v3/series$", "^/api/v3/series$"})
+	sc := &ServiceConfig{Name: "sonarr", CompiledWhitelist: whitelist}
+
+	if rule := sc.MatchRule(DefaultRuleSet, "POST", "/api/v3/series"); rule == nil || rule.Raw != "^/api/v3/series$" {
+		t.Errorf("MatchRule(default, POST) = %+v, want the first rule allowing POST", rule)
+	}
+	if rule := sc.MatchRule("missing", "GET", "/api/v3/series"); rule != nil {
+		t.Errorf("MatchRule() of an undefined rule set = %+v, want nil", rule)
+	}
+}
@@ -0,0 +1,47 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Problems collects configuration problems by severity. Errors make the
+// configuration invalid (unless lenient mode is enabled), warnings are only logged.
+type Problems struct {
+	Errors   []string
+	Warnings []string
+}
+
+// Errorf records a fatal problem.
+func (p *Problems) Errorf(format string, args ...any) {
+	p.Errors = append(p.Errors, fmt.Sprintf(format, args...))
+}
+
+// Warnf records a non-fatal problem.
+func (p *Problems) Warnf(format string, args ...any) {
+	p.Warnings = append(p.Warnings, fmt.Sprintf(format, args...))
+}
+
+// Merge appends all problems of other.
+func (p *Problems) Merge(other Problems) {
+	p.Errors = append(p.Errors, other.Errors...)
+	p.Warnings = append(p.Warnings, other.Warnings...)
+}
+
+// Downgrade turns all errors into warnings (used in lenient mode).
+func (p *Problems) Downgrade() {
+	for _, e := range p.Errors {
+		p.Warnings = append(p.Warnings, e+" (ignored in lenient mode)")
+	}
+	p.Errors = nil
+}
+
+// ValidationError is returned by Load when the configuration has fatal problems.
+type ValidationError struct {
+	Errors   []string
+	Warnings []string
+}
+
+func (e *ValidationError) Error() string {
+	return "configuration validation failed: " + strings.Join(e.Errors, "; ")
+}
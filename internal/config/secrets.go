@@ -0,0 +1,71 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"arr-proxy/internal/secrets"
+
+	"github.com/spf13/viper"
+)
+
+// Prefixes of configuration values referring to a secret stored elsewhere.
+const (
+	secretFilePrefix = "file:"
+	secretEnvPrefix  = "env:"
+)
+
+// lookupSecret returns the secret setting key of v, which env overrides.
+// <env>_FILE names a file holding the value (Docker and Kubernetes secret mounts),
+// and values of the form "file:<path>" or "env:<VAR>" are resolved. Values
+// encrypted with "arr-proxy secret encrypt --name <env>" are decrypted with the
+// master key. The proxy loads the configuration again on SIGHUP, so rotated
+// secrets are resolved anew. Errors never contain the secret itself.
+func lookupSecret(v *viper.Viper, key, env string) (string, error) {
+	var value string
+	var err error
+	if file := os.Getenv(env + "_FILE"); file != "" {
+		if os.Getenv(env) != "" {
+			return "", fmt.Errorf("both %s and %s_FILE are set", env, env)
+		}
+		value, err = readSecretFile(file)
+	} else {
+		value, err = resolveSecret(v.GetString(key))
+	}
+	if err != nil || !secrets.IsEncrypted(value) {
+		return value, err
+	}
+
+	masterKey, err := secrets.LoadMasterKey()
+	if err != nil {
+		return "", err
+	}
+	return secrets.Decrypt(masterKey, env, value)
+}
+
+// resolveSecret resolves file: and env: references. Other values are returned as is.
+func resolveSecret(value string) (string, error) {
+	switch {
+	case strings.HasPrefix(value, secretFilePrefix):
+		return readSecretFile(strings.TrimPrefix(value, secretFilePrefix))
+	case strings.HasPrefix(value, secretEnvPrefix):
+		name := strings.TrimPrefix(value, secretEnvPrefix)
+		resolved, ok := os.LookupEnv(name)
+		if !ok {
+			return "", fmt.Errorf("environment variable %s referenced by %q is not set", name, value)
+		}
+		return resolved, nil
+	default:
+		return value, nil
+	}
+}
+
+// readSecretFile reads a secret file, ignoring trailing line breaks.
+func readSecretFile(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read secret file: %w", err)
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
@@ -0,0 +1,126 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"arr-proxy/internal/secrets"
+
+	"github.com/spf13/viper"
+)
+
+func TestLookupSecret(t *testing.T) {
+	dir := t.TempDir()
+	secretFile := filepath.Join(dir, "secret")
+	if err := os.WriteFile(secretFile, []byte("from-file\n"), 0600); err != nil {
+		t.Fatalf("failed to write secret file: %v", err)
+	}
+	masterKey, err := secrets.GenerateKey()
+	if err != nil {
+		t.Fatalf("GenerateKey() unexpected error: %v", err)
+	}
+	key, _ := secrets.ParseKey(masterKey)
+	encrypted, err := secrets.Encrypt(key, "TEST_SECRET", "decrypted")
+	if err != nil {
+		t.Fatalf("Encrypt() unexpected error: %v", err)
+	}
+	otherSetting, err := secrets.Encrypt(key, "OTHER_SECRET", "decrypted")
+	if err != nil {
+		t.Fatalf("Encrypt() unexpected error: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		value   string
+		env     map[string]string
+		want    string
+		wantErr string
+	}{
+		{name: "plain value", value: "plain", want: "plain"},
+		{name: "empty value", value: "", want: ""},
+		{name: "file reference", value: "file:" + secretFile, want: "from-file"},
+		{name: "env reference", value: "env:TEST_SECRET_SOURCE", env: map[string]string{"TEST_SECRET_SOURCE": "from-env"}, want: "from-env"},
+		{name: "environment variable", value: "plain", env: map[string]string{"TEST_SECRET": "override"}, want: "override"},
+		{name: "file environment variable", value: "plain", env: map[string]string{"TEST_SECRET_FILE": secretFile}, want: "from-file"},
+		{name: "encrypted value", value: encrypted, env: map[string]string{"APP_MASTER_KEY": masterKey}, want: "decrypted"},
+		{name: "encrypted env reference", value: "env:TEST_SECRET_SOURCE", env: map[string]string{"TEST_SECRET_SOURCE": encrypted, "APP_MASTER_KEY": masterKey}, want: "decrypted"},
+		{name: "encrypted for another setting", value: otherSetting, env: map[string]string{"APP_MASTER_KEY": masterKey}, wantErr: "another setting than TEST_SECRET"},
+		{name: "encrypted without master key", value: encrypted, wantErr: "no master key configured"},
+		{name: "missing file", value: "file:" + filepath.Join(dir, "missing"), wantErr: "failed to read secret file"},
+		{name: "unset env reference", value: "env:TEST_SECRET_UNSET", wantErr: "TEST_SECRET_UNSET"},
+		{
+			name:    "both environment variables",
+			env:     map[string]string{"TEST_SECRET": "override", "TEST_SECRET_FILE": secretFile},
+			wantErr: "both TEST_SECRET and TEST_SECRET_FILE are set",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, env := range []string{"TEST_SECRET", "TEST_SECRET_FILE", "TEST_SECRET_SOURCE", "APP_MASTER_KEY", "APP_MASTER_KEY_FILE"} {
+				t.Setenv(env, tt.env[env])
+			}
+			v := viper.New()
+			v.SetDefault("api_key", tt.value)
+			_ = v.BindEnv("api_key", "TEST_SECRET")
+
+			got, err := lookupSecret(v, "api_key", "TEST_SECRET")
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("lookupSecret() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("lookupSecret() unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("lookupSecret() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadSecretsFromFiles(t *testing.T) {
+	dir := t.TempDir()
+	proxyKey := filepath.Join(dir, "proxy_key")
+	sonarrKey := filepath.Join(dir, "sonarr_key")
+	for path, content := range map[string]string{proxyKey: "proxy-secret\n", sonarrKey: "sonarr-secret"} {
+		if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatalf("failed to write secret file: %v", err)
+		}
+	}
+	writeServiceFile(t, dir, "sonarr", "url: http://sonarr:8989\napi_key: file:"+sonarrKey+"\nwhitelist:\n  - '^/api/v3/status$'\n")
+	t.Setenv("APP_CONFIG_DIR", dir)
+	t.Setenv("APP_AUTH_MODE", "apikey")
+	t.Setenv("APP_API_KEY", "")
+	t.Setenv("APP_API_KEY_FILE", proxyKey)
+	t.Setenv("SONARR_URL", "")
+	t.Setenv("SONARR_API_KEY", "")
+	t.Setenv("RADARR_URL", "")
+
+	cfg, err := LoadWithOptions(LoadOptions{})
+	if err != nil {
+		t.Fatalf("LoadWithOptions() unexpected error: %v", err)
+	}
+	if cfg.Auth.APIKey != "proxy-secret" {
+		t.Errorf("Auth.APIKey = %q, want value of APP_API_KEY_FILE", cfg.Auth.APIKey)
+	}
+	if sc := cfg.Service("sonarr"); sc == nil || sc.APIKey != "sonarr-secret" {
+		t.Errorf("sonarr = %+v, want api_key from file reference", sc)
+	}
+
+	// Rotated secrets are picked up by the next load
+	if err := os.WriteFile(proxyKey, []byte("rotated"), 0600); err != nil {
+		t.Fatalf("failed to rotate secret file: %v", err)
+	}
+	cfg, err = LoadWithOptions(LoadOptions{})
+	if err != nil {
+		t.Fatalf("LoadWithOptions() unexpected error after rotation: %v", err)
+	}
+	if cfg.Auth.APIKey != "rotated" {
+		t.Errorf("Auth.APIKey after rotation = %q, want %q", cfg.Auth.APIKey, "rotated")
+	}
+}
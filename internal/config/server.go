@@ -1,7 +1,10 @@
 package config
 
 import (
+	"crypto/tls"
 	"log/slog"
+	"slices"
+	"strings"
 	"time"
 
 	"github.com/spf13/viper"
@@ -15,11 +18,43 @@ type ServerConfig struct {
 	ReadHeaderTimeout time.Duration
 	MaxBodySize       int64
 	TLSMinVersion     string
+	TLSCipherSuites   []uint16      // TLS 1.2 cipher suites; nil uses Go's defaults
+	TLSCurves         []tls.CurveID // key exchange preferences; nil uses Go's defaults
+	TLSALPN           []string      // application protocols in order of preference
 	LogLevel          string
+	LowercasePaths    bool
+	LearnFile         string
+	KeyStore          string // file of managed API keys, see the admin API
+}
+
+// serverEnvKeys maps server settings to the environment variables overriding them.
+var serverEnvKeys = map[string]string{
+	"read_timeout":        "APP_READ_TIMEOUT",
+	"write_timeout":       "APP_WRITE_TIMEOUT",
+	"idle_timeout":        "APP_IDLE_TIMEOUT",
+	"read_header_timeout": "APP_READ_HEADER_TIMEOUT",
+	"max_body_size":       "APP_MAX_BODY_SIZE",
+	"tls_min_version":     "APP_TLS_MIN_VERSION",
+	"tls_cipher_suites":   "APP_TLS_CIPHER_SUITES",
+	"tls_curves":          "APP_TLS_CURVES",
+	"tls_alpn":            "APP_TLS_ALPN",
+	"log_level":           "APP_LOG_LEVEL",
+	"lowercase_paths":     "APP_LOWERCASE_PATHS",
+	"learn_file":          "APP_LEARN_FILE",
+	"key_store":           "APP_KEY_STORE",
 }
 
 // LoadServerConfig loads server configuration from server.yaml with env overrides
 func LoadServerConfig(configPaths ...string) ServerConfig {
+	cfg, problems := loadServerConfig(nil, "", configPaths...)
+	logProblems(problems)
+	return cfg
+}
+
+// loadServerConfig loads server configuration from server.yaml, overridden by the
+// server section of the unified config file and by environment variables.
+func loadServerConfig(unified map[string]any, unifiedName string, configPaths ...string) (ServerConfig, Problems) {
+	var problems Problems
 	v := viper.New()
 	v.SetConfigName("server")
 	v.SetConfigType("yaml")
@@ -31,6 +66,19 @@ func LoadServerConfig(configPaths ...string) ServerConfig {
 	v.AddConfigPath(".")
 	v.AddConfigPath("./config")
 
+	// Read config file (optional - won't fail if missing)
+	if err := v.ReadInConfig(); err != nil {
+		if _, ok := err.(viper.ConfigFileNotFoundError); !ok {
+			slog.Warn("Failed to read server config file", "error", err)
+		}
+	}
+	mergeUnified(v, "server", unifiedName, "server.yaml", unified, serverEnvKeys, &problems)
+	for _, key := range v.AllKeys() {
+		if _, ok := serverEnvKeys[key]; !ok {
+			problems.Warnf("server config: unknown key %q", key)
+		}
+	}
+
 	// Set defaults
 	v.SetDefault("read_timeout", "30s")
 	v.SetDefault("write_timeout", "30s")
@@ -38,23 +86,14 @@ func LoadServerConfig(configPaths ...string) ServerConfig {
 	v.SetDefault("read_header_timeout", "20s")
 	v.SetDefault("max_body_size", 10*1024*1024) // 10MB
 	v.SetDefault("tls_min_version", "1.2")
+	v.SetDefault("tls_alpn", []string{"h2", "http/1.1"})
 	v.SetDefault("log_level", "info")
+	v.SetDefault("lowercase_paths", false)
 
 	// Bind environment variables
 	v.SetEnvPrefix("APP")
-	_ = v.BindEnv("read_timeout", "APP_READ_TIMEOUT")
-	_ = v.BindEnv("write_timeout", "APP_WRITE_TIMEOUT")
-	_ = v.BindEnv("idle_timeout", "APP_IDLE_TIMEOUT")
-	_ = v.BindEnv("read_header_timeout", "APP_READ_HEADER_TIMEOUT")
-	_ = v.BindEnv("max_body_size", "APP_MAX_BODY_SIZE")
-	_ = v.BindEnv("tls_min_version", "APP_TLS_MIN_VERSION")
-	_ = v.BindEnv("log_level", "APP_LOG_LEVEL")
-
-	// Read config file (optional - won't fail if missing)
-	if err := v.ReadInConfig(); err != nil {
-		if _, ok := err.(viper.ConfigFileNotFoundError); !ok {
-			slog.Warn("Failed to read server config file", "error", err)
-		}
+	for key, env := range serverEnvKeys {
+		_ = v.BindEnv(key, env)
 	}
 
 	readTimeout, err := time.ParseDuration(v.GetString("read_timeout"))
@@ -87,6 +126,21 @@ func LoadServerConfig(configPaths ...string) ServerConfig {
 		tlsMinVersion = "1.2"
 	}
 
+	cipherSuites := parseCipherSuites(listValue(v, "tls_cipher_suites"), &problems)
+	if len(cipherSuites) > 0 && tlsMinVersion == "1.3" {
+		problems.Warnf("APP_TLS_CIPHER_SUITES only applies to TLS 1.2, but the minimum version is 1.3")
+	}
+	curves := parseCurves(listValue(v, "tls_curves"), &problems)
+	alpn := listValue(v, "tls_alpn")
+	for _, proto := range alpn {
+		if proto != "h2" && proto != "http/1.1" {
+			problems.Errorf("APP_TLS_ALPN: unsupported protocol %q (use h2 and http/1.1)", proto)
+		}
+	}
+	if len(alpn) == 0 {
+		problems.Errorf("APP_TLS_ALPN: at least one protocol required (h2, http/1.1)")
+	}
+
 	maxBodySize := v.GetInt64("max_body_size")
 	if maxBodySize <= 0 {
 		slog.Warn("Invalid max_body_size, using default 10MB", "value", maxBodySize)
@@ -107,6 +161,60 @@ func LoadServerConfig(configPaths ...string) ServerConfig {
 		ReadHeaderTimeout: readHeaderTimeout,
 		MaxBodySize:       maxBodySize,
 		TLSMinVersion:     tlsMinVersion,
+		TLSCipherSuites:   cipherSuites,
+		TLSCurves:         curves,
+		TLSALPN:           alpn,
 		LogLevel:          logLevel,
+		LowercasePaths:    v.GetBool("lowercase_paths"),
+		LearnFile:         v.GetString("learn_file"),
+		KeyStore:          v.GetString("key_store"),
+	}, problems
+}
+
+// parseCipherSuites resolves cipher suite names as listed by tls.CipherSuites, for
+// example TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256. Insecure suites are rejected.
+func parseCipherSuites(names []string, problems *Problems) []uint16 {
+	var ids []uint16
+	for _, name := range names {
+		name = strings.ToUpper(name)
+		i := slices.IndexFunc(tls.CipherSuites(), func(cs *tls.CipherSuite) bool { return cs.Name == name })
+		switch {
+		case i >= 0 && !slices.Contains(tls.CipherSuites()[i].SupportedVersions, tls.VersionTLS12):
+			problems.Errorf("APP_TLS_CIPHER_SUITES: %s is a TLS 1.3 cipher suite, which cannot be configured", name)
+		case i >= 0:
+			ids = append(ids, tls.CipherSuites()[i].ID)
+		case slices.ContainsFunc(tls.InsecureCipherSuites(), func(cs *tls.CipherSuite) bool { return cs.Name == name }):
+			problems.Errorf("APP_TLS_CIPHER_SUITES: %s is insecure", name)
+		default:
+			problems.Errorf("APP_TLS_CIPHER_SUITES: unknown cipher suite %q", name)
+		}
+	}
+	return ids
+}
+
+// curveNames are the key exchange mechanisms APP_TLS_CURVES accepts, also by the
+// names tls.CurveID prints.
+var curveNames = map[string]tls.CurveID{
+	"x25519mlkem768": tls.X25519MLKEM768,
+	"x25519":         tls.X25519,
+	"p256":           tls.CurveP256,
+	"p384":           tls.CurveP384,
+	"p521":           tls.CurveP521,
+	"curvep256":      tls.CurveP256,
+	"curvep384":      tls.CurveP384,
+	"curvep521":      tls.CurveP521,
+}
+
+// parseCurves resolves key exchange names like X25519 or P-256.
+func parseCurves(names []string, problems *Problems) []tls.CurveID {
+	var curves []tls.CurveID
+	for _, name := range names {
+		id, ok := curveNames[strings.ReplaceAll(strings.ToLower(name), "-", "")]
+		if !ok {
+			problems.Errorf("APP_TLS_CURVES: unknown curve %q (use X25519MLKEM768, X25519, P-256, P-384 or P-521)", name)
+			continue
+		}
+		curves = append(curves, id)
 	}
+	return curves
 }
@@ -0,0 +1,198 @@
+package config
+
+import (
+	"fmt"
+	"log/slog"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// serviceKeys are the keys allowed in a service configuration file.
+var serviceKeys = map[string]bool{
+	"url": true, "api_key": true, "whitelist": true, "rule_sets": true, "candidate_whitelist": true,
+	"auth": true, "allowed_ips": true, "denied_ips": true,
+}
+
+// serviceEnvPrefix returns the environment variable prefix of a service,
+// e.g. "SONARR" or "SONARR_4K" for "sonarr-4k".
+func serviceEnvPrefix(name string) string {
+	return strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+// loadServiceConfig loads a service configuration from <name>.yaml, overridden by
+// the service's section of the unified config file and by the <NAME>_URL /
+// <NAME>_API_KEY environment variables.
+// Returns a nil config and no problems if the service is not configured. The config
+// is nil as well if the service is unusable (invalid URL or no valid whitelist pattern).
+func loadServiceConfig(name string, unified map[string]any, unifiedName string, configPaths ...string) (*ServiceConfig, Problems) {
+	label := strings.ToUpper(name[:1]) + name[1:]
+	envPrefix := serviceEnvPrefix(name)
+	var problems Problems
+
+	v := viper.New()
+	v.SetConfigName(name)
+	v.SetConfigType("yaml")
+	for _, p := range configPaths {
+		v.AddConfigPath(p)
+	}
+
+	// Try to read config file (optional)
+	if err := v.ReadInConfig(); err != nil {
+		if _, ok := err.(viper.ConfigFileNotFoundError); !ok {
+			problems.Errorf("failed to read %s config file: %v", label, err)
+		}
+	}
+
+	envKeys := map[string]string{"url": envPrefix + "_URL", "api_key": envPrefix + "_API_KEY"}
+	mergeUnified(v, label, unifiedName, name+".yaml", unified, envKeys, &problems)
+
+	// Bind environment variables
+	for key, env := range envKeys {
+		_ = v.BindEnv(key, env)
+	}
+
+	urlStr := v.GetString("url")
+	if urlStr == "" {
+		return nil, problems // Service not configured
+	}
+
+	for _, key := range unknownKeys(v, serviceKeys) {
+		problems.Warnf("%s config: unknown key %q", label, key)
+	}
+
+	// Validate URL is parseable
+	parsedURL, err := url.Parse(urlStr)
+	switch {
+	case err != nil:
+		problems.Errorf("invalid %s URL %q: %v", label, urlStr, err)
+	case parsedURL.Scheme != "http" && parsedURL.Scheme != "https":
+		problems.Errorf("invalid %s URL %q: scheme must be http or https (set %s_URL)", label, urlStr, envPrefix)
+	case parsedURL.Host == "":
+		problems.Errorf("invalid %s URL %q: missing host (set %s_URL)", label, urlStr, envPrefix)
+	}
+	urlValid := len(problems.Errors) == 0
+
+	apiKey, err := lookupSecret(v, "api_key", envKeys["api_key"])
+	if err != nil {
+		problems.Errorf("%s api_key: %v", label, err)
+	} else if apiKey == "" {
+		problems.Errorf("%s api_key is missing (set %s_API_KEY, %s_API_KEY_FILE or api_key in %s.yaml)", label, envPrefix, envPrefix, name)
+	}
+
+	whitelist := v.GetStringSlice("whitelist")
+	compiledWhitelist := compileRuleSet(fmt.Sprintf("%s whitelist", label), whitelist, &problems)
+	if len(whitelist) == 0 {
+		problems.Warnf("%s whitelist is empty, all requests will be blocked", label)
+	}
+
+	// Named rule sets that clients can be restricted to, in addition to the default whitelist
+	ruleSets := v.GetStringMapStringSlice("rule_sets")
+	compiledRuleSets := make(map[string][]WhitelistRule, len(ruleSets))
+	for setName, patterns := range ruleSets {
+		if setName == DefaultRuleSet {
+			problems.Errorf("%s rule set %q is reserved for the whitelist", label, setName)
+			continue
+		}
+		compiledRuleSets[setName] = compileRuleSet(fmt.Sprintf("%s rule set %q", label, setName), patterns, &problems)
+	}
+
+	// Candidate whitelist is evaluated in report-only mode
+	candidateWhitelist := v.GetStringSlice("candidate_whitelist")
+	compiledCandidate := compileRuleSet(fmt.Sprintf("%s candidate whitelist", label), candidateWhitelist, &problems)
+	if len(candidateWhitelist) > 0 {
+		slog.Info(label+" candidate whitelist enabled (report-only)", "rules", len(compiledCandidate))
+	}
+
+	// Broken address rules deny all requests rather than being skipped
+	allowedIPs, deniedIPs := listValue(v, "allowed_ips"), listValue(v, "denied_ips")
+	ipRules, ipProblems := parseIPRules(label+" ", allowedIPs, deniedIPs)
+	problems.Merge(ipProblems)
+
+	// Without a valid URL or with only broken patterns the service cannot be used
+	if !urlValid || (len(whitelist) > 0 && len(compiledWhitelist) == 0) {
+		problems.Warnf("%s disabled due to configuration errors", label)
+		return nil, problems
+	}
+
+	cfg := &ServiceConfig{
+		Name:                       name,
+		URL:                        urlStr,
+		APIKey:                     apiKey,
+		Whitelist:                  whitelist,
+		CompiledWhitelist:          compiledWhitelist,
+		RuleSets:                   ruleSets,
+		CompiledRuleSets:           compiledRuleSets,
+		CandidateWhitelist:         candidateWhitelist,
+		CompiledCandidateWhitelist: compiledCandidate,
+		Auth:                       normalizeAuthMethods(listValue(v, "auth")),
+		AllowedIPs:                 allowedIPs,
+		DeniedIPs:                  deniedIPs,
+		IPRules:                    ipRules,
+		ParsedURL:                  parsedURL,
+	}
+
+	return cfg, problems
+}
+
+// compileRuleSet compiles patterns, recording uncompilable patterns as errors and
+// duplicate patterns as warnings.
+func compileRuleSet(label string, patterns []string, problems *Problems) []WhitelistRule {
+	compiled, compileProblems := compileRules(patterns)
+	for _, p := range compileProblems {
+		problems.Errorf("%s: %s", label, p)
+	}
+	seen := make(map[string]bool, len(patterns))
+	for _, p := range patterns {
+		if seen[p] {
+			problems.Warnf("%s: duplicate rule %q", label, p)
+		}
+		seen[p] = true
+	}
+	return compiled
+}
+
+// ignorePathCase makes all rules of sc case-insensitive. With lowercased request
+// paths a pattern containing uppercase letters would otherwise never match.
+func (sc *ServiceConfig) ignorePathCase() {
+	fold := func(rules []WhitelistRule) {
+		for i := range rules {
+			rules[i].Pattern = regexp.MustCompile("(?i)" + rules[i].Pattern.String())
+		}
+	}
+	fold(sc.CompiledWhitelist)
+	for _, rules := range sc.CompiledRuleSets {
+		fold(rules)
+	}
+	fold(sc.CompiledCandidateWhitelist)
+}
+
+// unknownKeys returns the top-level keys set in v that are not in known.
+func unknownKeys(v *viper.Viper, known map[string]bool) []string {
+	found := make(map[string]bool)
+	for _, key := range v.AllKeys() {
+		top, _, _ := strings.Cut(key, ".")
+		if !known[top] {
+			found[top] = true
+		}
+	}
+	keys := make([]string, 0, len(found))
+	for k := range found {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// logProblems logs warnings and (in lenient mode, already downgraded) errors.
+func logProblems(problems Problems) {
+	for _, e := range problems.Errors {
+		slog.Error("Configuration error", "problem", e)
+	}
+	for _, w := range problems.Warnings {
+		slog.Warn("Configuration warning", "problem", w)
+	}
+}
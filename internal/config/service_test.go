@@ -0,0 +1,178 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeServiceFile(t *testing.T, dir, name, content string) {
+	t.Helper()
+	if err := os.WriteFile(filepath.Join(dir, name+".yaml"), []byte(content), 0600); err != nil {
+		t.Fatalf("failed to write %s.yaml: %v", name, err)
+	}
+}
+
+func containsProblem(problems []string, substr string) bool {
+	for _, p := range problems {
+		if strings.Contains(p, substr) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestLoadServiceConfigProblems(t *testing.T) {
+	tests := []struct {
+		name         string
+		content      string
+		env          map[string]string
+		wantNil      bool
+		wantErrors   []string
+		wantWarnings []string
+	}{
+		{
+			name:    "valid",
+			content: "url: http://sonarr:8989\napi_key: key\nwhitelist:\n  - '^/api/v3/status$'\n",
+		},
+		{
+			name:    "not configured",
+			content: "whitelist:\n  - '^/api/v3/status$'\n",
+			wantNil: true,
+		},
+		{
+			name:       "invalid scheme disables service",
+			content:    "url: ftp://sonarr\napi_key: key\nwhitelist:\n  - '^/api/v3/status$'\n",
+			wantNil:    true,
+			wantErrors: []string{"scheme must be http or https"},
+		},
+		{
+			name:       "missing host",
+			content:    "url: http://\napi_key: key\nwhitelist:\n  - '^/api/v3/status$'\n",
+			wantNil:    true,
+			wantErrors: []string{"missing host"},
+		},
+		{
+			name:       "missing api key",
+			content:    "url: http://sonarr:8989\nwhitelist:\n  - '^/api/v3/status$'\n",
+			wantErrors: []string{"api_key is missing"},
+		},
+		{
+			name:    "api key from environment",
+			content: "url: http://sonarr:8989\nwhitelist:\n  - '^/api/v3/status$'\n",
+			env:     map[string]string{"SONARR_API_KEY": "key"},
+		},
+		{
+			name:       "uncompilable pattern",
+			content:    "url: http://sonarr:8989\napi_key: key\nwhitelist:\n  - '^/api/v3/(status$'\n  - '^/api/v3/queue$'\n",
+			wantErrors: []string{"invalid pattern"},
+		},
+		{
+			name:       "all patterns uncompilable disables service",
+			content:    "url: http://sonarr:8989\napi_key: key\nwhitelist:\n  - '^/api/v3/(status$'\n",
+			wantNil:    true,
+			wantErrors: []string{"invalid pattern"},
+		},
+		{
+			name:         "duplicate rule",
+			content:      "url: http://sonarr:8989\napi_key: key\nwhitelist:\n  - '^/api/v3/status$'\n  - '^/api/v3/status$'\n",
+			wantWarnings: []string{"duplicate rule"},
+		},
+		{
+			name:         "unknown key",
+			content:      "url: http://sonarr:8989\napi_key: key\nwhitelsit:\n  - '^/api/v3/status$'\n",
+			wantWarnings: []string{`unknown key "whitelsit"`, "whitelist is empty"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("SONARR_URL", "")
+			t.Setenv("SONARR_API_KEY", "")
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+			dir := t.TempDir()
+			writeServiceFile(t, dir, "sonarr", tt.content)
+
+			cfg, problems := loadServiceConfig("sonarr", nil, "", dir)
+			if (cfg == nil) != tt.wantNil {
+				t.Errorf("loadServiceConfig() config nil = %v, want %v", cfg == nil, tt.wantNil)
+			}
+			if len(problems.Errors) != len(tt.wantErrors) {
+				t.Errorf("loadServiceConfig() errors = %q, want %d", problems.Errors, len(tt.wantErrors))
+			}
+			for _, want := range tt.wantErrors {
+				if !containsProblem(problems.Errors, want) {
+					t.Errorf("loadServiceConfig() errors = %q, want one containing %q", problems.Errors, want)
+				}
+			}
+			for _, want := range tt.wantWarnings {
+				if !containsProblem(problems.Warnings, want) {
+					t.Errorf("loadServiceConfig() warnings = %q, want one containing %q", problems.Warnings, want)
+				}
+			}
+		})
+	}
+}
+
+func TestLoadLenientMode(t *testing.T) {
+	dir := t.TempDir()
+	writeServiceFile(t, dir, "sonarr", "url: http://sonarr:8989\napi_key: key\nwhitelist:\n  - '^/api/v3/status$'\n")
+	writeServiceFile(t, dir, "radarr", "url: ftp://radarr\napi_key: key\nwhitelist:\n  - '^/api/v3/status$'\n")
+	t.Setenv("APP_CONFIG_DIR", dir)
+	t.Setenv("APP_AUTH_MODE", "apikey")
+	t.Setenv("APP_API_KEY", "proxy-key")
+	t.Setenv("SONARR_URL", "")
+	t.Setenv("RADARR_URL", "")
+
+	_, err := LoadWithOptions(LoadOptions{})
+	if err == nil || !strings.Contains(err.Error(), "Radarr URL") {
+		t.Fatalf("LoadWithOptions(strict) error = %v, want invalid Radarr URL", err)
+	}
+
+	cfg, err := LoadWithOptions(LoadOptions{Lenient: true})
+	if err != nil {
+		t.Fatalf("LoadWithOptions(lenient) unexpected error: %v", err)
+	}
+	if cfg.Service("sonarr") == nil || cfg.Service("radarr") != nil {
+		t.Errorf("LoadWithOptions(lenient) sonarr=%v radarr=%v, want only Sonarr enabled", cfg.Service("sonarr") != nil, cfg.Service("radarr") != nil)
+	}
+}
+
+func TestAuthMethods(t *testing.T) {
+	dir := t.TempDir()
+	writeServiceFile(t, dir, "arr-proxy", "auth:\n  mode: [basic, apikey]\nservices:\n  sonarr:\n    url: http://sonarr:8989\n    api_key: key\n    auth: apikey\n  radarr:\n    url: http://radarr:7878\n    api_key: key\n")
+	t.Setenv("APP_CONFIG_DIR", dir)
+	t.Setenv("APP_AUTH_MODE", "")
+	t.Setenv("APP_API_KEY", "proxy-key")
+	t.Setenv("APP_BASIC_AUTH_USER", "user")
+	t.Setenv("APP_BASIC_AUTH_PASS", "pass")
+	t.Setenv("SONARR_URL", "")
+	t.Setenv("RADARR_URL", "")
+
+	cfg, err := LoadWithOptions(LoadOptions{})
+	if err != nil {
+		t.Fatalf("LoadWithOptions() unexpected error: %v", err)
+	}
+	if cfg.Auth.Mode != "basic,apikey" {
+		t.Errorf("Auth.Mode = %q, want %q", cfg.Auth.Mode, "basic,apikey")
+	}
+	tests := []struct {
+		service string
+		want    []string
+	}{
+		{"", []string{AuthModeBasic, AuthModeAPIKey}},
+		{"sonarr", []string{AuthModeAPIKey}},
+		{"radarr", []string{AuthModeBasic, AuthModeAPIKey}},
+	}
+	for _, tt := range tests {
+		if got := cfg.AuthMethods(tt.service); strings.Join(got, ",") != strings.Join(tt.want, ",") {
+			t.Errorf("AuthMethods(%q) = %v, want %v", tt.service, got, tt.want)
+		}
+	}
+	if !cfg.UsesAuth(AuthModeBasic) || cfg.UsesAuth(AuthModeMTLS) {
+		t.Errorf("UsesAuth() basic = %v, mtls = %v, want true, false", cfg.UsesAuth(AuthModeBasic), cfg.UsesAuth(AuthModeMTLS))
+	}
+}
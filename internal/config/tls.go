@@ -0,0 +1,40 @@
+package config
+
+import (
+	"path/filepath"
+
+	"github.com/spf13/viper"
+)
+
+// loadTLSConfig loads the server certificate files and the additional TLS settings
+// of v. A self-signed certificate is generated on first start, by default in the
+// config directory.
+func loadTLSConfig(v *viper.Viper, configDir string) (cert, key string, tlsConfig TLSConfig, problems Problems) {
+	certificates, problems := keyPairList(v, "tls_certificates", "APP_TLS_CERTIFICATES")
+	cert, key = v.GetString("tls_cert"), v.GetString("tls_key")
+	selfSigned := v.GetBool("tls_self_signed")
+	if selfSigned && cert == "" && key == "" {
+		cert, key = filepath.Join(configDir, "self-signed.crt"), filepath.Join(configDir, "self-signed.key")
+	}
+	return cert, key, TLSConfig{
+		Certificates:    certificates,
+		SelfSigned:      selfSigned,
+		SelfSignedHosts: listValue(v, "tls_self_signed_hosts"),
+	}, problems
+}
+
+// validateTLS checks that the TLS settings are complete.
+func validateTLS(cfg *Config) Problems {
+	var problems Problems
+	// If TLS cert is provided, key must also be provided
+	if (cfg.TLSCert != "" && cfg.TLSKey == "") || (cfg.TLSCert == "" && cfg.TLSKey != "") {
+		problems.Errorf("APP_TLS_CERT and APP_TLS_KEY must both be set for HTTPS")
+	}
+	if len(cfg.TLS.Certificates) > 0 && !cfg.TLSEnabled() {
+		problems.Errorf("APP_TLS_CERT and APP_TLS_KEY required for APP_TLS_CERTIFICATES (the default certificate for clients without a matching SNI)")
+	}
+	if len(cfg.TLS.SelfSignedHosts) > 0 && !cfg.TLS.SelfSigned {
+		problems.Warnf("APP_TLS_SELF_SIGNED_HOSTS is set but APP_TLS_SELF_SIGNED is not enabled")
+	}
+	return problems
+}
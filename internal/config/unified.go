@@ -0,0 +1,184 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// unifiedFileName is the base name of the optional single configuration file.
+const unifiedFileName = "arr-proxy"
+
+// unifiedAppKeys maps keys of the unified file to the flat keys used for app settings.
+var unifiedAppKeys = map[string]string{
+	"port":                         "port",
+	"tls.cert":                     "tls_cert",
+	"tls.key":                      "tls_key",
+	"tls.certificates":             "tls_certificates",
+	"tls.self_signed":              "tls_self_signed",
+	"tls.self_signed_hosts":        "tls_self_signed_hosts",
+	"tls.ca_cert":                  "ca_cert",
+	"tls.crl_files":                "crl_files",
+	"tls.denylist":                 "cert_denylist",
+	"tls.ca_dir":                   "ca_dir",
+	"tls.enroll_validity":          "enroll_validity",
+	"auth.mode":                    "auth_mode",
+	"auth.api_key":                 "api_key",
+	"auth.admin_key":               "admin_key",
+	"auth.basic.user":              "basic_auth_user",
+	"auth.basic.password":          "basic_auth_pass",
+	"auth.token.algorithm":         "token_algorithm",
+	"auth.token.key":               "token_key",
+	"auth.token.max_ttl":           "token_max_ttl",
+	"auth.jwt.jwks":                "jwt_jwks",
+	"auth.jwt.jwks_refresh":        "jwt_jwks_refresh",
+	"auth.jwt.issuer":              "jwt_issuer",
+	"auth.jwt.audience":            "jwt_audience",
+	"auth.jwt.identity_claim":      "jwt_identity_claim",
+	"auth.jwt.groups_claim":        "jwt_groups_claim",
+	"auth.forward.url":             "forward_auth_url",
+	"auth.forward.headers":         "forward_auth_headers",
+	"auth.forward.identity_header": "forward_auth_identity_header",
+	"auth.forward.groups_header":   "forward_auth_groups_header",
+	"auth.forward.cache_ttl":       "forward_auth_cache_ttl",
+	"auth.header.user_header":      "header_auth_user",
+	"auth.header.groups_header":    "header_auth_groups",
+	"auth.header.trusted_proxies":  "trusted_proxies",
+	"auth.mtls.require_mapping":    "mtls_require_mapping",
+	"auth.lockout.threshold":       "lockout_threshold",
+	"auth.lockout.window":          "lockout_window",
+	"auth.lockout.ban_time":        "lockout_ban_time",
+	"auth.lockout.max_ban_time":    "lockout_max_ban_time",
+	"network.allowed_ips":          "allowed_ips",
+	"network.denied_ips":           "denied_ips",
+	"network.proxy_protocol":       "proxy_protocol",
+	"network.forwarded_header":     "forwarded_header",
+}
+
+// unifiedSections are the top-level keys of the unified file holding nested maps
+// that are validated by the loader of the section.
+var unifiedSections = map[string]bool{"server": true, "services": true, "clients": true}
+
+// unifiedConfig holds the sections of the optional arr-proxy.yaml file.
+// All sections are empty if the file does not exist.
+type unifiedConfig struct {
+	path     string
+	app      map[string]any            // app settings keyed like the environment-bound keys
+	server   map[string]any            // server section, keyed like server.yaml
+	services map[string]map[string]any // service sections, keyed like <service>.yaml
+	clients  []byte                    // raw file content, clients are decoded case-sensitively
+}
+
+// name returns the file name used in problem messages.
+func (u *unifiedConfig) name() string {
+	if u.path == "" {
+		return unifiedFileName + ".yaml"
+	}
+	return filepath.Base(u.path)
+}
+
+// loadUnifiedConfig reads arr-proxy.yaml from APP_CONFIG_FILE, the config directory
+// or the working directory.
+func loadUnifiedConfig(configDir string) (*unifiedConfig, Problems) {
+	var problems Problems
+	u := &unifiedConfig{
+		app:      make(map[string]any),
+		server:   make(map[string]any),
+		services: make(map[string]map[string]any),
+	}
+
+	v := viper.New()
+	if file := os.Getenv("APP_CONFIG_FILE"); file != "" {
+		v.SetConfigFile(file)
+	} else {
+		v.SetConfigName(unifiedFileName)
+		v.SetConfigType("yaml")
+		v.AddConfigPath(configDir)
+		v.AddConfigPath(".")
+	}
+
+	if err := v.ReadInConfig(); err != nil {
+		if _, ok := err.(viper.ConfigFileNotFoundError); !ok {
+			problems.Errorf("failed to read %s: %v", v.ConfigFileUsed(), err)
+		}
+		return u, problems
+	}
+	u.path = v.ConfigFileUsed()
+
+	for _, key := range v.AllKeys() {
+		top, _, _ := strings.Cut(key, ".")
+		if _, ok := unifiedAppKeys[key]; !ok && !unifiedSections[top] {
+			problems.Warnf("%s: unknown key %q", u.name(), key)
+		}
+	}
+
+	for key, flat := range unifiedAppKeys {
+		if v.IsSet(key) {
+			u.app[flat] = v.Get(key)
+		}
+	}
+	u.server = v.GetStringMap("server")
+	for name := range v.GetStringMap("services") {
+		u.services[name] = v.GetStringMap("services." + name)
+	}
+	if v.IsSet("clients") {
+		data, err := os.ReadFile(u.path)
+		if err != nil {
+			problems.Errorf("failed to read %s: %v", u.path, err)
+		}
+		u.clients = data
+	}
+
+	return u, problems
+}
+
+// mergeUnified layers a section of the unified file over the values v read from a
+// legacy file and reports conflicts between the sources. envKeys maps keys to the
+// environment variables overriding them. Must be called before environment
+// variables are bound to v.
+func mergeUnified(v *viper.Viper, label, unifiedName, legacyName string, section map[string]any, envKeys map[string]string, problems *Problems) {
+	keys := make([]string, 0, len(section))
+	for key := range section {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		if v.InConfig(key) && !sameValue(v.Get(key), section[key]) {
+			problems.Warnf("%s: %q is set in both %s and %s, using %s", label, key, unifiedName, legacyName, unifiedName)
+		}
+	}
+	if len(section) > 0 {
+		_ = v.MergeConfigMap(section)
+	}
+
+	envNames := make([]string, 0, len(envKeys))
+	for key := range envKeys {
+		envNames = append(envNames, key)
+	}
+	sort.Strings(envNames)
+
+	for _, key := range envNames {
+		env := envKeys[key]
+		envValue := os.Getenv(env)
+		if envValue == "" && os.Getenv(env+"_FILE") != "" {
+			env += "_FILE"
+			envValue = secretFilePrefix + os.Getenv(env)
+		}
+		if envValue == "" || !v.InConfig(key) {
+			continue
+		}
+		if fileValue := v.GetString(key); fileValue != "" && fileValue != envValue {
+			problems.Warnf("%s: %s overrides %q from the config file", label, env, key)
+		}
+	}
+}
+
+// sameValue compares configuration values regardless of their decoded types.
+func sameValue(a, b any) bool {
+	return fmt.Sprint(a) == fmt.Sprint(b)
+}
@@ -0,0 +1,349 @@
+package config
+
+import (
+	"cmp"
+	"crypto/tls"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestLoadUnifiedConfig(t *testing.T) {
+	dir := t.TempDir()
+	writeServiceFile(t, dir, "sonarr", "url: http://legacy-sonarr:8989\napi_key: legacy-key\nwhitelist:\n  - '^/api/v3/status$'\n")
+	writeServiceFile(t, dir, "clients", "clients:\n  Alice:\n    services: [sonarr]\n")
+	writeServiceFile(t, dir, "arr-proxy", `port: 9443
+auth:
+  mode: apikey
+  api_key: unified-key
+server:
+  log_level: debug
+services:
+  sonarr:
+    url: http://sonarr:8989
+  lidarr-4k:
+    url: http://lidarr:8686
+    whitelist:
+      - 'GET:^/api/v1/artist$'
+clients:
+  Alice:
+    services: [lidarr-4k]
+`)
+	t.Setenv("APP_CONFIG_DIR", dir)
+	t.Setenv("APP_PORT", "")
+	t.Setenv("APP_API_KEY", "")
+	t.Setenv("APP_AUTH_MODE", "")
+	t.Setenv("APP_LOG_LEVEL", "")
+	t.Setenv("SONARR_URL", "")
+	t.Setenv("SONARR_API_KEY", "")
+	t.Setenv("RADARR_URL", "")
+	t.Setenv("LIDARR_4K_API_KEY", "lidarr-key")
+
+	cfg, err := LoadWithOptions(LoadOptions{})
+	if err != nil {
+		t.Fatalf("LoadWithOptions() unexpected error: %v", err)
+	}
+
+	if cfg.Port != "9443" || cfg.Auth.APIKey != "unified-key" || cfg.Server.LogLevel != "debug" {
+		t.Errorf("app settings = port %q, api key %q, log level %q, want values from arr-proxy.yaml", cfg.Port, cfg.Auth.APIKey, cfg.Server.LogLevel)
+	}
+	if got := strings.Join(cfg.ServiceNames(), ","); got != "lidarr-4k,sonarr" {
+		t.Errorf("ServiceNames() = %q, want %q", got, "lidarr-4k,sonarr")
+	}
+	if sc := cfg.Service("sonarr"); sc == nil || sc.URL != "http://sonarr:8989" || sc.APIKey != "legacy-key" {
+		t.Errorf("sonarr = %+v, want url from arr-proxy.yaml and api_key from sonarr.yaml", sc)
+	}
+	if sc := cfg.Service("lidarr-4k"); sc == nil || sc.APIKey != "lidarr-key" || !sc.IsWhitelisted("GET", "/api/v1/artist") {
+		t.Errorf("lidarr-4k = %+v, want api_key from LIDARR_4K_API_KEY and whitelist from arr-proxy.yaml", sc)
+	}
+	if client := cfg.Clients["Alice"]; client == nil || !client.AllowsService("lidarr-4k") || client.AllowsService("sonarr") {
+		t.Errorf("client Alice = %+v, want definition from arr-proxy.yaml", client)
+	}
+}
+
+func TestLoadTLSOptions(t *testing.T) {
+	dir := t.TempDir()
+	writeServiceFile(t, dir, "arr-proxy", `tls:
+  certificates:
+    - cert: /certs/media.crt
+      key: /certs/media.key
+  self_signed: true
+server:
+  tls_cipher_suites: [TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256]
+  tls_curves: [X25519, P-384]
+  tls_alpn: [http/1.1]
+services:
+  sonarr:
+    url: http://sonarr:8989
+    api_key: key
+`)
+	t.Setenv("APP_CONFIG_DIR", dir)
+	t.Setenv("APP_AUTH_MODE", "apikey")
+	t.Setenv("APP_API_KEY", "proxy-key")
+	t.Setenv("APP_TLS_CERT", "")
+	t.Setenv("APP_TLS_KEY", "")
+	t.Setenv("APP_TLS_CERTIFICATES", "")
+	t.Setenv("SONARR_URL", "")
+	t.Setenv("SONARR_API_KEY", "")
+	t.Setenv("RADARR_URL", "")
+
+	cfg, err := LoadWithOptions(LoadOptions{})
+	if err != nil {
+		t.Fatalf("LoadWithOptions() unexpected error: %v", err)
+	}
+	want := []KeyPair{
+		{Cert: filepath.Join(dir, "self-signed.crt"), Key: filepath.Join(dir, "self-signed.key")},
+		{Cert: "/certs/media.crt", Key: "/certs/media.key"},
+	}
+	if got := cfg.Certificates(); !slices.Equal(got, want) {
+		t.Errorf("Certificates() = %v, want self-signed default then %v", got, want[1:])
+	}
+	if got := cfg.Server.TLSCipherSuites; !slices.Equal(got, []uint16{tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256}) {
+		t.Errorf("TLSCipherSuites = %v", got)
+	}
+	if got := cfg.Server.TLSCurves; !slices.Equal(got, []tls.CurveID{tls.X25519, tls.CurveP384}) {
+		t.Errorf("TLSCurves = %v", got)
+	}
+	if got := cfg.Server.TLSALPN; !slices.Equal(got, []string{"http/1.1"}) {
+		t.Errorf("TLSALPN = %v", got)
+	}
+
+	// Environment variables give certificates as CERT:KEY pairs
+	t.Setenv("APP_TLS_CERTIFICATES", "/certs/a.crt:/certs/a.key, /certs/b.crt:/certs/b.key")
+	cfg, err = LoadWithOptions(LoadOptions{})
+	if err != nil {
+		t.Fatalf("LoadWithOptions() unexpected error: %v", err)
+	}
+	if got := cfg.TLS.Certificates; len(got) != 2 || got[1] != (KeyPair{Cert: "/certs/b.crt", Key: "/certs/b.key"}) {
+		t.Errorf("TLS.Certificates = %v, want pairs from APP_TLS_CERTIFICATES", got)
+	}
+}
+
+func TestLoadLowercasePaths(t *testing.T) {
+	dir := t.TempDir()
+	writeServiceFile(t, dir, "arr-proxy", `server:
+  lowercase_paths: true
+services:
+  sonarr:
+    url: http://sonarr:8989
+    api_key: key
+    whitelist:
+      - 'GET:^/api/v3/seriesLookup$'
+    rule_sets:
+      readonly: ['GET:^/api/v3/Series$']
+`)
+	t.Setenv("APP_CONFIG_DIR", dir)
+	t.Setenv("APP_AUTH_MODE", "apikey")
+	t.Setenv("APP_API_KEY", "proxy-key")
+	t.Setenv("APP_LOWERCASE_PATHS", "")
+	t.Setenv("SONARR_URL", "")
+	t.Setenv("SONARR_API_KEY", "")
+	t.Setenv("RADARR_URL", "")
+
+	cfg, err := LoadWithOptions(LoadOptions{})
+	if err != nil {
+		t.Fatalf("LoadWithOptions() unexpected error: %v", err)
+	}
+	// Request paths are lowercased, so patterns must match them regardless of case
+	sc := cfg.Service("sonarr")
+	if !sc.IsWhitelisted("GET", "/api/v3/serieslookup") {
+		t.Error("whitelist pattern with uppercase letters does not match the lowercased path")
+	}
+	if sc.MatchRule("readonly", "GET", "/api/v3/series") == nil {
+		t.Error("rule set pattern with uppercase letters does not match the lowercased path")
+	}
+}
+
+func TestLoadUnifiedConfigProblems(t *testing.T) {
+	tests := []struct {
+		name         string
+		legacy       map[string]string
+		unified      string
+		env          map[string]string
+		wantErrors   []string
+		wantWarnings []string
+	}{
+		{
+			name:         "file conflict",
+			legacy:       map[string]string{"sonarr": "url: http://legacy:8989\n"},
+			unified:      "services:\n  sonarr:\n    url: http://sonarr:8989\n    api_key: key\n",
+			wantWarnings: []string{`"url" is set in both arr-proxy.yaml and sonarr.yaml`},
+		},
+		{
+			name:         "environment override",
+			unified:      "services:\n  sonarr:\n    url: http://sonarr:8989\n    api_key: key\n",
+			env:          map[string]string{"SONARR_URL": "http://env:8989"},
+			wantWarnings: []string{`SONARR_URL overrides "url"`},
+		},
+		{
+			name:         "unknown key",
+			unified:      "logging: debug\nservices:\n  sonarr:\n    url: http://sonarr:8989\n    api_key: key\n",
+			wantWarnings: []string{`unknown key "logging"`},
+		},
+		{
+			name:       "reserved service name",
+			unified:    "services:\n  info:\n    url: http://info:80\n    api_key: key\n  sonarr:\n    url: http://sonarr:8989\n    api_key: key\n",
+			wantErrors: []string{`invalid service name "info"`},
+		},
+		{
+			name:       "plaintext client key",
+			unified:    "services:\n  sonarr:\n    url: http://sonarr:8989\n    api_key: key\nclients:\n  bot:\n    api_keys: [plaintext]\n",
+			wantErrors: []string{`client "bot" api_keys[0] is not a key hash`},
+		},
+		{
+			name:       "invalid service name",
+			unified:    "services:\n  my_service:\n    url: http://svc:80\n    api_key: key\n  sonarr:\n    url: http://sonarr:8989\n    api_key: key\n",
+			wantErrors: []string{`invalid service name "my_service"`},
+		},
+		{
+			name:       "token mode without key",
+			unified:    "services:\n  sonarr:\n    url: http://sonarr:8989\n    api_key: key\n",
+			env:        map[string]string{"APP_AUTH_MODE": "token"},
+			wantErrors: []string{"APP_TOKEN_KEY or APP_TOKEN_KEY_FILE required"},
+		},
+		{
+			name:       "short token key",
+			unified:    "auth:\n  mode: token\n  token:\n    key: short\nservices:\n  sonarr:\n    url: http://sonarr:8989\n    api_key: key\n",
+			env:        map[string]string{"APP_AUTH_MODE": "token"},
+			wantErrors: []string{"must be at least 32 bytes"},
+		},
+		{
+			name:       "oidc mode without settings",
+			unified:    "services:\n  sonarr:\n    url: http://sonarr:8989\n    api_key: key\n",
+			env:        map[string]string{"APP_AUTH_MODE": "oidc"},
+			wantErrors: []string{"APP_JWT_JWKS", "APP_JWT_ISSUER", "APP_JWT_AUDIENCE"},
+		},
+		{
+			name:       "missing JWKS file",
+			unified:    "auth:\n  jwt:\n    jwks: /nonexistent/jwks.json\n    issuer: https://sso.example.com\n    audience: arr-proxy\nservices:\n  sonarr:\n    url: http://sonarr:8989\n    api_key: key\n",
+			env:        map[string]string{"APP_AUTH_MODE": "jwt"},
+			wantErrors: []string{"APP_JWT_JWKS: stat /nonexistent/jwks.json"},
+		},
+		{
+			name:       "forward mode with invalid URL",
+			unified:    "auth:\n  forward:\n    url: authelia:9091\nservices:\n  sonarr:\n    url: http://sonarr:8989\n    api_key: key\n",
+			env:        map[string]string{"APP_AUTH_MODE": "forward"},
+			wantErrors: []string{`invalid APP_FORWARD_AUTH_URL "authelia:9091"`},
+		},
+		{
+			name:         "header mode with invalid CIDR",
+			unified:      "auth:\n  header:\n    trusted_proxies: [10.0.0.0/8, 0.0.0.0/0, 172.16.0.0/33]\nservices:\n  sonarr:\n    url: http://sonarr:8989\n    api_key: key\n",
+			env:          map[string]string{"APP_AUTH_MODE": "header"},
+			wantErrors:   []string{`APP_TRUSTED_PROXIES: invalid CIDR "172.16.0.0/33"`},
+			wantWarnings: []string{"APP_TRUSTED_PROXIES contains 0.0.0.0/0"},
+		},
+		{
+			name:       "token combined with oidc",
+			unified:    "services:\n  sonarr:\n    url: http://sonarr:8989\n    api_key: key\n",
+			env:        map[string]string{"APP_AUTH_MODE": "token, oidc"},
+			wantErrors: []string{"token and jwt auth cannot be combined", "APP_TOKEN_KEY or APP_TOKEN_KEY_FILE required", "APP_JWT_JWKS", "APP_JWT_ISSUER", "APP_JWT_AUDIENCE"},
+		},
+		{
+			name:         "invalid service auth method",
+			unified:      "auth:\n  forward:\n    url: http://authelia:9091/api/authz/forward-auth\nservices:\n  sonarr:\n    url: http://sonarr:8989\n    api_key: key\n    auth: [forward, apikey, magic]\n",
+			wantErrors:   []string{`invalid sonarr auth method "magic"`},
+			wantWarnings: []string{"sonarr auth: forward auth decides every request"},
+		},
+		{
+			name:       "invalid certificate matches",
+			unified:    "services:\n  sonarr:\n    url: http://sonarr:8989\n    api_key: key\nclients:\n  tv:\n    certificates:\n      - {}\n      - fingerprint: ab:cd\n      - spiffe: home.example/tv\n",
+			wantErrors: []string{`client "tv" certificates[0] sets no attribute`, `client "tv" certificates[1] fingerprint "ab:cd"`, `client "tv" certificates[2] spiffe`},
+		},
+		{
+			name:       "revocation without CA",
+			unified:    "tls:\n  crl_files: [/nonexistent/ca.crl]\nservices:\n  sonarr:\n    url: http://sonarr:8989\n    api_key: key\n",
+			wantErrors: []string{"certificate revocation list: stat /nonexistent/ca.crl", "APP_CA_CERT required to check APP_CRL_FILES"},
+		},
+		{
+			name:       "enrollment without CA",
+			unified:    "tls:\n  ca_dir: /nonexistent/ca\n  enroll_validity: forever\nservices:\n  sonarr:\n    url: http://sonarr:8989\n    api_key: key\n",
+			wantErrors: []string{"APP_CA_DIR: stat /nonexistent/ca/ca.crt", "APP_CA_DIR: stat /nonexistent/ca/ca.key", `invalid APP_ENROLL_VALIDITY "forever"`},
+		},
+		{
+			name:         "SNI certificates without default",
+			unified:      "tls:\n  certificates:\n    - cert: /certs/media.crt\n    - /certs/tv.crt:/certs/tv.key\n  self_signed_hosts: [proxy.home]\nservices:\n  sonarr:\n    url: http://sonarr:8989\n    api_key: key\n",
+			wantErrors:   []string{"APP_TLS_CERTIFICATES[0]: certificate and key required", "APP_TLS_CERT and APP_TLS_KEY required for APP_TLS_CERTIFICATES"},
+			wantWarnings: []string{"APP_TLS_SELF_SIGNED_HOSTS is set but APP_TLS_SELF_SIGNED is not enabled"},
+		},
+		{
+			name:         "invalid TLS policy",
+			unified:      "server:\n  tls_min_version: '1.3'\n  tls_cipher_suites: [TLS_RSA_WITH_RC4_128_SHA, TLS_AES_128_GCM_SHA256, tls_ecdhe_ecdsa_with_aes_128_gcm_sha256, BOGUS]\n  tls_curves: [X25519, P-256, brainpool]\n  tls_alpn: [h3, http/1.1]\nservices:\n  sonarr:\n    url: http://sonarr:8989\n    api_key: key\n",
+			wantErrors:   []string{"TLS_RSA_WITH_RC4_128_SHA is insecure", "TLS_AES_128_GCM_SHA256 is a TLS 1.3 cipher suite", `unknown cipher suite "BOGUS"`, `unknown curve "brainpool"`, `unsupported protocol "h3"`},
+			wantWarnings: []string{"APP_TLS_CIPHER_SUITES only applies to TLS 1.2"},
+		},
+		{
+			name:         "invalid network settings",
+			unified:      "network:\n  denied_ips: [10.0.0.0/33]\n  proxy_protocol: [0.0.0.0/0]\n  forwarded_header: X-Client-IP\nservices:\n  sonarr:\n    url: http://sonarr:8989\n    api_key: key\n    allowed_ips: [lan]\nclients:\n  tv:\n    denied_ips: ['::1/129']\n",
+			wantErrors:   []string{`APP_DENIED_IPS: invalid CIDR "10.0.0.0/33"`, `invalid APP_FORWARDED_HEADER "X-Client-IP"`, `Sonarr allowed_ips: invalid CIDR "lan"`, `client "tv" denied_ips: invalid CIDR "::1/129"`},
+			wantWarnings: []string{"APP_PROXY_PROTOCOL contains 0.0.0.0/0"},
+		},
+		{
+			name:       "invalid lockout settings",
+			unified:    "auth:\n  lockout:\n    threshold: -1\n    window: 0s\n    ban_time: 48h\nservices:\n  sonarr:\n    url: http://sonarr:8989\n    api_key: key\n",
+			wantErrors: []string{`invalid APP_LOCKOUT_THRESHOLD "-1"`, `invalid APP_LOCKOUT_WINDOW "0s"`, "APP_LOCKOUT_BAN_TIME must not exceed APP_LOCKOUT_MAX_BAN_TIME"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			for name, content := range tt.legacy {
+				writeServiceFile(t, dir, name, content)
+			}
+			writeServiceFile(t, dir, "arr-proxy", tt.unified)
+			t.Setenv("APP_CONFIG_DIR", dir)
+			t.Setenv("APP_AUTH_MODE", cmp.Or(tt.env["APP_AUTH_MODE"], "apikey"))
+			t.Setenv("APP_API_KEY", "proxy-key")
+			for _, env := range []string{"SONARR_URL", "SONARR_API_KEY", "RADARR_URL"} {
+				t.Setenv(env, tt.env[env])
+			}
+
+			_, err := LoadWithOptions(LoadOptions{})
+			var problems Problems
+			if verr, ok := err.(*ValidationError); ok {
+				problems = Problems{Errors: verr.Errors, Warnings: verr.Warnings}
+			} else if err != nil {
+				t.Fatalf("LoadWithOptions() unexpected error: %v", err)
+			} else {
+				// Warnings of a valid configuration are only logged, collect them directly
+				u, unifiedProblems := loadUnifiedConfig(dir)
+				problems.Merge(unifiedProblems)
+				_, serviceProblems := loadServiceConfig("sonarr", u.services["sonarr"], u.name(), dir)
+				problems.Merge(serviceProblems)
+			}
+
+			if len(problems.Errors) != len(tt.wantErrors) {
+				t.Errorf("errors = %q, want %d", problems.Errors, len(tt.wantErrors))
+			}
+			for _, want := range tt.wantErrors {
+				if !containsProblem(problems.Errors, want) {
+					t.Errorf("errors = %q, want one containing %q", problems.Errors, want)
+				}
+			}
+			for _, want := range tt.wantWarnings {
+				if !containsProblem(problems.Warnings, want) {
+					t.Errorf("warnings = %q, want one containing %q", problems.Warnings, want)
+				}
+			}
+		})
+	}
+}
+
+func TestLoadUnifiedConfigFileEnv(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "custom.yaml")
+	if err := os.WriteFile(file, []byte("port: 1234\n"), 0600); err != nil {
+		t.Fatalf("failed to write config file: %v", err)
+	}
+	t.Setenv("APP_CONFIG_FILE", file)
+
+	u, problems := loadUnifiedConfig(t.TempDir())
+	if len(problems.Errors) > 0 {
+		t.Fatalf("loadUnifiedConfig() errors = %q", problems.Errors)
+	}
+	if u.path != file || u.name() != "custom.yaml" || u.app["port"] == nil {
+		t.Errorf("loadUnifiedConfig() path = %q, app = %v, want settings from APP_CONFIG_FILE", u.path, u.app)
+	}
+}
@@ -4,7 +4,7 @@ import (
 	"testing"
 )
 
-func TestCompileWhitelist(t *testing.T) {
+func TestCompileRules(t *testing.T) {
 	tests := []struct {
 		name     string
 		patterns []string
@@ -155,7 +155,10 @@ func TestCompileWhitelist(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			rules := compileWhitelist(tt.patterns)
+			rules, problems := compileRules(tt.patterns)
+			if len(problems) > 0 {
+				t.Fatalf("compileRules(%q) problems: %v", tt.patterns, problems)
+			}
 			cfg := &ServiceConfig{CompiledWhitelist: rules}
 			got := cfg.IsWhitelisted(tt.method, tt.path)
 			if got != tt.want {
@@ -195,3 +198,13 @@ func TestIsValidMethodSpec(t *testing.T) {
 		})
 	}
 }
+
+func TestCompileRulesInvalidPattern(t *testing.T) {
+	rules, problems := compileRules([]string{"GET:^/api/v3/(broken$", "^/api/v3/status$"})
+	if len(rules) != 1 || rules[0].Raw != "^/api/v3/status$" {
+		t.Errorf("compileRules() kept %d rules, want only the valid pattern", len(rules))
+	}
+	if len(problems) != 1 {
+		t.Errorf("compileRules() reported %d problems, want 1", len(problems))
+	}
+}
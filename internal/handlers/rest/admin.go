@@ -0,0 +1,291 @@
+package rest
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"arr-proxy/internal/apikeys"
+	"arr-proxy/internal/config"
+	"arr-proxy/internal/lockout"
+	"arr-proxy/internal/middleware"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// maxAdminBodySize limits admin API request bodies.
+const maxAdminBodySize = 64 * 1024
+
+// AdminHandler serves the admin API managing keys and brute-force bans.
+type AdminHandler struct {
+	config  *config.Config
+	store   *apikeys.Store // nil without a key store
+	lockout *lockout.Guard // nil unless lockout is enabled
+}
+
+// NewAdminHandler creates a new AdminHandler.
+func NewAdminHandler(cfg *config.Config, store *apikeys.Store, guard *lockout.Guard) *AdminHandler {
+	return &AdminHandler{
+		config:  cfg,
+		store:   store,
+		lockout: guard,
+	}
+}
+
+// Routes registers the admin endpoints on r. Key endpoints need a key store and
+// ban endpoints need lockout.
+func (h *AdminHandler) Routes(r chi.Router) {
+	if h.store != nil {
+		r.Get("/keys", h.listKeys)
+		r.Post("/keys", h.createKey)
+		r.Post("/keys/{id}/rotate", h.rotateKey)
+		r.Post("/keys/{id}/expire", h.expireKey)
+		r.Delete("/keys/{id}", h.revokeKey)
+	}
+	if h.lockout != nil {
+		r.Get("/bans", h.listBans)
+		r.Delete("/bans", h.clearBans)
+		r.Delete("/bans/{key}", h.clearBan)
+	}
+}
+
+// keyInfo is the masked representation of a managed key.
+type keyInfo struct {
+	ID         string     `json:"id"`
+	Key        string     `json:"key"` // masked
+	Owner      string     `json:"owner"`
+	Services   []string   `json:"services,omitempty"`
+	Active     bool       `json:"active"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+}
+
+// newKeyResponse carries a newly generated key, shown only once.
+type newKeyResponse struct {
+	APIKey string  `json:"api_key"`
+	Key    keyInfo `json:"key"`
+}
+
+// banInfo is the representation of a brute-force ban.
+type banInfo struct {
+	Key      string    `json:"key"` // ip:<address> or user:<name>
+	Failures int       `json:"failures"`
+	Count    int       `json:"count"` // bans of the key, each twice as long as the previous
+	Until    time.Time `json:"until"`
+}
+
+type createKeyRequest struct {
+	Owner     string     `json:"owner"`
+	Services  []string   `json:"services"`
+	ExpiresAt *time.Time `json:"expires_at"`
+	ExpiresIn string     `json:"expires_in"` // duration, alternative to expires_at
+}
+
+type rotateKeyRequest struct {
+	Grace string `json:"grace"` // how long the old key stays valid, default immediately revoked
+}
+
+type expireKeyRequest struct {
+	ExpiresAt *time.Time `json:"expires_at"`
+	ExpiresIn string     `json:"expires_in"`
+}
+
+func (h *AdminHandler) listKeys(w http.ResponseWriter, r *http.Request) {
+	records := h.store.List()
+	keys := make([]keyInfo, 0, len(records))
+	for i := range records {
+		keys = append(keys, newKeyInfo(&records[i]))
+	}
+	writeJSON(w, http.StatusOK, keys)
+}
+
+func (h *AdminHandler) createKey(w http.ResponseWriter, r *http.Request) {
+	var req createKeyRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	if req.Owner == "" {
+		http.Error(w, "owner is required", http.StatusBadRequest)
+		return
+	}
+	for _, name := range req.Services {
+		if h.config.Service(name) == nil {
+			http.Error(w, "unknown service "+name, http.StatusBadRequest)
+			return
+		}
+	}
+	if len(req.Services) == 0 {
+		req.Services = nil // no limit
+	}
+	expiresAt, ok := parseExpiry(w, req.ExpiresAt, req.ExpiresIn)
+	if !ok {
+		return
+	}
+
+	rec, key, err := h.store.Create(req.Owner, req.Services, expiresAt)
+	if err != nil {
+		h.storeError(w, err)
+		return
+	}
+	slog.Info("API key created", "id", rec.ID, "owner", rec.Owner, "services", rec.Services, "admin", middleware.GetIdentity(r.Context()).Name)
+	writeJSON(w, http.StatusCreated, newKeyResponse{APIKey: key, Key: newKeyInfo(&rec)})
+}
+
+func (h *AdminHandler) rotateKey(w http.ResponseWriter, r *http.Request) {
+	var req rotateKeyRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	var grace time.Duration
+	if req.Grace != "" {
+		d, err := time.ParseDuration(req.Grace)
+		if err != nil || d < 0 {
+			http.Error(w, "invalid grace duration", http.StatusBadRequest)
+			return
+		}
+		grace = d
+	}
+
+	id := chi.URLParam(r, "id")
+	rec, key, err := h.store.Rotate(id, grace)
+	if err != nil {
+		h.storeError(w, err)
+		return
+	}
+	slog.Info("API key rotated", "id", id, "new_id", rec.ID, "owner", rec.Owner, "grace", grace, "admin", middleware.GetIdentity(r.Context()).Name)
+	writeJSON(w, http.StatusCreated, newKeyResponse{APIKey: key, Key: newKeyInfo(&rec)})
+}
+
+func (h *AdminHandler) expireKey(w http.ResponseWriter, r *http.Request) {
+	var req expireKeyRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	expiresAt, ok := parseExpiry(w, req.ExpiresAt, req.ExpiresIn)
+	if !ok {
+		return
+	}
+	if expiresAt == nil {
+		now := time.Now().UTC()
+		expiresAt = &now
+	}
+
+	rec, err := h.store.Expire(chi.URLParam(r, "id"), *expiresAt)
+	if err != nil {
+		h.storeError(w, err)
+		return
+	}
+	slog.Info("API key expiry set", "id", rec.ID, "owner", rec.Owner, "expires_at", expiresAt, "admin", middleware.GetIdentity(r.Context()).Name)
+	writeJSON(w, http.StatusOK, newKeyInfo(&rec))
+}
+
+func (h *AdminHandler) revokeKey(w http.ResponseWriter, r *http.Request) {
+	rec, err := h.store.Revoke(chi.URLParam(r, "id"))
+	if err != nil {
+		h.storeError(w, err)
+		return
+	}
+	slog.Info("API key revoked", "id", rec.ID, "owner", rec.Owner, "admin", middleware.GetIdentity(r.Context()).Name)
+	writeJSON(w, http.StatusOK, newKeyInfo(&rec))
+}
+
+func (h *AdminHandler) listBans(w http.ResponseWriter, r *http.Request) {
+	list := h.lockout.List()
+	bans := make([]banInfo, 0, len(list))
+	for _, b := range list {
+		bans = append(bans, banInfo{Key: b.Key, Failures: b.Failures, Count: b.Count, Until: b.Until.UTC()})
+	}
+	writeJSON(w, http.StatusOK, bans)
+}
+
+func (h *AdminHandler) clearBan(w http.ResponseWriter, r *http.Request) {
+	key := chi.URLParam(r, "key")
+	if !h.lockout.Clear(key) {
+		http.Error(w, "404 Not Found", http.StatusNotFound)
+		return
+	}
+	slog.Info("Ban cleared", "ban", key, "admin", middleware.GetIdentity(r.Context()).Name)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *AdminHandler) clearBans(w http.ResponseWriter, r *http.Request) {
+	n := h.lockout.ClearAll()
+	slog.Info("All bans cleared", "count", n, "admin", middleware.GetIdentity(r.Context()).Name)
+	writeJSON(w, http.StatusOK, map[string]int{"cleared": n})
+}
+
+func (h *AdminHandler) storeError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, apikeys.ErrNotFound):
+		http.Error(w, "404 Not Found", http.StatusNotFound)
+	case errors.Is(err, apikeys.ErrRevoked):
+		http.Error(w, "key is revoked", http.StatusConflict)
+	default:
+		slog.Error("Key store error", "error", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	}
+}
+
+func newKeyInfo(rec *apikeys.Record) keyInfo {
+	return keyInfo{
+		ID:         rec.ID,
+		Key:        rec.Masked(),
+		Owner:      rec.Owner,
+		Services:   rec.Services,
+		Active:     rec.Active(time.Now()),
+		CreatedAt:  rec.CreatedAt,
+		ExpiresAt:  rec.ExpiresAt,
+		LastUsedAt: rec.LastUsedAt,
+		RevokedAt:  rec.RevokedAt,
+	}
+}
+
+// parseExpiry returns the expiry given as absolute time or duration from now, or
+// nil if neither is set. Writes a 400 response and returns false if invalid.
+func parseExpiry(w http.ResponseWriter, at *time.Time, in string) (*time.Time, bool) {
+	if at != nil && in != "" {
+		http.Error(w, "set either expires_at or expires_in", http.StatusBadRequest)
+		return nil, false
+	}
+	if in != "" {
+		d, err := time.ParseDuration(in)
+		if err != nil {
+			http.Error(w, "invalid expires_in duration", http.StatusBadRequest)
+			return nil, false
+		}
+		t := time.Now().UTC().Add(d)
+		return &t, true
+	}
+	return at, true
+}
+
+// decodeJSON decodes an optional JSON request body. Writes a 400 response and
+// returns false if the body is invalid.
+func decodeJSON(w http.ResponseWriter, r *http.Request, v any) bool {
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxAdminBodySize))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil && !errors.Is(err, io.EOF) {
+		http.Error(w, "invalid JSON body: "+err.Error(), http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	// Marshal before writing header so errors can be returned properly
+	data, err := json.Marshal(v)
+	if err != nil {
+		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if _, err := w.Write(data); err != nil {
+		slog.Error("Failed to write response", "error", err)
+	}
+}
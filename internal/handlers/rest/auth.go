@@ -0,0 +1,161 @@
+package rest
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"slices"
+
+	"arr-proxy/internal/config"
+	"arr-proxy/internal/middleware"
+)
+
+// authMethods builds the authentication of the configured auth methods. Each method
+// is built once so state such as the forward-auth cache is shared between services.
+type authMethods struct {
+	config *config.Config
+	creds  Credentials
+	built  map[string]middleware.AuthMethod
+}
+
+// chain returns the middleware authenticating requests with the listed methods,
+// tried in order. The token method implies API keys so clients can mint tokens.
+func (a *authMethods) chain(names []string) (func(http.Handler) http.Handler, error) {
+	if len(names) == 0 {
+		return nil, fmt.Errorf("no auth method configured")
+	}
+	var methods []middleware.AuthMethod
+	for _, name := range names {
+		m, err := a.method(name)
+		if err != nil {
+			return nil, err
+		}
+		methods = append(methods, m)
+		if name == config.AuthModeToken && !slices.Contains(names, config.AuthModeAPIKey) {
+			apiKeys, _ := a.method(config.AuthModeAPIKey)
+			methods = append(methods, apiKeys)
+		}
+	}
+
+	switch {
+	case len(names) == 1 && names[0] == config.AuthModeToken:
+		// Requests without a bearer token are authenticated by API key
+		return middleware.BearerAuth(&tokenVerifier{minter: a.creds.Minter}, methods[1].Authenticate), nil
+	case len(methods) == 1:
+		// A single method keeps its own handling of requests without credentials
+		return methods[0].Authenticate, nil
+	}
+	return middleware.FirstOf(methods...), nil
+}
+
+// method returns the named auth method.
+func (a *authMethods) method(name string) (middleware.AuthMethod, error) {
+	if m, ok := a.built[name]; ok {
+		return m, nil
+	}
+	cfg := a.config
+	m := middleware.AuthMethod{Name: name}
+	switch name {
+	case config.AuthModeBasic:
+		m.Present = middleware.HasBasicAuth
+		m.Challenge = `Basic realm="Restricted"`
+		m.Authenticate = middleware.BasicAuth(a.creds.Secrets.basicAuth)
+	case config.AuthModeAPIKey:
+		m.Present = middleware.HasAPIKey
+		m.Authenticate = middleware.APIKeyAuth(&keyLookup{method: "apikey", static: a.creds.Secrets.apiKeys, store: a.creds.KeyStore})
+	case config.AuthModeToken:
+		if a.creds.Minter == nil {
+			return m, fmt.Errorf("token auth mode requires a token key")
+		}
+		m.Present = middleware.HasBearerToken
+		m.Challenge = "Bearer"
+		m.Authenticate = middleware.BearerAuth(&tokenVerifier{minter: a.creds.Minter}, nil)
+	case config.AuthModeJWT:
+		if a.creds.JWT == nil {
+			return m, fmt.Errorf("jwt auth mode requires a key set")
+		}
+		m.Present = middleware.HasBearerToken
+		m.Challenge = "Bearer"
+		m.Authenticate = middleware.BearerAuth(&jwtVerifier{config: cfg, validator: a.creds.JWT}, nil)
+	case config.AuthModeForward:
+		// The auth service decides on every request, with or without credentials
+		m.Present = middleware.Always
+		m.Authenticate = middleware.ForwardAuth(middleware.ForwardAuthOptions{
+			URL:            cfg.Auth.Forward.URL,
+			Headers:        cfg.Auth.Forward.Headers,
+			IdentityHeader: cfg.Auth.Forward.IdentityHeader,
+			GroupsHeader:   cfg.Auth.Forward.GroupsHeader,
+			CacheTTL:       cfg.Auth.Forward.CacheTTL,
+			Client: &http.Client{
+				Timeout: forwardAuthTimeout,
+				// Redirects to a login page are passed to the client
+				CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+			},
+			Identify: cfg.ClientFor,
+		})
+	case config.AuthModeHeader:
+		m.Present = middleware.HasHeader(cfg.Auth.Header.UserHeader)
+		m.Authenticate = middleware.TrustedHeaderAuth(middleware.TrustedHeaderOptions{
+			UserHeader:     cfg.Auth.Header.UserHeader,
+			GroupsHeader:   cfg.Auth.Header.GroupsHeader,
+			TrustedProxies: cfg.Network.TrustedProxies,
+			Identify:       cfg.ClientFor,
+		})
+	case config.AuthModeMTLS:
+		// The certificate itself is verified during the TLS handshake
+		m.Present = middleware.HasClientCert
+		m.Authenticate = middleware.ClientCertAuth(cfg.ClientForCert)
+	default:
+		return m, fmt.Errorf("unknown auth mode: %s", name)
+	}
+	if a.creds.Lockout != nil && (name == config.AuthModeBasic || name == config.AuthModeAPIKey) {
+		// Guessable credentials are locked out after repeated failures
+		m.Authenticate = middleware.Lockout(a.creds.Lockout, m)
+	}
+	a.built[name] = m
+	return m, nil
+}
+
+// serviceAuth middleware authenticates requests with the methods of the service
+// named by the first path segment, or with global if the service has no override.
+func serviceAuth(global func(http.Handler) http.Handler, services map[string]func(http.Handler) http.Handler) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		fallback := global(next)
+		handlers := make(map[string]http.Handler, len(services))
+		for name, authenticate := range services {
+			handlers[name] = authenticate(next)
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			name, _ := splitServicePath(r.URL.Path)
+			if h, ok := handlers[name]; ok {
+				h.ServeHTTP(w, r)
+				return
+			}
+			fallback.ServeHTTP(w, r)
+		})
+	}
+}
+
+// clientAuthType returns how the TLS handshake treats client certificates: required
+// if mTLS is the only method anywhere, verified if given when it is one of several
+// or devices enroll their first certificate.
+func clientAuthType(cfg *config.Config) tls.ClientAuthType {
+	if !cfg.UsesAuth(config.AuthModeMTLS) {
+		return tls.NoClientCert
+	}
+	if cfg.Enrollment.Enabled() {
+		return tls.VerifyClientCertIfGiven
+	}
+	lists := [][]string{cfg.Auth.Methods()}
+	for _, sc := range cfg.Services {
+		if len(sc.Auth) > 0 {
+			lists = append(lists, sc.Auth)
+		}
+	}
+	for _, methods := range lists {
+		if len(methods) != 1 || methods[0] != config.AuthModeMTLS {
+			return tls.VerifyClientCertIfGiven
+		}
+	}
+	return tls.RequireAndVerifyClientCert
+}
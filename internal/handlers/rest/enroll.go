@@ -0,0 +1,88 @@
+package rest
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"arr-proxy/internal/ca"
+	"arr-proxy/internal/config"
+	"arr-proxy/internal/middleware"
+)
+
+// maxCSRSize limits enrollment request bodies.
+const maxCSRSize = 64 * 1024
+
+// EnrollHandler issues client certificates for CSRs posted with a one-time
+// enrollment token.
+type EnrollHandler struct {
+	config    *config.Config
+	authority *ca.CA
+}
+
+// NewEnrollHandler creates a new EnrollHandler.
+func NewEnrollHandler(cfg *config.Config, authority *ca.CA) *EnrollHandler {
+	return &EnrollHandler{
+		config:    cfg,
+		authority: authority,
+	}
+}
+
+// ServeHTTP handles POST /enroll. The body is a PEM or DER encoded CSR and the
+// response the issued certificate followed by the CA certificate, in PEM.
+func (h *EnrollHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	scheme, token, _ := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !strings.EqualFold(scheme, "Bearer") || token == "" {
+		slog.Warn("Enrollment failed", "reason", "no enrollment token provided", "client_ip", middleware.ClientIP(r))
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxCSRSize))
+	if err != nil {
+		http.Error(w, "request body too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+	if block, _ := pem.Decode(body); block != nil {
+		if block.Type != "CERTIFICATE REQUEST" && block.Type != "NEW CERTIFICATE REQUEST" {
+			http.Error(w, "expected a PEM certificate request", http.StatusBadRequest)
+			return
+		}
+		body = block.Bytes
+	}
+	csr, err := x509.ParseCertificateRequest(body)
+	if err != nil {
+		http.Error(w, "invalid certificate request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	cert, enrollment, err := h.authority.Enroll(strings.TrimSpace(token), csr, h.config.Enrollment.Validity, middleware.ClientIP(r))
+	switch {
+	case errors.Is(err, ca.ErrInvalidToken):
+		slog.Warn("Enrollment failed", "reason", err.Error(), "enrollment", enrollment.ID, "client", enrollment.Name, "client_ip", middleware.ClientIP(r))
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	case errors.Is(err, ca.ErrInvalidCSR):
+		slog.Warn("Enrollment failed", "reason", err.Error(), "enrollment", enrollment.ID, "client", enrollment.Name, "client_ip", middleware.ClientIP(r))
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case err != nil:
+		slog.Error("Failed to issue enrolled certificate", "enrollment", enrollment.ID, "client", enrollment.Name, "error", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	slog.Info("Client certificate enrolled", "enrollment", enrollment.ID, "client", enrollment.Name, "serial", cert.SerialNumber.Text(16),
+		"fingerprint", config.Fingerprint(cert), "expires_at", cert.NotAfter, "client_ip", middleware.ClientIP(r))
+	w.Header().Set("Content-Type", "application/x-pem-file")
+	w.WriteHeader(http.StatusCreated)
+	if _, err := w.Write(append(ca.EncodeCertPEM(cert), ca.EncodeCertPEM(h.authority.Certificate())...)); err != nil {
+		slog.Error("Failed to write response", "error", err)
+	}
+}
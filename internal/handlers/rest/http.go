@@ -2,49 +2,134 @@ package rest
 
 import (
 	"context"
-	"crypto/tls"
-	"crypto/x509"
 	"fmt"
+	"log"
 	"log/slog"
+	"net"
 	"net/http"
 	"os"
+	"slices"
+	"time"
 
+	"arr-proxy/internal/apikeys"
 	"arr-proxy/internal/config"
+	"arr-proxy/internal/lockout"
 	"arr-proxy/internal/middleware"
+	"arr-proxy/internal/proxyproto"
+	"arr-proxy/internal/tokens"
 
 	"github.com/go-chi/chi/v5"
 	chiMiddleware "github.com/go-chi/chi/v5/middleware"
 )
 
+// forwardAuthTimeout limits calls to the forward-auth service.
+const forwardAuthTimeout = 5 * time.Second
+
 // Server is the main server struct.
 type Server struct {
-	config *config.Config
-	server *http.Server
+	config     *config.Config
+	server     *http.Server
+	stopReload context.CancelFunc // nil without TLS
+}
+
+// Handlers are the endpoint handlers served by the server.
+type Handlers struct {
+	Proxy  *ProxyHandler
+	Info   *InfoHandler
+	Admin  *AdminHandler  // nil if the admin API is disabled
+	Token  *TokenHandler  // nil if tokens are disabled
+	Enroll *EnrollHandler // nil if certificate enrollment is disabled
+}
+
+// Credentials are the credential stores and verifiers used for authentication.
+type Credentials struct {
+	Secrets  *Secrets
+	KeyStore *apikeys.Store    // nil without a key store
+	Minter   *tokens.Minter    // nil unless tokens are enabled
+	JWT      *tokens.Validator // nil unless in jwt mode
+	Lockout  *lockout.Guard    // nil unless brute-force lockout is enabled
+}
+
+// clientLogFormatter formats chi's request log with the client address resolved by
+// RealIP instead of the connection's remote address, which may be a proxy's.
+type clientLogFormatter struct {
+	*chiMiddleware.DefaultLogFormatter
+}
+
+func (f *clientLogFormatter) NewLogEntry(r *http.Request) chiMiddleware.LogEntry {
+	// Log a copy; the request passed on keeps its remote address
+	logged := *r
+	logged.RemoteAddr = middleware.ClientIP(r)
+	return f.DefaultLogFormatter.NewLogEntry(&logged)
 }
 
 // New creates a new server.
-func New(cfg *config.Config, proxyHandler *ProxyHandler, infoHandler *InfoHandler) (*Server, error) {
+func New(cfg *config.Config, handlers Handlers, creds Credentials) (*Server, error) {
 	r := chi.NewRouter()
 
 	// Core middleware (order matters)
 	r.Use(middleware.RequestID)
+	r.Use(middleware.RealIP(cfg.Network.TrustedProxies, cfg.Network.ForwardedHeader))
 	r.Use(middleware.SecurityHeaders)
-	r.Use(chiMiddleware.Logger)
-
-	// Apply authentication middleware based on mode
-	switch cfg.Auth.Mode {
-	case config.AuthModeBasic:
-		r.Use(middleware.BasicAuth(cfg.Auth.BasicAuth.User, cfg.Auth.BasicAuth.Password))
-	case config.AuthModeAPIKey:
-		r.Use(middleware.APIKeyAuth(cfg.Auth.APIKey))
-	case config.AuthModeMTLS:
-		// mTLS auth is handled by TLS client cert verification
-	default:
-		return nil, fmt.Errorf("unknown auth mode: %s", cfg.Auth.Mode)
+	r.Use(chiMiddleware.RequestLogger(&clientLogFormatter{&chiMiddleware.DefaultLogFormatter{Logger: log.New(os.Stdout, "", log.LstdFlags)}}))
+	r.Use(middleware.CanonicalPath(cfg.Server.LowercasePaths))
+	if filter := networkFilter(cfg); filter != nil {
+		r.Use(filter)
 	}
 
-	r.Get("/info", infoHandler.ServeHTTP)
-	r.Handle("/*", proxyHandler)
+	// Select authentication middleware based on the configured auth methods, which
+	// services may override
+	methods := &authMethods{config: cfg, creds: creds, built: make(map[string]middleware.AuthMethod)}
+	authenticate, err := methods.chain(cfg.Auth.Methods())
+	if err != nil {
+		return nil, err
+	}
+	overrides := make(map[string]func(http.Handler) http.Handler)
+	for _, name := range cfg.ServiceNames() {
+		if sc := cfg.Services[name]; len(sc.Auth) > 0 {
+			if overrides[name], err = methods.chain(sc.Auth); err != nil {
+				return nil, fmt.Errorf("service %s: %w", name, err)
+			}
+		}
+	}
+	if len(overrides) > 0 {
+		authenticate = serviceAuth(authenticate, overrides)
+	}
+
+	// The admin API has its own credential and bypasses client authentication
+	if handlers.Admin != nil {
+		adminAuth := middleware.AdminAuth(&keyLookup{method: "admin", static: creds.Secrets.admins})
+		if creds.Lockout != nil {
+			// Guessing the admin key bans the client like any other credential, and
+			// a banned client cannot lift its own ban
+			adminAuth = middleware.Lockout(creds.Lockout, middleware.AuthMethod{
+				Name:         "admin",
+				Present:      middleware.HasHeader("X-Admin-Key"),
+				Authenticate: adminAuth,
+			})
+		}
+		r.Route("/admin", func(r chi.Router) {
+			r.Use(adminAuth)
+			handlers.Admin.Routes(r)
+		})
+	}
+
+	// Devices without a client certificate enroll with a one-time token
+	if handlers.Enroll != nil {
+		r.Post("/enroll", handlers.Enroll.ServeHTTP)
+	}
+
+	r.Group(func(r chi.Router) {
+		r.Use(authenticate)
+		if filter := clientFilter(cfg); filter != nil {
+			r.Use(filter)
+		}
+		r.Get("/info", handlers.Info.ServeHTTP)
+		if handlers.Token != nil {
+			r.Post("/token", handlers.Token.ServeHTTP)
+		}
+		r.Handle("/*", handlers.Proxy)
+	})
 
 	server := &http.Server{
 		Addr:              ":" + cfg.Port,
@@ -54,60 +139,51 @@ func New(cfg *config.Config, proxyHandler *ProxyHandler, infoHandler *InfoHandle
 		IdleTimeout:       cfg.Server.IdleTimeout,
 		ReadHeaderTimeout: cfg.Server.ReadHeaderTimeout,
 	}
+	s := &Server{config: cfg, server: server}
 
 	// Configure TLS if certificates are provided
 	if cfg.TLSEnabled() {
-		// Set TLS minimum version from config
-		var minVersion uint16 = tls.VersionTLS12
-		if cfg.Server.TLSMinVersion == "1.3" {
-			minVersion = tls.VersionTLS13
-		}
-
-		tlsConfig := &tls.Config{
-			MinVersion: minVersion,
-		}
-
-		// Load CA cert for client verification if provided
-		if cfg.CACert != "" {
-			caCert, err := os.ReadFile(cfg.CACert)
-			if err != nil {
-				return nil, fmt.Errorf("failed to read CA cert: %w", err)
-			}
-			caCertPool := x509.NewCertPool()
-			if ok := caCertPool.AppendCertsFromPEM(caCert); !ok {
-				return nil, fmt.Errorf("failed to parse CA certificate")
-			}
-			tlsConfig.ClientCAs = caCertPool
-		}
-
-		// Require client certs only for mTLS mode
-		if cfg.Auth.Mode == config.AuthModeMTLS {
-			tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
-		} else {
-			tlsConfig.ClientAuth = tls.NoClientCert
+		tlsConfig, reloader, err := newTLSConfig(cfg, handlers)
+		if err != nil {
+			return nil, err
 		}
+		ctx, cancel := context.WithCancel(context.Background())
+		s.stopReload = cancel
+		go reloader.Run(ctx)
 
 		server.TLSConfig = tlsConfig
+		server.Protocols = new(http.Protocols)
+		server.Protocols.SetHTTP1(slices.Contains(cfg.Server.TLSALPN, "http/1.1"))
+		server.Protocols.SetHTTP2(slices.Contains(cfg.Server.TLSALPN, "h2"))
 	}
 
-	return &Server{
-		config: cfg,
-		server: server,
-	}, nil
+	return s, nil
 }
 
 // Start starts the server.
 func (s *Server) Start() error {
+	ln, err := net.Listen("tcp", s.server.Addr)
+	if err != nil {
+		return err
+	}
+	// Load balancers announce the client address before the TLS handshake
+	if len(s.config.Network.ProxyProtocol) > 0 {
+		ln = proxyproto.NewListener(ln, s.config.Network.ProxyProtocol, s.config.Server.ReadHeaderTimeout)
+	}
 	if s.config.TLSEnabled() {
 		slog.Info("Starting HTTPS server", "port", s.config.Port)
-		return s.server.ListenAndServeTLS(s.config.TLSCert, s.config.TLSKey)
+		// The certificate comes from the reloader
+		return s.server.ServeTLS(ln, "", "")
 	}
 	slog.Info("Starting HTTP server", "port", s.config.Port)
-	return s.server.ListenAndServe()
+	return s.server.Serve(ln)
 }
 
 // Shutdown gracefully shuts down the server.
 func (s *Server) Shutdown(ctx context.Context) error {
+	if s.stopReload != nil {
+		s.stopReload()
+	}
 	return s.server.Shutdown(ctx)
 }
 
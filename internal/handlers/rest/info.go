@@ -7,45 +7,37 @@ import (
 	"net/http"
 
 	"arr-proxy/internal/config"
+	"arr-proxy/internal/usecases"
 )
 
 // InfoHandler is the handler for the info endpoint.
 type InfoHandler struct {
-	config *config.Config
+	config          *config.Config
+	candidateReport *usecases.CandidateReport
 }
 
 // NewInfoHandler creates a new InfoHandler.
-func NewInfoHandler(cfg *config.Config) *InfoHandler {
+func NewInfoHandler(cfg *config.Config, candidateReport *usecases.CandidateReport) *InfoHandler {
 	return &InfoHandler{
-		config: cfg,
+		config:          cfg,
+		candidateReport: candidateReport,
 	}
 }
 
 type serviceInfo struct {
-	URL       string   `json:"url"`
-	Whitelist []string `json:"whitelist"`
+	URL                string                  `json:"url"`
+	Whitelist          []string                `json:"whitelist"`
+	CandidateWhitelist []string                `json:"candidate_whitelist,omitempty"`
+	CandidateReport    *usecases.ServiceReport `json:"candidate_report,omitempty"`
 }
 
-type infoResponse struct {
-	Sonarr *serviceInfo `json:"sonarr,omitempty"`
-	Radarr *serviceInfo `json:"radarr,omitempty"`
-}
+// infoResponse maps service names to their configuration.
+type infoResponse map[string]*serviceInfo
 
 func (h *InfoHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	resp := infoResponse{}
-
-	if h.config.Sonarr != nil {
-		resp.Sonarr = &serviceInfo{
-			URL:       h.config.Sonarr.URL,
-			Whitelist: h.config.Sonarr.Whitelist,
-		}
-	}
-
-	if h.config.Radarr != nil {
-		resp.Radarr = &serviceInfo{
-			URL:       h.config.Radarr.URL,
-			Whitelist: h.config.Radarr.Whitelist,
-		}
+	resp := make(infoResponse, len(h.config.Services))
+	for name, sc := range h.config.Services {
+		resp[name] = h.serviceInfo(name, sc)
 	}
 
 	// Marshal before writing header so errors can be returned properly
@@ -60,3 +52,18 @@ func (h *InfoHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		slog.Error("Failed to write response", "error", err)
 	}
 }
+
+func (h *InfoHandler) serviceInfo(name string, sc *config.ServiceConfig) *serviceInfo {
+	info := &serviceInfo{
+		URL:       sc.URL,
+		Whitelist: sc.Whitelist,
+	}
+	if sc.HasCandidateWhitelist() {
+		info.CandidateWhitelist = sc.CandidateWhitelist
+		info.CandidateReport = h.candidateReport.Snapshot(name)
+		if info.CandidateReport == nil {
+			info.CandidateReport = &usecases.ServiceReport{Requests: map[string]int64{}}
+		}
+	}
+	return info
+}
@@ -0,0 +1,55 @@
+package rest
+
+import (
+	"net/http"
+	"net/netip"
+
+	"arr-proxy/internal/config"
+	"arr-proxy/internal/middleware"
+)
+
+// networkFilter returns the middleware applying the global client address rules
+// and those of the service named by the first path segment, before authentication.
+// It returns nil if no such rules are configured.
+func networkFilter(cfg *config.Config) func(http.Handler) http.Handler {
+	services := make(map[string]config.IPRules)
+	for name, sc := range cfg.Services {
+		if !sc.IPRules.Empty() {
+			services[name] = sc.IPRules
+		}
+	}
+	if cfg.Network.IPRules.Empty() && len(services) == 0 {
+		return nil
+	}
+	return middleware.IPFilter(func(r *http.Request, addr netip.Addr) string {
+		if reason := cfg.Network.IPRules.Check(addr); reason != "" {
+			return reason
+		}
+		name, _ := splitServicePath(r.URL.Path)
+		if reason := services[name].Check(addr); reason != "" {
+			return "service " + name + ": " + reason
+		}
+		return ""
+	})
+}
+
+// clientFilter returns the middleware applying the client address rules of the
+// authenticated identity. It returns nil if no client has such rules.
+func clientFilter(cfg *config.Config) func(http.Handler) http.Handler {
+	clients := make(map[string]config.IPRules)
+	for name, client := range cfg.Clients {
+		if !client.IPRules.Empty() {
+			clients[name] = client.IPRules
+		}
+	}
+	if len(clients) == 0 {
+		return nil
+	}
+	return middleware.IPFilter(func(r *http.Request, addr netip.Addr) string {
+		name := middleware.GetIdentity(r.Context()).Name
+		if reason := clients[name].Check(addr); reason != "" {
+			return "client " + name + ": " + reason
+		}
+		return ""
+	})
+}
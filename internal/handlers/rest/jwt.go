@@ -0,0 +1,32 @@
+package rest
+
+import (
+	"context"
+	"fmt"
+
+	"arr-proxy/internal/config"
+	"arr-proxy/internal/middleware"
+	"arr-proxy/internal/tokens"
+)
+
+// jwtVerifier authenticates tokens of an external identity provider and maps
+// their claims to client identities.
+type jwtVerifier struct {
+	config    *config.Config
+	validator *tokens.Validator
+}
+
+// VerifyToken implements middleware.TokenVerifier.
+func (v *jwtVerifier) VerifyToken(ctx context.Context, token string) (middleware.Identity, error) {
+	claims, err := v.validator.Validate(ctx, token)
+	if err != nil {
+		return middleware.Identity{}, err
+	}
+	jwt := v.config.Auth.JWT
+	subject := tokens.ClaimString(claims, jwt.IdentityClaim)
+	if subject == "" {
+		return middleware.Identity{}, fmt.Errorf("token has no %q claim", jwt.IdentityClaim)
+	}
+	groups := tokens.ClaimStrings(claims, jwt.GroupsClaim)
+	return middleware.Identity{Name: v.config.ClientFor(subject, groups), Method: "jwt"}, nil
+}
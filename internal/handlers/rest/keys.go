@@ -0,0 +1,43 @@
+package rest
+
+import (
+	"arr-proxy/internal/apikeys"
+	"arr-proxy/internal/config"
+	"arr-proxy/internal/middleware"
+)
+
+// keyLookup authenticates API keys against the configured keys and, if present,
+// the key store.
+type keyLookup struct {
+	method string
+	static func() *apikeys.Set // current configured keys
+	store  *apikeys.Store      // nil if no key store is configured
+}
+
+// Lookup implements middleware.KeyLookup.
+func (l *keyLookup) Lookup(key string) (middleware.Identity, bool) {
+	if name, ok := l.static().Lookup(key); ok {
+		return middleware.Identity{Name: name, Method: l.method}, true
+	}
+	if l.store != nil {
+		if rec, ok := l.store.Lookup(key); ok {
+			return middleware.Identity{Name: rec.Owner, Method: l.method, Services: rec.Services}, true
+		}
+	}
+	return middleware.Identity{}, false
+}
+
+// apiKeySet collects the shared proxy API key and the hashed keys of all clients.
+func apiKeySet(cfg *config.Config) *apikeys.Set {
+	keys := apikeys.NewSet()
+	keys.Add(middleware.DefaultIdentity, cfg.Auth.APIKey)
+	for name, client := range cfg.Clients {
+		for _, key := range client.APIKeys {
+			// Invalid entries (lenient mode) are skipped so they never act as plaintext keys
+			if apikeys.IsHash(key) {
+				keys.Add(name, key)
+			}
+		}
+	}
+	return keys
+}
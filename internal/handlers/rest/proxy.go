@@ -11,20 +11,28 @@ import (
 	"time"
 
 	"arr-proxy/internal/config"
+	"arr-proxy/internal/middleware"
 	"arr-proxy/internal/usecases"
 )
 
 // ProxyHandler is the handler for proxying requests.
 type ProxyHandler struct {
-	config       *config.Config
-	proxyUseCase *usecases.ProxyUseCase
+	config          *config.Config
+	secrets         *Secrets
+	proxyUseCase    *usecases.ProxyUseCase
+	candidateReport *usecases.CandidateReport
+	learner         *usecases.Learner
 }
 
 // NewProxyHandler creates a new ProxyHandler.
-func NewProxyHandler(cfg *config.Config, proxyUseCase *usecases.ProxyUseCase) *ProxyHandler {
+// learner may be nil if learning mode is disabled.
+func NewProxyHandler(cfg *config.Config, secrets *Secrets, proxyUseCase *usecases.ProxyUseCase, candidateReport *usecases.CandidateReport, learner *usecases.Learner) *ProxyHandler {
 	return &ProxyHandler{
-		config:       cfg,
-		proxyUseCase: proxyUseCase,
+		config:          cfg,
+		secrets:         secrets,
+		proxyUseCase:    proxyUseCase,
+		candidateReport: candidateReport,
+		learner:         learner,
 	}
 }
 
@@ -46,41 +54,48 @@ func (h *ProxyHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		clientCN = r.TLS.PeerCertificates[0].Subject.CommonName
 	}
 
-	var serviceConfig *config.ServiceConfig
-
-	if strings.HasPrefix(r.URL.Path, "/sonarr") {
-		r.URL.Path = strings.TrimPrefix(r.URL.Path, "/sonarr")
-		if r.URL.Path == "" {
-			r.URL.Path = "/"
-		}
-		serviceConfig = h.config.Sonarr
-	} else if strings.HasPrefix(r.URL.Path, "/radarr") {
-		r.URL.Path = strings.TrimPrefix(r.URL.Path, "/radarr")
-		if r.URL.Path == "" {
-			r.URL.Path = "/"
+	// The path has already been canonicalized by the CanonicalPath middleware
+	serviceName, rest := splitServicePath(r.URL.Path)
+	serviceConfig := h.config.Service(serviceName)
+	if serviceConfig == nil {
+		// Sonarr and Radarr are always routed so a missing configuration is reported as such
+		if serviceName == "sonarr" || serviceName == "radarr" {
+			http.Error(w, "503 Service Not Configured", http.StatusServiceUnavailable)
+			return
 		}
-		serviceConfig = h.config.Radarr
-	} else {
 		http.Error(w, "404 Not Found", http.StatusNotFound)
 		return
 	}
-
-	// Check if the service is configured
-	if serviceConfig == nil {
-		http.Error(w, "503 Service Not Configured", http.StatusServiceUnavailable)
-		return
+	r.URL.Path = rest
+
+	identity := middleware.GetIdentity(r.Context())
+	var decision config.Decision
+	switch {
+	case !identity.AllowsService(serviceName):
+		decision = config.Decision{Reason: "service not allowed for credential"}
+	case !identity.AllowsMethod(r.Method):
+		decision = config.Decision{Reason: "credential is read-only"}
+	default:
+		decision = h.config.EvaluateScoped(identity.Name, identity.RuleSets, serviceConfig, r.Method, r.URL.Path)
 	}
+	h.learner.Observe(identity.Name, serviceName, r.Method, r.URL.Path, r.URL.Query(), !decision.Allowed)
 
-	if !serviceConfig.IsWhitelisted(r.Method, r.URL.Path) {
-		slog.Warn("Request blocked", "method", r.Method, "path", r.URL.Path, "client_cn", clientCN, "reason", "method/endpoint not whitelisted", "status", 403)
+	if !decision.Allowed {
+		slog.Warn("Request blocked", "method", r.Method, "path", r.URL.Path, "client_ip", middleware.ClientIP(r), "client", identity.Name, "client_cn", clientCN, "reason", decision.Reason, "status", 403)
 		http.Error(w, "403 Forbidden", http.StatusForbidden)
 		return
 	}
 
+	// Report-only: evaluate the candidate whitelist but forward the request regardless
+	if serviceConfig.HasCandidateWhitelist() && !serviceConfig.IsCandidateWhitelisted(r.Method, r.URL.Path) {
+		slog.Warn("Request would be blocked by candidate whitelist", "service", serviceName, "method", r.Method, "path", r.URL.Path, "client_ip", middleware.ClientIP(r), "client_cn", clientCN, "reason", "method/endpoint not in candidate whitelist", "mode", "report-only")
+		h.candidateReport.Record(serviceName, r.Method, r.URL.Path)
+	}
+
 	// Enforce body size limit on all requests (protection against DoS)
 	maxBodySize := h.config.Server.MaxBodySize
 	if r.ContentLength > maxBodySize {
-		slog.Warn("Request blocked", "method", r.Method, "path", r.URL.Path, "client_cn", clientCN, "reason", "payload too large", "status", 413)
+		slog.Warn("Request blocked", "method", r.Method, "path", r.URL.Path, "client_ip", middleware.ClientIP(r), "client_cn", clientCN, "reason", "payload too large", "status", 413)
 		http.Error(w, "413 Payload Too Large", http.StatusRequestEntityTooLarge)
 		return
 	}
@@ -89,7 +104,7 @@ func (h *ProxyHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	if r.Method == "POST" || r.Method == "PUT" || r.Method == "PATCH" {
 		bodyBytes, err := io.ReadAll(io.LimitReader(r.Body, maxBodySize+1))
 		if err != nil {
-			slog.Warn("Request blocked", "method", r.Method, "path", r.URL.Path, "client_cn", clientCN, "reason", "failed to read payload", "status", 400)
+			slog.Warn("Request blocked", "method", r.Method, "path", r.URL.Path, "client_ip", middleware.ClientIP(r), "client_cn", clientCN, "reason", "failed to read payload", "status", 400)
 			http.Error(w, "400 Bad Request", http.StatusBadRequest)
 			return
 		}
@@ -97,7 +112,7 @@ func (h *ProxyHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 		// Check if body exceeded max size
 		if int64(len(bodyBytes)) > maxBodySize {
-			slog.Warn("Request blocked", "method", r.Method, "path", r.URL.Path, "client_cn", clientCN, "reason", "payload too large", "status", 413)
+			slog.Warn("Request blocked", "method", r.Method, "path", r.URL.Path, "client_ip", middleware.ClientIP(r), "client_cn", clientCN, "reason", "payload too large", "status", 413)
 			http.Error(w, "413 Payload Too Large", http.StatusRequestEntityTooLarge)
 			return
 		}
@@ -107,7 +122,7 @@ func (h *ProxyHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		if strings.Contains(contentType, "application/json") && len(bodyBytes) > 0 {
 			var payload map[string]interface{}
 			if err := json.Unmarshal(bodyBytes, &payload); err != nil {
-				slog.Warn("Request blocked", "method", r.Method, "path", r.URL.Path, "client_cn", clientCN, "reason", "invalid JSON payload", "status", 400)
+				slog.Warn("Request blocked", "method", r.Method, "path", r.URL.Path, "client_ip", middleware.ClientIP(r), "client_cn", clientCN, "reason", "invalid JSON payload", "status", 400)
 				http.Error(w, "400 Bad Request", http.StatusBadRequest)
 				return
 			}
@@ -118,7 +133,14 @@ func (h *ProxyHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 
 	sw := &statusResponseWriter{ResponseWriter: w, statusCode: http.StatusOK}
-	h.proxyUseCase.ServeHTTP(sw, r, serviceConfig.ParsedURL, serviceConfig.APIKey)
+	h.proxyUseCase.ServeHTTP(sw, r, serviceConfig.ParsedURL, h.secrets.serviceKey(serviceConfig))
 	latency := time.Since(start)
-	slog.Info("Request completed", "method", r.Method, "path", r.URL.Path, "client_cn", clientCN, "status", sw.statusCode, "latency", latency)
+	slog.Info("Request completed", "method", r.Method, "path", r.URL.Path, "client_ip", middleware.ClientIP(r), "client", identity.Name, "client_cn", clientCN, "status", sw.statusCode, "latency", latency)
+}
+
+// splitServicePath splits a request path into the service name (its first segment)
+// and the remaining path to forward.
+func splitServicePath(path string) (string, string) {
+	name, rest, _ := strings.Cut(strings.TrimPrefix(path, "/"), "/")
+	return name, "/" + rest
 }
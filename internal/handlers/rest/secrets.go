@@ -0,0 +1,87 @@
+package rest
+
+import (
+	"sync/atomic"
+
+	"arr-proxy/internal/apikeys"
+	"arr-proxy/internal/config"
+	"arr-proxy/internal/tokens"
+)
+
+// secretValues are the credentials resolved from the configuration's secrets.
+type secretValues struct {
+	apiKeys     *apikeys.Set
+	admins      *apikeys.Set
+	basicUser   string
+	basicPass   string
+	serviceKeys map[string]string // upstream API keys by service name
+}
+
+// Secrets holds the proxy API keys, basic auth credentials, admin key, token key
+// and upstream API keys. Reload replaces them while the server is running, so
+// rotated secret files take effect without a restart.
+type Secrets struct {
+	minter  *tokens.Minter // nil unless tokens are enabled
+	current atomic.Pointer[secretValues]
+}
+
+// NewSecrets returns the secrets of cfg. minter may be nil if tokens are disabled.
+func NewSecrets(cfg *config.Config, minter *tokens.Minter) *Secrets {
+	s := &Secrets{minter: minter}
+	s.current.Store(newSecretValues(cfg))
+	return s
+}
+
+func newSecretValues(cfg *config.Config) *secretValues {
+	admins := apikeys.NewSet()
+	admins.Add("admin", cfg.Auth.AdminKey)
+	v := &secretValues{
+		apiKeys:     apiKeySet(cfg),
+		admins:      admins,
+		basicUser:   cfg.Auth.BasicAuth.User,
+		basicPass:   cfg.Auth.BasicAuth.Password,
+		serviceKeys: make(map[string]string, len(cfg.Services)),
+	}
+	for name, sc := range cfg.Services {
+		v.serviceKeys[name] = sc.APIKey
+	}
+	return v
+}
+
+// Reload replaces the secrets with those of cfg, a configuration loaded again.
+// Other settings of cfg are ignored; changing them requires a restart. On error
+// the previous secrets are kept.
+func (s *Secrets) Reload(cfg *config.Config) error {
+	if s.minter != nil && cfg.TokensEnabled() {
+		if err := s.minter.SetKey([]byte(cfg.Auth.Token.Key)); err != nil {
+			return err
+		}
+	}
+	s.current.Store(newSecretValues(cfg))
+	return nil
+}
+
+// basicAuth returns the basic auth user and password.
+func (s *Secrets) basicAuth() (string, string) {
+	v := s.current.Load()
+	return v.basicUser, v.basicPass
+}
+
+// apiKeys returns the proxy API keys of the shared key and all clients.
+func (s *Secrets) apiKeys() *apikeys.Set {
+	return s.current.Load().apiKeys
+}
+
+// admins returns the admin API key.
+func (s *Secrets) admins() *apikeys.Set {
+	return s.current.Load().admins
+}
+
+// serviceKey returns the upstream API key of sc. A service missing from a
+// reloaded configuration keeps the key loaded at startup.
+func (s *Secrets) serviceKey(sc *config.ServiceConfig) string {
+	if key, ok := s.current.Load().serviceKeys[sc.Name]; ok {
+		return key
+	}
+	return sc.APIKey
+}
@@ -0,0 +1,96 @@
+package rest
+
+import (
+	"crypto/tls"
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+	"time"
+
+	"arr-proxy/internal/ca"
+	"arr-proxy/internal/config"
+	"arr-proxy/internal/revocation"
+	"arr-proxy/internal/tlsreload"
+)
+
+// selfSignedValidity is the validity of generated self-signed certificates.
+const selfSignedValidity = 365 * 24 * time.Hour
+
+// newTLSConfig builds the TLS configuration of the server. Certificates and client
+// CAs are served by the returned reloader, which the caller runs.
+func newTLSConfig(cfg *config.Config, handlers Handlers) (*tls.Config, *tlsreload.Reloader, error) {
+	if cfg.TLS.SelfSigned {
+		if err := writeSelfSigned(cfg); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	// Set TLS minimum version from config
+	var minVersion uint16 = tls.VersionTLS12
+	if cfg.Server.TLSMinVersion == "1.3" {
+		minVersion = tls.VersionTLS13
+	}
+
+	tlsConfig := &tls.Config{
+		MinVersion:       minVersion,
+		CipherSuites:     cfg.Server.TLSCipherSuites,
+		CurvePreferences: cfg.Server.TLSCurves,
+		// Set explicitly, configs cloned after a client CA reload keep them
+		NextProtos: cfg.Server.TLSALPN,
+	}
+
+	// Serve the certificates and client CAs from files that are reloaded when they
+	// are renewed
+	var pairs []tlsreload.KeyPair
+	for _, pair := range cfg.Certificates() {
+		pairs = append(pairs, tlsreload.KeyPair{Cert: pair.Cert, Key: pair.Key})
+	}
+	reloader, err := tlsreload.New(pairs, cfg.CACert)
+	if err != nil {
+		return nil, nil, err
+	}
+	if cfg.CACert != "" {
+		if handlers.Enroll != nil && !slices.ContainsFunc(reloader.ClientCAs(), handlers.Enroll.authority.Certificate().Equal) {
+			slog.Warn("APP_CA_CERT does not contain the enrollment CA, enrolled certificates will be rejected", "ca_dir", cfg.Enrollment.CADir)
+		}
+
+		// Reject revoked client certificates after chain verification. Unlike
+		// VerifyPeerCertificate, VerifyConnection also runs for resumed sessions
+		if cfg.Revocation.Enabled() {
+			checker, err := revocation.NewChecker(cfg.Revocation.CRLFiles, cfg.Revocation.Denylist, reloader.ClientCAs())
+			if err != nil {
+				return nil, nil, fmt.Errorf("failed to load certificate revocation lists: %w", err)
+			}
+			reloader.OnClientCAs(checker.SetIssuers)
+			tlsConfig.VerifyConnection = checker.VerifyConnection
+		}
+	}
+
+	// Require client certs only if mTLS is the only auth method and devices need no
+	// certificate to enroll
+	tlsConfig.ClientAuth = clientAuthType(cfg)
+
+	reloader.Configure(tlsConfig)
+	return tlsConfig, reloader, nil
+}
+
+// writeSelfSigned generates the self-signed certificate on first start.
+func writeSelfSigned(cfg *config.Config) error {
+	hosts := cfg.TLS.SelfSignedHosts
+	if len(hosts) == 0 {
+		hosts = []string{"localhost", "127.0.0.1", "::1"}
+		if hostname, err := os.Hostname(); err == nil && hostname != "localhost" {
+			hosts = append([]string{hostname}, hosts...)
+		}
+	}
+	cert, err := ca.WriteSelfSigned(cfg.TLSCert, cfg.TLSKey, hosts, selfSignedValidity)
+	if err != nil {
+		return fmt.Errorf("failed to generate self-signed certificate: %w", err)
+	}
+	if cert != nil {
+		slog.Warn("Generated self-signed TLS certificate, clients must trust or pin it", "path", cfg.TLSCert, "hosts", hosts,
+			"fingerprint", config.Fingerprint(cert), "not_after", cert.NotAfter)
+	}
+	return nil
+}
@@ -0,0 +1,133 @@
+package rest
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"time"
+
+	"arr-proxy/internal/config"
+	"arr-proxy/internal/middleware"
+	"arr-proxy/internal/tokens"
+)
+
+// tokenMethod is the authentication method of identities established by a proxy token.
+const tokenMethod = "token"
+
+// TokenHandler mints short-lived tokens carrying a subset of the caller's permissions.
+type TokenHandler struct {
+	config *config.Config
+	minter *tokens.Minter
+}
+
+// NewTokenHandler creates a new TokenHandler.
+func NewTokenHandler(cfg *config.Config, minter *tokens.Minter) *TokenHandler {
+	return &TokenHandler{
+		config: cfg,
+		minter: minter,
+	}
+}
+
+type tokenRequest struct {
+	TTL      string   `json:"ttl"`       // duration, default and maximum is the configured max TTL
+	Services []string `json:"services"`  // empty means all services of the caller
+	RuleSets []string `json:"rule_sets"` // empty means all rule sets of the caller
+	ReadOnly bool     `json:"read_only"`
+}
+
+type tokenResponse struct {
+	Token     string    `json:"token"`
+	TokenType string    `json:"token_type"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// ServeHTTP handles POST /token.
+func (h *TokenHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	identity := middleware.GetIdentity(r.Context())
+	if identity.Method == tokenMethod {
+		// A token could otherwise be renewed indefinitely
+		http.Error(w, "tokens cannot be used to mint tokens", http.StatusForbidden)
+		return
+	}
+
+	var req tokenRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	ttl := h.config.Auth.Token.MaxTTL
+	if req.TTL != "" {
+		d, err := time.ParseDuration(req.TTL)
+		if err != nil || d <= 0 {
+			http.Error(w, "invalid ttl duration", http.StatusBadRequest)
+			return
+		}
+		if d > ttl {
+			http.Error(w, fmt.Sprintf("ttl exceeds the maximum of %s", ttl), http.StatusBadRequest)
+			return
+		}
+		ttl = d
+	}
+
+	claims := tokens.Claims{
+		Subject:  identity.Name,
+		Services: identity.Services,
+		RuleSets: identity.RuleSets,
+		ReadOnly: identity.ReadOnly || req.ReadOnly,
+	}
+	if len(req.Services) > 0 {
+		client := h.config.Clients[identity.Name]
+		for _, name := range req.Services {
+			if h.config.Service(name) == nil {
+				http.Error(w, "unknown service "+name, http.StatusBadRequest)
+				return
+			}
+			if !identity.AllowsService(name) || (client != nil && !client.AllowsService(name)) {
+				http.Error(w, "service not allowed for credential: "+name, http.StatusForbidden)
+				return
+			}
+		}
+		claims.Services = req.Services
+	}
+	if len(req.RuleSets) > 0 {
+		allowed := h.config.RuleSets(identity.Name)
+		for _, name := range req.RuleSets {
+			if !slices.Contains(allowed, name) || (identity.RuleSets != nil && !slices.Contains(identity.RuleSets, name)) {
+				http.Error(w, "rule set not allowed for credential: "+name, http.StatusForbidden)
+				return
+			}
+		}
+		claims.RuleSets = req.RuleSets
+	}
+
+	token, minted, err := h.minter.Mint(claims, ttl)
+	if err != nil {
+		slog.Error("Failed to mint token", "error", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	expiresAt := time.Unix(minted.ExpiresAt, 0).UTC()
+	slog.Info("Token minted", "id", minted.ID, "client", identity.Name, "services", minted.Services, "rule_sets", minted.RuleSets, "read_only", minted.ReadOnly, "expires_at", expiresAt)
+	writeJSON(w, http.StatusCreated, tokenResponse{Token: token, TokenType: "Bearer", ExpiresAt: expiresAt})
+}
+
+// tokenVerifier authenticates proxy tokens.
+type tokenVerifier struct {
+	minter *tokens.Minter
+}
+
+// VerifyToken implements middleware.TokenVerifier.
+func (v *tokenVerifier) VerifyToken(_ context.Context, token string) (middleware.Identity, error) {
+	claims, err := v.minter.Parse(token)
+	if err != nil {
+		return middleware.Identity{}, err
+	}
+	return middleware.Identity{
+		Name:     claims.Subject,
+		Method:   tokenMethod,
+		Services: claims.Services,
+		RuleSets: claims.RuleSets,
+		ReadOnly: claims.ReadOnly,
+	}, nil
+}
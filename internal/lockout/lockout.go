@@ -0,0 +1,184 @@
+// Package lockout bans clients after repeated authentication failures, by client
+// address and by user name. Every further ban of the same client lasts twice as
+// long as the previous one.
+package lockout
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Options configures when and for how long clients are banned.
+type Options struct {
+	Threshold  int           // failures within Window that start a ban
+	Window     time.Duration // failures are counted in windows of this length
+	BanTime    time.Duration // length of the first ban
+	MaxBanTime time.Duration // longest ban; also how long bans are remembered for escalation
+}
+
+// Ban describes a banned client.
+type Ban struct {
+	Key      string // IPKey or UserKey
+	Failures int    // failures that started the ban
+	Count    int    // bans of the key, including this one
+	Until    time.Time
+}
+
+// IPKey returns the key of a client address.
+func IPKey(addr string) string {
+	return "ip:" + addr
+}
+
+// UserKey returns the key of a user name.
+func UserKey(name string) string {
+	return "user:" + name
+}
+
+// entry tracks the failures and bans of a key.
+type entry struct {
+	failures     int
+	firstFailure time.Time // start of the current window
+	lastFailure  time.Time
+	bans         int
+	banFailures  int // failures that started the current ban
+	until        time.Time
+}
+
+// Guard counts authentication failures and bans keys that reach the threshold.
+// It is safe for concurrent use.
+type Guard struct {
+	opts Options
+	now  func() time.Time
+
+	mu        sync.Mutex
+	entries   map[string]*entry
+	lastPrune time.Time
+}
+
+// New returns a guard with the given options.
+func New(opts Options) *Guard {
+	return &Guard{opts: opts, now: time.Now, entries: make(map[string]*entry)}
+}
+
+// Banned returns the ban of any of the keys that lasts longest.
+func (g *Guard) Banned(keys ...string) (Ban, bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	now := g.now()
+	var ban Ban
+	for _, key := range keys {
+		if e := g.entries[key]; e != nil && now.Before(e.until) && e.until.After(ban.Until) {
+			ban = Ban{Key: key, Failures: e.banFailures, Count: e.bans, Until: e.until}
+		}
+	}
+	return ban, !ban.Until.IsZero()
+}
+
+// Fail records an authentication failure of the keys and returns the bans it
+// started.
+func (g *Guard) Fail(keys ...string) []Ban {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	now := g.now()
+	g.prune(now)
+
+	var bans []Ban
+	for _, key := range keys {
+		e := g.entries[key]
+		if e == nil {
+			e = &entry{}
+			g.entries[key] = e
+		}
+		if e.failures == 0 || now.Sub(e.firstFailure) > g.opts.Window {
+			e.failures, e.firstFailure = 0, now
+		}
+		e.failures++
+		e.lastFailure = now
+		if e.failures < g.opts.Threshold {
+			continue
+		}
+		e.bans++
+		e.banFailures, e.failures = e.failures, 0
+		e.until = now.Add(g.banTime(e.bans))
+		bans = append(bans, Ban{Key: key, Failures: e.banFailures, Count: e.bans, Until: e.until})
+	}
+	return bans
+}
+
+// Succeed resets the failure counts of the keys after a successful authentication.
+// Earlier bans still count towards the length of the next one.
+func (g *Guard) Succeed(keys ...string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for _, key := range keys {
+		if e := g.entries[key]; e != nil {
+			e.failures = 0
+		}
+	}
+}
+
+// List returns the active bans sorted by key.
+func (g *Guard) List() []Ban {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	now := g.now()
+	bans := []Ban{}
+	for key, e := range g.entries {
+		if now.Before(e.until) {
+			bans = append(bans, Ban{Key: key, Failures: e.banFailures, Count: e.bans, Until: e.until})
+		}
+	}
+	sort.Slice(bans, func(i, j int) bool { return bans[i].Key < bans[j].Key })
+	return bans
+}
+
+// Clear lifts the ban of key and forgets its failures. It returns false if the key
+// is not banned.
+func (g *Guard) Clear(key string) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	e := g.entries[key]
+	delete(g.entries, key)
+	return e != nil && g.now().Before(e.until)
+}
+
+// ClearAll lifts all bans and forgets all failures. It returns the number of bans
+// lifted.
+func (g *Guard) ClearAll() int {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	now := g.now()
+	n := 0
+	for _, e := range g.entries {
+		if now.Before(e.until) {
+			n++
+		}
+	}
+	g.entries = make(map[string]*entry)
+	return n
+}
+
+// banTime returns the length of the nth ban: BanTime doubled for every earlier
+// ban, up to MaxBanTime.
+func (g *Guard) banTime(n int) time.Duration {
+	d := g.opts.BanTime
+	for i := 1; i < n && d < g.opts.MaxBanTime; i++ {
+		d *= 2
+	}
+	return min(d, g.opts.MaxBanTime)
+}
+
+// prune forgets keys that are not banned and have not failed for MaxBanTime, at
+// most once per Window.
+func (g *Guard) prune(now time.Time) {
+	if now.Sub(g.lastPrune) < g.opts.Window {
+		return
+	}
+	g.lastPrune = now
+	for key, e := range g.entries {
+		if !now.Before(e.until) && now.Sub(e.lastFailure) > g.opts.MaxBanTime {
+			delete(g.entries, key)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package lockout
+
+import (
+	"testing"
+	"time"
+)
+
+func newTestGuard() (*Guard, *time.Time) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	g := New(Options{Threshold: 3, Window: 10 * time.Minute, BanTime: time.Minute, MaxBanTime: 5 * time.Minute})
+	g.now = func() time.Time { return now }
+	return g, &now
+}
+
+func TestGuardBansAtThreshold(t *testing.T) {
+	g, now := newTestGuard()
+	ip := IPKey("203.0.113.7")
+
+	for i := 0; i < 2; i++ {
+		if bans := g.Fail(ip); len(bans) != 0 {
+			t.Fatalf("failure %d started a ban: %v", i+1, bans)
+		}
+	}
+	if _, ok := g.Banned(ip); ok {
+		t.Fatal("banned below the threshold")
+	}
+	bans := g.Fail(ip)
+	if len(bans) != 1 || bans[0].Key != ip || bans[0].Failures != 3 || bans[0].Count != 1 || !bans[0].Until.Equal(now.Add(time.Minute)) {
+		t.Fatalf("third failure: bans %+v", bans)
+	}
+	if ban, ok := g.Banned(UserKey("alice"), ip); !ok || ban.Key != ip {
+		t.Errorf("Banned() = %+v, %v", ban, ok)
+	}
+
+	*now = now.Add(time.Minute)
+	if _, ok := g.Banned(ip); ok {
+		t.Error("still banned after the ban time")
+	}
+}
+
+func TestGuardDoublesBanTime(t *testing.T) {
+	g, now := newTestGuard()
+	key := UserKey("alice")
+
+	for _, want := range []time.Duration{time.Minute, 2 * time.Minute, 4 * time.Minute, 5 * time.Minute, 5 * time.Minute} {
+		var bans []Ban
+		for i := 0; i < 3; i++ {
+			bans = g.Fail(key)
+		}
+		if len(bans) != 1 {
+			t.Fatalf("no ban after threshold")
+		}
+		if got := bans[0].Until.Sub(*now); got != want {
+			t.Errorf("ban %d lasts %s, want %s", bans[0].Count, got, want)
+		}
+		*now = bans[0].Until
+	}
+}
+
+func TestGuardWindowAndSuccess(t *testing.T) {
+	g, now := newTestGuard()
+	ip := IPKey("203.0.113.7")
+
+	g.Fail(ip)
+	g.Fail(ip)
+	*now = now.Add(11 * time.Minute)
+	if bans := g.Fail(ip); len(bans) != 0 {
+		t.Error("failures of an earlier window counted")
+	}
+
+	g.Fail(ip)
+	g.Succeed(ip)
+	g.Fail(ip)
+	if bans := g.Fail(ip); len(bans) != 0 {
+		t.Error("failures before a success counted")
+	}
+}
+
+func TestGuardListAndClear(t *testing.T) {
+	g, _ := newTestGuard()
+	ip, user := IPKey("203.0.113.7"), UserKey("alice")
+	for i := 0; i < 3; i++ {
+		g.Fail(user, ip)
+	}
+	g.Fail(IPKey("198.51.100.1"))
+
+	bans := g.List()
+	if len(bans) != 2 || bans[0].Key != ip || bans[1].Key != user || bans[0].Failures != 3 {
+		t.Fatalf("List() = %+v", bans)
+	}
+
+	if !g.Clear(ip) {
+		t.Error("Clear() of a banned key returned false")
+	}
+	if g.Clear(ip) || g.Clear(IPKey("198.51.100.1")) {
+		t.Error("Clear() of a key that is not banned returned true")
+	}
+	if _, ok := g.Banned(ip); ok {
+		t.Error("banned after Clear()")
+	}
+	if n := g.ClearAll(); n != 1 {
+		t.Errorf("ClearAll() = %d, want 1", n)
+	}
+	if bans := g.List(); len(bans) != 0 {
+		t.Errorf("List() after ClearAll() = %+v", bans)
+	}
+}
@@ -1,29 +1,39 @@
 package middleware
 
 import (
+	"context"
 	"crypto/subtle"
 	"log/slog"
 	"net/http"
+	"strings"
 )
 
-// BasicAuth middleware enforces HTTP Basic Authentication
-func BasicAuth(user, pass string) func(next http.Handler) http.Handler {
+// BasicAuth middleware enforces HTTP Basic Authentication. The expected user and
+// password are looked up on every request so they can be rotated.
+func BasicAuth(credentials func() (user, pass string)) func(next http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			user, pass := credentials()
 			u, p, ok := r.BasicAuth()
 			if !ok || subtle.ConstantTimeCompare([]byte(u), []byte(user)) != 1 || subtle.ConstantTimeCompare([]byte(p), []byte(pass)) != 1 {
-				slog.Warn("Authentication failed", "method", "basic", "path", r.URL.Path, "remote_addr", r.RemoteAddr)
+				slog.Warn("Authentication failed", "method", "basic", "user", u, "path", r.URL.Path, "client_ip", ClientIP(r))
 				w.Header().Set("WWW-Authenticate", `Basic realm="Restricted"`)
 				http.Error(w, "Unauthorized", http.StatusUnauthorized)
 				return
 			}
-			next.ServeHTTP(w, r)
+			next.ServeHTTP(w, r.WithContext(WithIdentity(r.Context(), Identity{Name: u, Method: "basic"})))
 		})
 	}
 }
 
-// APIKeyAuth middleware enforces API Key Authentication via header or query param
-func APIKeyAuth(keyValue string) func(next http.Handler) http.Handler {
+// KeyLookup resolves a presented API key to the identity owning it.
+type KeyLookup interface {
+	Lookup(key string) (Identity, bool)
+}
+
+// APIKeyAuth middleware enforces API Key Authentication via header or query param.
+// The request runs as the identity owning the key.
+func APIKeyAuth(keys KeyLookup) func(next http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			// Check Header first
@@ -35,23 +45,77 @@ func APIKeyAuth(keyValue string) func(next http.Handler) http.Handler {
 				key = r.URL.Query().Get("apikey")
 				if key != "" {
 					fromQueryParam = true
-					slog.Warn("API key provided via query parameter (less secure)", "path", r.URL.Path, "remote_addr", r.RemoteAddr)
+					slog.Warn("API key provided via query parameter (less secure)", "path", r.URL.Path, "client_ip", ClientIP(r))
 				}
 			}
 
-			// Reject empty keys to prevent bypass when keyValue is also empty
+			// Reject empty keys to prevent bypass when a configured key is also empty
 			if key == "" {
-				slog.Warn("Authentication failed", "method", "apikey", "reason", "no key provided", "path", r.URL.Path, "remote_addr", r.RemoteAddr)
+				slog.Warn("Authentication failed", "method", "apikey", "reason", "no key provided", "path", r.URL.Path, "client_ip", ClientIP(r))
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+
+			identity, ok := keys.Lookup(key)
+			if !ok {
+				slog.Warn("Authentication failed", "method", "apikey", "reason", "invalid key", "path", r.URL.Path, "client_ip", ClientIP(r), "via_query_param", fromQueryParam)
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(WithIdentity(r.Context(), identity)))
+		})
+	}
+}
+
+// AdminAuth middleware protects the admin API. The key is only accepted in the
+// X-Admin-Key header so it never ends up in access logs.
+func AdminAuth(keys KeyLookup) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			identity, ok := keys.Lookup(r.Header.Get("X-Admin-Key"))
+			if !ok {
+				slog.Warn("Authentication failed", "method", "admin", "path", r.URL.Path, "client_ip", ClientIP(r))
 				http.Error(w, "Unauthorized", http.StatusUnauthorized)
 				return
 			}
+			next.ServeHTTP(w, r.WithContext(WithIdentity(r.Context(), identity)))
+		})
+	}
+}
+
+// TokenVerifier validates a bearer token and returns the identity it was issued to.
+type TokenVerifier interface {
+	VerifyToken(ctx context.Context, token string) (Identity, error)
+}
+
+// BearerAuth middleware authenticates requests carrying an "Authorization: Bearer"
+// token. Requests without a bearer token are passed to fallback, or rejected if
+// fallback is nil.
+func BearerAuth(tokens TokenVerifier, fallback func(next http.Handler) http.Handler) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		other := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			slog.Warn("Authentication failed", "method", "token", "reason", "no token provided", "path", r.URL.Path, "client_ip", ClientIP(r))
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		})
+		if fallback != nil {
+			other = fallback(next).ServeHTTP
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			scheme, token, _ := strings.Cut(r.Header.Get("Authorization"), " ")
+			if !strings.EqualFold(scheme, "Bearer") {
+				other.ServeHTTP(w, r)
+				return
+			}
 
-			if subtle.ConstantTimeCompare([]byte(key), []byte(keyValue)) != 1 {
-				slog.Warn("Authentication failed", "method", "apikey", "reason", "invalid key", "path", r.URL.Path, "remote_addr", r.RemoteAddr, "via_query_param", fromQueryParam)
+			identity, err := tokens.VerifyToken(r.Context(), strings.TrimSpace(token))
+			if err != nil {
+				slog.Warn("Authentication failed", "method", "token", "reason", err.Error(), "path", r.URL.Path, "client_ip", ClientIP(r))
+				w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
 				http.Error(w, "Unauthorized", http.StatusUnauthorized)
 				return
 			}
-			next.ServeHTTP(w, r)
+			next.ServeHTTP(w, r.WithContext(WithIdentity(r.Context(), identity)))
 		})
 	}
 }
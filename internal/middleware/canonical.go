@@ -0,0 +1,75 @@
+package middleware
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// ErrAmbiguousPath is returned when a path contains encodings that upstream
+// services could interpret differently than the proxy (e.g. %2F).
+var ErrAmbiguousPath = errors.New("ambiguous path encoding")
+
+// ambiguousEscapes are percent-encoded sequences that change the path structure
+// once decoded, so they are rejected instead of being decoded.
+var ambiguousEscapes = []string{"%2f", "%5c", "%00"}
+
+// CanonicalizePath turns an escaped request path into the canonical form used for
+// both whitelist matching and forwarding upstream:
+//   - encoded slashes, backslashes and NUL bytes are rejected
+//   - the path is percent-decoded exactly once (double encodings are rejected)
+//   - duplicate slashes are collapsed and "." / ".." segments are resolved
+//   - trailing slashes are removed
+//   - optionally, the path is lowercased
+func CanonicalizePath(escapedPath string, lowercase bool) (string, error) {
+	lower := strings.ToLower(escapedPath)
+	for _, seq := range ambiguousEscapes {
+		if strings.Contains(lower, seq) {
+			return "", ErrAmbiguousPath
+		}
+	}
+
+	decoded, err := url.PathUnescape(escapedPath)
+	if err != nil {
+		return "", ErrAmbiguousPath
+	}
+
+	if strings.Contains(decoded, "\\") {
+		return "", ErrAmbiguousPath
+	}
+
+	// A second round of decoding must not change anything, otherwise the path was
+	// double-encoded (e.g. %252F) and upstream may decode it again.
+	if again, err := url.PathUnescape(decoded); err == nil && again != decoded {
+		return "", ErrAmbiguousPath
+	}
+
+	// path.Clean collapses "//", resolves "." and "..", and drops trailing slashes
+	canonical := path.Clean("/" + decoded)
+	if lowercase {
+		canonical = strings.ToLower(canonical)
+	}
+	return canonical, nil
+}
+
+// CanonicalPath middleware rewrites the request path into its canonical form before
+// routing, so that what is authorized is exactly what is forwarded upstream.
+// Requests with ambiguous encodings are rejected with 400.
+func CanonicalPath(lowercase bool) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			canonical, err := CanonicalizePath(r.URL.EscapedPath(), lowercase)
+			if err != nil {
+				slog.Warn("Request blocked", "method", r.Method, "path", r.URL.EscapedPath(), "client_ip", ClientIP(r), "reason", err.Error(), "status", 400)
+				http.Error(w, "400 Bad Request", http.StatusBadRequest)
+				return
+			}
+			r.URL.Path = canonical
+			r.URL.RawPath = ""
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,57 @@
+package middleware
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestCanonicalizePath(t *testing.T) {
+	tests := []struct {
+		name      string
+		path      string
+		lowercase bool
+		want      string
+		wantErr   bool
+	}{
+		{name: "already canonical", path: "/sonarr/api/v3/series", want: "/sonarr/api/v3/series"},
+		{name: "root", path: "/", want: "/"},
+		{name: "empty", path: "", want: "/"},
+		{name: "duplicate slashes", path: "/sonarr//api///v3/series", want: "/sonarr/api/v3/series"},
+		{name: "trailing slash", path: "/sonarr/api/v3/series/", want: "/sonarr/api/v3/series"},
+		{name: "dot segment", path: "/sonarr/api/./v3/series", want: "/sonarr/api/v3/series"},
+		{name: "dot-dot segment", path: "/sonarr/api/v3/queue/../series", want: "/sonarr/api/v3/series"},
+		{name: "dot-dot above root", path: "/../../sonarr/api", want: "/sonarr/api"},
+		{name: "encoded dot-dot", path: "/sonarr/api/v3/queue/%2e%2e/series", want: "/sonarr/api/v3/series"},
+		{name: "encoded regular character", path: "/sonarr/api/v3/ser%69es", want: "/sonarr/api/v3/series"},
+		{name: "case preserved by default", path: "/sonarr/API/v3/Series", want: "/sonarr/API/v3/Series"},
+		{name: "lowercase enabled", path: "/Sonarr/API/v3/Series", lowercase: true, want: "/sonarr/api/v3/series"},
+
+		// Ambiguous encodings
+		{name: "encoded slash", path: "/sonarr/api/v3%2Fseries", wantErr: true},
+		{name: "encoded slash lowercase", path: "/sonarr/api/v3%2fseries", wantErr: true},
+		{name: "encoded backslash", path: "/sonarr/api/v3%5Cseries", wantErr: true},
+		{name: "literal backslash", path: "/sonarr/api/v3\\series", wantErr: true},
+		{name: "encoded NUL", path: "/sonarr/api/v3/series%00", wantErr: true},
+		{name: "double encoded slash", path: "/sonarr/api/v3%252Fseries", wantErr: true},
+		{name: "double encoded dot", path: "/sonarr/api/v3/%252e%252e/series", wantErr: true},
+		{name: "invalid escape", path: "/sonarr/api/v3/%zz", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := CanonicalizePath(tt.path, tt.lowercase)
+			if tt.wantErr {
+				if !errors.Is(err, ErrAmbiguousPath) {
+					t.Errorf("CanonicalizePath(%q) error = %v, want ErrAmbiguousPath", tt.path, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("CanonicalizePath(%q) unexpected error: %v", tt.path, err)
+			}
+			if got != tt.want {
+				t.Errorf("CanonicalizePath(%q) = %q, want %q", tt.path, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,80 @@
+package middleware
+
+import (
+	"log/slog"
+	"net/http"
+	"strings"
+)
+
+// AuthMethod is one of several ways a request may authenticate.
+type AuthMethod struct {
+	Name string
+	// Present returns true if the request carries a credential for this method.
+	Present func(r *http.Request) bool
+	// Challenge is the WWW-Authenticate value sent when no credential is presented, if any.
+	Challenge    string
+	Authenticate func(next http.Handler) http.Handler
+}
+
+// FirstOf middleware authenticates a request with the first method whose credential
+// the request carries, so clients of different kinds can share one listener. A
+// presented credential that fails is rejected without trying the remaining methods.
+func FirstOf(methods ...AuthMethod) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		handlers := make([]http.Handler, len(methods))
+		for i, m := range methods {
+			handlers[i] = m.Authenticate(next)
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			for i, m := range methods {
+				if m.Present(r) {
+					handlers[i].ServeHTTP(w, r)
+					return
+				}
+			}
+			for _, m := range methods {
+				if m.Challenge != "" {
+					w.Header().Add("WWW-Authenticate", m.Challenge)
+				}
+			}
+			slog.Warn("Authentication failed", "reason", "no credential provided", "path", r.URL.Path, "client_ip", ClientIP(r))
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		})
+	}
+}
+
+// HasClientCert returns true if the client presented a verified certificate.
+func HasClientCert(r *http.Request) bool {
+	return r.TLS != nil && len(r.TLS.PeerCertificates) > 0
+}
+
+// HasBearerToken returns true if the request carries an "Authorization: Bearer" token.
+func HasBearerToken(r *http.Request) bool {
+	scheme, _, _ := strings.Cut(r.Header.Get("Authorization"), " ")
+	return strings.EqualFold(scheme, "Bearer")
+}
+
+// HasAPIKey returns true if the request carries an API key in the X-Api-Key header
+// or the apikey query parameter.
+func HasAPIKey(r *http.Request) bool {
+	return r.Header.Get("X-Api-Key") != "" || r.URL.Query().Get("apikey") != ""
+}
+
+// HasBasicAuth returns true if the request carries basic auth credentials.
+func HasBasicAuth(r *http.Request) bool {
+	_, _, ok := r.BasicAuth()
+	return ok
+}
+
+// HasHeader returns a Present function matching requests that set the header.
+func HasHeader(name string) func(r *http.Request) bool {
+	return func(r *http.Request) bool {
+		return r.Header.Get(name) != ""
+	}
+}
+
+// Always is a Present function for methods that decide on every request, such as
+// forward auth. Such a method should come last.
+func Always(*http.Request) bool {
+	return true
+}
@@ -0,0 +1,59 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestFirstOf(t *testing.T) {
+	method := func(name string, present func(*http.Request) bool, challenge string) AuthMethod {
+		return AuthMethod{
+			Name:      name,
+			Present:   present,
+			Challenge: challenge,
+			Authenticate: func(next http.Handler) http.Handler {
+				return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					w.Header().Set("X-Method", name)
+					next.ServeHTTP(w, r)
+				})
+			},
+		}
+	}
+	handler := FirstOf(
+		method("bearer", HasBearerToken, "Bearer"),
+		method("apikey", HasAPIKey, ""),
+		method("basic", HasBasicAuth, `Basic realm="Restricted"`),
+	)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	tests := []struct {
+		name       string
+		setup      func(r *http.Request)
+		wantMethod string
+		wantStatus int
+	}{
+		{name: "bearer first", setup: func(r *http.Request) { r.Header.Set("Authorization", "Bearer t"); r.Header.Set("X-Api-Key", "k") }, wantMethod: "bearer", wantStatus: http.StatusOK},
+		{name: "api key header", setup: func(r *http.Request) { r.Header.Set("X-Api-Key", "k") }, wantMethod: "apikey", wantStatus: http.StatusOK},
+		{name: "api key query", setup: func(r *http.Request) { r.URL.RawQuery = "apikey=k" }, wantMethod: "apikey", wantStatus: http.StatusOK},
+		{name: "basic", setup: func(r *http.Request) { r.SetBasicAuth("u", "p") }, wantMethod: "basic", wantStatus: http.StatusOK},
+		{name: "no credential", setup: func(r *http.Request) {}, wantStatus: http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/info", nil)
+			tt.setup(r)
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			if w.Code != tt.wantStatus || w.Header().Get("X-Method") != tt.wantMethod {
+				t.Errorf("status = %d, method = %q, want %d, %q", w.Code, w.Header().Get("X-Method"), tt.wantStatus, tt.wantMethod)
+			}
+		})
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/info", nil)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	if got := w.Header().Values("WWW-Authenticate"); len(got) != 2 {
+		t.Errorf("WWW-Authenticate = %q, want the bearer and basic challenges", got)
+	}
+}
@@ -0,0 +1,190 @@
+package middleware
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxForwardAuthCache bounds the number of cached forward-auth decisions.
+const maxForwardAuthCache = 10000
+
+// forwardAuthPassHeaders are the auth service response headers returned to the
+// client when access is denied, e.g. to redirect browsers to a login page.
+var forwardAuthPassHeaders = []string{"Location", "WWW-Authenticate", "Set-Cookie"}
+
+// ForwardAuthOptions configures delegation of authentication to an external service.
+type ForwardAuthOptions struct {
+	URL            string        // auth endpoint called for every request
+	Headers        []string      // request headers passed to the auth endpoint
+	IdentityHeader string        // response header naming the authenticated user
+	GroupsHeader   string        // response header listing the user's groups, comma-separated
+	CacheTTL       time.Duration // how long decisions are cached, zero disables caching
+	Client         *http.Client
+	// Identify maps the user and groups reported by the auth service to a client identity.
+	Identify func(user string, groups []string) string
+}
+
+// forwardDecision is the outcome of an auth service call.
+type forwardDecision struct {
+	status   int         // auth service status, 2xx allows the request
+	header   http.Header // headers passed to the client on denial
+	identity Identity
+	expires  time.Time
+}
+
+// forwardAuth calls the auth service and caches its decisions.
+type forwardAuth struct {
+	opts  ForwardAuthOptions
+	now   func() time.Time
+	mu    sync.Mutex
+	cache map[string]*forwardDecision
+}
+
+// ForwardAuth middleware delegates authentication to an external service such as
+// Authelia or Authentik. The service receives the original method and URI in
+// X-Forwarded-* headers together with the configured credential headers; a 2xx
+// response allows the request as the user named in the identity header.
+func ForwardAuth(opts ForwardAuthOptions) func(next http.Handler) http.Handler {
+	fa := &forwardAuth{opts: opts, now: time.Now, cache: make(map[string]*forwardDecision)}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			d, err := fa.decide(r)
+			if err != nil {
+				slog.Error("Forward auth request failed", "url", opts.URL, "error", err, "path", r.URL.Path, "client_ip", ClientIP(r))
+				http.Error(w, "Authentication service unavailable", http.StatusServiceUnavailable)
+				return
+			}
+			if d.status < 200 || d.status > 299 {
+				slog.Warn("Authentication failed", "method", "forward", "status", d.status, "path", r.URL.Path, "client_ip", ClientIP(r))
+				for key, values := range d.header {
+					w.Header()[key] = values
+				}
+				status := d.status
+				if status != http.StatusForbidden && (status < 300 || status > 399) {
+					status = http.StatusUnauthorized
+				}
+				http.Error(w, http.StatusText(status), status)
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(WithIdentity(r.Context(), d.identity)))
+		})
+	}
+}
+
+// decide returns the cached or freshly requested decision for r. Auth service
+// failures are returned as errors and never cached.
+func (fa *forwardAuth) decide(r *http.Request) (*forwardDecision, error) {
+	key := fa.cacheKey(r)
+	now := fa.now()
+	if fa.opts.CacheTTL > 0 {
+		fa.mu.Lock()
+		d, ok := fa.cache[key]
+		fa.mu.Unlock()
+		if ok && now.Before(d.expires) {
+			return d, nil
+		}
+	}
+
+	d, err := fa.request(r)
+	if err != nil {
+		return nil, err
+	}
+	// Only allow and deny decisions are cached, not redirects to a login page
+	if fa.opts.CacheTTL > 0 && (d.status >= 200 && d.status <= 299 || d.status == http.StatusUnauthorized || d.status == http.StatusForbidden) {
+		d.expires = now.Add(fa.opts.CacheTTL)
+		fa.mu.Lock()
+		if len(fa.cache) >= maxForwardAuthCache {
+			for k, cached := range fa.cache {
+				if !now.Before(cached.expires) {
+					delete(fa.cache, k)
+				}
+			}
+			if len(fa.cache) >= maxForwardAuthCache {
+				fa.cache = make(map[string]*forwardDecision)
+			}
+		}
+		fa.cache[key] = d
+		fa.mu.Unlock()
+	}
+	return d, nil
+}
+
+// cacheKey identifies requests the auth service decides alike: same method, scheme,
+// URI, client address and credentials, as policies may depend on the network.
+// Credentials are hashed so they are not kept in memory.
+func (fa *forwardAuth) cacheKey(r *http.Request) string {
+	h := sha256.New()
+	h.Write([]byte(r.Method + "\x00" + forwardedProto(r) + "\x00" + r.Host + "\x00" + r.URL.RequestURI() + "\x00" + ClientAddr(r).String()))
+	for _, name := range fa.opts.Headers {
+		for _, value := range r.Header.Values(name) {
+			h.Write([]byte("\x00" + name + ":" + value))
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// forwardedProto returns the scheme the client used to reach the proxy.
+func forwardedProto(r *http.Request) string {
+	if r.TLS != nil {
+		return "https"
+	}
+	return "http"
+}
+
+func (fa *forwardAuth) request(r *http.Request) (*forwardDecision, error) {
+	req, err := http.NewRequestWithContext(r.Context(), http.MethodGet, fa.opts.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range fa.opts.Headers {
+		for _, value := range r.Header.Values(name) {
+			req.Header.Add(name, value)
+		}
+	}
+	req.Header.Set("X-Forwarded-Method", r.Method)
+	req.Header.Set("X-Forwarded-Proto", forwardedProto(r))
+	req.Header.Set("X-Forwarded-Host", r.Host)
+	req.Header.Set("X-Forwarded-Uri", r.URL.RequestURI())
+	if addr := ClientAddr(r); addr.IsValid() {
+		req.Header.Set("X-Forwarded-For", addr.String())
+	}
+
+	resp, err := fa.opts.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	// A failing auth service is unavailable; it did not deny the credentials
+	if resp.StatusCode >= 500 {
+		return nil, fmt.Errorf("auth service returned status %d", resp.StatusCode)
+	}
+
+	d := &forwardDecision{status: resp.StatusCode, header: make(http.Header)}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		for _, name := range forwardAuthPassHeaders {
+			if values := resp.Header.Values(name); len(values) > 0 {
+				d.header[name] = values
+			}
+		}
+		return d, nil
+	}
+
+	user := resp.Header.Get(fa.opts.IdentityHeader)
+	if user == "" {
+		user = DefaultIdentity
+	}
+	var groups []string
+	for _, g := range strings.Split(resp.Header.Get(fa.opts.GroupsHeader), ",") {
+		if g = strings.TrimSpace(g); g != "" {
+			groups = append(groups, g)
+		}
+	}
+	d.identity = Identity{Name: fa.opts.Identify(user, groups), Method: "forward"}
+	return d, nil
+}
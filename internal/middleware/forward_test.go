@@ -0,0 +1,66 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestForwardAuthServiceErrors(t *testing.T) {
+	var calls, status atomic.Int32
+	status.Store(http.StatusInternalServerError)
+	stub := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(int(status.Load()))
+	}))
+	defer stub.Close()
+
+	handler := ForwardAuth(ForwardAuthOptions{
+		URL:      stub.URL,
+		Headers:  []string{"Cookie"},
+		CacheTTL: time.Minute,
+		Client:   stub.Client(),
+		Identify: func(user string, groups []string) string { return user },
+	})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	request := func() int {
+		r := httptest.NewRequest("GET", "/sonarr/api/v3/series", nil)
+		r.Header.Set("Cookie", "session=bob")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	if code := request(); code != http.StatusServiceUnavailable {
+		t.Fatalf("auth service error: status %d, want 503", code)
+	}
+	if code := request(); code != http.StatusServiceUnavailable || calls.Load() != 2 {
+		t.Fatalf("second request: status %d after %d calls, want 503 after 2", code, calls.Load())
+	}
+
+	// Once the service recovers its decision is used and cached
+	status.Store(http.StatusOK)
+	if code := request(); code != http.StatusOK {
+		t.Fatalf("recovered auth service: status %d, want 200", code)
+	}
+	request()
+	if n := calls.Load(); n != 3 {
+		t.Errorf("auth service called %d times, want 3", n)
+	}
+
+	// Redirects to a login page are not cached
+	status.Store(http.StatusFound)
+	handler = ForwardAuth(ForwardAuthOptions{
+		URL:      stub.URL,
+		CacheTTL: time.Minute,
+		Client:   stub.Client(),
+		Identify: func(user string, groups []string) string { return user },
+	})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	request()
+	request()
+	if n := calls.Load(); n != 5 {
+		t.Errorf("auth service called %d times after redirects, want 5", n)
+	}
+}
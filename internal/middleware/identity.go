@@ -0,0 +1,76 @@
+package middleware
+
+import (
+	"context"
+	"crypto/x509"
+	"log/slog"
+	"net/http"
+	"slices"
+)
+
+const identityKey contextKey = "identity"
+
+// DefaultIdentity is the identity name used for the shared proxy API key.
+const DefaultIdentity = "default"
+
+// Identity describes the authenticated client of a request.
+type Identity struct {
+	Name     string   // client identity (basic auth user, certificate CN, ...)
+	Method   string   // authentication method that established the identity
+	Services []string // services the credential is limited to, nil means no limit
+	RuleSets []string // rule sets the credential is limited to, nil means no limit
+	ReadOnly bool     // credential only allows safe methods
+}
+
+// AllowsService returns true if the credential may be used for the named service.
+// Client restrictions from the configuration apply in addition.
+func (id Identity) AllowsService(name string) bool {
+	return id.Services == nil || slices.Contains(id.Services, name)
+}
+
+// AllowsMethod returns true if the credential may be used for the HTTP method.
+func (id Identity) AllowsMethod(method string) bool {
+	if !id.ReadOnly {
+		return true
+	}
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
+}
+
+// WithIdentity returns a copy of ctx carrying the given identity.
+func WithIdentity(ctx context.Context, id Identity) context.Context {
+	return context.WithValue(ctx, identityKey, id)
+}
+
+// GetIdentity retrieves the authenticated identity from the context.
+// Returns the zero Identity if the request was not authenticated.
+func GetIdentity(ctx context.Context) Identity {
+	if id, ok := ctx.Value(identityKey).(Identity); ok {
+		return id
+	}
+	return Identity{}
+}
+
+// ClientCertAuth middleware derives the request identity from the verified client
+// certificate; identify maps the certificate to a client identity and returns false
+// if the certificate is not allowed. Certificate verification itself happens during
+// the TLS handshake.
+func ClientCertAuth(identify func(cert *x509.Certificate) (string, bool)) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+				slog.Warn("Authentication failed", "method", "mtls", "reason", "no client certificate", "path", r.URL.Path, "client_ip", ClientIP(r))
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+			cert := r.TLS.PeerCertificates[0]
+			name, ok := identify(cert)
+			if !ok {
+				slog.Warn("Authentication failed", "method", "mtls", "reason", "certificate not mapped to a client", "client_cn", cert.Subject.CommonName, "serial", cert.SerialNumber.String(), "path", r.URL.Path, "client_ip", ClientIP(r))
+				http.Error(w, "Forbidden", http.StatusForbidden)
+				return
+			}
+			id := Identity{Name: name, Method: "mtls"}
+			next.ServeHTTP(w, r.WithContext(WithIdentity(r.Context(), id)))
+		})
+	}
+}
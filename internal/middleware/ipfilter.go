@@ -0,0 +1,28 @@
+package middleware
+
+import (
+	"log/slog"
+	"net/http"
+	"net/netip"
+)
+
+// IPFilter middleware rejects requests from client addresses that check denies.
+// check returns why the address is not permitted, or an empty string. The client
+// address is the one resolved by RealIP.
+func IPFilter(check func(r *http.Request, addr netip.Addr) string) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			addr := ClientAddr(r)
+			reason := "unknown client address"
+			if addr.IsValid() {
+				reason = check(r, addr)
+			}
+			if reason != "" {
+				slog.Warn("Request rejected", "reason", reason, "client_ip", ClientIP(r), "path", r.URL.Path)
+				http.Error(w, "Forbidden", http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,32 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+)
+
+func TestIPFilter(t *testing.T) {
+	lan := netip.MustParsePrefix("192.168.1.0/24")
+	handler := IPFilter(func(r *http.Request, addr netip.Addr) string {
+		if !lan.Contains(addr) {
+			return "outside LAN"
+		}
+		return ""
+	})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	for remoteAddr, want := range map[string]int{
+		"192.168.1.5:51234": http.StatusOK,
+		"203.0.113.7:51234": http.StatusForbidden,
+		"not-an-address":    http.StatusForbidden,
+	} {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = remoteAddr
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		if w.Code != want {
+			t.Errorf("request from %s: status %d, want %d", remoteAddr, w.Code, want)
+		}
+	}
+}
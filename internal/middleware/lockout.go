@@ -0,0 +1,75 @@
+package middleware
+
+import (
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"arr-proxy/internal/lockout"
+)
+
+// Lockout middleware authenticates requests with m, counting failures by client
+// address and Basic Auth user name, and rejects banned clients with 429 Too Many
+// Requests before their credentials are checked. A request fails if it carries a
+// credential for m and m answers 401 without passing it on; requests without a
+// credential, such as a browser's first request, do not count.
+func Lockout(guard *lockout.Guard, m AuthMethod) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		h := m.Authenticate(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if lw, ok := w.(*lockoutWriter); ok {
+				lw.passed = true
+				w = lw.ResponseWriter
+			}
+			next.ServeHTTP(w, r)
+		}))
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ip := ClientIP(r)
+			keys := []string{lockout.IPKey(ip)}
+			user, _, _ := r.BasicAuth()
+			if user != "" {
+				keys = append(keys, lockout.UserKey(user))
+			}
+
+			if ban, ok := guard.Banned(keys...); ok {
+				retryAfter := time.Until(ban.Until)
+				slog.Warn("Authentication blocked", "method", m.Name, "reason", "banned", "ban", ban.Key, "until", ban.Until, "path", r.URL.Path, "client_ip", ip)
+				w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+				http.Error(w, "Too Many Requests", http.StatusTooManyRequests)
+				return
+			}
+
+			if !m.Present(r) {
+				h.ServeHTTP(w, r)
+				return
+			}
+			lw := &lockoutWriter{ResponseWriter: w}
+			h.ServeHTTP(lw, r)
+			switch {
+			case lw.passed:
+				guard.Succeed(keys...)
+			case lw.status == http.StatusUnauthorized:
+				for _, ban := range guard.Fail(keys...) {
+					slog.Warn("Client banned", "method", m.Name, "ban", ban.Key, "failures", ban.Failures, "count", ban.Count,
+						"ban_time", time.Until(ban.Until).Round(time.Second).String(), "until", ban.Until, "client_ip", ip)
+				}
+			}
+		})
+	}
+}
+
+// lockoutWriter records the status of responses written by the authentication and
+// whether it passed the request on.
+type lockoutWriter struct {
+	http.ResponseWriter
+	status int
+	passed bool
+}
+
+func (w *lockoutWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
@@ -0,0 +1,62 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"arr-proxy/internal/lockout"
+)
+
+func TestLockout(t *testing.T) {
+	guard := lockout.New(lockout.Options{Threshold: 2, Window: time.Minute, BanTime: time.Minute, MaxBanTime: time.Hour})
+	basic := AuthMethod{Name: "basic", Present: HasBasicAuth, Authenticate: BasicAuth(func() (string, string) { return "admin", "secret" })}
+	handler := Lockout(guard, basic)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	request := func(remoteAddr, user, pass string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = remoteAddr
+		if user != "" {
+			r.SetBasicAuth(user, pass)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	// Requests without credentials do not count
+	for i := 0; i < 3; i++ {
+		if w := request("203.0.113.7:1234", "", ""); w.Code != http.StatusUnauthorized {
+			t.Fatalf("request without credentials: status %d", w.Code)
+		}
+	}
+
+	// A success resets the failure count
+	request("203.0.113.7:1234", "admin", "wrong")
+	if w := request("203.0.113.7:1234", "admin", "secret"); w.Code != http.StatusOK {
+		t.Fatalf("valid credentials: status %d", w.Code)
+	}
+	if w := request("203.0.113.7:1234", "admin", "wrong"); w.Code != http.StatusUnauthorized {
+		t.Fatalf("first failure after success: status %d", w.Code)
+	}
+
+	// The second failure bans the address
+	request("203.0.113.7:1234", "mallory", "guess")
+	w := request("203.0.113.7:1234", "admin", "secret")
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("banned address: status %d, want 429", w.Code)
+	}
+	if got := w.Header().Get("Retry-After"); got != "60" {
+		t.Errorf("Retry-After = %q, want 60", got)
+	}
+
+	// Failures of a user name count across addresses
+	request("198.51.100.2:1234", "mallory", "guess")
+	if w := request("198.51.100.3:1234", "mallory", "secret"); w.Code != http.StatusTooManyRequests {
+		t.Errorf("banned user from another address: status %d, want 429", w.Code)
+	}
+	if w := request("198.51.100.1:1234", "admin", "secret"); w.Code != http.StatusOK {
+		t.Errorf("other client: status %d", w.Code)
+	}
+}
@@ -0,0 +1,110 @@
+package middleware
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/netip"
+	"slices"
+	"strings"
+)
+
+const clientAddrKey contextKey = "client_addr"
+
+// RealIP middleware stores the address of the client in the request context. It
+// is the connection's remote address or, for connections from trusted proxies,
+// the address the proxies forwarded the request for in header: X-Forwarded-For
+// (the default if empty), Forwarded or X-Real-IP. Only that header is read, as
+// proxies pass the others on from the client unchanged.
+func RealIP(trustedProxies []netip.Prefix, header string) func(next http.Handler) http.Handler {
+	if header == "" {
+		header = "X-Forwarded-For"
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := context.WithValue(r.Context(), clientAddrKey, clientAddr(r, trustedProxies, header))
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// ClientAddr returns the client address stored by RealIP, or the connection's
+// remote address without RealIP. The zero Addr is returned if the address is not
+// known.
+func ClientAddr(r *http.Request) netip.Addr {
+	if addr, ok := r.Context().Value(clientAddrKey).(netip.Addr); ok {
+		return addr
+	}
+	return parseAddr(r.RemoteAddr)
+}
+
+// ClientIP returns the client address for logging and lockout, or the host of the
+// connection's remote address if the client address is not known. It never
+// includes a port, so all connections of a client share one value.
+func ClientIP(r *http.Request) string {
+	if addr := ClientAddr(r); addr.IsValid() {
+		return addr.String()
+	}
+	if addr := parseAddr(r.RemoteAddr); addr.IsValid() {
+		return addr.String()
+	}
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
+	return r.RemoteAddr
+}
+
+// clientAddr returns the address of the client that sent r. The forwarded header
+// is followed from the right for as long as the hop that appended the address is
+// a trusted proxy, so clients cannot spoof their address by sending the header
+// themselves. The zero Addr is returned if a trusted proxy forwarded an unknown
+// or obfuscated address.
+func clientAddr(r *http.Request, trustedProxies []netip.Prefix, header string) netip.Addr {
+	addr := parseAddr(r.RemoteAddr)
+	hops := forwardedFor(r.Header, header)
+	for i := len(hops) - 1; i >= 0 && addr.IsValid() && containsAddr(trustedProxies, addr); i-- {
+		addr = parseAddr(hops[i])
+	}
+	return addr
+}
+
+// forwardedFor returns the addresses in the forwarded header, in the order the hops
+// were appended: the for= parameters of Forwarded (RFC 7239) or the comma-separated
+// addresses of other headers.
+func forwardedFor(h http.Header, header string) []string {
+	var hops []string
+	for _, line := range h.Values(header) {
+		for _, element := range strings.Split(line, ",") {
+			if !strings.EqualFold(header, "Forwarded") {
+				hops = append(hops, strings.TrimSpace(element))
+				continue
+			}
+			for _, pair := range strings.Split(element, ";") {
+				key, value, _ := strings.Cut(strings.TrimSpace(pair), "=")
+				if strings.EqualFold(key, "for") {
+					hops = append(hops, strings.Trim(value, `"`))
+				}
+			}
+		}
+	}
+	return hops
+}
+
+// parseAddr parses an address with or without port; IPv6 addresses with a port are
+// in brackets. IPv4-mapped IPv6 addresses are unmapped. The zero Addr is returned
+// for anything else.
+func parseAddr(s string) netip.Addr {
+	if host, _, err := net.SplitHostPort(s); err == nil {
+		s = host
+	}
+	addr, err := netip.ParseAddr(strings.TrimSuffix(strings.TrimPrefix(s, "["), "]"))
+	if err != nil {
+		return netip.Addr{}
+	}
+	return addr.Unmap()
+}
+
+// containsAddr returns true if addr lies in one of the prefixes.
+func containsAddr(prefixes []netip.Prefix, addr netip.Addr) bool {
+	return slices.ContainsFunc(prefixes, func(p netip.Prefix) bool { return p.Contains(addr) })
+}
@@ -0,0 +1,82 @@
+package middleware
+
+import (
+	"cmp"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+)
+
+func TestClientAddr(t *testing.T) {
+	trusted := []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}
+	tests := []struct {
+		name       string
+		remoteAddr string
+		header     string
+		headers    map[string]string
+		want       string
+	}{
+		{name: "direct connection", remoteAddr: "192.168.1.5:51234", want: "192.168.1.5"},
+		{name: "untrusted connection cannot forward", remoteAddr: "192.168.1.5:51234", headers: map[string]string{"X-Forwarded-For": "10.0.0.1"}, want: "192.168.1.5"},
+		{name: "trusted proxy", remoteAddr: "10.0.0.2:443", headers: map[string]string{"X-Forwarded-For": "192.168.1.5"}, want: "192.168.1.5"},
+		{name: "spoofed hop left of the client", remoteAddr: "10.0.0.2:443", headers: map[string]string{"X-Forwarded-For": "192.168.1.1, 203.0.113.7, 10.0.0.3"}, want: "203.0.113.7"},
+		{name: "spoofed forwarded header ignored", remoteAddr: "10.0.0.2:443", headers: map[string]string{"Forwarded": "for=192.168.1.5", "X-Forwarded-For": "203.0.113.9"}, want: "203.0.113.9"},
+		{name: "spoofed forwarded header without X-Forwarded-For", remoteAddr: "10.0.0.2:443", headers: map[string]string{"Forwarded": "for=192.168.1.5", "X-Real-IP": "192.168.1.6"}, want: "10.0.0.2"},
+		{name: "forwarded header", remoteAddr: "10.0.0.2:443", header: "Forwarded", headers: map[string]string{"Forwarded": `for="[2001:db8::1]:4711";proto=https, for=10.0.0.3`}, want: "2001:db8::1"},
+		{name: "spoofed X-Forwarded-For ignored", remoteAddr: "10.0.0.2:443", header: "Forwarded", headers: map[string]string{"Forwarded": "for=203.0.113.9", "X-Forwarded-For": "192.168.1.5"}, want: "203.0.113.9"},
+		{name: "real IP header", remoteAddr: "10.0.0.2:443", header: "X-Real-IP", headers: map[string]string{"X-Real-IP": "192.168.1.5"}, want: "192.168.1.5"},
+		{name: "untrusted connection cannot set real IP", remoteAddr: "192.168.1.5:51234", header: "X-Real-IP", headers: map[string]string{"X-Real-IP": "10.0.0.1"}, want: "192.168.1.5"},
+		{name: "unknown forwarded address", remoteAddr: "10.0.0.2:443", header: "Forwarded", headers: map[string]string{"Forwarded": "for=unknown"}, want: "invalid IP"},
+		{name: "IPv4-mapped remote address", remoteAddr: "[::ffff:192.168.1.5]:51234", want: "192.168.1.5"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			r.RemoteAddr = tt.remoteAddr
+			for k, v := range tt.headers {
+				r.Header.Set(k, v)
+			}
+			if got := clientAddr(r, trusted, cmp.Or(tt.header, "X-Forwarded-For")).String(); got != tt.want {
+				t.Errorf("clientAddr() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRealIP(t *testing.T) {
+	var got netip.Addr
+	handler := RealIP([]netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}, "")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = ClientAddr(r)
+	}))
+	r := httptest.NewRequest("GET", "/", nil)
+	r.RemoteAddr = "10.0.0.2:443"
+	r.Header.Set("X-Forwarded-For", "192.168.1.5")
+	handler.ServeHTTP(httptest.NewRecorder(), r)
+	if got != netip.MustParseAddr("192.168.1.5") {
+		t.Errorf("ClientAddr() = %s, want the forwarded address", got)
+	}
+
+	// Without RealIP the connection's remote address is used
+	if got := ClientAddr(r); got != netip.MustParseAddr("10.0.0.2") {
+		t.Errorf("ClientAddr() without RealIP = %s, want the remote address", got)
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	handler := RealIP([]netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}, "")
+	var got string
+	h := handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = ClientIP(r)
+	}))
+	for _, port := range []string{"443", "444"} {
+		// A trusted proxy forwarding an obfuscated address
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = "10.0.0.2:" + port
+		r.Header.Set("X-Forwarded-For", "_hidden")
+		h.ServeHTTP(httptest.NewRecorder(), r)
+		if got != "10.0.0.2" {
+			t.Errorf("ClientIP() = %q, want the remote host without port", got)
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package middleware
+
+import (
+	"log/slog"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// TrustedHeaderOptions configures identities asserted by an authenticating reverse proxy.
+type TrustedHeaderOptions struct {
+	UserHeader     string         // request header naming the user
+	GroupsHeader   string         // request header listing the user's groups, comma-separated
+	TrustedProxies []netip.Prefix // connections allowed to set the headers
+	// Identify maps the user and groups to a client identity.
+	Identify func(user string, groups []string) string
+}
+
+// TrustedHeaderAuth middleware accepts the identity named in a request header,
+// but only from connections whose remote address is a trusted proxy. The headers
+// are removed before the request is passed on.
+func TrustedHeaderAuth(opts TrustedHeaderOptions) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			user := r.Header.Get(opts.UserHeader)
+			if !isTrusted(r.RemoteAddr, opts.TrustedProxies) {
+				reason := "untrusted source"
+				if user != "" {
+					reason = "identity header from untrusted source"
+				}
+				slog.Warn("Authentication failed", "method", "header", "reason", reason, "path", r.URL.Path, "client_ip", ClientIP(r))
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+			if user == "" {
+				slog.Warn("Authentication failed", "method", "header", "reason", "no identity header", "path", r.URL.Path, "client_ip", ClientIP(r))
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+
+			var groups []string
+			for _, g := range strings.Split(r.Header.Get(opts.GroupsHeader), ",") {
+				if g = strings.TrimSpace(g); g != "" {
+					groups = append(groups, g)
+				}
+			}
+			r.Header.Del(opts.UserHeader)
+			r.Header.Del(opts.GroupsHeader)
+			id := Identity{Name: opts.Identify(user, groups), Method: "header"}
+			next.ServeHTTP(w, r.WithContext(WithIdentity(r.Context(), id)))
+		})
+	}
+}
+
+// isTrusted returns true if remoteAddr (host:port) lies in one of the prefixes.
+func isTrusted(remoteAddr string, prefixes []netip.Prefix) bool {
+	addr := parseAddr(remoteAddr)
+	return addr.IsValid() && containsAddr(prefixes, addr)
+}
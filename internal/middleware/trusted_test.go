@@ -0,0 +1,29 @@
+package middleware
+
+import (
+	"net/netip"
+	"testing"
+)
+
+func TestIsTrusted(t *testing.T) {
+	prefixes := []netip.Prefix{
+		netip.MustParsePrefix("10.0.0.0/8"),
+		netip.MustParsePrefix("fd00::/8"),
+	}
+	tests := []struct {
+		remoteAddr string
+		want       bool
+	}{
+		{"10.1.2.3:51234", true},
+		{"[::ffff:10.1.2.3]:51234", true},
+		{"[fd00::1]:443", true},
+		{"192.168.1.5:51234", false},
+		{"[fe80::1]:443", false},
+		{"not-an-address", false},
+	}
+	for _, tt := range tests {
+		if got := isTrusted(tt.remoteAddr, prefixes); got != tt.want {
+			t.Errorf("isTrusted(%q) = %v, want %v", tt.remoteAddr, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,201 @@
+// Package proxyproto accepts connections relayed by load balancers that announce
+// the original client address with the PROXY protocol (versions 1 and 2), as sent
+// by HAProxy in TCP mode and most L4 load balancers.
+package proxyproto
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/netip"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// maxV1HeaderSize is the longest version 1 header, including CRLF.
+	maxV1HeaderSize = 107
+	// defaultTimeout limits how long the header may take to arrive.
+	defaultTimeout = 10 * time.Second
+)
+
+// v2Signature starts every version 2 header.
+var v2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// ErrInvalidHeader is returned by reads of connections whose PROXY header is
+// missing or malformed.
+var ErrInvalidHeader = errors.New("invalid PROXY protocol header")
+
+// Listener wraps connections from trusted sources, which must start with a PROXY
+// header. Connections from other sources are returned unchanged, so clients can
+// still connect directly.
+type Listener struct {
+	net.Listener
+	trusted []netip.Prefix
+	timeout time.Duration
+}
+
+// NewListener returns a listener accepting PROXY headers from the trusted networks.
+// timeout limits how long a header may take to arrive; zero uses a default.
+func NewListener(l net.Listener, trusted []netip.Prefix, timeout time.Duration) *Listener {
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+	return &Listener{Listener: l, trusted: trusted, timeout: timeout}
+}
+
+// Accept waits for the next connection. The header is read on the connection's
+// first use, so a slow sender does not block other connections.
+func (l *Listener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	addr, ok := c.RemoteAddr().(*net.TCPAddr)
+	if !ok || !slices.ContainsFunc(l.trusted, func(p netip.Prefix) bool { return p.Contains(addr.AddrPort().Addr().Unmap()) }) {
+		return c, nil
+	}
+	return &Conn{Conn: c, reader: bufio.NewReader(c), timeout: l.timeout}, nil
+}
+
+// Conn is a connection whose remote address is the one announced in its PROXY
+// header. Without an address in the header (health checks use the LOCAL command)
+// it is the address of the load balancer.
+type Conn struct {
+	net.Conn
+	reader  *bufio.Reader
+	timeout time.Duration
+
+	once   sync.Once
+	remote net.Addr // nil unless the header announced an address
+	err    error
+}
+
+// Read reads data after the header.
+func (c *Conn) Read(b []byte) (int, error) {
+	c.once.Do(c.readHeader)
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.reader.Read(b)
+}
+
+// RemoteAddr returns the client address announced in the header.
+func (c *Conn) RemoteAddr() net.Addr {
+	c.once.Do(c.readHeader)
+	if c.remote != nil {
+		return c.remote
+	}
+	return c.Conn.RemoteAddr()
+}
+
+func (c *Conn) readHeader() {
+	_ = c.Conn.SetReadDeadline(time.Now().Add(c.timeout))
+	defer c.Conn.SetReadDeadline(time.Time{})
+
+	var addr netip.AddrPort
+	sig, err := c.reader.Peek(len(v2Signature))
+	switch {
+	case err != nil:
+	case bytes.Equal(sig, v2Signature):
+		addr, err = readV2(c.reader)
+	default:
+		addr, err = readV1(c.reader)
+	}
+	if err != nil {
+		slog.Warn("Connection rejected", "reason", fmt.Sprintf("%v: %v", ErrInvalidHeader, err), "remote_addr", c.Conn.RemoteAddr().String())
+		c.err = fmt.Errorf("%w: %w", ErrInvalidHeader, err)
+		c.Conn.Close()
+		return
+	}
+	if addr.IsValid() {
+		c.remote = net.TCPAddrFromAddrPort(addr)
+	}
+}
+
+// readV1 reads a version 1 header: "PROXY TCP4 <src> <dst> <sport> <dport>\r\n".
+// UNKNOWN connections have no address.
+func readV1(r *bufio.Reader) (netip.AddrPort, error) {
+	var line []byte
+	for len(line) < maxV1HeaderSize {
+		b, err := r.ReadByte()
+		if err != nil {
+			return netip.AddrPort{}, err
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+	}
+	text, ok := strings.CutSuffix(string(line), "\r\n")
+	if !ok {
+		return netip.AddrPort{}, errors.New("header not terminated")
+	}
+	fields := strings.Split(text, " ")
+	if fields[0] != "PROXY" || len(fields) < 2 {
+		return netip.AddrPort{}, errors.New("no PROXY header")
+	}
+	if fields[1] == "UNKNOWN" {
+		return netip.AddrPort{}, nil
+	}
+	if (fields[1] != "TCP4" && fields[1] != "TCP6") || len(fields) != 6 {
+		return netip.AddrPort{}, fmt.Errorf("unsupported header %q", text)
+	}
+	src, err := netip.ParseAddr(fields[2])
+	if err != nil || src.Is4() != (fields[1] == "TCP4") {
+		return netip.AddrPort{}, fmt.Errorf("invalid source address %q", fields[2])
+	}
+	port, err := strconv.ParseUint(fields[4], 10, 16)
+	if err != nil {
+		return netip.AddrPort{}, fmt.Errorf("invalid source port %q", fields[4])
+	}
+	return netip.AddrPortFrom(src, uint16(port)), nil
+}
+
+// readV2 reads a binary version 2 header. LOCAL connections and address families
+// other than TCP over IPv4 and IPv6 have no address.
+func readV2(r *bufio.Reader) (netip.AddrPort, error) {
+	var header [16]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return netip.AddrPort{}, err
+	}
+	if header[12]>>4 != 2 {
+		return netip.AddrPort{}, fmt.Errorf("unsupported version %d", header[12]>>4)
+	}
+	payload := make([]byte, binary.BigEndian.Uint16(header[14:]))
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return netip.AddrPort{}, err
+	}
+	switch command := header[12] & 0x0f; command {
+	case 0x0: // LOCAL
+		return netip.AddrPort{}, nil
+	case 0x1: // PROXY
+	default:
+		return netip.AddrPort{}, fmt.Errorf("unsupported command %d", command)
+	}
+
+	switch family := header[13]; family {
+	case 0x11: // TCP over IPv4
+		if len(payload) < 12 {
+			return netip.AddrPort{}, errors.New("truncated IPv4 addresses")
+		}
+		src := netip.AddrFrom4([4]byte(payload[0:4]))
+		return netip.AddrPortFrom(src, binary.BigEndian.Uint16(payload[8:])), nil
+	case 0x21: // TCP over IPv6
+		if len(payload) < 36 {
+			return netip.AddrPort{}, errors.New("truncated IPv6 addresses")
+		}
+		src := netip.AddrFrom16([16]byte(payload[0:16])).Unmap()
+		return netip.AddrPortFrom(src, binary.BigEndian.Uint16(payload[32:])), nil
+	default:
+		return netip.AddrPort{}, nil
+	}
+}
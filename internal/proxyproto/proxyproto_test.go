@@ -0,0 +1,106 @@
+package proxyproto
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+)
+
+// v2Header builds a version 2 header for TCP over IPv4 with a trailing TLV.
+func v2Header(command byte, src netip.AddrPort) []byte {
+	dst := netip.MustParseAddrPort("192.0.2.1:443")
+	payload := append(src.Addr().AsSlice(), dst.Addr().AsSlice()...)
+	payload = binary.BigEndian.AppendUint16(payload, src.Port())
+	payload = binary.BigEndian.AppendUint16(payload, dst.Port())
+	payload = append(payload, 0x04, 0x00, 0x01, 0xff) // PP2_TYPE_NOOP
+	header := append([]byte{}, v2Signature...)
+	header = append(header, 0x20|command, 0x11)
+	header = binary.BigEndian.AppendUint16(header, uint16(len(payload)))
+	return append(header, payload...)
+}
+
+// accept sends data over a new connection to a listener trusting trusted and
+// returns the accepted connection's remote address and the data read after the
+// header.
+func accept(t *testing.T, trusted string, data []byte) (string, string, error) {
+	t.Helper()
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	l := NewListener(inner, []netip.Prefix{netip.MustParsePrefix(trusted)}, time.Second)
+	defer l.Close()
+
+	client, err := net.Dial("tcp", inner.Addr().String())
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	defer client.Close()
+	if _, err := client.Write(data); err != nil {
+		t.Fatalf("failed to write: %v", err)
+	}
+	client.(*net.TCPConn).CloseWrite()
+
+	conn, err := l.Accept()
+	if err != nil {
+		t.Fatalf("Accept() error: %v", err)
+	}
+	defer conn.Close()
+	remote := conn.RemoteAddr().String()
+	body, err := io.ReadAll(conn)
+	return remote, string(body), err
+}
+
+func TestListener(t *testing.T) {
+	tests := []struct {
+		name       string
+		trusted    string
+		data       []byte
+		wantRemote string // empty means the dialing address
+	}{
+		{name: "v1 TCP4", trusted: "127.0.0.0/8", data: []byte("PROXY TCP4 203.0.113.7 192.0.2.1 51234 443\r\nhello"), wantRemote: "203.0.113.7:51234"},
+		{name: "v1 TCP6", trusted: "127.0.0.0/8", data: []byte("PROXY TCP6 2001:db8::7 2001:db8::1 51234 443\r\nhello"), wantRemote: "[2001:db8::7]:51234"},
+		{name: "v1 UNKNOWN", trusted: "127.0.0.0/8", data: []byte("PROXY UNKNOWN\r\nhello")},
+		{name: "v2 PROXY", trusted: "127.0.0.0/8", data: append(v2Header(0x1, netip.MustParseAddrPort("203.0.113.7:51234")), "hello"...), wantRemote: "203.0.113.7:51234"},
+		{name: "v2 LOCAL", trusted: "127.0.0.0/8", data: append(v2Header(0x0, netip.MustParseAddrPort("203.0.113.7:51234")), "hello"...)},
+		{name: "untrusted source is not parsed", trusted: "10.0.0.0/8", data: []byte("hello")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			remote, body, err := accept(t, tt.trusted, tt.data)
+			if err != nil {
+				t.Fatalf("Read() error: %v", err)
+			}
+			if body != "hello" {
+				t.Errorf("read %q after the header, want %q", body, "hello")
+			}
+			host, _, _ := net.SplitHostPort(remote)
+			if tt.wantRemote == "" && host != "127.0.0.1" || tt.wantRemote != "" && remote != tt.wantRemote {
+				t.Errorf("RemoteAddr() = %s, want %s", remote, tt.wantRemote)
+			}
+		})
+	}
+}
+
+func TestListenerRejectsInvalidHeaders(t *testing.T) {
+	for name, data := range map[string]string{
+		"missing header":      "GET / HTTP/1.1\r\n\r\n",
+		"spoofed family":      "PROXY TCP4 2001:db8::7 192.0.2.1 51234 443\r\n",
+		"unterminated header": "PROXY TCP4 203.0.113.7 192.0.2.1 51234 443",
+		"v2 version 3":        string(v2Signature) + "\x31\x11\x00\x00",
+	} {
+		t.Run(name, func(t *testing.T) {
+			remote, _, err := accept(t, "127.0.0.0/8", []byte(data))
+			if !errors.Is(err, ErrInvalidHeader) {
+				t.Errorf("Read() error = %v, want ErrInvalidHeader", err)
+			}
+			if host, _, _ := net.SplitHostPort(remote); host != "127.0.0.1" {
+				t.Errorf("RemoteAddr() = %s, want the dialing address", remote)
+			}
+		})
+	}
+}
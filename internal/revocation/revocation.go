@@ -0,0 +1,270 @@
+// Package revocation rejects revoked client certificates during the TLS handshake,
+// based on local CRL files and a denylist of serial numbers and fingerprints.
+package revocation
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/big"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// statInterval limits how often the files are checked for changes.
+const statInterval = 10 * time.Second
+
+// ErrRevoked is returned for revoked client certificates.
+var ErrRevoked = errors.New("client certificate revoked")
+
+// revokedSet is the parsed content of the CRL files and the denylist.
+type revokedSet struct {
+	bySerial     map[string]bool // issuer subject + serial, from CRLs
+	serials      map[string]bool // serial regardless of issuer, from the denylist
+	fingerprints map[string]bool // SHA-256 fingerprints, from the denylist
+}
+
+// Checker rejects certificates listed in CRL files or a denylist file. The files are
+// reloaded when they change; if a reload fails the previous lists stay in effect.
+type Checker struct {
+	crlFiles []string
+	denylist string
+	issuers  []*x509.Certificate
+	now      func() time.Time
+
+	mu      sync.Mutex
+	set     *revokedSet
+	mtimes  map[string]time.Time
+	checked time.Time
+}
+
+// NewChecker loads the CRL files, which must be signed by one of issuers, and the
+// denylist file. denylist may be empty.
+func NewChecker(crlFiles []string, denylist string, issuers []*x509.Certificate) (*Checker, error) {
+	c := &Checker{crlFiles: crlFiles, denylist: denylist, issuers: issuers, now: time.Now}
+	mtimes, err := c.stat()
+	if err != nil {
+		return nil, err
+	}
+	set, err := c.load()
+	if err != nil {
+		return nil, err
+	}
+	c.set, c.mtimes, c.checked = set, mtimes, c.now()
+	return c, nil
+}
+
+// VerifyConnection implements tls.Config.VerifyConnection. It runs after the chain
+// has been verified, also for resumed sessions, and rejects connections whose
+// client certificate is revoked.
+func (c *Checker) VerifyConnection(cs tls.ConnectionState) error {
+	if len(cs.PeerCertificates) == 0 {
+		return nil
+	}
+	leaf := cs.PeerCertificates[0]
+	if reason := c.current().revoked(leaf); reason != "" {
+		slog.Warn("Client certificate rejected", "reason", reason, "client_cn", leaf.Subject.CommonName, "serial", serialHex(leaf.SerialNumber), "resumed", cs.DidResume)
+		return ErrRevoked
+	}
+	return nil
+}
+
+// SetIssuers replaces the CAs the CRLs must be signed by, after the client CA bundle
+// was reloaded. The lists are reloaded on the next check.
+func (c *Checker) SetIssuers(issuers []*x509.Certificate) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.issuers = issuers
+	c.mtimes, c.checked = nil, time.Time{}
+}
+
+// current returns the revoked set, reloading it if a file changed.
+func (c *Checker) current() *revokedSet {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.now()
+	if now.Sub(c.checked) < statInterval {
+		return c.set
+	}
+	c.checked = now
+	mtimes, err := c.stat()
+	if err != nil {
+		slog.Error("Failed to check revocation lists, keeping previous lists", "error", err)
+		return c.set
+	}
+	if sameTimes(mtimes, c.mtimes) {
+		return c.set
+	}
+	set, err := c.load()
+	if err != nil {
+		slog.Error("Failed to reload revocation lists, keeping previous lists", "error", err)
+		return c.set
+	}
+	c.set, c.mtimes = set, mtimes
+	slog.Info("Revocation lists reloaded", "serials", len(set.bySerial)+len(set.serials), "fingerprints", len(set.fingerprints))
+	return c.set
+}
+
+func (c *Checker) files() []string {
+	files := c.crlFiles
+	if c.denylist != "" {
+		files = append(files[:len(files):len(files)], c.denylist)
+	}
+	return files
+}
+
+func (c *Checker) stat() (map[string]time.Time, error) {
+	mtimes := make(map[string]time.Time)
+	for _, path := range c.files() {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		mtimes[path] = info.ModTime()
+	}
+	return mtimes, nil
+}
+
+func sameTimes(a, b map[string]time.Time) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for path, t := range a {
+		if !t.Equal(b[path]) {
+			return false
+		}
+	}
+	return true
+}
+
+func (c *Checker) load() (*revokedSet, error) {
+	set := &revokedSet{bySerial: make(map[string]bool), serials: make(map[string]bool), fingerprints: make(map[string]bool)}
+	for _, path := range c.crlFiles {
+		if err := c.loadCRL(path, set); err != nil {
+			return nil, fmt.Errorf("CRL %s: %w", path, err)
+		}
+	}
+	if c.denylist != "" {
+		if err := loadDenylist(c.denylist, set); err != nil {
+			return nil, fmt.Errorf("denylist %s: %w", c.denylist, err)
+		}
+	}
+	return set, nil
+}
+
+// loadCRL adds the serials of a PEM or DER encoded CRL to set after checking its
+// signature against the issuers.
+func (c *Checker) loadCRL(path string, set *revokedSet) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if block, _ := pem.Decode(data); block != nil {
+		if block.Type != "X509 CRL" {
+			return fmt.Errorf("unexpected PEM block %q", block.Type)
+		}
+		data = block.Bytes
+	}
+	crl, err := x509.ParseRevocationList(data)
+	if err != nil {
+		return err
+	}
+
+	var issuer *x509.Certificate
+	for _, ca := range c.issuers {
+		if bytes.Equal(ca.RawSubject, crl.RawIssuer) && crl.CheckSignatureFrom(ca) == nil {
+			issuer = ca
+			break
+		}
+	}
+	if issuer == nil {
+		return errors.New("not signed by a configured CA")
+	}
+	if !crl.NextUpdate.IsZero() && c.now().After(crl.NextUpdate) {
+		slog.Warn("CRL is past its next update, publish a new one", "path", path, "next_update", crl.NextUpdate)
+	}
+	for _, entry := range crl.RevokedCertificateEntries {
+		set.bySerial[string(issuer.RawSubject)+serialHex(entry.SerialNumber)] = true
+	}
+	return nil
+}
+
+// loadDenylist adds the entries of a denylist file to set: one hex serial number or
+// SHA-256 fingerprint per line; colons, a "sha256:" prefix and # comments are allowed.
+func loadDenylist(path string, set *revokedSet) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		entry, _, _ := strings.Cut(scanner.Text(), "#")
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		kind, value, err := parseDenylistEntry(entry)
+		if err != nil {
+			return fmt.Errorf("line %d: %w", line, err)
+		}
+		if kind == "fingerprint" {
+			set.fingerprints[value] = true
+		} else {
+			set.serials[value] = true
+		}
+	}
+	return scanner.Err()
+}
+
+// parseDenylistEntry parses a denylist entry into its kind, "serial" or
+// "fingerprint", and its normalized value.
+func parseDenylistEntry(entry string) (kind, value string, err error) {
+	value, prefixed := strings.CutPrefix(strings.ToLower(entry), "sha256:")
+	value = strings.TrimPrefix(strings.ReplaceAll(value, ":", ""), "0x")
+	n, ok := new(big.Int).SetString(value, 16)
+	switch {
+	case !ok || n.Sign() < 0:
+		return "", "", fmt.Errorf("invalid entry %q (use a hex serial number or SHA-256 fingerprint)", entry)
+	case prefixed || len(value) == 2*sha256.Size:
+		if len(value) != 2*sha256.Size {
+			return "", "", fmt.Errorf("invalid fingerprint %q (use the hex SHA-256 of the certificate)", entry)
+		}
+		return "fingerprint", value, nil
+	case len(value) > 40:
+		// Serial numbers have at most 20 bytes
+		return "", "", fmt.Errorf("invalid serial number %q", entry)
+	}
+	return "serial", serialHex(n), nil
+}
+
+// revoked returns why cert is revoked, or an empty string.
+func (s *revokedSet) revoked(cert *x509.Certificate) string {
+	serial := serialHex(cert.SerialNumber)
+	sum := sha256.Sum256(cert.Raw)
+	switch {
+	case s.bySerial[string(cert.RawIssuer)+serial]:
+		return "revoked by CRL"
+	case s.serials[serial]:
+		return "serial number on denylist"
+	case s.fingerprints[hex.EncodeToString(sum[:])]:
+		return "fingerprint on denylist"
+	}
+	return ""
+}
+
+// serialHex formats a serial number as lowercase hex without leading zeros.
+func serialHex(serial *big.Int) string {
+	return serial.Text(16)
+}
@@ -0,0 +1,281 @@
+package revocation
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+func newCA(t *testing.T, name string) *testCA {
+	t.Helper()
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("failed to create CA: %v", err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return &testCA{cert: cert, key: key}
+}
+
+func (ca *testCA) issue(t *testing.T, serial int64) *x509.Certificate {
+	t.Helper()
+	return ca.keyPair(t, serial, x509.ExtKeyUsageClientAuth).Leaf
+}
+
+// keyPair issues a certificate for the host revocation.test.
+func (ca *testCA) keyPair(t *testing.T, serial int64, usage x509.ExtKeyUsage) tls.Certificate {
+	t.Helper()
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: "client"},
+		DNSNames:     []string{"revocation.test"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatalf("failed to issue certificate: %v", err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: cert}
+}
+
+func (ca *testCA) writeCRL(t *testing.T, path string, serials ...int64) {
+	t.Helper()
+	var entries []x509.RevocationListEntry
+	for _, s := range serials {
+		entries = append(entries, x509.RevocationListEntry{SerialNumber: big.NewInt(s), RevocationTime: time.Now()})
+	}
+	der, err := x509.CreateRevocationList(rand.Reader, &x509.RevocationList{
+		Number:                    big.NewInt(1),
+		ThisUpdate:                time.Now(),
+		NextUpdate:                time.Now().Add(time.Hour),
+		RevokedCertificateEntries: entries,
+	}, ca.cert, ca.key)
+	if err != nil {
+		t.Fatalf("failed to create CRL: %v", err)
+	}
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "X509 CRL", Bytes: der}), 0o600); err != nil {
+		t.Fatalf("failed to write CRL: %v", err)
+	}
+}
+
+func verify(c *Checker, cert *x509.Certificate, ca *testCA) error {
+	return c.VerifyConnection(tls.ConnectionState{
+		PeerCertificates: []*x509.Certificate{cert},
+		VerifiedChains:   [][]*x509.Certificate{{cert, ca.cert}},
+	})
+}
+
+func TestChecker(t *testing.T) {
+	ca := newCA(t, "Home CA")
+	valid, revoked, denied, deniedByFingerprint := ca.issue(t, 10), ca.issue(t, 11), ca.issue(t, 0x1a2b), ca.issue(t, 13)
+
+	dir := t.TempDir()
+	crlPath := filepath.Join(dir, "ca.crl")
+	ca.writeCRL(t, crlPath, 11)
+	sum := sha256.Sum256(deniedByFingerprint.Raw)
+	denylist := filepath.Join(dir, "denylist")
+	content := "# lost phone\n1A:2B\nsha256:" + hex.EncodeToString(sum[:]) + "  # tablet\n"
+	if err := os.WriteFile(denylist, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := NewChecker([]string{crlPath}, denylist, []*x509.Certificate{ca.cert})
+	if err != nil {
+		t.Fatalf("NewChecker() unexpected error: %v", err)
+	}
+	tests := []struct {
+		name    string
+		cert    *x509.Certificate
+		wantErr bool
+	}{
+		{"valid", valid, false},
+		{"revoked by CRL", revoked, true},
+		{"serial on denylist", denied, true},
+		{"fingerprint on denylist", deniedByFingerprint, true},
+	}
+	for _, tt := range tests {
+		if err := verify(c, tt.cert, ca); (err != nil) != tt.wantErr {
+			t.Errorf("%s: VerifyConnection() error = %v, wantErr %v", tt.name, err, tt.wantErr)
+		}
+	}
+	if err := c.VerifyConnection(tls.ConnectionState{}); err != nil {
+		t.Errorf("VerifyConnection() without certificate error = %v", err)
+	}
+
+	// Changed files are picked up after statInterval
+	now := time.Now()
+	c.now = func() time.Time { return now }
+	ca.writeCRL(t, crlPath, 10)
+	future := time.Now().Add(time.Minute)
+	_ = os.Chtimes(crlPath, future, future)
+	if err := verify(c, valid, ca); err != nil {
+		t.Errorf("VerifyConnection() reloaded before statInterval")
+	}
+	now = now.Add(statInterval)
+	if err := verify(c, valid, ca); err == nil {
+		t.Errorf("VerifyConnection() accepted certificate revoked by reloaded CRL")
+	}
+	if err := verify(c, revoked, ca); err != nil {
+		t.Errorf("VerifyConnection() rejected certificate no longer in reloaded CRL")
+	}
+
+	// A broken file keeps the previous lists
+	_ = os.WriteFile(denylist, []byte("not-hex\n"), 0o600)
+	_ = os.Chtimes(denylist, future.Add(time.Minute), future.Add(time.Minute))
+	now = now.Add(statInterval)
+	if err := verify(c, denied, ca); err == nil {
+		t.Errorf("VerifyConnection() dropped denylist after failed reload")
+	}
+}
+
+func TestCheckerRejectsResumedSession(t *testing.T) {
+	ca := newCA(t, "Home CA")
+	denylist := filepath.Join(t.TempDir(), "denylist")
+	_ = os.WriteFile(denylist, nil, 0o600)
+	c, err := NewChecker(nil, denylist, []*x509.Certificate{ca.cert})
+	if err != nil {
+		t.Fatalf("NewChecker() unexpected error: %v", err)
+	}
+	now := time.Now()
+	c.now = func() time.Time { return now }
+
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		Certificates:     []tls.Certificate{ca.keyPair(t, 100, x509.ExtKeyUsageServerAuth)},
+		ClientAuth:       tls.RequireAndVerifyClientCert,
+		ClientCAs:        pool,
+		VerifyConnection: c.VerifyConnection,
+	})
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			// The session ticket is sent with the first data
+			_, _ = conn.Write([]byte("x"))
+			_ = conn.Close()
+		}
+	}()
+
+	client := &tls.Config{
+		Certificates:       []tls.Certificate{ca.keyPair(t, 11, x509.ExtKeyUsageClientAuth)},
+		RootCAs:            pool,
+		ServerName:         "revocation.test",
+		ClientSessionCache: tls.NewLRUClientSessionCache(1),
+	}
+	connect := func() (resumed bool, err error) {
+		conn, err := tls.Dial("tcp", ln.Addr().String(), client)
+		if err != nil {
+			return false, err
+		}
+		defer conn.Close()
+		// A rejected client certificate is reported when reading after TLS 1.3 handshakes
+		if _, err := conn.Read(make([]byte, 1)); err != nil {
+			return false, err
+		}
+		return conn.ConnectionState().DidResume, nil
+	}
+
+	if _, err := connect(); err != nil {
+		t.Fatalf("first connection failed: %v", err)
+	}
+	if resumed, err := connect(); err != nil || !resumed {
+		t.Fatalf("second connection: resumed %v, error %v", resumed, err)
+	}
+
+	_ = os.WriteFile(denylist, []byte("0b\n"), 0o600)
+	future := time.Now().Add(time.Minute)
+	_ = os.Chtimes(denylist, future, future)
+	now = now.Add(statInterval)
+	if _, err := connect(); err == nil {
+		t.Errorf("resumed session of a revoked certificate accepted")
+	}
+}
+
+func TestNewCheckerRejectsForeignCRL(t *testing.T) {
+	ca, other := newCA(t, "Home CA"), newCA(t, "Other CA")
+	path := filepath.Join(t.TempDir(), "other.crl")
+	other.writeCRL(t, path, 1)
+	if _, err := NewChecker([]string{path}, "", []*x509.Certificate{ca.cert}); err == nil {
+		t.Errorf("NewChecker() accepted a CRL not signed by the CA")
+	}
+}
+
+func TestCheckerSetIssuers(t *testing.T) {
+	ca, renewed := newCA(t, "Home CA"), newCA(t, "Home CA 2")
+	path := filepath.Join(t.TempDir(), "ca.crl")
+	ca.writeCRL(t, path, 11)
+	c, err := NewChecker([]string{path}, "", []*x509.Certificate{ca.cert})
+	if err != nil {
+		t.Fatalf("NewChecker() unexpected error: %v", err)
+	}
+
+	// The CRL of a new CA is rejected until the CA is trusted, then loaded right away
+	renewed.writeCRL(t, path, 20)
+	c.SetIssuers([]*x509.Certificate{ca.cert})
+	if err := verify(c, ca.issue(t, 11), ca); err == nil {
+		t.Errorf("VerifyConnection() dropped CRL after reloading a foreign CRL")
+	}
+	c.SetIssuers([]*x509.Certificate{renewed.cert})
+	if err := verify(c, renewed.issue(t, 20), renewed); err == nil {
+		t.Errorf("VerifyConnection() accepted certificate revoked by the CRL of the new issuer")
+	}
+}
+
+func TestParseDenylistEntry(t *testing.T) {
+	fingerprint := hex.EncodeToString(make([]byte, sha256.Size))
+	tests := []struct {
+		entry     string
+		wantKind  string
+		wantValue string
+		wantErr   bool
+	}{
+		{entry: "1A:2B", wantKind: "serial", wantValue: "1a2b"},
+		{entry: "0x00ff", wantKind: "serial", wantValue: "ff"},
+		{entry: fingerprint, wantKind: "fingerprint", wantValue: fingerprint},
+		{entry: "SHA256:" + fingerprint, wantKind: "fingerprint", wantValue: fingerprint},
+		{entry: "sha256:abcd", wantErr: true},
+		{entry: "serial-1", wantErr: true},
+	}
+	for _, tt := range tests {
+		kind, value, err := parseDenylistEntry(tt.entry)
+		if (err != nil) != tt.wantErr || kind != tt.wantKind || value != tt.wantValue {
+			t.Errorf("parseDenylistEntry(%q) = %q, %q, %v, want %q, %q, error %v", tt.entry, kind, value, err, tt.wantKind, tt.wantValue, tt.wantErr)
+		}
+	}
+}
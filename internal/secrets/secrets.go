@@ -0,0 +1,123 @@
+// Package secrets encrypts and decrypts configuration values with a master key.
+package secrets
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Prefix marks an encrypted configuration value.
+const Prefix = "enc:"
+
+// KeySize is the master key length in bytes (AES-256).
+const KeySize = 32
+
+// Environment variables supplying the master key.
+const (
+	MasterKeyEnv     = "APP_MASTER_KEY"
+	MasterKeyFileEnv = "APP_MASTER_KEY_FILE"
+)
+
+// ErrNoMasterKey is returned when an encrypted value is found but no master key is configured.
+var ErrNoMasterKey = errors.New("no master key configured (set " + MasterKeyEnv + " or " + MasterKeyFileEnv + ")")
+
+// IsEncrypted returns true if value is an encrypted configuration value.
+func IsEncrypted(value string) bool {
+	return strings.HasPrefix(value, Prefix)
+}
+
+// GenerateKey returns a new random master key, base64 encoded.
+func GenerateKey() (string, error) {
+	key := make([]byte, KeySize)
+	if _, err := rand.Read(key); err != nil {
+		return "", fmt.Errorf("failed to generate key: %w", err)
+	}
+	return base64.StdEncoding.EncodeToString(key), nil
+}
+
+// ParseKey decodes a base64 encoded master key.
+func ParseKey(encoded string) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return nil, fmt.Errorf("invalid master key: not base64: %w", err)
+	}
+	if len(key) != KeySize {
+		return nil, fmt.Errorf("invalid master key: got %d bytes, want %d", len(key), KeySize)
+	}
+	return key, nil
+}
+
+// LoadMasterKey reads the master key from APP_MASTER_KEY or the file named by
+// APP_MASTER_KEY_FILE. Returns ErrNoMasterKey if neither is set.
+func LoadMasterKey() ([]byte, error) {
+	encoded := os.Getenv(MasterKeyEnv)
+	if file := os.Getenv(MasterKeyFileEnv); file != "" {
+		if encoded != "" {
+			return nil, fmt.Errorf("both %s and %s are set", MasterKeyEnv, MasterKeyFileEnv)
+		}
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read master key file: %w", err)
+		}
+		encoded = string(data)
+	}
+	if encoded == "" {
+		return nil, ErrNoMasterKey
+	}
+	return ParseKey(encoded)
+}
+
+// Encrypt seals plaintext with AES-256-GCM and returns "enc:" followed by the
+// base64 encoded nonce and ciphertext. The ciphertext is bound to the setting
+// name, the environment variable of the setting (e.g. SONARR_API_KEY), so it
+// cannot be moved to another setting.
+func Encrypt(key []byte, name, plaintext string) (string, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	sealed := aead.Seal(nonce, nonce, []byte(plaintext), []byte(name))
+	return Prefix + base64.RawURLEncoding.EncodeToString(sealed), nil
+}
+
+// Decrypt opens a value produced by Encrypt for the setting name. Errors never
+// contain the plaintext.
+func Decrypt(key []byte, name, value string) (string, error) {
+	if !IsEncrypted(value) {
+		return "", fmt.Errorf("value is not encrypted (missing %q prefix)", Prefix)
+	}
+	sealed, err := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(value, Prefix))
+	if err != nil {
+		return "", fmt.Errorf("invalid encrypted value: %w", err)
+	}
+	aead, err := newAEAD(key)
+	if err != nil {
+		return "", err
+	}
+	if len(sealed) < aead.NonceSize() {
+		return "", errors.New("invalid encrypted value: too short")
+	}
+	plaintext, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], []byte(name))
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt value: wrong master key, encrypted for another setting than %s, or corrupted data", name)
+	}
+	return string(plaintext), nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("invalid master key: %w", err)
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,123 @@
+package secrets
+
+import (
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestEncryptDecrypt(t *testing.T) {
+	encoded, err := GenerateKey()
+	if err != nil {
+		t.Fatalf("GenerateKey() unexpected error: %v", err)
+	}
+	key, err := ParseKey(encoded)
+	if err != nil {
+		t.Fatalf("ParseKey() unexpected error: %v", err)
+	}
+
+	for _, plaintext := range []string{"", "api-key", "with spaces and ünïcode"} {
+		value, err := Encrypt(key, "SONARR_API_KEY", plaintext)
+		if err != nil {
+			t.Fatalf("Encrypt(%q) unexpected error: %v", plaintext, err)
+		}
+		if !IsEncrypted(value) || (plaintext != "" && strings.Contains(value, plaintext)) {
+			t.Errorf("Encrypt(%q) = %q, want opaque value with %q prefix", plaintext, value, Prefix)
+		}
+		got, err := Decrypt(key, "SONARR_API_KEY", value)
+		if err != nil {
+			t.Fatalf("Decrypt() unexpected error: %v", err)
+		}
+		if got != plaintext {
+			t.Errorf("Decrypt() = %q, want %q", got, plaintext)
+		}
+	}
+}
+
+func TestDecryptErrors(t *testing.T) {
+	key := make([]byte, KeySize)
+	otherKey := make([]byte, KeySize)
+	otherKey[0] = 1
+	value, err := Encrypt(key, "SONARR_API_KEY", "secret")
+	if err != nil {
+		t.Fatalf("Encrypt() unexpected error: %v", err)
+	}
+	sealed, _ := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(value, Prefix))
+	sealed[len(sealed)-1] ^= 0xff
+	tampered := Prefix + base64.RawURLEncoding.EncodeToString(sealed)
+
+	tests := []struct {
+		name    string
+		key     []byte
+		setting string
+		value   string
+		wantErr string
+	}{
+		{name: "wrong key", key: otherKey, value: value, wantErr: "wrong master key"},
+		{name: "other setting", key: key, setting: "RADARR_API_KEY", value: value, wantErr: "another setting than RADARR_API_KEY"},
+		{name: "tampered", key: key, value: tampered, wantErr: "or corrupted data"},
+		{name: "not encrypted", key: key, value: "secret", wantErr: "not encrypted"},
+		{name: "invalid base64", key: key, value: Prefix + "!!!", wantErr: "invalid encrypted value"},
+		{name: "too short", key: key, value: Prefix + "AAAA", wantErr: "too short"},
+		{name: "invalid key", key: []byte("short"), value: value, wantErr: "invalid master key"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setting := tt.setting
+			if setting == "" {
+				setting = "SONARR_API_KEY"
+			}
+			_, err := Decrypt(tt.key, setting, tt.value)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("Decrypt() error = %v, want %q", err, tt.wantErr)
+			}
+			if strings.Contains(err.Error(), "secret") {
+				t.Errorf("Decrypt() error %q leaks the plaintext", err)
+			}
+		})
+	}
+}
+
+func TestLoadMasterKey(t *testing.T) {
+	encoded, err := GenerateKey()
+	if err != nil {
+		t.Fatalf("GenerateKey() unexpected error: %v", err)
+	}
+	keyFile := filepath.Join(t.TempDir(), "master.key")
+	if err := os.WriteFile(keyFile, []byte(encoded+"\n"), 0600); err != nil {
+		t.Fatalf("failed to write key file: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		env     string
+		file    string
+		wantErr string
+	}{
+		{name: "env", env: encoded},
+		{name: "file", file: keyFile},
+		{name: "none", wantErr: "no master key"},
+		{name: "both", env: encoded, file: keyFile, wantErr: "both"},
+		{name: "wrong length", env: "c2hvcnQ=", wantErr: "got 5 bytes"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv(MasterKeyEnv, tt.env)
+			t.Setenv(MasterKeyFileEnv, tt.file)
+			key, err := LoadMasterKey()
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("LoadMasterKey() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil || len(key) != KeySize {
+				t.Fatalf("LoadMasterKey() = %d bytes, %v, want %d bytes", len(key), err, KeySize)
+			}
+		})
+	}
+}
@@ -0,0 +1,304 @@
+// Package tlsreload serves the TLS server certificates and the client CA bundle from
+// files that are reloaded when they change or on SIGHUP, so renewed certificates
+// take effect without a restart.
+package tlsreload
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+const (
+	// pollInterval is how often the files are checked for changes.
+	pollInterval = 10 * time.Second
+	// expiryCheckInterval is how often the expiry warnings are repeated.
+	expiryCheckInterval = 24 * time.Hour
+	// expiryWarning is how long before expiry certificates are warned about.
+	expiryWarning = 30 * 24 * time.Hour
+)
+
+// clientCAs is a loaded client CA bundle.
+type clientCAs struct {
+	pool  *x509.CertPool
+	certs []*x509.Certificate
+}
+
+// KeyPair is a certificate file and its private key file.
+type KeyPair struct {
+	Cert string
+	Key  string
+}
+
+// Reloader loads the server certificates and client CA bundle. If a reload fails
+// the previously loaded files stay in effect.
+type Reloader struct {
+	pairs  []KeyPair
+	caFile string
+	now    func() time.Time
+
+	certs []atomic.Pointer[tls.Certificate] // one per pair
+	cas   atomic.Pointer[clientCAs]
+
+	mu          sync.Mutex
+	mtimes      map[string]time.Time
+	onClientCAs func([]*x509.Certificate)
+}
+
+// New loads the certificates and, if caFile is not empty, the client CA bundle. The
+// first certificate is served to clients whose SNI matches no certificate.
+func New(pairs []KeyPair, caFile string) (*Reloader, error) {
+	if len(pairs) == 0 {
+		return nil, errors.New("no TLS certificate")
+	}
+	r := &Reloader{pairs: pairs, caFile: caFile, now: time.Now, certs: make([]atomic.Pointer[tls.Certificate], len(pairs))}
+	r.mtimes = r.stat()
+	for i := range pairs {
+		if err := r.loadCertificate(i); err != nil {
+			return nil, err
+		}
+	}
+	if caFile != "" {
+		if err := r.loadClientCAs(); err != nil {
+			return nil, err
+		}
+	}
+	r.checkExpiry()
+	return r, nil
+}
+
+// GetCertificate implements tls.Config.GetCertificate. Like tls.Config.Certificates
+// it returns the first certificate the client supports, by SNI and signature
+// algorithms, and otherwise the first certificate.
+func (r *Reloader) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	if len(r.certs) > 1 {
+		for i := range r.certs {
+			if cert := r.certs[i].Load(); hello.SupportsCertificate(cert) == nil {
+				return cert, nil
+			}
+		}
+	}
+	return r.certs[0].Load(), nil
+}
+
+// Certificates returns the current server certificates.
+func (r *Reloader) Certificates() []*x509.Certificate {
+	certs := make([]*x509.Certificate, len(r.certs))
+	for i := range r.certs {
+		certs[i] = r.certs[i].Load().Leaf
+	}
+	return certs
+}
+
+// ClientCAs returns the certificates of the current client CA bundle.
+func (r *Reloader) ClientCAs() []*x509.Certificate {
+	if cas := r.cas.Load(); cas != nil {
+		return cas.certs
+	}
+	return nil
+}
+
+// OnClientCAs registers fn to be called with the new certificates whenever the
+// client CA bundle is reloaded. It must be called before Run.
+func (r *Reloader) OnClientCAs(fn func([]*x509.Certificate)) {
+	r.onClientCAs = fn
+}
+
+// Configure makes config take the server certificates and, with a client CA bundle,
+// the client CAs from r. config must not be modified afterwards.
+func (r *Reloader) Configure(config *tls.Config) {
+	config.GetCertificate = r.GetCertificate
+	if r.caFile == "" {
+		return
+	}
+	// ClientCAs cannot be swapped on a config in use, so handshakes after a reload
+	// get a copy with the new pool
+	initial := r.cas.Load()
+	config.ClientCAs = initial.pool
+	config.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+		cas := r.cas.Load()
+		if cas == initial {
+			return nil, nil
+		}
+		c := config.Clone()
+		c.ClientCAs = cas.pool
+		return c, nil
+	}
+}
+
+// Run reloads changed files and reloads all files on SIGHUP until ctx is done. It
+// also repeats the expiry warnings daily.
+func (r *Reloader) Run(ctx context.Context) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	poll := time.NewTicker(pollInterval)
+	defer poll.Stop()
+	expiry := time.NewTicker(expiryCheckInterval)
+	defer expiry.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			slog.Info("SIGHUP received, reloading TLS certificates")
+			if err := r.Reload(); err != nil {
+				slog.Error("Failed to reload TLS certificates, keeping previous certificates", "error", err)
+			}
+		case <-poll.C:
+			if err := r.reloadChanged(); err != nil {
+				slog.Error("Failed to reload TLS certificates, keeping previous certificates", "error", err)
+			}
+		case <-expiry.C:
+			r.checkExpiry()
+		}
+	}
+}
+
+// Reload loads all files, whether they changed or not.
+func (r *Reloader) Reload() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.mtimes = r.stat()
+	var err error
+	for i := range r.pairs {
+		err = errors.Join(err, r.loadCertificate(i))
+	}
+	if r.caFile != "" {
+		err = errors.Join(err, r.loadClientCAs())
+	}
+	r.checkExpiry()
+	return err
+}
+
+// reloadChanged loads the files that changed since they were last loaded.
+func (r *Reloader) reloadChanged() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	mtimes := r.stat()
+	changed := func(paths ...string) bool {
+		for _, path := range paths {
+			if !mtimes[path].Equal(r.mtimes[path]) {
+				return true
+			}
+		}
+		return false
+	}
+	var err error
+	var reloaded bool
+	for i, pair := range r.pairs {
+		if changed(pair.Cert, pair.Key) {
+			// A failed load is retried when the files change again, for example when
+			// the key is written after the certificate
+			err = errors.Join(err, r.loadCertificate(i))
+			reloaded = true
+		}
+	}
+	if r.caFile != "" && changed(r.caFile) {
+		err = errors.Join(err, r.loadClientCAs())
+		reloaded = true
+	}
+	r.mtimes = mtimes
+	if reloaded {
+		r.checkExpiry()
+	}
+	return err
+}
+
+// stat returns the modification times of the files. Files that cannot be read have
+// a zero time, so they are reloaded once they reappear.
+func (r *Reloader) stat() map[string]time.Time {
+	mtimes := make(map[string]time.Time)
+	paths := []string{r.caFile}
+	for _, pair := range r.pairs {
+		paths = append(paths, pair.Cert, pair.Key)
+	}
+	for _, path := range paths {
+		if path == "" {
+			continue
+		}
+		if info, err := os.Stat(path); err == nil {
+			mtimes[path] = info.ModTime()
+		}
+	}
+	return mtimes
+}
+
+func (r *Reloader) loadCertificate(i int) error {
+	pair := r.pairs[i]
+	cert, err := tls.LoadX509KeyPair(pair.Cert, pair.Key)
+	if err != nil {
+		return fmt.Errorf("failed to load TLS certificate %s: %w", pair.Cert, err)
+	}
+	r.certs[i].Store(&cert)
+	slog.Info("TLS certificate loaded", "path", pair.Cert, "subject", cert.Leaf.Subject.CommonName,
+		"dns_names", cert.Leaf.DNSNames, "serial", cert.Leaf.SerialNumber.Text(16), "not_after", cert.Leaf.NotAfter)
+	return nil
+}
+
+func (r *Reloader) loadClientCAs() error {
+	data, err := os.ReadFile(r.caFile)
+	if err != nil {
+		return fmt.Errorf("failed to read CA cert: %w", err)
+	}
+	cas := &clientCAs{pool: x509.NewCertPool()}
+	for rest := data; ; {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return fmt.Errorf("failed to parse CA certificate: %w", err)
+		}
+		cas.pool.AddCert(cert)
+		cas.certs = append(cas.certs, cert)
+	}
+	if len(cas.certs) == 0 {
+		return errors.New("failed to parse CA certificate")
+	}
+
+	r.cas.Store(cas)
+	slog.Info("Client CA certificates loaded", "path", r.caFile, "count", len(cas.certs))
+	if r.onClientCAs != nil {
+		r.onClientCAs(cas.certs)
+	}
+	return nil
+}
+
+// checkExpiry warns about certificates that expire soon or have expired.
+func (r *Reloader) checkExpiry() {
+	for i, cert := range r.Certificates() {
+		r.warnExpiry("TLS certificate", r.pairs[i].Cert, cert)
+	}
+	for _, cert := range r.ClientCAs() {
+		r.warnExpiry("Client CA certificate", r.caFile, cert)
+	}
+}
+
+func (r *Reloader) warnExpiry(kind, path string, cert *x509.Certificate) {
+	left := cert.NotAfter.Sub(r.now())
+	switch {
+	case left <= 0:
+		slog.Error(kind+" has expired", "path", path, "subject", cert.Subject.CommonName, "not_after", cert.NotAfter)
+	case left < expiryWarning:
+		slog.Warn(kind+" expires soon", "path", path, "subject", cert.Subject.CommonName, "not_after", cert.NotAfter,
+			"days_left", int(left.Hours()/24))
+	}
+}
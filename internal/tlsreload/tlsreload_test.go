@@ -0,0 +1,224 @@
+package tlsreload
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeCert writes a self-signed certificate and its key, advancing the
+// modification time past that of the previous files.
+func writeCert(t *testing.T, certFile, keyFile string, serial int64, mtime time.Time) *x509.Certificate {
+	t.Helper()
+	return writeHostCert(t, certFile, keyFile, "proxy.example.com", serial, mtime)
+}
+
+func writeHostCert(t *testing.T, certFile, keyFile, host string, serial int64, mtime time.Time) *x509.Certificate {
+	t.Helper()
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(serial),
+		Subject:               pkix.Name{CommonName: host},
+		DNSNames:              []string{host},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("failed to create certificate: %v", err)
+	}
+	keyDER, _ := x509.MarshalPKCS8PrivateKey(key)
+	writeFile(t, certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), mtime)
+	if keyFile != "" {
+		writeFile(t, keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), mtime)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return cert
+}
+
+func writeFile(t *testing.T, path string, data []byte, mtime time.Time) {
+	t.Helper()
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatalf("failed to write %s: %v", path, err)
+	}
+	if err := os.Chtimes(path, mtime, mtime); err != nil {
+		t.Fatalf("failed to set mtime of %s: %v", path, err)
+	}
+}
+
+func serving(t *testing.T, r *Reloader) int64 {
+	t.Helper()
+	cert, err := r.GetCertificate(&tls.ClientHelloInfo{})
+	if err != nil || cert == nil {
+		t.Fatalf("GetCertificate() = %v, %v", cert, err)
+	}
+	return cert.Leaf.SerialNumber.Int64()
+}
+
+func TestReloadCertificate(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key")
+	start := time.Now().Add(-time.Hour)
+	writeCert(t, certFile, keyFile, 1, start)
+
+	r, err := New([]KeyPair{{certFile, keyFile}}, "")
+	if err != nil {
+		t.Fatalf("New() error: %v", err)
+	}
+	if got := serving(t, r); got != 1 {
+		t.Fatalf("serving serial %d, want 1", got)
+	}
+	if err := r.reloadChanged(); err != nil || serving(t, r) != 1 {
+		t.Fatalf("reloadChanged() without changes = %v, serving %d", err, serving(t, r))
+	}
+
+	writeCert(t, certFile, keyFile, 2, start.Add(time.Minute))
+	if err := r.reloadChanged(); err != nil {
+		t.Fatalf("reloadChanged() error: %v", err)
+	}
+	if got := serving(t, r); got != 2 {
+		t.Errorf("serving serial %d after renewal, want 2", got)
+	}
+
+	// A certificate written before its key does not match the old key
+	writeCert(t, certFile, "", 3, start.Add(2*time.Minute))
+	if err := r.reloadChanged(); err == nil {
+		t.Error("reloadChanged() with mismatched key succeeded")
+	}
+	if got := serving(t, r); got != 2 {
+		t.Errorf("serving serial %d after failed reload, want previous 2", got)
+	}
+	writeCert(t, certFile, keyFile, 4, start.Add(3*time.Minute))
+	if err := r.reloadChanged(); err != nil {
+		t.Fatalf("reloadChanged() error: %v", err)
+	}
+	if got := serving(t, r); got != 4 {
+		t.Errorf("serving serial %d once the key is written, want 4", got)
+	}
+
+	// Reload loads the files even if their modification time did not change
+	writeCert(t, certFile, keyFile, 5, start.Add(3*time.Minute))
+	if err := r.Reload(); err != nil {
+		t.Fatalf("Reload() error: %v", err)
+	}
+	if got := serving(t, r); got != 5 {
+		t.Errorf("serving serial %d after Reload, want 5", got)
+	}
+}
+
+func TestGetCertificateBySNI(t *testing.T) {
+	dir := t.TempDir()
+	var pairs []KeyPair
+	for i, host := range []string{"proxy.example.com", "*.media.example.com", "sonarr.example.com"} {
+		pair := KeyPair{filepath.Join(dir, fmt.Sprintf("%d.crt", i)), filepath.Join(dir, fmt.Sprintf("%d.key", i))}
+		writeHostCert(t, pair.Cert, pair.Key, host, int64(i+1), time.Now())
+		pairs = append(pairs, pair)
+	}
+	r, err := New(pairs, "")
+	if err != nil {
+		t.Fatalf("New() error: %v", err)
+	}
+
+	tests := []struct {
+		serverName string
+		want       int64
+	}{
+		{"sonarr.example.com", 3},
+		{"radarr.media.example.com", 2},
+		{"proxy.example.com", 1},
+		{"unknown.example.com", 1},
+		{"", 1},
+	}
+	for _, tt := range tests {
+		hello := &tls.ClientHelloInfo{
+			ServerName:        tt.serverName,
+			SupportedVersions: []uint16{tls.VersionTLS13},
+			SignatureSchemes:  []tls.SignatureScheme{tls.ECDSAWithP256AndSHA256},
+		}
+		cert, err := r.GetCertificate(hello)
+		if err != nil || cert.Leaf.SerialNumber.Int64() != tt.want {
+			t.Errorf("GetCertificate(%q) = serial %d, %v, want %d", tt.serverName, cert.Leaf.SerialNumber.Int64(), err, tt.want)
+		}
+	}
+}
+
+func TestReloadClientCAs(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile, caFile := filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key"), filepath.Join(dir, "ca.crt")
+	start := time.Now().Add(-time.Hour)
+	writeCert(t, certFile, keyFile, 1, start)
+	oldCA := writeCert(t, caFile, "", 10, start)
+
+	r, err := New([]KeyPair{{certFile, keyFile}}, caFile)
+	if err != nil {
+		t.Fatalf("New() error: %v", err)
+	}
+	var notified []*x509.Certificate
+	r.OnClientCAs(func(certs []*x509.Certificate) { notified = certs })
+	config := &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert}
+	r.Configure(config)
+
+	if pool := config.ClientCAs; pool == nil || !pool.Equal(poolOf(oldCA)) {
+		t.Fatal("Configure() did not set the client CA pool")
+	}
+	if c, err := config.GetConfigForClient(&tls.ClientHelloInfo{}); c != nil || err != nil {
+		t.Errorf("GetConfigForClient() before a reload = %v, %v, want the base config", c, err)
+	}
+
+	newCA := writeCert(t, caFile, "", 11, start.Add(time.Minute))
+	if err := r.reloadChanged(); err != nil {
+		t.Fatalf("reloadChanged() error: %v", err)
+	}
+	if len(notified) != 1 || !notified[0].Equal(newCA) {
+		t.Errorf("OnClientCAs notified with %d certificates, want the new CA", len(notified))
+	}
+	c, err := config.GetConfigForClient(&tls.ClientHelloInfo{})
+	if err != nil || c == nil {
+		t.Fatalf("GetConfigForClient() after a reload = %v, %v", c, err)
+	}
+	if !c.ClientCAs.Equal(poolOf(newCA)) || c.ClientAuth != tls.RequireAndVerifyClientCert {
+		t.Error("GetConfigForClient() did not return the base config with the new client CA pool")
+	}
+
+	// An invalid bundle keeps the previous CAs
+	writeFile(t, caFile, []byte("not a certificate"), start.Add(2*time.Minute))
+	if err := r.reloadChanged(); err == nil {
+		t.Error("reloadChanged() with an invalid CA bundle succeeded")
+	}
+	if cas := r.ClientCAs(); len(cas) != 1 || !cas[0].Equal(newCA) {
+		t.Error("ClientCAs() changed after a failed reload")
+	}
+}
+
+func TestNewRejectsInvalidFiles(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key")
+	if _, err := New([]KeyPair{{certFile, keyFile}}, ""); err == nil {
+		t.Error("New() with missing files succeeded")
+	}
+	writeCert(t, certFile, keyFile, 1, time.Now())
+	if _, err := New([]KeyPair{{certFile, keyFile}}, filepath.Join(dir, "missing.crt")); err == nil {
+		t.Error("New() with a missing CA file succeeded")
+	}
+}
+
+func poolOf(certs ...*x509.Certificate) *x509.CertPool {
+	pool := x509.NewCertPool()
+	for _, cert := range certs {
+		pool.AddCert(cert)
+	}
+	return pool
+}
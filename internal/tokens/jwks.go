@@ -0,0 +1,307 @@
+package tokens
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// minJWKSRefetch limits how often an unknown key ID triggers a refetch.
+const minJWKSRefetch = time.Minute
+
+// maxJWKSSize limits the size of a fetched key set.
+const maxJWKSSize = 1 << 20
+
+// ErrUnknownKey is returned when no key of the set can verify a token.
+var ErrUnknownKey = errors.New("no matching key for token")
+
+// jwk is a JSON Web Key as found in a key set. Only public signature keys are used.
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// publicKey is a parsed key of a key set.
+type publicKey struct {
+	kid string
+	alg string // algorithm the key is restricted to, empty for any of its type
+	key any    // *rsa.PublicKey, *ecdsa.PublicKey or ed25519.PublicKey
+}
+
+// accepts returns true if the key can verify signatures made with alg.
+func (k *publicKey) accepts(alg string) bool {
+	if k.alg != "" && k.alg != alg {
+		return false
+	}
+	switch key := k.key.(type) {
+	case *rsa.PublicKey:
+		return strings.HasPrefix(alg, "RS") || strings.HasPrefix(alg, "PS")
+	case *ecdsa.PublicKey:
+		return ecdsaCurves[alg] == key.Curve.Params().Name
+	case ed25519.PublicKey:
+		return alg == AlgEdDSA
+	}
+	return false
+}
+
+// JWKS is a JSON Web Key Set loaded from a local file or an URL. The set is
+// reloaded after the refresh interval and, rate limited, when a token names an
+// unknown key ID, so keys rotated by the issuer are picked up without a restart.
+// Reloads run in the background: requests are served with the current keys
+// meanwhile and only wait for keys they do not find.
+type JWKS struct {
+	source  string
+	refresh time.Duration
+	client  *http.Client
+	now     func() time.Time
+
+	mu        sync.Mutex
+	keys      []publicKey
+	loadedAt  time.Time     // last successful load
+	attempted time.Time     // last load attempt
+	loading   chan struct{} // closed when the running reload is done, nil if none
+}
+
+// NewJWKS creates a key set read from source, a file path or an http(s) URL.
+// Keys are loaded on first use; call Refresh to load them eagerly.
+func NewJWKS(source string, refresh time.Duration) *JWKS {
+	return &JWKS{
+		source:  source,
+		refresh: refresh,
+		client:  &http.Client{Timeout: 10 * time.Second},
+		now:     time.Now,
+	}
+}
+
+// IsURL returns true if source is fetched over HTTP rather than read from a file.
+func IsURL(source string) bool {
+	return strings.HasPrefix(source, "https://") || strings.HasPrefix(source, "http://")
+}
+
+// Refresh reloads the key set. On failure the previously loaded keys are kept.
+func (s *JWKS) Refresh(ctx context.Context) error {
+	s.mu.Lock()
+	attempted := s.now()
+	s.attempted = attempted
+	s.mu.Unlock()
+	return s.load(ctx, attempted)
+}
+
+// Key returns the key for verifying a token signed with alg by the key kid. An
+// empty kid matches any key accepting alg.
+func (s *JWKS) Key(ctx context.Context, kid, alg string) (any, error) {
+	s.mu.Lock()
+	now := s.now()
+	var done <-chan struct{}
+	if s.loadedAt.IsZero() || now.Sub(s.loadedAt) >= s.refresh {
+		done = s.reload(now)
+	}
+	if key := s.find(kid, alg); key != nil {
+		s.mu.Unlock()
+		return key, nil
+	}
+	// The issuer may have rotated its keys since the last load
+	if done == nil {
+		done = s.reload(now)
+	}
+	s.mu.Unlock()
+	if done == nil {
+		return nil, ErrUnknownKey
+	}
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if key := s.find(kid, alg); key != nil {
+		return key, nil
+	}
+	return nil, ErrUnknownKey
+}
+
+// reload starts loading the key set in the background unless the last attempt
+// was too recent, and returns a channel closed when the running load is done, or
+// nil if none is running. Callers must hold s.mu.
+func (s *JWKS) reload(now time.Time) <-chan struct{} {
+	if s.loading != nil {
+		return s.loading
+	}
+	if !s.attempted.IsZero() && now.Sub(s.attempted) < minJWKSRefetch {
+		return nil
+	}
+	s.attempted = now
+	done := make(chan struct{})
+	s.loading = done
+	go func() {
+		// Not bound to the request that triggered the reload; the client has a timeout
+		if err := s.load(context.Background(), now); err != nil {
+			slog.Warn("Failed to load JWKS, keeping previous keys", "source", s.source, "error", err)
+		}
+		s.mu.Lock()
+		s.loading = nil
+		s.mu.Unlock()
+		close(done)
+	}()
+	return done
+}
+
+// find returns the key for kid and alg. Callers must hold s.mu.
+func (s *JWKS) find(kid, alg string) any {
+	for i := range s.keys {
+		k := &s.keys[i]
+		if (kid == "" || k.kid == kid) && k.accepts(alg) {
+			return k.key
+		}
+	}
+	return nil
+}
+
+// load reads and parses the key set attempted at the given time, holding s.mu
+// only to swap in the new keys.
+func (s *JWKS) load(ctx context.Context, attempted time.Time) error {
+	data, err := s.read(ctx)
+	if err != nil {
+		return err
+	}
+	keys, err := parseJWKS(data)
+	if err != nil {
+		return fmt.Errorf("invalid JWKS %s: %w", s.source, err)
+	}
+	s.mu.Lock()
+	s.keys = keys
+	s.loadedAt = attempted
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *JWKS) read(ctx context.Context) ([]byte, error) {
+	if !IsURL(s.source) {
+		data, err := os.ReadFile(s.source)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read JWKS: %w", err)
+		}
+		return data, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.source, nil)
+	if err != nil {
+		return nil, fmt.Errorf("invalid JWKS URL: %w", err)
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch JWKS: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch JWKS %s: status %d", s.source, resp.StatusCode)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxJWKSSize))
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch JWKS: %w", err)
+	}
+	return data, nil
+}
+
+// parseJWKS parses the public signature keys of a key set. Keys of unsupported
+// types are skipped; a set without any usable key is an error.
+func parseJWKS(data []byte) ([]publicKey, error) {
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, err
+	}
+	var keys []publicKey
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		key, err := k.publicKey()
+		if err != nil {
+			slog.Warn("Skipping JWKS key", "kid", k.Kid, "error", err)
+			continue
+		}
+		keys = append(keys, publicKey{kid: k.Kid, alg: k.Alg, key: key})
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("no usable signature keys")
+	}
+	return keys, nil
+}
+
+func (k *jwk) publicKey() (any, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeSegment(k.N)
+		if err != nil || len(n) == 0 {
+			return nil, errors.New("invalid RSA modulus")
+		}
+		e, err := decodeSegment(k.E)
+		if err != nil || len(e) == 0 || len(e) > 4 {
+			return nil, errors.New("invalid RSA exponent")
+		}
+		pub := &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+		if pub.N.BitLen() < 2048 {
+			return nil, fmt.Errorf("RSA key of %d bits is too small", pub.N.BitLen())
+		}
+		return pub, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, errX := decodeSegment(k.X)
+		y, errY := decodeSegment(k.Y)
+		size := (curve.Params().BitSize + 7) / 8
+		if errX != nil || errY != nil || len(x) != size || len(y) != size {
+			return nil, errors.New("invalid EC coordinates")
+		}
+		// Rejects points that are not on the curve
+		pub, err := ecdsa.ParseUncompressedPublicKey(curve, append(append([]byte{4}, x...), y...))
+		if err != nil {
+			return nil, fmt.Errorf("invalid EC key: %w", err)
+		}
+		return pub, nil
+	case "OKP":
+		if k.Crv != "Ed25519" {
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := decodeSegment(k.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid Ed25519 key")
+		}
+		return ed25519.PublicKey(x), nil
+	}
+	return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+}
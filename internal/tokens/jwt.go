@@ -0,0 +1,190 @@
+// Package tokens signs and verifies JSON Web Tokens.
+package tokens
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	_ "crypto/sha512" // SHA-384 and SHA-512 for RS384, ES512, ...
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+// Supported signature algorithms.
+const (
+	AlgHS256 = "HS256"
+	AlgEdDSA = "EdDSA"
+	AlgRS256 = "RS256"
+	AlgRS384 = "RS384"
+	AlgRS512 = "RS512"
+	AlgPS256 = "PS256"
+	AlgPS384 = "PS384"
+	AlgPS512 = "PS512"
+	AlgES256 = "ES256"
+	AlgES384 = "ES384"
+	AlgES512 = "ES512"
+)
+
+// algHashes are the digests of the RSA and ECDSA algorithms, which can only be verified.
+var algHashes = map[string]crypto.Hash{
+	AlgRS256: crypto.SHA256, AlgRS384: crypto.SHA384, AlgRS512: crypto.SHA512,
+	AlgPS256: crypto.SHA256, AlgPS384: crypto.SHA384, AlgPS512: crypto.SHA512,
+	AlgES256: crypto.SHA256, AlgES384: crypto.SHA384, AlgES512: crypto.SHA512,
+}
+
+// ecdsaCurves are the curves required by the ECDSA algorithms.
+var ecdsaCurves = map[string]string{AlgES256: "P-256", AlgES384: "P-384", AlgES512: "P-521"}
+
+// ErrInvalidSignature is returned when a token signature does not verify.
+var ErrInvalidSignature = errors.New("invalid token signature")
+
+// Header is the JOSE header of a token.
+type Header struct {
+	Alg string `json:"alg"`
+	Typ string `json:"typ,omitempty"`
+	Kid string `json:"kid,omitempty"`
+}
+
+// Encode signs claims with key using alg and returns the compact serialization.
+// key is a []byte secret for HS256 or an ed25519.PrivateKey for EdDSA.
+func Encode(alg, kid string, key any, claims any) (string, error) {
+	header, err := json.Marshal(Header{Alg: alg, Typ: "JWT", Kid: kid})
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	signingInput := encodeSegment(header) + "." + encodeSegment(payload)
+
+	var sig []byte
+	switch alg {
+	case AlgHS256:
+		secret, ok := key.([]byte)
+		if !ok {
+			return "", fmt.Errorf("%s requires a secret key", alg)
+		}
+		mac := hmac.New(sha256.New, secret)
+		mac.Write([]byte(signingInput))
+		sig = mac.Sum(nil)
+	case AlgEdDSA:
+		priv, ok := key.(ed25519.PrivateKey)
+		if !ok {
+			return "", fmt.Errorf("%s requires an Ed25519 private key", alg)
+		}
+		sig = ed25519.Sign(priv, []byte(signingInput))
+	default:
+		return "", fmt.Errorf("unsupported signing algorithm %q", alg)
+	}
+	return signingInput + "." + encodeSegment(sig), nil
+}
+
+// Decode splits a compact token and decodes its header and claims without
+// verifying the signature. Use Verify before trusting the claims.
+func Decode(token string, claims any) (Header, error) {
+	var header Header
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return header, errors.New("malformed token")
+	}
+	headerJSON, err := decodeSegment(parts[0])
+	if err != nil {
+		return header, fmt.Errorf("malformed token header: %w", err)
+	}
+	if err := json.Unmarshal(headerJSON, &header); err != nil {
+		return header, fmt.Errorf("malformed token header: %w", err)
+	}
+	payload, err := decodeSegment(parts[1])
+	if err != nil {
+		return header, fmt.Errorf("malformed token payload: %w", err)
+	}
+	if err := json.Unmarshal(payload, claims); err != nil {
+		return header, fmt.Errorf("malformed token payload: %w", err)
+	}
+	return header, nil
+}
+
+// Verify checks the token signature with key, which must match alg: a []byte
+// secret for HS256, an ed25519.PublicKey for EdDSA, an *rsa.PublicKey for RS* and
+// PS* or an *ecdsa.PublicKey for ES*. The algorithm is chosen by the caller,
+// never by the token alone, to prevent algorithm confusion.
+func Verify(token, alg string, key any) error {
+	i := strings.LastIndex(token, ".")
+	if i < 0 {
+		return errors.New("malformed token")
+	}
+	signingInput := []byte(token[:i])
+	sig, err := decodeSegment(token[i+1:])
+	if err != nil {
+		return fmt.Errorf("malformed token signature: %w", err)
+	}
+
+	var ok bool
+	switch alg {
+	case AlgHS256:
+		secret, isSecret := key.([]byte)
+		if !isSecret {
+			return fmt.Errorf("%s requires a secret key", alg)
+		}
+		mac := hmac.New(sha256.New, secret)
+		mac.Write(signingInput)
+		ok = hmac.Equal(sig, mac.Sum(nil))
+	case AlgEdDSA:
+		pub, isEd := key.(ed25519.PublicKey)
+		if !isEd {
+			return fmt.Errorf("%s requires an Ed25519 public key", alg)
+		}
+		ok = ed25519.Verify(pub, signingInput, sig)
+	case AlgRS256, AlgRS384, AlgRS512, AlgPS256, AlgPS384, AlgPS512:
+		pub, isRSA := key.(*rsa.PublicKey)
+		if !isRSA {
+			return fmt.Errorf("%s requires an RSA public key", alg)
+		}
+		h := algHashes[alg]
+		digest := h.New()
+		digest.Write(signingInput)
+		if alg[0] == 'P' {
+			ok = rsa.VerifyPSS(pub, h, digest.Sum(nil), sig, nil) == nil
+		} else {
+			ok = rsa.VerifyPKCS1v15(pub, h, digest.Sum(nil), sig) == nil
+		}
+	case AlgES256, AlgES384, AlgES512:
+		pub, isEC := key.(*ecdsa.PublicKey)
+		if !isEC || pub.Curve.Params().Name != ecdsaCurves[alg] {
+			return fmt.Errorf("%s requires an ECDSA %s public key", alg, ecdsaCurves[alg])
+		}
+		// JWS uses the fixed-size concatenation r || s instead of ASN.1
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		if len(sig) != 2*size {
+			return ErrInvalidSignature
+		}
+		h := algHashes[alg]
+		digest := h.New()
+		digest.Write(signingInput)
+		r := new(big.Int).SetBytes(sig[:size])
+		s := new(big.Int).SetBytes(sig[size:])
+		ok = ecdsa.Verify(pub, digest.Sum(nil), r, s)
+	default:
+		return fmt.Errorf("unsupported signing algorithm %q", alg)
+	}
+	if !ok {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+func encodeSegment(data []byte) string {
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeSegment(s string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(s)
+}
@@ -0,0 +1,141 @@
+package tokens
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Issuer is the iss claim of tokens minted by the proxy.
+const Issuer = "arr-proxy"
+
+// minSecretSize is the minimum HS256 secret length in bytes.
+const minSecretSize = 32
+
+// clockSkew is the tolerance for tokens issued slightly in the future.
+const clockSkew = time.Minute
+
+// Claims are the claims of a token minted by the proxy. Empty permission lists
+// mean the token carries all permissions of its subject.
+type Claims struct {
+	Issuer    string   `json:"iss"`
+	Subject   string   `json:"sub"`
+	IssuedAt  int64    `json:"iat"`
+	ExpiresAt int64    `json:"exp"`
+	ID        string   `json:"jti"`
+	Services  []string `json:"services,omitempty"`
+	RuleSets  []string `json:"rule_sets,omitempty"`
+	ReadOnly  bool     `json:"read_only,omitempty"`
+}
+
+// Minter mints and verifies proxy tokens with a single key.
+type Minter struct {
+	alg       string
+	mu        sync.RWMutex
+	signKey   any
+	verifyKey any
+	now       func() time.Time
+}
+
+// NewMinter creates a Minter. For HS256 key is the shared secret (at least 32
+// bytes); for EdDSA it is a PEM encoded PKCS#8 Ed25519 private key.
+func NewMinter(alg string, key []byte) (*Minter, error) {
+	m := &Minter{alg: alg, now: time.Now}
+	if err := m.SetKey(key); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// SetKey replaces the key of m. Tokens signed with the previous key are no longer
+// accepted. On error the previous key is kept.
+func (m *Minter) SetKey(key []byte) error {
+	var signKey, verifyKey any
+	switch m.alg {
+	case AlgHS256:
+		if len(key) < minSecretSize {
+			return fmt.Errorf("%s token key must be at least %d bytes", m.alg, minSecretSize)
+		}
+		signKey, verifyKey = key, key
+	case AlgEdDSA:
+		block, _ := pem.Decode(key)
+		if block == nil {
+			return fmt.Errorf("%s token key must be a PEM encoded private key", m.alg)
+		}
+		parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return fmt.Errorf("invalid %s token key: %w", m.alg, err)
+		}
+		priv, ok := parsed.(ed25519.PrivateKey)
+		if !ok {
+			return fmt.Errorf("%s token key is not an Ed25519 key", m.alg)
+		}
+		signKey, verifyKey = priv, priv.Public()
+	default:
+		return fmt.Errorf("unsupported token algorithm %q (valid: %s, %s)", m.alg, AlgHS256, AlgEdDSA)
+	}
+	m.mu.Lock()
+	m.signKey, m.verifyKey = signKey, verifyKey
+	m.mu.Unlock()
+	return nil
+}
+
+// Mint signs a token for claims valid for ttl. Issuer, issue time, expiry and ID
+// are set by Mint; the completed claims are returned with the token.
+func (m *Minter) Mint(claims Claims, ttl time.Duration) (string, Claims, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", Claims{}, fmt.Errorf("failed to generate token id: %w", err)
+	}
+	now := m.now()
+	claims.Issuer = Issuer
+	claims.IssuedAt = now.Unix()
+	claims.ExpiresAt = now.Add(ttl).Unix()
+	claims.ID = hex.EncodeToString(id)
+
+	m.mu.RLock()
+	signKey := m.signKey
+	m.mu.RUnlock()
+	token, err := Encode(m.alg, "", signKey, claims)
+	if err != nil {
+		return "", Claims{}, err
+	}
+	return token, claims, nil
+}
+
+// Parse verifies a token minted by m and returns its claims.
+func (m *Minter) Parse(token string) (Claims, error) {
+	var claims Claims
+	header, err := Decode(token, &claims)
+	if err != nil {
+		return Claims{}, err
+	}
+	if header.Alg != m.alg {
+		return Claims{}, fmt.Errorf("unexpected token algorithm %q", header.Alg)
+	}
+	m.mu.RLock()
+	verifyKey := m.verifyKey
+	m.mu.RUnlock()
+	if err := Verify(token, m.alg, verifyKey); err != nil {
+		return Claims{}, err
+	}
+
+	now := m.now()
+	switch {
+	case claims.Issuer != Issuer:
+		return Claims{}, fmt.Errorf("unexpected token issuer %q", claims.Issuer)
+	case claims.Subject == "":
+		return Claims{}, errors.New("token has no subject")
+	case claims.ExpiresAt == 0 || !now.Before(time.Unix(claims.ExpiresAt, 0)):
+		return Claims{}, errors.New("token expired")
+	case time.Unix(claims.IssuedAt, 0).After(now.Add(clockSkew)):
+		return Claims{}, errors.New("token issued in the future")
+	}
+	return claims, nil
+}
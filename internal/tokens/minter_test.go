@@ -0,0 +1,156 @@
+package tokens
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newEd25519PEM(t *testing.T) []byte {
+	t.Helper()
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(priv)
+	if err != nil {
+		t.Fatalf("failed to marshal key: %v", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+}
+
+func TestMintParse(t *testing.T) {
+	secret := []byte(strings.Repeat("s", 32))
+	for _, tt := range []struct {
+		alg string
+		key []byte
+	}{
+		{alg: AlgHS256, key: secret},
+		{alg: AlgEdDSA, key: newEd25519PEM(t)},
+	} {
+		t.Run(tt.alg, func(t *testing.T) {
+			m, err := NewMinter(tt.alg, tt.key)
+			if err != nil {
+				t.Fatalf("NewMinter() unexpected error: %v", err)
+			}
+			token, minted, err := m.Mint(Claims{Subject: "alice", Services: []string{"sonarr"}, ReadOnly: true}, time.Hour)
+			if err != nil {
+				t.Fatalf("Mint() unexpected error: %v", err)
+			}
+			if strings.Count(token, ".") != 2 || minted.ID == "" || minted.ExpiresAt-minted.IssuedAt != 3600 {
+				t.Fatalf("Mint() = %q, %+v, want compact JWT valid for one hour", token, minted)
+			}
+
+			claims, err := m.Parse(token)
+			if err != nil {
+				t.Fatalf("Parse() unexpected error: %v", err)
+			}
+			if claims.Subject != "alice" || !claims.ReadOnly || len(claims.Services) != 1 {
+				t.Errorf("Parse() = %+v, want minted claims", claims)
+			}
+		})
+	}
+}
+
+func TestParseRejects(t *testing.T) {
+	secret := []byte(strings.Repeat("s", 32))
+	m, err := NewMinter(AlgHS256, secret)
+	if err != nil {
+		t.Fatalf("NewMinter() unexpected error: %v", err)
+	}
+	other, _ := NewMinter(AlgHS256, []byte(strings.Repeat("o", 32)))
+	ed, _ := NewMinter(AlgEdDSA, newEd25519PEM(t))
+
+	valid, _, _ := m.Mint(Claims{Subject: "alice"}, time.Hour)
+	expired, _, _ := m.Mint(Claims{Subject: "alice"}, -time.Second)
+	noSubject, _, _ := m.Mint(Claims{}, time.Hour)
+	foreign, _, _ := other.Mint(Claims{Subject: "alice"}, time.Hour)
+	edToken, _, _ := ed.Mint(Claims{Subject: "alice"}, time.Hour)
+	wrongIssuer, _ := Encode(AlgHS256, "", secret, Claims{Issuer: "someone", Subject: "alice", ExpiresAt: time.Now().Add(time.Hour).Unix()})
+	future, _ := Encode(AlgHS256, "", secret, Claims{Issuer: Issuer, Subject: "alice", IssuedAt: time.Now().Add(time.Hour).Unix(), ExpiresAt: time.Now().Add(2 * time.Hour).Unix()})
+	parts := strings.Split(valid, ".")
+	unsigned := "eyJhbGciOiJub25lIn0." + parts[1] + "."
+	tampered := parts[0] + "." + encodeSegment([]byte(`{"iss":"arr-proxy","sub":"admin","exp":9999999999}`)) + "." + parts[2]
+
+	tests := []struct {
+		name    string
+		token   string
+		wantErr string
+	}{
+		{name: "expired", token: expired, wantErr: "expired"},
+		{name: "no subject", token: noSubject, wantErr: "no subject"},
+		{name: "other secret", token: foreign, wantErr: "signature"},
+		{name: "other algorithm", token: edToken, wantErr: "unexpected token algorithm"},
+		{name: "alg none", token: unsigned, wantErr: "unexpected token algorithm"},
+		{name: "tampered payload", token: tampered, wantErr: "signature"},
+		{name: "wrong issuer", token: wrongIssuer, wantErr: "issuer"},
+		{name: "issued in the future", token: future, wantErr: "future"},
+		{name: "malformed", token: "abc", wantErr: "malformed"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := m.Parse(tt.token); err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Parse() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+	if _, err := m.Parse(valid); err != nil {
+		t.Errorf("Parse(valid) unexpected error: %v", err)
+	}
+}
+
+func TestNewMinterInvalidKey(t *testing.T) {
+	tests := []struct {
+		name    string
+		alg     string
+		key     []byte
+		wantErr string
+	}{
+		{name: "short secret", alg: AlgHS256, key: []byte("short"), wantErr: "at least 32 bytes"},
+		{name: "not PEM", alg: AlgEdDSA, key: []byte("secret"), wantErr: "PEM"},
+		{name: "unknown algorithm", alg: "RS512", key: []byte(strings.Repeat("s", 32)), wantErr: "unsupported"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewMinter(tt.alg, tt.key); err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("NewMinter() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestMinterSetKey(t *testing.T) {
+	m, err := NewMinter(AlgHS256, []byte(strings.Repeat("a", 32)))
+	if err != nil {
+		t.Fatalf("NewMinter() unexpected error: %v", err)
+	}
+	old, _, err := m.Mint(Claims{Subject: "alice"}, time.Hour)
+	if err != nil {
+		t.Fatalf("Mint() unexpected error: %v", err)
+	}
+
+	if err := m.SetKey([]byte("short")); err == nil {
+		t.Fatal("SetKey() accepted a short secret")
+	}
+	if _, err := m.Parse(old); err != nil {
+		t.Fatalf("Parse() after a rejected key: %v", err)
+	}
+
+	if err := m.SetKey([]byte(strings.Repeat("b", 32))); err != nil {
+		t.Fatalf("SetKey() unexpected error: %v", err)
+	}
+	if _, err := m.Parse(old); err == nil {
+		t.Error("Parse() accepted a token signed with the previous key")
+	}
+	token, _, err := m.Mint(Claims{Subject: "alice"}, time.Hour)
+	if err != nil {
+		t.Fatalf("Mint() unexpected error: %v", err)
+	}
+	if _, err := m.Parse(token); err != nil {
+		t.Errorf("Parse() of a token signed with the new key: %v", err)
+	}
+}
@@ -0,0 +1,126 @@
+package tokens
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// asymmetricAlgs are the algorithms accepted for tokens of an external issuer.
+// Shared-secret algorithms are never accepted with public keys.
+var asymmetricAlgs = []string{
+	AlgRS256, AlgRS384, AlgRS512, AlgPS256, AlgPS384, AlgPS512,
+	AlgES256, AlgES384, AlgES512, AlgEdDSA,
+}
+
+// Validator verifies tokens of an external issuer, such as an OIDC provider,
+// against its published key set.
+type Validator struct {
+	keys     *JWKS
+	issuer   string
+	audience string
+	now      func() time.Time
+}
+
+// NewValidator creates a Validator accepting tokens signed by a key of keys, issued
+// by issuer and intended for audience.
+func NewValidator(keys *JWKS, issuer, audience string) *Validator {
+	return &Validator{keys: keys, issuer: issuer, audience: audience, now: time.Now}
+}
+
+// Validate verifies the token and its iss, aud, exp and nbf claims and returns all claims.
+func (v *Validator) Validate(ctx context.Context, token string) (map[string]any, error) {
+	var claims map[string]any
+	header, err := Decode(token, &claims)
+	if err != nil {
+		return nil, err
+	}
+	if !slices.Contains(asymmetricAlgs, header.Alg) {
+		return nil, fmt.Errorf("unexpected token algorithm %q", header.Alg)
+	}
+	key, err := v.keys.Key(ctx, header.Kid, header.Alg)
+	if err != nil {
+		return nil, err
+	}
+	if err := Verify(token, header.Alg, key); err != nil {
+		return nil, err
+	}
+
+	now := v.now()
+	if iss, _ := claims["iss"].(string); iss != v.issuer {
+		return nil, fmt.Errorf("unexpected token issuer %q", iss)
+	}
+	if !hasAudience(claims["aud"], v.audience) {
+		return nil, errors.New("token is not intended for this audience")
+	}
+	exp, ok := claims["exp"].(float64)
+	if !ok {
+		return nil, errors.New("token has no expiry")
+	}
+	if !now.Add(-clockSkew).Before(time.Unix(int64(exp), 0)) {
+		return nil, errors.New("token expired")
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && time.Unix(int64(nbf), 0).After(now.Add(clockSkew)) {
+		return nil, errors.New("token not yet valid")
+	}
+	return claims, nil
+}
+
+// hasAudience returns true if the aud claim, a string or a list of strings, contains audience.
+func hasAudience(aud any, audience string) bool {
+	switch aud := aud.(type) {
+	case string:
+		return aud == audience
+	case []any:
+		for _, a := range aud {
+			if a == audience {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ClaimString returns the string claim at path, where dots separate nested
+// objects (e.g. "realm_access.roles").
+func ClaimString(claims map[string]any, path string) string {
+	s, _ := claim(claims, path).(string)
+	return s
+}
+
+// ClaimStrings returns the claim at path as a list. A single string is returned
+// as a list of one element; other values are ignored.
+func ClaimStrings(claims map[string]any, path string) []string {
+	switch v := claim(claims, path).(type) {
+	case string:
+		return []string{v}
+	case []any:
+		list := make([]string, 0, len(v))
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				list = append(list, s)
+			}
+		}
+		return list
+	}
+	return nil
+}
+
+func claim(claims map[string]any, path string) any {
+	// A claim name containing dots takes precedence over nested lookup
+	if v, ok := claims[path]; ok {
+		return v
+	}
+	head, rest, found := strings.Cut(path, ".")
+	if !found {
+		return nil
+	}
+	nested, ok := claims[head].(map[string]any)
+	if !ok {
+		return nil
+	}
+	return claim(nested, rest)
+}
@@ -0,0 +1,294 @@
+package tokens
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// signToken signs claims like an external issuer would.
+func signToken(t *testing.T, alg, kid string, key crypto.Signer, claims map[string]any) string {
+	t.Helper()
+	header, _ := json.Marshal(Header{Alg: alg, Typ: "JWT", Kid: kid})
+	payload, _ := json.Marshal(claims)
+	signingInput := encodeSegment(header) + "." + encodeSegment(payload)
+
+	var sig []byte
+	var err error
+	switch k := key.(type) {
+	case ed25519.PrivateKey:
+		sig = ed25519.Sign(k, []byte(signingInput))
+	case *rsa.PrivateKey:
+		digest := algHashes[alg].New()
+		digest.Write([]byte(signingInput))
+		sig, err = rsa.SignPKCS1v15(rand.Reader, k, algHashes[alg], digest.Sum(nil))
+	case *ecdsa.PrivateKey:
+		digest := algHashes[alg].New()
+		digest.Write([]byte(signingInput))
+		var r, s *big.Int
+		r, s, err = ecdsa.Sign(rand.Reader, k, digest.Sum(nil))
+		size := (k.Curve.Params().BitSize + 7) / 8
+		sig = make([]byte, 2*size)
+		r.FillBytes(sig[:size])
+		s.FillBytes(sig[size:])
+	}
+	if err != nil {
+		t.Fatalf("failed to sign token: %v", err)
+	}
+	return signingInput + "." + encodeSegment(sig)
+}
+
+// publicJWK returns the JWK of key's public key.
+func publicJWK(kid string, key crypto.Signer) map[string]string {
+	switch pub := key.Public().(type) {
+	case ed25519.PublicKey:
+		return map[string]string{"kty": "OKP", "kid": kid, "crv": "Ed25519", "x": encodeSegment(pub)}
+	case *rsa.PublicKey:
+		return map[string]string{"kty": "RSA", "kid": kid, "n": encodeSegment(pub.N.Bytes()), "e": encodeSegment([]byte{1, 0, 1})}
+	case *ecdsa.PublicKey:
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		x, y := make([]byte, size), make([]byte, size)
+		pub.X.FillBytes(x)
+		pub.Y.FillBytes(y)
+		return map[string]string{"kty": "EC", "kid": kid, "crv": pub.Curve.Params().Name, "x": encodeSegment(x), "y": encodeSegment(y)}
+	}
+	return nil
+}
+
+func writeJWKS(t *testing.T, path string, keys ...map[string]string) {
+	t.Helper()
+	data, _ := json.Marshal(map[string]any{"keys": keys})
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatalf("failed to write JWKS: %v", err)
+	}
+}
+
+func validClaims() map[string]any {
+	return map[string]any{
+		"iss": "https://sso.example.com",
+		"aud": []string{"other", "arr-proxy"},
+		"sub": "alice",
+		"exp": time.Now().Add(time.Hour).Unix(),
+	}
+}
+
+func TestValidator(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+	otherKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	writeJWKS(t, path, publicJWK("rsa", rsaKey), publicJWK("ec", ecKey), publicJWK("ed", edKey))
+	v := NewValidator(NewJWKS(path, time.Hour), "https://sso.example.com", "arr-proxy")
+
+	with := func(change func(c map[string]any)) map[string]any {
+		c := validClaims()
+		change(c)
+		return c
+	}
+	rsaPublic := publicJWK("rsa", rsaKey)["n"]
+
+	tests := []struct {
+		name    string
+		token   string
+		wantErr string
+	}{
+		{name: "RS256", token: signToken(t, AlgRS256, "rsa", rsaKey, validClaims())},
+		{name: "ES256", token: signToken(t, AlgES256, "ec", ecKey, validClaims())},
+		{name: "EdDSA", token: signToken(t, AlgEdDSA, "ed", edKey, validClaims())},
+		{name: "no kid", token: signToken(t, AlgES256, "", ecKey, validClaims())},
+		{name: "single audience", token: signToken(t, AlgES256, "ec", ecKey, with(func(c map[string]any) { c["aud"] = "arr-proxy" }))},
+		{name: "unknown key", token: signToken(t, AlgES256, "ec", otherKey, validClaims()), wantErr: "signature"},
+		{name: "unknown kid", token: signToken(t, AlgES256, "new", otherKey, validClaims()), wantErr: "no matching key"},
+		{name: "kid of other key type", token: signToken(t, AlgES256, "rsa", ecKey, validClaims()), wantErr: "no matching key"},
+		{name: "wrong issuer", token: signToken(t, AlgES256, "ec", ecKey, with(func(c map[string]any) { c["iss"] = "https://evil.example.com" })), wantErr: "issuer"},
+		{name: "wrong audience", token: signToken(t, AlgES256, "ec", ecKey, with(func(c map[string]any) { c["aud"] = "other" })), wantErr: "audience"},
+		{name: "no audience", token: signToken(t, AlgES256, "ec", ecKey, with(func(c map[string]any) { delete(c, "aud") })), wantErr: "audience"},
+		{name: "expired", token: signToken(t, AlgES256, "ec", ecKey, with(func(c map[string]any) { c["exp"] = time.Now().Add(-time.Hour).Unix() })), wantErr: "expired"},
+		{name: "no expiry", token: signToken(t, AlgES256, "ec", ecKey, with(func(c map[string]any) { delete(c, "exp") })), wantErr: "no expiry"},
+		{name: "not yet valid", token: signToken(t, AlgES256, "ec", ecKey, with(func(c map[string]any) { c["nbf"] = time.Now().Add(time.Hour).Unix() })), wantErr: "not yet valid"},
+		{name: "HMAC with public key", token: mustEncode(t, AlgHS256, "rsa", []byte(rsaPublic), validClaims()), wantErr: "unexpected token algorithm"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims, err := v.Validate(context.Background(), tt.token)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("Validate() unexpected error: %v", err)
+				}
+				if ClaimString(claims, "sub") != "alice" {
+					t.Errorf("Validate() claims = %v, want sub alice", claims)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Validate() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func mustEncode(t *testing.T, alg, kid string, key any, claims any) string {
+	t.Helper()
+	token, err := Encode(alg, kid, key, claims)
+	if err != nil {
+		t.Fatalf("Encode() unexpected error: %v", err)
+	}
+	return token
+}
+
+func TestJWKSRotation(t *testing.T) {
+	oldKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	newKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+	var rotated atomic.Bool
+	var fetches atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		key := publicJWK("old", oldKey)
+		if rotated.Load() {
+			key = publicJWK("new", newKey)
+		}
+		_ = json.NewEncoder(w).Encode(map[string]any{"keys": []map[string]string{key}})
+	}))
+	defer srv.Close()
+
+	now := time.Now()
+	keys := NewJWKS(srv.URL, time.Hour)
+	keys.now = func() time.Time { return now }
+	v := NewValidator(keys, "https://sso.example.com", "arr-proxy")
+
+	if _, err := v.Validate(context.Background(), signToken(t, AlgES256, "old", oldKey, validClaims())); err != nil {
+		t.Fatalf("Validate(old key) unexpected error: %v", err)
+	}
+	rotated.Store(true)
+	newToken := signToken(t, AlgES256, "new", newKey, validClaims())
+
+	// Unknown key IDs only trigger a refetch once per minJWKSRefetch
+	if _, err := v.Validate(context.Background(), newToken); err == nil {
+		t.Errorf("Validate(new key) succeeded before refetch was allowed")
+	}
+	now = now.Add(minJWKSRefetch)
+	if _, err := v.Validate(context.Background(), newToken); err != nil {
+		t.Errorf("Validate(new key) unexpected error after rotation: %v", err)
+	}
+	if got := fetches.Load(); got != 2 {
+		t.Errorf("JWKS fetched %d times, want 2", got)
+	}
+}
+
+func TestJWKSRefreshInBackground(t *testing.T) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	release := make(chan struct{})
+	var fetches atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Every fetch after the first hangs like a slow identity provider
+		if fetches.Add(1) > 1 {
+			<-release
+		}
+		_ = json.NewEncoder(w).Encode(map[string]any{"keys": []map[string]string{publicJWK("k1", key)}})
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	now := time.Now()
+	keys := NewJWKS(srv.URL, time.Hour)
+	keys.now = func() time.Time { return now }
+	if _, err := keys.Key(context.Background(), "k1", AlgES256); err != nil {
+		t.Fatalf("Key() unexpected error: %v", err)
+	}
+
+	// A due refresh does not delay requests for known keys
+	now = now.Add(time.Hour)
+	done := make(chan error)
+	go func() {
+		_, err := keys.Key(context.Background(), "k1", AlgES256)
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Key() during refresh unexpected error: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Key() waited for the refresh")
+	}
+
+	// Unknown key IDs wait for the running refresh, bounded by the request context
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := keys.Key(ctx, "k2", AlgES256); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Key(unknown) during refresh error = %v, want deadline exceeded", err)
+	}
+	if got := fetches.Load(); got != 2 {
+		t.Errorf("JWKS fetched %d times, want 2", got)
+	}
+}
+
+func TestParseJWKS(t *testing.T) {
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	offCurve := publicJWK("bad", ecKey)
+	offCurve["y"] = offCurve["x"]
+	encryption := publicJWK("enc", ecKey)
+	encryption["use"] = "enc"
+
+	tests := []struct {
+		name     string
+		keys     []map[string]string
+		wantKeys int
+		wantErr  bool
+	}{
+		{name: "valid key", keys: []map[string]string{publicJWK("ec", ecKey)}, wantKeys: 1},
+		{name: "skips unusable keys", keys: []map[string]string{publicJWK("ec", ecKey), offCurve, encryption, {"kty": "oct", "k": "c2VjcmV0"}}, wantKeys: 1},
+		{name: "no usable key", keys: []map[string]string{offCurve}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, _ := json.Marshal(map[string]any{"keys": tt.keys})
+			keys, err := parseJWKS(data)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseJWKS() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if len(keys) != tt.wantKeys {
+				t.Errorf("parseJWKS() = %d keys, want %d", len(keys), tt.wantKeys)
+			}
+		})
+	}
+}
+
+func TestClaimStrings(t *testing.T) {
+	var claims map[string]any
+	_ = json.Unmarshal([]byte(`{"groups": ["a", "b"], "role": "c", "realm_access": {"roles": ["d"]}, "x.y": "dotted"}`), &claims)
+	tests := []struct {
+		path string
+		want []string
+	}{
+		{"groups", []string{"a", "b"}},
+		{"role", []string{"c"}},
+		{"realm_access.roles", []string{"d"}},
+		{"x.y", []string{"dotted"}},
+		{"missing", nil},
+	}
+	for _, tt := range tests {
+		if got := ClaimStrings(claims, tt.path); fmt.Sprint(got) != fmt.Sprint(tt.want) {
+			t.Errorf("ClaimStrings(%q) = %v, want %v", tt.path, got, tt.want)
+		}
+	}
+}
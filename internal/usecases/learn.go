@@ -0,0 +1,261 @@
+package usecases
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/url"
+	"os"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// idPlaceholder replaces numeric path segments in learned path templates.
+const idPlaceholder = "{id}"
+
+var numericSegment = regexp.MustCompile(`^[0-9]+$`)
+
+// methodOrder is the canonical order of methods in generated whitelist rules.
+var methodOrder = []string{"GET", "HEAD", "OPTIONS", "POST", "PUT", "PATCH", "DELETE"}
+
+// Observation is a single learned (identity, service, method, path template, query keys) tuple.
+type Observation struct {
+	Identity  string   `json:"identity"`
+	Service   string   `json:"service"`
+	Method    string   `json:"method"`
+	Path      string   `json:"path"`
+	QueryKeys []string `json:"query_keys,omitempty"`
+	Blocked   bool     `json:"blocked,omitempty"`
+}
+
+func (o Observation) key() string {
+	return strings.Join([]string{o.Identity, o.Service, o.Method, o.Path, strings.Join(o.QueryKeys, ","), fmt.Sprint(o.Blocked)}, "\x00")
+}
+
+// Learner records observed requests to a JSON lines file, writing each distinct
+// observation only once. A nil Learner records nothing.
+type Learner struct {
+	mu   sync.Mutex
+	file *os.File
+	seen map[string]bool
+}
+
+// NewLearner opens (or creates) the recording file. Existing observations are
+// loaded so they are not written again.
+func NewLearner(path string) (*Learner, error) {
+	seen := make(map[string]bool)
+	if existing, err := os.Open(path); err == nil {
+		observations, err := ReadObservations(existing)
+		_ = existing.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read learning file: %w", err)
+		}
+		for _, o := range observations {
+			seen[o.key()] = true
+		}
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open learning file: %w", err)
+	}
+	return &Learner{file: file, seen: seen}, nil
+}
+
+// Observe records a request. The proxy API key query parameter is never recorded.
+func (l *Learner) Observe(identity, service, method, path string, query url.Values, blocked bool) {
+	if l == nil {
+		return
+	}
+
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		if k == "apikey" {
+			continue
+		}
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	o := Observation{
+		Identity:  identity,
+		Service:   service,
+		Method:    method,
+		Path:      PathTemplate(path),
+		QueryKeys: keys,
+		Blocked:   blocked,
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.seen[o.key()] {
+		return
+	}
+	data, err := json.Marshal(o)
+	if err != nil {
+		slog.Error("Failed to encode learned request", "error", err)
+		return
+	}
+	if _, err := l.file.Write(append(data, '\n')); err != nil {
+		slog.Error("Failed to write learned request", "error", err)
+		return
+	}
+	l.seen[o.key()] = true
+}
+
+// Close closes the recording file.
+func (l *Learner) Close() error {
+	if l == nil {
+		return nil
+	}
+	return l.file.Close()
+}
+
+// PathTemplate collapses numeric path segments into an {id} parameter.
+func PathTemplate(path string) string {
+	segments := strings.Split(path, "/")
+	for i, seg := range segments {
+		if numericSegment.MatchString(seg) {
+			segments[i] = idPlaceholder
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+// ReadObservations parses a JSON lines recording.
+func ReadObservations(r io.Reader) ([]Observation, error) {
+	var observations []Observation
+	scanner := bufio.NewScanner(r)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+		var o Observation
+		if err := json.Unmarshal([]byte(text), &o); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		observations = append(observations, o)
+	}
+	return observations, scanner.Err()
+}
+
+// SuggestedRule is a proposed whitelist entry derived from observations.
+type SuggestedRule struct {
+	Pattern   string   // whitelist pattern in "METHODS:regex" format
+	QueryKeys []string // query parameters seen for the matched paths
+	Blocked   bool     // true if all observations were blocked by the active whitelist
+}
+
+type templateStats struct {
+	methods   map[string]bool
+	queryKeys map[string]bool
+	blocked   bool
+}
+
+// SuggestWhitelist turns observations into a minimal rule set per service.
+// A collection path and its {id} item path seen with the same methods are merged
+// into a single rule. If identity is non-empty, only its observations are used.
+func SuggestWhitelist(observations []Observation, identity string) map[string][]SuggestedRule {
+	byService := make(map[string]map[string]*templateStats)
+	for _, o := range observations {
+		if identity != "" && o.Identity != identity {
+			continue
+		}
+		if !slices.Contains(methodOrder, o.Method) {
+			continue // not expressible as a whitelist rule
+		}
+		templates, ok := byService[o.Service]
+		if !ok {
+			templates = make(map[string]*templateStats)
+			byService[o.Service] = templates
+		}
+		ts, ok := templates[o.Path]
+		if !ok {
+			ts = &templateStats{methods: make(map[string]bool), queryKeys: make(map[string]bool), blocked: true}
+			templates[o.Path] = ts
+		}
+		ts.methods[o.Method] = true
+		for _, k := range o.QueryKeys {
+			ts.queryKeys[k] = true
+		}
+		ts.blocked = ts.blocked && o.Blocked
+	}
+
+	suggestions := make(map[string][]SuggestedRule, len(byService))
+	for service, templates := range byService {
+		paths := make([]string, 0, len(templates))
+		for p := range templates {
+			paths = append(paths, p)
+		}
+		sort.Strings(paths)
+
+		merged := make(map[string]bool)
+		rules := make([]SuggestedRule, 0, len(paths))
+		for _, p := range paths {
+			if merged[p] {
+				continue
+			}
+			ts := templates[p]
+			methods := methodSpec(ts.methods)
+			regex := templateRegex(p)
+
+			// Merge "/x" and "/x/{id}" when both are used with the same methods
+			itemPath := p + "/" + idPlaceholder
+			if item, ok := templates[itemPath]; ok && p != "/" && methodSpec(item.methods) == methods {
+				merged[itemPath] = true
+				regex = templateRegex(p) + `(?:/\d+)?`
+				for k := range item.queryKeys {
+					ts.queryKeys[k] = true
+				}
+				ts.blocked = ts.blocked && item.blocked
+			}
+
+			rules = append(rules, SuggestedRule{
+				Pattern:   methods + ":^" + regex + "$",
+				QueryKeys: sortedKeys(ts.queryKeys),
+				Blocked:   ts.blocked,
+			})
+		}
+		suggestions[service] = rules
+	}
+	return suggestions
+}
+
+func methodSpec(methods map[string]bool) string {
+	ordered := make([]string, 0, len(methods))
+	for _, m := range methodOrder {
+		if methods[m] {
+			ordered = append(ordered, m)
+		}
+	}
+	return strings.Join(ordered, ",")
+}
+
+func templateRegex(template string) string {
+	segments := strings.Split(template, "/")
+	for i, seg := range segments {
+		if seg == idPlaceholder {
+			segments[i] = `\d+`
+		} else {
+			segments[i] = regexp.QuoteMeta(seg)
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+func sortedKeys(m map[string]bool) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
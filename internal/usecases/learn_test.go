@@ -0,0 +1,70 @@
+package usecases
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestPathTemplate(t *testing.T) {
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"/api/v3/series", "/api/v3/series"},
+		{"/api/v3/series/5", "/api/v3/series/{id}"},
+		{"/api/v3/episode/12/file/34", "/api/v3/episode/{id}/file/{id}"},
+		{"/api/v3/series/lookup", "/api/v3/series/lookup"},
+		{"/api/v3/tag/5a", "/api/v3/tag/5a"},
+		{"/", "/"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			if got := PathTemplate(tt.path); got != tt.want {
+				t.Errorf("PathTemplate(%q) = %q, want %q", tt.path, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSuggestWhitelist(t *testing.T) {
+	observations := []Observation{
+		{Identity: "nzb360", Service: "sonarr", Method: "GET", Path: "/api/v3/series"},
+		{Identity: "nzb360", Service: "sonarr", Method: "GET", Path: "/api/v3/series/{id}"},
+		{Identity: "nzb360", Service: "sonarr", Method: "GET", Path: "/api/v3/queue", QueryKeys: []string{"page", "pageSize"}},
+		{Identity: "nzb360", Service: "sonarr", Method: "DELETE", Path: "/api/v3/queue/{id}", QueryKeys: []string{"removeFromClient"}, Blocked: true},
+		{Identity: "nzb360", Service: "radarr", Method: "POST", Path: "/api/v3/movie"},
+		{Identity: "nzb360", Service: "radarr", Method: "GET", Path: "/api/v3/movie"},
+		{Identity: "lunasea", Service: "radarr", Method: "GET", Path: "/api/v3/calendar"},
+		{Identity: "nzb360", Service: "radarr", Method: "PROPFIND", Path: "/api/v3/movie"},
+	}
+
+	t.Run("all identities", func(t *testing.T) {
+		got := SuggestWhitelist(observations, "")
+		want := map[string][]SuggestedRule{
+			"sonarr": {
+				// queue and queue/{id} differ in methods, so they are not merged
+				{Pattern: `GET:^/api/v3/queue$`, QueryKeys: []string{"page", "pageSize"}},
+				{Pattern: `DELETE:^/api/v3/queue/\d+$`, QueryKeys: []string{"removeFromClient"}, Blocked: true},
+				{Pattern: `GET:^/api/v3/series(?:/\d+)?$`, QueryKeys: []string{}},
+			},
+			"radarr": {
+				{Pattern: `GET:^/api/v3/calendar$`, QueryKeys: []string{}},
+				{Pattern: `GET,POST:^/api/v3/movie$`, QueryKeys: []string{}},
+			},
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("SuggestWhitelist() = %#v, want %#v", got, want)
+		}
+	})
+
+	t.Run("single identity", func(t *testing.T) {
+		got := SuggestWhitelist(observations, "lunasea")
+		want := map[string][]SuggestedRule{
+			"radarr": {{Pattern: `GET:^/api/v3/calendar$`, QueryKeys: []string{}}},
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("SuggestWhitelist() = %#v, want %#v", got, want)
+		}
+	})
+}
@@ -0,0 +1,69 @@
+package usecases
+
+import (
+	"sync"
+)
+
+// maxReportedRequests bounds the number of distinct method/path entries kept per service.
+const maxReportedRequests = 1000
+
+// ServiceReport summarizes the requests a candidate whitelist would have blocked for one service.
+type ServiceReport struct {
+	WouldBlock int64            `json:"would_block"`
+	Requests   map[string]int64 `json:"requests"`
+	Truncated  bool             `json:"truncated,omitempty"`
+}
+
+// CandidateReport counts requests that were forwarded under the enforced whitelist
+// but would have been blocked by a service's report-only candidate whitelist.
+type CandidateReport struct {
+	mu       sync.Mutex
+	services map[string]*ServiceReport
+}
+
+// NewCandidateReport creates an empty CandidateReport.
+func NewCandidateReport() *CandidateReport {
+	return &CandidateReport{
+		services: make(map[string]*ServiceReport),
+	}
+}
+
+// Record counts a request the candidate whitelist would have blocked.
+func (cr *CandidateReport) Record(service, method, path string) {
+	cr.mu.Lock()
+	defer cr.mu.Unlock()
+
+	sr, ok := cr.services[service]
+	if !ok {
+		sr = &ServiceReport{Requests: make(map[string]int64)}
+		cr.services[service] = sr
+	}
+	sr.WouldBlock++
+
+	key := method + " " + path
+	if _, ok := sr.Requests[key]; !ok && len(sr.Requests) >= maxReportedRequests {
+		sr.Truncated = true
+		return
+	}
+	sr.Requests[key]++
+}
+
+// Snapshot returns a copy of the report for a service, or nil if nothing was recorded.
+func (cr *CandidateReport) Snapshot(service string) *ServiceReport {
+	cr.mu.Lock()
+	defer cr.mu.Unlock()
+
+	sr, ok := cr.services[service]
+	if !ok {
+		return nil
+	}
+	requests := make(map[string]int64, len(sr.Requests))
+	for k, v := range sr.Requests {
+		requests[k] = v
+	}
+	return &ServiceReport{
+		WouldBlock: sr.WouldBlock,
+		Requests:   requests,
+		Truncated:  sr.Truncated,
+	}
+}
@@ -0,0 +1,152 @@
+package test
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"arr-proxy/internal/config"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type adminKey struct {
+	ID       string   `json:"id"`
+	Key      string   `json:"key"`
+	Owner    string   `json:"owner"`
+	Services []string `json:"services"`
+	Active   bool     `json:"active"`
+}
+
+type adminNewKey struct {
+	APIKey string   `json:"api_key"`
+	Key    adminKey `json:"key"`
+}
+
+func TestAdminKeyManagement(t *testing.T) {
+	cfg, err := config.Load()
+	require.NoError(t, err)
+	cfg.Port = getFreePort()
+	cfg.Auth.Mode = config.AuthModeAPIKey
+	cfg.Auth.APIKey = ""
+	cfg.Auth.AdminKey = "admin-secret"
+	cfg.Server.KeyStore = filepath.Join(t.TempDir(), "keys.json")
+
+	url, stop, err := StartProxy(&cfg)
+	require.NoError(t, err)
+	defer stop()
+
+	client := &http.Client{
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{
+				RootCAs: caCertPool,
+			},
+		},
+	}
+
+	admin := func(method, path, body string) *http.Response {
+		req, _ := http.NewRequest(method, url+"/admin"+path, strings.NewReader(body))
+		req.Header.Set("X-Admin-Key", "admin-secret")
+		resp, err := client.Do(req)
+		require.NoError(t, err)
+		return resp
+	}
+	proxyStatus := func(path, key string) int {
+		req, _ := http.NewRequest("GET", url+path, nil)
+		req.Header.Set("X-Api-Key", key)
+		resp, err := client.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	decode := func(resp *http.Response, v any) {
+		defer resp.Body.Close()
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(v))
+	}
+
+	t.Run("admin key required", func(t *testing.T) {
+		req, _ := http.NewRequest("GET", url+"/admin/keys", nil)
+		req.Header.Set("X-Api-Key", "admin-secret")
+		resp, err := client.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	})
+
+	resp := admin("POST", "/keys", `{"owner":"alice","services":["sonarr"],"expires_in":"24h"}`)
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+	var created adminNewKey
+	decode(resp, &created)
+	require.NotEmpty(t, created.APIKey)
+
+	t.Run("created key authenticates for allowed services", func(t *testing.T) {
+		assert.Equal(t, http.StatusOK, proxyStatus("/sonarr/api/v3/system/status", created.APIKey))
+		assert.Equal(t, http.StatusForbidden, proxyStatus("/radarr/api/v3/system/status", created.APIKey))
+	})
+
+	t.Run("list is masked", func(t *testing.T) {
+		var keys []adminKey
+		decode(admin("GET", "/keys", ""), &keys)
+		require.Len(t, keys, 1)
+		assert.Equal(t, "alice", keys[0].Owner)
+		assert.True(t, keys[0].Active)
+		assert.NotContains(t, keys[0].Key, created.APIKey)
+		assert.Contains(t, keys[0].Key, "****")
+	})
+
+	var rotated adminNewKey
+	t.Run("rotate replaces the key", func(t *testing.T) {
+		resp := admin("POST", "/keys/"+created.Key.ID+"/rotate", "")
+		require.Equal(t, http.StatusCreated, resp.StatusCode)
+		decode(resp, &rotated)
+		assert.Equal(t, http.StatusOK, proxyStatus("/sonarr/api/v3/system/status", rotated.APIKey))
+		assert.Equal(t, http.StatusUnauthorized, proxyStatus("/sonarr/api/v3/system/status", created.APIKey))
+	})
+
+	t.Run("expire and revoke", func(t *testing.T) {
+		resp := admin("POST", "/keys/"+rotated.Key.ID+"/expire", `{"expires_in":"-1s"}`)
+		resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, http.StatusUnauthorized, proxyStatus("/sonarr/api/v3/system/status", rotated.APIKey))
+
+		resp = admin("DELETE", "/keys/"+rotated.Key.ID, "")
+		resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+		resp = admin("DELETE", "/keys/"+rotated.Key.ID, "")
+		resp.Body.Close()
+		assert.Equal(t, http.StatusConflict, resp.StatusCode)
+
+		resp = admin("DELETE", "/keys/unknown", "")
+		resp.Body.Close()
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	})
+
+	t.Run("invalid requests", func(t *testing.T) {
+		for _, body := range []string{`{"services":["sonarr"]}`, `{"owner":"bob","services":["lidarr"]}`, `{"owner":"bob","unknown":1}`, `{`} {
+			resp := admin("POST", "/keys", body)
+			resp.Body.Close()
+			assert.Equal(t, http.StatusBadRequest, resp.StatusCode, body)
+		}
+	})
+
+	t.Run("keys are never exposed", func(t *testing.T) {
+		var buf bytes.Buffer
+		resp := admin("GET", "/keys", "")
+		_, _ = buf.ReadFrom(resp.Body)
+		resp.Body.Close()
+		assert.NotContains(t, buf.String(), created.APIKey)
+		assert.NotContains(t, buf.String(), "sha256:")
+
+		stored, err := os.ReadFile(cfg.Server.KeyStore)
+		require.NoError(t, err)
+		assert.NotContains(t, string(stored), created.APIKey)
+		assert.NotContains(t, string(stored), rotated.APIKey)
+	})
+}
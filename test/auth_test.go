@@ -8,6 +8,7 @@ import (
 	"strconv"
 	"testing"
 
+	"arr-proxy/internal/apikeys"
 	"arr-proxy/internal/config"
 
 	"github.com/stretchr/testify/assert"
@@ -147,4 +148,58 @@ func TestAPIKeyAuth(t *testing.T) {
 		defer resp.Body.Close()
 		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
 	})
-}
\ No newline at end of file
+}
+func TestHashedAPIKeys(t *testing.T) {
+	sharedHash, err := apikeys.Hash("shared-proxy-key")
+	require.NoError(t, err)
+	oldHash, err := apikeys.Hash("alice-old-key")
+	require.NoError(t, err)
+	newHash, err := apikeys.Hash("alice-new-key")
+	require.NoError(t, err)
+
+	cfg, err := config.Load()
+	require.NoError(t, err)
+	cfg.Port = getFreePort()
+	cfg.Auth.Mode = config.AuthModeAPIKey
+	cfg.Auth.APIKey = sharedHash
+	cfg.Clients = map[string]*config.ClientConfig{
+		"alice": {Name: "alice", Services: []string{"sonarr"}, APIKeys: []string{oldHash, newHash}},
+	}
+
+	url, stop, err := StartProxy(&cfg)
+	require.NoError(t, err)
+	defer stop()
+
+	client := &http.Client{
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{
+				RootCAs: caCertPool,
+			},
+		},
+	}
+
+	tests := []struct {
+		name       string
+		path       string
+		key        string
+		wantStatus int
+	}{
+		{name: "shared key matches hash", path: "/info", key: "shared-proxy-key", wantStatus: http.StatusOK},
+		{name: "hash itself is not a key", path: "/info", key: sharedHash, wantStatus: http.StatusUnauthorized},
+		{name: "old client key during rotation", path: "/sonarr/api/v3/system/status", key: "alice-old-key", wantStatus: http.StatusOK},
+		{name: "new client key during rotation", path: "/sonarr/api/v3/system/status", key: "alice-new-key", wantStatus: http.StatusOK},
+		{name: "client restricted to its services", path: "/radarr/api/v3/system/status", key: "alice-new-key", wantStatus: http.StatusForbidden},
+		{name: "unknown key", path: "/info", key: "alice-other-key", wantStatus: http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, _ := http.NewRequest("GET", url+tt.path, nil)
+			req.Header.Set("X-Api-Key", tt.key)
+			resp, err := client.Do(req)
+			require.NoError(t, err)
+			defer resp.Body.Close()
+			assert.Equal(t, tt.wantStatus, resp.StatusCode)
+		})
+	}
+}
@@ -0,0 +1,62 @@
+package test
+
+import (
+	"crypto/tls"
+	"net/http"
+	"testing"
+
+	"arr-proxy/internal/config"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestComposedAuth(t *testing.T) {
+	cfg, err := config.Load()
+	require.NoError(t, err)
+	cfg.Port = getFreePort()
+	cfg.Auth.Mode = "mtls,apikey"
+	cfg.Auth.APIKey = "mobile-key"
+	// Radarr only accepts client certificates
+	cfg.Services["radarr"].Auth = []string{config.AuthModeMTLS}
+
+	url, stop, err := StartProxy(&cfg)
+	require.NoError(t, err)
+	defer stop()
+
+	certClient := &http.Client{Transport: &http.Transport{TLSClientConfig: clientTLSConfig}}
+	plainClient := &http.Client{
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{
+				RootCAs: caCertPool,
+			},
+		},
+	}
+
+	tests := []struct {
+		name       string
+		client     *http.Client
+		path       string
+		apiKey     string
+		wantStatus int
+	}{
+		{name: "client certificate", client: certClient, path: "/sonarr/api/v3/system/status", wantStatus: http.StatusOK},
+		{name: "api key without certificate", client: plainClient, path: "/sonarr/api/v3/system/status", apiKey: "mobile-key", wantStatus: http.StatusOK},
+		{name: "invalid api key", client: plainClient, path: "/sonarr/api/v3/system/status", apiKey: "wrong", wantStatus: http.StatusUnauthorized},
+		{name: "no credential", client: plainClient, path: "/info", wantStatus: http.StatusUnauthorized},
+		{name: "service override accepts certificate", client: certClient, path: "/radarr/api/v3/system/status", wantStatus: http.StatusOK},
+		{name: "service override rejects api key", client: plainClient, path: "/radarr/api/v3/system/status", apiKey: "mobile-key", wantStatus: http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, _ := http.NewRequest("GET", url+tt.path, nil)
+			if tt.apiKey != "" {
+				req.Header.Set("X-Api-Key", tt.apiKey)
+			}
+			resp, err := tt.client.Do(req)
+			require.NoError(t, err)
+			resp.Body.Close()
+			assert.Equal(t, tt.wantStatus, resp.StatusCode)
+		})
+	}
+}
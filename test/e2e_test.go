@@ -73,6 +73,14 @@ func TestProxyEndpoint(t *testing.T) {
 		{"movie POST allowed", "radarr", "/api/v3/movie", "POST", "", http.StatusOK},
 		{"movie DELETE blocked", "radarr", "/api/v3/movie", "DELETE", "", http.StatusForbidden},
 		{"movie PUT blocked", "radarr", "/api/v3/movie", "PUT", "", http.StatusForbidden},
+
+		// Path canonicalization tests
+		{"duplicate slashes canonicalized", "sonarr", "//api/v3//system/status", "GET", "", http.StatusOK},
+		{"trailing slash canonicalized", "sonarr", "/api/v3/system/status/", "GET", "", http.StatusOK},
+		{"dot segments canonicalized", "sonarr", "/api/v3/queue/../readonly", "GET", "", http.StatusOK},
+		{"dot segments cannot escape whitelist", "sonarr", "/api/v3/readonly/../nonexistent", "GET", "", http.StatusForbidden},
+		{"encoded slash rejected", "sonarr", "/api/v3/system%2Fstatus", "GET", "", http.StatusBadRequest},
+		{"service prefix must be a full segment", "sonarrx", "/api/v3/system/status", "GET", "", http.StatusNotFound},
 	}
 
 	for _, tc := range testCases {
@@ -121,3 +129,32 @@ func TestAuthentication(t *testing.T) {
 		assert.Equal(t, http.StatusOK, resp.StatusCode)
 	})
 }
+
+func TestCandidateWhitelistReportOnly(t *testing.T) {
+	client := newTestClient()
+
+	// Allowed by the enforced whitelist but not by the candidate: must still be forwarded
+	resp, err := client.Get(proxyURL + "/radarr/api/v3/readonly")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp, err = client.Get(proxyURL + "/info")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	var info struct {
+		Radarr struct {
+			CandidateWhitelist []string `json:"candidate_whitelist"`
+			CandidateReport    struct {
+				WouldBlock int64            `json:"would_block"`
+				Requests   map[string]int64 `json:"requests"`
+			} `json:"candidate_report"`
+		} `json:"radarr"`
+	}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&info))
+
+	assert.NotEmpty(t, info.Radarr.CandidateWhitelist)
+	assert.GreaterOrEqual(t, info.Radarr.CandidateReport.WouldBlock, int64(1))
+	assert.GreaterOrEqual(t, info.Radarr.CandidateReport.Requests["GET /api/v3/readonly"], int64(1))
+}
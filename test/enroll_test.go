@@ -0,0 +1,94 @@
+package test
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"arr-proxy/internal/ca"
+	"arr-proxy/internal/config"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCertificateEnrollment(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "ca")
+	authority, err := ca.Init(dir, "Enrollment CA", 24*time.Hour)
+	require.NoError(t, err)
+	token, _, err := authority.CreateEnrollment("phone", time.Hour)
+	require.NoError(t, err)
+
+	// Trust both the test CA and the enrollment CA for client certificates
+	testCA, err := os.ReadFile(os.Getenv("APP_CA_CERT"))
+	require.NoError(t, err)
+	bundle := filepath.Join(t.TempDir(), "client-cas.crt")
+	require.NoError(t, os.WriteFile(bundle, append(testCA, ca.EncodeCertPEM(authority.Certificate())...), 0600))
+
+	cfg, err := config.Load()
+	require.NoError(t, err)
+	cfg.Port = getFreePort()
+	cfg.Auth.Mode = config.AuthModeMTLS
+	cfg.CACert = bundle
+	cfg.Enrollment = config.EnrollmentConfig{CADir: dir, Validity: time.Hour}
+	url, stop, err := StartProxy(&cfg)
+	require.NoError(t, err)
+	t.Cleanup(stop)
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	csrDER, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{Subject: pkix.Name{CommonName: "admin"}}, key)
+	require.NoError(t, err)
+	csr := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: csrDER})
+
+	// A device without a certificate can connect but only enroll
+	noCert := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: caCertPool}}}
+	enroll := func(token string) *http.Response {
+		req, err := http.NewRequest(http.MethodPost, url+"/enroll", bytes.NewReader(csr))
+		require.NoError(t, err)
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp, err := noCert.Do(req)
+		require.NoError(t, err)
+		return resp
+	}
+
+	resp, err := noCert.Get(url + "/info")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	resp = enroll(token)
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	require.Equal(t, http.StatusCreated, resp.StatusCode, string(body))
+	block, _ := pem.Decode(body)
+	require.NotNil(t, block)
+	cert, err := x509.ParseCertificate(block.Bytes)
+	require.NoError(t, err)
+	assert.Equal(t, "phone", cert.Subject.CommonName, "CN is bound to the enrollment, not the CSR")
+
+	resp = enroll(token)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode, "enrollment tokens are single use")
+
+	// The enrolled certificate authenticates
+	enrolled := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{
+		RootCAs:      caCertPool,
+		Certificates: []tls.Certificate{{Certificate: [][]byte{cert.Raw}, PrivateKey: key}},
+	}}}
+	resp, err = enrolled.Get(url + "/info")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
@@ -0,0 +1,154 @@
+package test
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"arr-proxy/internal/config"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestForwardAuth(t *testing.T) {
+	// Stub of an Authelia-style auth endpoint: sessions map to users and groups
+	var mu sync.Mutex
+	var calls []http.Header
+	authSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		calls = append(calls, r.Header.Clone())
+		mu.Unlock()
+		switch r.Header.Get("Cookie") {
+		case "session=bob":
+			w.Header().Set("Remote-User", "bob")
+			w.Header().Set("Remote-Groups", "users, family")
+			w.WriteHeader(http.StatusOK)
+		case "session=carol":
+			w.Header().Set("Remote-User", "carol")
+			w.WriteHeader(http.StatusOK)
+		case "session=mallory":
+			w.WriteHeader(http.StatusForbidden)
+		case "":
+			// Network-based policy: the LAN needs no login
+			if strings.HasPrefix(r.Header.Get("X-Forwarded-For"), "192.168.") {
+				w.Header().Set("Remote-User", "lan")
+				w.WriteHeader(http.StatusOK)
+				return
+			}
+			fallthrough
+		default:
+			w.Header().Set("Location", "https://auth.example.com/login")
+			w.WriteHeader(http.StatusFound)
+		}
+	}))
+	defer authSrv.Close()
+	callCount := func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(calls)
+	}
+
+	cfg, err := config.Load()
+	require.NoError(t, err)
+	cfg.Port = getFreePort()
+	cfg.Auth.Mode = config.AuthModeForward
+	cfg.Auth.Forward = config.ForwardAuthConfig{
+		URL:            authSrv.URL,
+		Headers:        []string{"Authorization", "Cookie"},
+		IdentityHeader: "Remote-User",
+		GroupsHeader:   "Remote-Groups",
+		CacheTTL:       time.Minute,
+	}
+	// The test client connects from the loopback address and sets the forwarded address itself
+	cfg.Network.TrustedProxies = []netip.Prefix{netip.MustParsePrefix("127.0.0.0/8"), netip.MustParsePrefix("::1/128")}
+	cfg.Clients = map[string]*config.ClientConfig{
+		"family": {Name: "family", Services: []string{"sonarr"}, Groups: []string{"family"}},
+	}
+
+	url, stop, err := StartProxy(&cfg)
+	require.NoError(t, err)
+	defer stop()
+
+	client := &http.Client{
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{
+				RootCAs: caCertPool,
+			},
+		},
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+	}
+	getFrom := func(path, session, from string) *http.Response {
+		req, _ := http.NewRequest("GET", url+path, nil)
+		if from != "" {
+			req.Header.Set("X-Forwarded-For", from)
+		}
+		if session != "" {
+			req.Header.Set("Cookie", "session="+session)
+		}
+		resp, err := client.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+		return resp
+	}
+	get := func(path, session string) *http.Response {
+		return getFrom(path, session, "")
+	}
+
+	tests := []struct {
+		name       string
+		path       string
+		session    string
+		wantStatus int
+	}{
+		{name: "group mapped to client", path: "/sonarr/api/v3/system/status", session: "bob", wantStatus: http.StatusOK},
+		{name: "client restricts services", path: "/radarr/api/v3/system/status", session: "bob", wantStatus: http.StatusForbidden},
+		{name: "unmapped user gets default whitelist", path: "/radarr/api/v3/system/status", session: "carol", wantStatus: http.StatusOK},
+		{name: "denied by auth service", path: "/info", session: "mallory", wantStatus: http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.wantStatus, get(tt.path, tt.session).StatusCode)
+		})
+	}
+
+	t.Run("login redirect passed through", func(t *testing.T) {
+		resp := get("/info", "")
+		assert.Equal(t, http.StatusFound, resp.StatusCode)
+		assert.Equal(t, "https://auth.example.com/login", resp.Header.Get("Location"))
+	})
+
+	t.Run("original request forwarded", func(t *testing.T) {
+		before := callCount()
+		get("/sonarr/api/v3/queue?page=2", "bob")
+		require.Equal(t, before+1, callCount())
+		mu.Lock()
+		h := calls[len(calls)-1]
+		mu.Unlock()
+		assert.Equal(t, "GET", h.Get("X-Forwarded-Method"))
+		assert.Equal(t, "https", h.Get("X-Forwarded-Proto"))
+		assert.Equal(t, "/sonarr/api/v3/queue?page=2", h.Get("X-Forwarded-Uri"))
+		assert.Equal(t, "session=bob", h.Get("Cookie"))
+	})
+
+	t.Run("decisions are cached", func(t *testing.T) {
+		before := callCount()
+		assert.Equal(t, http.StatusOK, get("/sonarr/api/v3/system/status", "bob").StatusCode)
+		assert.Equal(t, before, callCount())
+	})
+
+	t.Run("decisions are cached per client address", func(t *testing.T) {
+		assert.Equal(t, http.StatusOK, getFrom("/sonarr/api/v3/system/status", "", "192.168.1.5").StatusCode)
+		assert.Equal(t, http.StatusFound, getFrom("/sonarr/api/v3/system/status", "", "203.0.113.9").StatusCode)
+	})
+
+	t.Run("auth service unavailable", func(t *testing.T) {
+		authSrv.Close()
+		assert.Equal(t, http.StatusServiceUnavailable, get("/info", "dave").StatusCode)
+	})
+}
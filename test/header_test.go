@@ -0,0 +1,80 @@
+package test
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/netip"
+	"testing"
+
+	"arr-proxy/internal/config"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTrustedHeaderAuth(t *testing.T) {
+	client := &http.Client{
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{
+				RootCAs: caCertPool,
+			},
+		},
+	}
+	start := func(trusted ...string) string {
+		cfg, err := config.Load()
+		require.NoError(t, err)
+		cfg.Port = getFreePort()
+		cfg.Auth.Mode = config.AuthModeHeader
+		cfg.Auth.Header = config.TrustedHeaderConfig{
+			UserHeader:   "X-Forwarded-User",
+			GroupsHeader: "X-Forwarded-Groups",
+		}
+		for _, p := range trusted {
+			cfg.Network.TrustedProxies = append(cfg.Network.TrustedProxies, netip.MustParsePrefix(p))
+		}
+		cfg.Clients = map[string]*config.ClientConfig{
+			"family": {Name: "family", Services: []string{"sonarr"}, Groups: []string{"family"}},
+		}
+		url, stop, err := StartProxy(&cfg)
+		require.NoError(t, err)
+		t.Cleanup(stop)
+		return url
+	}
+	status := func(url, path, user, groups string) int {
+		req, _ := http.NewRequest("GET", url+path, nil)
+		if user != "" {
+			req.Header.Set("X-Forwarded-User", user)
+		}
+		if groups != "" {
+			req.Header.Set("X-Forwarded-Groups", groups)
+		}
+		resp, err := client.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	// The test client connects from the loopback address
+	trustedURL := start("127.0.0.0/8", "::1/128")
+	untrustedURL := start("10.0.0.0/8")
+
+	tests := []struct {
+		name       string
+		url        string
+		path       string
+		user       string
+		groups     string
+		wantStatus int
+	}{
+		{name: "group mapped to client", url: trustedURL, path: "/sonarr/api/v3/system/status", user: "bob", groups: "users,family", wantStatus: http.StatusOK},
+		{name: "client restricts services", url: trustedURL, path: "/radarr/api/v3/system/status", user: "bob", groups: "family", wantStatus: http.StatusForbidden},
+		{name: "unmapped user gets default whitelist", url: trustedURL, path: "/radarr/api/v3/system/status", user: "carol", wantStatus: http.StatusOK},
+		{name: "missing header", url: trustedURL, path: "/info", wantStatus: http.StatusUnauthorized},
+		{name: "header from untrusted source", url: untrustedURL, path: "/info", user: "bob", wantStatus: http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.wantStatus, status(tt.url, tt.path, tt.user, tt.groups))
+		})
+	}
+}
@@ -0,0 +1,83 @@
+package test
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/netip"
+	"testing"
+
+	"arr-proxy/internal/config"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIPFilter(t *testing.T) {
+	client := &http.Client{
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{
+				RootCAs: caCertPool,
+			},
+		},
+	}
+	prefixes := func(list ...string) []netip.Prefix {
+		var prefixes []netip.Prefix
+		for _, p := range list {
+			prefixes = append(prefixes, netip.MustParsePrefix(p))
+		}
+		return prefixes
+	}
+
+	cfg, err := config.Load()
+	require.NoError(t, err)
+	cfg.Port = getFreePort()
+	cfg.Auth.Mode = config.AuthModeHeader
+	cfg.Auth.Header = config.TrustedHeaderConfig{UserHeader: "X-Forwarded-User"}
+	// The test client connects from the loopback address and sets the forwarded address itself
+	cfg.Network = config.NetworkConfig{
+		TrustedProxies: prefixes("127.0.0.0/8", "::1/128"),
+		IPRules:        config.IPRules{Denied: prefixes("203.0.113.13/32")},
+	}
+	cfg.Services["radarr"].IPRules = config.IPRules{Allowed: prefixes("192.168.0.0/16")}
+	cfg.Clients = map[string]*config.ClientConfig{
+		"writer": {Name: "writer", IPRules: config.IPRules{Allowed: prefixes("192.168.1.0/24")}},
+	}
+	url, stop, err := StartProxy(&cfg)
+	require.NoError(t, err)
+	t.Cleanup(stop)
+
+	tests := []struct {
+		name       string
+		path       string
+		user       string
+		from       string
+		forwarded  string // Forwarded header sent by the client, passed on by the proxy
+		wantStatus int
+	}{
+		{name: "no rules apply", path: "/sonarr/api/v3/system/status", user: "reader", from: "203.0.113.7", wantStatus: http.StatusOK},
+		{name: "globally denied", path: "/sonarr/api/v3/system/status", user: "reader", from: "203.0.113.13", wantStatus: http.StatusForbidden},
+		{name: "denied before authentication", path: "/info", from: "203.0.113.13", wantStatus: http.StatusForbidden},
+		{name: "outside service allowed_ips", path: "/radarr/api/v3/system/status", user: "reader", from: "203.0.113.7", wantStatus: http.StatusForbidden},
+		{name: "inside service allowed_ips", path: "/radarr/api/v3/system/status", user: "reader", from: "192.168.2.1", wantStatus: http.StatusOK},
+		{name: "inside client allowed_ips", path: "/sonarr/api/v3/system/status", user: "writer", from: "192.168.1.5", wantStatus: http.StatusOK},
+		{name: "outside client allowed_ips", path: "/sonarr/api/v3/system/status", user: "writer", from: "192.168.2.1", wantStatus: http.StatusForbidden},
+		{name: "spoofed Forwarded header", path: "/sonarr/api/v3/system/status", user: "writer", from: "203.0.113.9", forwarded: "for=192.168.1.5", wantStatus: http.StatusForbidden},
+		{name: "spoofed Forwarded header for service", path: "/radarr/api/v3/system/status", user: "reader", from: "203.0.113.9", forwarded: "for=192.168.2.1", wantStatus: http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, _ := http.NewRequest("GET", url+tt.path, nil)
+			req.Header.Set("X-Forwarded-For", tt.from)
+			if tt.forwarded != "" {
+				req.Header.Set("Forwarded", tt.forwarded)
+			}
+			if tt.user != "" {
+				req.Header.Set("X-Forwarded-User", tt.user)
+			}
+			resp, err := client.Do(req)
+			require.NoError(t, err)
+			resp.Body.Close()
+			assert.Equal(t, tt.wantStatus, resp.StatusCode)
+		})
+	}
+}
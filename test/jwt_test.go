@@ -0,0 +1,135 @@
+package test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"arr-proxy/internal/config"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// signES256 signs claims as an identity provider with the given key ID would.
+func signES256(t *testing.T, key *ecdsa.PrivateKey, kid string, claims map[string]any) string {
+	t.Helper()
+	enc := base64.RawURLEncoding
+	header, _ := json.Marshal(map[string]string{"alg": "ES256", "typ": "JWT", "kid": kid})
+	payload, _ := json.Marshal(claims)
+	signingInput := enc.EncodeToString(header) + "." + enc.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signingInput))
+	r, s, err := ecdsa.Sign(rand.Reader, key, digest[:])
+	require.NoError(t, err)
+	sig := make([]byte, 64)
+	r.FillBytes(sig[:32])
+	s.FillBytes(sig[32:])
+	return signingInput + "." + enc.EncodeToString(sig)
+}
+
+func TestJWTAuth(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	otherKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	x, y := make([]byte, 32), make([]byte, 32)
+	key.X.FillBytes(x)
+	key.Y.FillBytes(y)
+	jwks, _ := json.Marshal(map[string]any{"keys": []map[string]string{{
+		"kty": "EC", "kid": "sso-1", "crv": "P-256", "use": "sig",
+		"x": base64.RawURLEncoding.EncodeToString(x), "y": base64.RawURLEncoding.EncodeToString(y),
+	}}})
+	jwksPath := filepath.Join(t.TempDir(), "jwks.json")
+	require.NoError(t, os.WriteFile(jwksPath, jwks, 0600))
+
+	cfg, err := config.Load()
+	require.NoError(t, err)
+	cfg.Port = getFreePort()
+	cfg.Auth.Mode = config.AuthModeJWT
+	cfg.Auth.JWT = config.JWTConfig{
+		JWKS:          jwksPath,
+		JWKSRefresh:   time.Hour,
+		Issuer:        "https://sso.example.com",
+		Audience:      "arr-proxy",
+		IdentityClaim: "preferred_username",
+		GroupsClaim:   "groups",
+	}
+	cfg.Clients = map[string]*config.ClientConfig{
+		"family": {Name: "family", Services: []string{"sonarr"}, Groups: []string{"family"}},
+	}
+
+	url, stop, err := StartProxy(&cfg)
+	require.NoError(t, err)
+	defer stop()
+
+	client := &http.Client{
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{
+				RootCAs: caCertPool,
+			},
+		},
+	}
+
+	claims := func(user string, groups []string, change func(c map[string]any)) map[string]any {
+		c := map[string]any{
+			"iss":                "https://sso.example.com",
+			"aud":                "arr-proxy",
+			"sub":                "f3a1c0de",
+			"preferred_username": user,
+			"groups":             groups,
+			"exp":                time.Now().Add(time.Hour).Unix(),
+		}
+		if change != nil {
+			change(c)
+		}
+		return c
+	}
+	member := signES256(t, key, "sso-1", claims("bob", []string{"family"}, nil))
+	stranger := signES256(t, key, "sso-1", claims("carol", nil, nil))
+
+	tests := []struct {
+		name       string
+		path       string
+		token      string
+		wantStatus int
+	}{
+		{name: "group member allowed", path: "/sonarr/api/v3/system/status", token: member, wantStatus: http.StatusOK},
+		{name: "group restricts services", path: "/radarr/api/v3/system/status", token: member, wantStatus: http.StatusForbidden},
+		{name: "unmapped user gets default whitelist", path: "/radarr/api/v3/system/status", token: stranger, wantStatus: http.StatusOK},
+		{name: "expired", path: "/info", token: signES256(t, key, "sso-1", claims("bob", nil, func(c map[string]any) { c["exp"] = time.Now().Add(-time.Hour).Unix() })), wantStatus: http.StatusUnauthorized},
+		{name: "wrong audience", path: "/info", token: signES256(t, key, "sso-1", claims("bob", nil, func(c map[string]any) { c["aud"] = "grafana" })), wantStatus: http.StatusUnauthorized},
+		{name: "wrong issuer", path: "/info", token: signES256(t, key, "sso-1", claims("bob", nil, func(c map[string]any) { c["iss"] = "https://evil.example.com" })), wantStatus: http.StatusUnauthorized},
+		{name: "unknown signing key", path: "/info", token: signES256(t, otherKey, "sso-1", claims("bob", nil, nil)), wantStatus: http.StatusUnauthorized},
+		{name: "missing identity claim", path: "/info", token: signES256(t, key, "sso-1", claims("", nil, nil)), wantStatus: http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, _ := http.NewRequest("GET", url+tt.path, nil)
+			req.Header.Set("Authorization", "Bearer "+tt.token)
+			resp, err := client.Do(req)
+			require.NoError(t, err)
+			resp.Body.Close()
+			assert.Equal(t, tt.wantStatus, resp.StatusCode)
+		})
+	}
+
+	t.Run("bearer token required", func(t *testing.T) {
+		req, _ := http.NewRequest("GET", url+"/info", nil)
+		req.Header.Set("X-Api-Key", "dummy")
+		resp, err := client.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+		assert.Equal(t, "Bearer", resp.Header.Get("WWW-Authenticate"))
+	})
+}
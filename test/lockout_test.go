@@ -0,0 +1,115 @@
+package test
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"testing"
+	"time"
+
+	"arr-proxy/internal/config"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type adminBan struct {
+	Key      string    `json:"key"`
+	Failures int       `json:"failures"`
+	Count    int       `json:"count"`
+	Until    time.Time `json:"until"`
+}
+
+func TestLockout(t *testing.T) {
+	cfg, err := config.Load()
+	require.NoError(t, err)
+	cfg.Port = getFreePort()
+	cfg.Auth.Mode = config.AuthModeAPIKey
+	cfg.Auth.APIKey = "proxy-key"
+	cfg.Auth.AdminKey = "admin-secret"
+	cfg.Auth.Lockout = config.LockoutConfig{Threshold: 3, Window: time.Minute, BanTime: time.Minute, MaxBanTime: time.Hour}
+	// The test client connects from the loopback address and sets the forwarded address itself
+	cfg.Network.TrustedProxies = []netip.Prefix{netip.MustParsePrefix("127.0.0.0/8"), netip.MustParsePrefix("::1/128")}
+
+	proxyURL, stop, err := StartProxy(&cfg)
+	require.NoError(t, err)
+	defer stop()
+
+	client := &http.Client{
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{
+				RootCAs: caCertPool,
+			},
+		},
+	}
+	const attacker, operator = "203.0.113.7", "192.168.1.10"
+	do := func(method, path, from string, header http.Header) *http.Response {
+		req, _ := http.NewRequest(method, proxyURL+path, nil)
+		for name := range header {
+			req.Header.Set(name, header.Get(name))
+		}
+		req.Header.Set("X-Forwarded-For", from)
+		resp, err := client.Do(req)
+		require.NoError(t, err)
+		return resp
+	}
+	proxyStatus := func(from, key string) int {
+		resp := do("GET", "/sonarr/api/v3/system/status", from, http.Header{"X-Api-Key": {key}})
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	admin := func(method, path, from string) *http.Response {
+		return do(method, "/admin"+path, from, http.Header{"X-Admin-Key": {"admin-secret"}})
+	}
+
+	for i := 0; i < 3; i++ {
+		require.Equal(t, http.StatusUnauthorized, proxyStatus(attacker, "wrong-key"))
+	}
+	resp := do("GET", "/sonarr/api/v3/system/status", attacker, http.Header{"X-Api-Key": {"proxy-key"}})
+	resp.Body.Close()
+	assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode, "banned client with valid key")
+	assert.NotEmpty(t, resp.Header.Get("Retry-After"))
+	assert.Equal(t, http.StatusOK, proxyStatus(operator, "proxy-key"), "other client")
+
+	// A banned client cannot lift its own ban, even with the admin key
+	resp = admin("DELETE", "/bans/"+url.PathEscape("ip:"+attacker), attacker)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+
+	resp = admin("GET", "/bans", operator)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var bans []adminBan
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&bans))
+	resp.Body.Close()
+	require.Len(t, bans, 1)
+	assert.Equal(t, "ip:"+attacker, bans[0].Key)
+	assert.Equal(t, 3, bans[0].Failures)
+	assert.Equal(t, 1, bans[0].Count)
+
+	resp = admin("DELETE", "/bans/"+url.PathEscape(bans[0].Key), operator)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+	assert.Equal(t, http.StatusOK, proxyStatus(attacker, "proxy-key"), "ban cleared")
+
+	resp = admin("DELETE", "/bans/"+url.PathEscape(bans[0].Key), operator)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode, "clearing a key that is not banned")
+
+	// Without a key store only the ban endpoints are served
+	resp = admin("GET", "/keys", operator)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	// Guessing the admin key bans the client like any other credential
+	for i := 0; i < 3; i++ {
+		resp = do("GET", "/admin/bans", attacker, http.Header{"X-Admin-Key": {"guess"}})
+		resp.Body.Close()
+		require.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	}
+	resp = admin("DELETE", "/bans", attacker)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode, "banned client with the admin key")
+	assert.Equal(t, http.StatusTooManyRequests, proxyStatus(attacker, "proxy-key"), "ban applies to proxy routes")
+}
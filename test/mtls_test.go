@@ -0,0 +1,57 @@
+package test
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"testing"
+
+	"arr-proxy/internal/config"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClientCertMapping(t *testing.T) {
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: clientTLSConfig}}
+	sum := sha256.Sum256(clientTLSConfig.Certificates[0].Certificate[0])
+	fingerprint := hex.EncodeToString(sum[:])
+
+	start := func(requireMapping bool, clients map[string]*config.ClientConfig) string {
+		cfg, err := config.Load()
+		require.NoError(t, err)
+		cfg.Port = getFreePort()
+		cfg.Auth.Mode = config.AuthModeMTLS
+		cfg.Auth.MTLS.RequireMapping = requireMapping
+		cfg.Clients = clients
+		url, stop, err := StartProxy(&cfg)
+		require.NoError(t, err)
+		t.Cleanup(stop)
+		return url
+	}
+	mappedURL := start(true, map[string]*config.ClientConfig{
+		"tv": {Name: "tv", Services: []string{"sonarr"}, Certificates: []config.CertificateMatch{{Fingerprint: fingerprint}}},
+	})
+	unmappedURL := start(true, map[string]*config.ClientConfig{
+		"kitchen": {Name: "kitchen", Certificates: []config.CertificateMatch{{OU: "Kitchen"}}},
+	})
+
+	tests := []struct {
+		name       string
+		url        string
+		path       string
+		wantStatus int
+	}{
+		{name: "mapped client", url: mappedURL, path: "/sonarr/api/v3/system/status", wantStatus: http.StatusOK},
+		{name: "mapped client restricts services", url: mappedURL, path: "/radarr/api/v3/system/status", wantStatus: http.StatusForbidden},
+		{name: "unmapped certificate rejected", url: unmappedURL, path: "/info", wantStatus: http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := client.Get(tt.url + tt.path)
+			require.NoError(t, err)
+			resp.Body.Close()
+			assert.Equal(t, tt.wantStatus, resp.StatusCode)
+		})
+	}
+}
@@ -0,0 +1,64 @@
+package test
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/netip"
+	"testing"
+
+	"arr-proxy/internal/config"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProxyProtocol(t *testing.T) {
+	cfg, err := config.Load()
+	require.NoError(t, err)
+	cfg.Port = getFreePort()
+	cfg.Network = config.NetworkConfig{
+		ProxyProtocol: []netip.Prefix{netip.MustParsePrefix("127.0.0.0/8"), netip.MustParsePrefix("::1/128")},
+		IPRules:       config.IPRules{Denied: []netip.Prefix{netip.MustParsePrefix("203.0.113.13/32")}},
+	}
+	url, stop, err := StartProxy(&cfg)
+	require.NoError(t, err)
+	t.Cleanup(stop)
+
+	// The client acts as load balancer, announcing the address before the TLS handshake
+	status := func(header string) (int, error) {
+		client := &http.Client{
+			Transport: &http.Transport{
+				TLSClientConfig: clientTLSConfig,
+				DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+					conn, err := (&net.Dialer{}).DialContext(ctx, network, addr)
+					if err != nil {
+						return nil, err
+					}
+					if _, err := conn.Write([]byte(header)); err != nil {
+						conn.Close()
+						return nil, err
+					}
+					return conn, nil
+				},
+			},
+		}
+		resp, err := client.Get(url + "/sonarr/api/v3/system/status")
+		if err != nil {
+			return 0, err
+		}
+		resp.Body.Close()
+		return resp.StatusCode, nil
+	}
+
+	code, err := status("PROXY TCP4 203.0.113.7 127.0.0.1 51234 " + cfg.Port + "\r\n")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, code)
+
+	code, err = status("PROXY TCP4 203.0.113.13 127.0.0.1 51234 " + cfg.Port + "\r\n")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusForbidden, code, "the announced address is denied")
+
+	_, err = status("")
+	assert.Error(t, err, "connections from the load balancer must send a PROXY header")
+}
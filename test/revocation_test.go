@@ -0,0 +1,43 @@
+package test
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"arr-proxy/internal/config"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRevokedClientCert(t *testing.T) {
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: clientTLSConfig}}
+	start := func(denylist string) string {
+		path := filepath.Join(t.TempDir(), "denylist")
+		require.NoError(t, os.WriteFile(path, []byte(denylist), 0600))
+		cfg, err := config.Load()
+		require.NoError(t, err)
+		cfg.Port = getFreePort()
+		cfg.Auth.Mode = config.AuthModeMTLS
+		cfg.Revocation.Denylist = path
+		url, stop, err := StartProxy(&cfg)
+		require.NoError(t, err)
+		t.Cleanup(stop)
+		return url
+	}
+
+	t.Run("certificate not on denylist", func(t *testing.T) {
+		resp, err := client.Get(start("# nothing revoked\n1234\n") + "/info")
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+	})
+
+	t.Run("revoked serial fails the handshake", func(t *testing.T) {
+		// The test client certificate has serial number 2023
+		_, err := client.Get(start("07:E7\n") + "/info")
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,61 @@
+package test
+
+import (
+	"crypto/tls"
+	"net/http"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"arr-proxy/internal/config"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSecretReload(t *testing.T) {
+	keyFile := filepath.Join(t.TempDir(), "api_key")
+	require.NoError(t, os.WriteFile(keyFile, []byte("first-key\n"), 0600))
+	t.Setenv("APP_AUTH_MODE", "apikey")
+	t.Setenv("APP_API_KEY", "")
+	t.Setenv("APP_API_KEY_FILE", keyFile)
+
+	cfg, err := config.Load()
+	require.NoError(t, err)
+	cfg.Port = getFreePort()
+
+	proxyURL, stop, err := StartProxy(&cfg)
+	require.NoError(t, err)
+	defer stop()
+
+	client := &http.Client{
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{
+				RootCAs: caCertPool,
+			},
+		},
+	}
+	status := func(key string) int {
+		req, _ := http.NewRequest("GET", proxyURL+"/sonarr/api/v3/system/status", nil)
+		req.Header.Set("X-Api-Key", key)
+		resp, err := client.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	require.Equal(t, http.StatusOK, status("first-key"))
+
+	// The rotated secret file is read again on SIGHUP
+	require.NoError(t, os.WriteFile(keyFile, []byte("second-key\n"), 0600))
+	require.NoError(t, syscall.Kill(os.Getpid(), syscall.SIGHUP))
+	assert.Eventually(t, func() bool { return status("second-key") == http.StatusOK }, 5*time.Second, 50*time.Millisecond, "rotated key")
+	assert.Equal(t, http.StatusUnauthorized, status("first-key"), "previous key")
+
+	// A configuration that fails to load keeps the previous secrets
+	require.NoError(t, os.Remove(keyFile))
+	require.NoError(t, syscall.Kill(os.Getpid(), syscall.SIGHUP))
+	time.Sleep(200 * time.Millisecond)
+	assert.Equal(t, http.StatusOK, status("second-key"), "after a failed reload")
+}
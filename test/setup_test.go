@@ -228,6 +228,13 @@ whitelist:
   # For testing method restrictions
   - 'GET:^/api/v3/readonly$'
   - 'DELETE:^/api/v3/deleteonly$'
+candidate_whitelist:
+  # Stricter policy evaluated in report-only mode (readonly endpoint dropped)
+  - '^/api/v3/system/status$'
+  - 'GET:^/api/v3/series(?:/.*)?$'
+  - 'GET,POST:^/api/v3/movie(?:/.*)?$'
+  - 'GET:^/api/v3/queue$'
+  - 'DELETE:^/api/v3/deleteonly$'
 `, url, apiKey)
 	return os.WriteFile(filepath.Join(configDir, fileName), []byte(content), 0644)
 }
@@ -0,0 +1,56 @@
+package test
+
+import (
+	"crypto/tls"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"arr-proxy/internal/ca"
+	"arr-proxy/internal/config"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTLSCertificatesAndPolicy(t *testing.T) {
+	dir := t.TempDir()
+	media, err := ca.WriteSelfSigned(filepath.Join(dir, "media.crt"), filepath.Join(dir, "media.key"), []string{"media.example.com"}, time.Hour)
+	require.NoError(t, err)
+
+	cfg, err := config.Load()
+	require.NoError(t, err)
+	cfg.Port = getFreePort()
+	cfg.TLSCert, cfg.TLSKey = filepath.Join(dir, "self-signed.crt"), filepath.Join(dir, "self-signed.key")
+	cfg.TLS = config.TLSConfig{
+		Certificates:    []config.KeyPair{{Cert: filepath.Join(dir, "media.crt"), Key: filepath.Join(dir, "media.key")}},
+		SelfSigned:      true,
+		SelfSignedHosts: []string{"localhost"},
+	}
+	cfg.Server.TLSALPN = []string{"http/1.1"}
+	url, stop, err := StartProxy(&cfg)
+	require.NoError(t, err)
+	t.Cleanup(stop)
+
+	dial := func(serverName string) tls.ConnectionState {
+		conn, err := tls.Dial("tcp", strings.TrimPrefix(url, "https://"), &tls.Config{
+			ServerName:         serverName,
+			InsecureSkipVerify: true, // the certificates are checked below
+			Certificates:       clientTLSConfig.Certificates,
+			NextProtos:         []string{"h2", "http/1.1"},
+		})
+		require.NoError(t, err)
+		defer conn.Close()
+		return conn.ConnectionState()
+	}
+
+	// The self-signed certificate is generated on start and is the default
+	state := dial("localhost")
+	assert.Equal(t, "localhost", state.PeerCertificates[0].Subject.CommonName)
+	assert.Equal(t, "http/1.1", state.NegotiatedProtocol, "h2 is disabled by APP_TLS_ALPN")
+	assert.Equal(t, "localhost", dial("unknown.example.com").PeerCertificates[0].Subject.CommonName)
+
+	// Other names get their certificate by SNI
+	assert.True(t, dial("media.example.com").PeerCertificates[0].Equal(media))
+}
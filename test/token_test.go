@@ -0,0 +1,134 @@
+package test
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"arr-proxy/internal/config"
+	"arr-proxy/internal/tokens"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type mintedToken struct {
+	Token     string    `json:"token"`
+	TokenType string    `json:"token_type"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+func TestScopedTokens(t *testing.T) {
+	tokenKey := strings.Repeat("k", 32)
+
+	cfg, err := config.Load()
+	require.NoError(t, err)
+	cfg.Port = getFreePort()
+	cfg.Auth.Mode = config.AuthModeToken
+	cfg.Auth.APIKey = "shared-proxy-key"
+	cfg.Auth.Token = config.TokenConfig{Algorithm: tokens.AlgHS256, Key: tokenKey, MaxTTL: time.Hour}
+
+	url, stop, err := StartProxy(&cfg)
+	require.NoError(t, err)
+	defer stop()
+
+	client := &http.Client{
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{
+				RootCAs: caCertPool,
+			},
+		},
+	}
+
+	mint := func(header, value, body string) *http.Response {
+		req, _ := http.NewRequest("POST", url+"/token", strings.NewReader(body))
+		req.Header.Set(header, value)
+		resp, err := client.Do(req)
+		require.NoError(t, err)
+		return resp
+	}
+	mintToken := func(body string) string {
+		resp := mint("X-Api-Key", "shared-proxy-key", body)
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusCreated, resp.StatusCode)
+		var minted mintedToken
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&minted))
+		assert.Equal(t, "Bearer", minted.TokenType)
+		assert.WithinDuration(t, time.Now().Add(30*time.Minute), minted.ExpiresAt, time.Minute)
+		return minted.Token
+	}
+	status := func(method, path, token string) int {
+		req, _ := http.NewRequest(method, url+path, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp, err := client.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	scoped := mintToken(`{"ttl": "30m", "services": ["sonarr"], "read_only": true}`)
+	full := mintToken(`{"ttl": "30m"}`)
+	expired, _ := tokens.Encode(tokens.AlgHS256, "", []byte(tokenKey), tokens.Claims{
+		Issuer: tokens.Issuer, Subject: "default", IssuedAt: time.Now().Add(-2 * time.Hour).Unix(), ExpiresAt: time.Now().Add(-time.Hour).Unix(),
+	})
+	forged, _ := tokens.Encode(tokens.AlgHS256, "", []byte(strings.Repeat("x", 32)), tokens.Claims{
+		Issuer: tokens.Issuer, Subject: "default", IssuedAt: time.Now().Unix(), ExpiresAt: time.Now().Add(time.Hour).Unix(),
+	})
+
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		token      string
+		wantStatus int
+	}{
+		{name: "scoped token reads", method: "GET", path: "/sonarr/api/v3/system/status", token: scoped, wantStatus: http.StatusOK},
+		{name: "scoped token is read-only", method: "POST", path: "/sonarr/api/v3/movie", token: scoped, wantStatus: http.StatusForbidden},
+		{name: "scoped token limited to its services", method: "GET", path: "/radarr/api/v3/system/status", token: scoped, wantStatus: http.StatusForbidden},
+		{name: "full token writes", method: "POST", path: "/sonarr/api/v3/movie", token: full, wantStatus: http.StatusOK},
+		{name: "expired token", method: "GET", path: "/info", token: expired, wantStatus: http.StatusUnauthorized},
+		{name: "token signed with another key", method: "GET", path: "/info", token: forged, wantStatus: http.StatusUnauthorized},
+		{name: "garbage token", method: "GET", path: "/info", token: "not-a-token", wantStatus: http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.wantStatus, status(tt.method, tt.path, tt.token))
+		})
+	}
+
+	t.Run("api key still accepted", func(t *testing.T) {
+		req, _ := http.NewRequest("GET", url+"/info", nil)
+		req.Header.Set("X-Api-Key", "shared-proxy-key")
+		resp, err := client.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+	})
+
+	t.Run("token cannot mint tokens", func(t *testing.T) {
+		resp := mint("Authorization", "Bearer "+full, `{"ttl": "10m"}`)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+	})
+
+	t.Run("mint requires authentication", func(t *testing.T) {
+		resp := mint("X-Api-Key", "wrong-key", `{}`)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	})
+
+	t.Run("ttl above maximum", func(t *testing.T) {
+		resp := mint("X-Api-Key", "shared-proxy-key", `{"ttl": "2h"}`)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	})
+
+	t.Run("rule set outside own permissions", func(t *testing.T) {
+		resp := mint("X-Api-Key", "shared-proxy-key", `{"rule_sets": ["admin"]}`)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+	})
+}